@@ -0,0 +1,115 @@
+// Command benchmark measures fray's hashing and transfer bookkeeping costs.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hexfusion/fray/internal/version"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "tree":
+		err = cmdTree(os.Args[2:])
+	case "resume":
+		err = cmdResume(os.Args[2:])
+	case "help", "-h", "--help":
+		printUsage()
+		return
+	default:
+		err = fmt.Errorf("unknown command: %s", os.Args[1])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("benchmark - fray performance measurements")
+	fmt.Println()
+	fmt.Println("Usage: benchmark <command> [options] <blob-url>")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  tree     Compare xxHash64 and SHA-256 merkle trees over a blob")
+	fmt.Println("  resume   Measure resume bookkeeping cost across chunk sizes")
+}
+
+// fetchBlob downloads a blob in chunks using the Fetcher range helpers, or
+// whole when the server doesn't report its size.
+func fetchBlob(ctx context.Context, url string, chunkSize int) ([]byte, time.Duration, error) {
+	f := oci.NewFetcher()
+
+	size, err := f.HeadSize(ctx, url)
+	if errors.Is(err, oci.ErrSizeUnknown) {
+		return fetchWhole(ctx, url)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("head: %w", err)
+	}
+
+	start := time.Now()
+	data := make([]byte, 0, size)
+	for offset := int64(0); offset < size; offset += int64(chunkSize) {
+		end := min(offset+int64(chunkSize), size)
+		chunk, err := f.FetchRange(ctx, url, offset, end)
+		if err != nil {
+			return nil, 0, fmt.Errorf("fetch %d-%d: %w", offset, end, err)
+		}
+		data = append(data, chunk...)
+	}
+
+	return data, time.Since(start), nil
+}
+
+// fetchWhole streams a blob in a single GET.
+func fetchWhole(ctx context.Context, url string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("fetch: unexpected status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch: %w", err)
+	}
+	return data, time.Since(start), nil
+}
+
+func humanBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/merkle"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+type resumeResult struct {
+	chunkSize  int
+	chunks     int
+	firstHalf  time.Duration
+	save       time.Duration
+	stateBytes int64
+	load       time.Duration
+	missing    time.Duration
+	resume     time.Duration
+}
+
+func cmdResume(args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	sizes := fs.String("sizes", "262144,1048576,4194304", "comma-separated chunk sizes in bytes")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("blob url required")
+	}
+
+	chunkSizes, err := parseSizes(*sizes)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	url := fs.Arg(0)
+	f := oci.NewFetcher()
+
+	size, err := f.HeadSize(ctx, url)
+	if err != nil {
+		return fmt.Errorf("head: %w", err)
+	}
+
+	stateDir, err := os.MkdirTemp("", "fray-bench-resume-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stateDir)
+
+	fmt.Printf("blob: %s\n\n", humanBytes(size))
+	fmt.Printf("%10s %7s %12s %10s %10s %10s %10s %12s\n",
+		"chunk", "chunks", "first-half", "save", "state", "load", "missing", "resume")
+
+	for _, cs := range chunkSizes {
+		r, err := benchResume(ctx, f, url, size, cs, filepath.Join(stateDir, fmt.Sprintf("%d.state", cs)))
+		if err != nil {
+			return fmt.Errorf("chunk size %d: %w", cs, err)
+		}
+		fmt.Printf("%10s %7d %12v %10v %10s %10v %10v %12v\n",
+			humanBytes(int64(r.chunkSize)), r.chunks, r.firstHalf, r.save,
+			humanBytes(r.stateBytes), r.load, r.missing, r.resume)
+	}
+
+	return nil
+}
+
+// benchResume downloads half the chunks, persists and reloads the tree, then
+// fetches the remainder. Network and state I/O are timed separately.
+func benchResume(ctx context.Context, f *oci.Fetcher, url string, size int64, chunkSize int, statePath string) (resumeResult, error) {
+	r := resumeResult{chunkSize: chunkSize}
+
+	tree := merkle.New(size, chunkSize)
+	r.chunks = tree.NumChunks
+
+	start := time.Now()
+	for i := 0; i < tree.NumChunks/2; i++ {
+		if err := fetchChunk(ctx, f, url, tree, i); err != nil {
+			return r, err
+		}
+	}
+	r.firstHalf = time.Since(start)
+
+	start = time.Now()
+	if err := tree.SaveToFile(statePath); err != nil {
+		return r, fmt.Errorf("save state: %w", err)
+	}
+	r.save = time.Since(start)
+
+	info, err := os.Stat(statePath)
+	if err != nil {
+		return r, err
+	}
+	r.stateBytes = info.Size()
+
+	start = time.Now()
+	tree, err = merkle.LoadFromFile(statePath)
+	if err != nil {
+		return r, fmt.Errorf("load state: %w", err)
+	}
+	r.load = time.Since(start)
+
+	start = time.Now()
+	ranges := tree.MissingRanges()
+	r.missing = time.Since(start)
+
+	start = time.Now()
+	for _, rng := range ranges {
+		for i := rng[0]; i < rng[1]; i++ {
+			if err := fetchChunk(ctx, f, url, tree, i); err != nil {
+				return r, err
+			}
+		}
+	}
+	r.resume = time.Since(start)
+
+	if !tree.Complete() {
+		return r, fmt.Errorf("incomplete after resume: %d/%d", tree.PresentCount, tree.NumChunks)
+	}
+
+	return r, nil
+}
+
+func fetchChunk(ctx context.Context, f *oci.Fetcher, url string, tree *merkle.Tree, i int) error {
+	start := tree.ChunkOffset(i)
+	data, err := f.FetchRange(ctx, url, start, start+int64(tree.ChunkLength(i)))
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", i, err)
+	}
+	return tree.SetChunk(i, data)
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid chunk size %q", part)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/merkle"
+)
+
+const defaultChunkSize = 1024 * 1024
+
+func cmdTree(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	chunkSize := fs.Int("c", defaultChunkSize, "chunk size in bytes")
+	rounds := fs.Int("n", 10, "root recompute rounds")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("blob url required")
+	}
+
+	data, fetchTime, err := fetchBlob(context.Background(), fs.Arg(0), *chunkSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("blob:   %s in %v\n", humanBytes(int64(len(data))), fetchTime)
+	fmt.Printf("chunks: %d x %s\n\n", (len(data)+*chunkSize-1) / *chunkSize, humanBytes(int64(*chunkSize)))
+
+	xxBuild, xxRoot := benchXXHashTree(data, *chunkSize, *rounds)
+	shaBuild, shaRoot := benchSHA256Tree(data, *chunkSize, *rounds)
+
+	fmt.Printf("%-8s %14s %14s\n", "hash", "build", "root")
+	fmt.Printf("%-8s %14v %14v\n", "xxhash64", xxBuild, xxRoot)
+	fmt.Printf("%-8s %14v %14v\n", "sha256", shaBuild, shaRoot)
+	fmt.Printf("\nsha256/xxhash64 build ratio: %.1fx\n", float64(shaBuild)/float64(xxBuild))
+
+	return nil
+}
+
+// benchXXHashTree builds a merkle.Tree and times leaf hashing and mean root recompute.
+func benchXXHashTree(data []byte, chunkSize, rounds int) (time.Duration, time.Duration) {
+	start := time.Now()
+	tree := merkle.New(int64(len(data)), chunkSize)
+	for i := 0; i < tree.NumChunks; i++ {
+		off := tree.ChunkOffset(i)
+		_ = tree.SetChunk(i, data[off:off+int64(tree.ChunkLength(i))])
+	}
+	build := time.Since(start)
+
+	start = time.Now()
+	for range rounds {
+		_ = tree.Root()
+	}
+	return build, time.Since(start) / time.Duration(rounds)
+}
+
+// benchSHA256Tree mirrors merkle.Tree with SHA-256 leaves and interior nodes.
+func benchSHA256Tree(data []byte, chunkSize, rounds int) (time.Duration, time.Duration) {
+	numChunks := (len(data) + chunkSize - 1) / chunkSize
+	leafCount := 1
+	for leafCount < numChunks {
+		leafCount *= 2
+	}
+
+	start := time.Now()
+	leaves := make([][32]byte, leafCount)
+	for i := 0; i < numChunks; i++ {
+		off := i * chunkSize
+		leaves[i] = sha256.Sum256(data[off:min(off+chunkSize, len(data))])
+	}
+	build := time.Since(start)
+
+	start = time.Now()
+	for range rounds {
+		_ = sha256Root(leaves)
+	}
+	return build, time.Since(start) / time.Duration(rounds)
+}
+
+func sha256Root(leaves [][32]byte) [32]byte {
+	level := make([][32]byte, len(leaves))
+	copy(level, leaves)
+
+	var buf [64]byte
+	for len(level) > 1 {
+		next := make([][32]byte, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			copy(buf[:32], level[i][:])
+			copy(buf[32:], level[i+1][:])
+			next[i/2] = sha256.Sum256(buf[:])
+		}
+		level = next
+	}
+	return level[0]
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/hexfusion/fray/internal/selftest"
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func cmdDoctor(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the capabilities as JSON")
+	selfTest := fs.Bool("selftest", false, "check this machine computes xxHash64 and SHA-256 correctly")
+	registryConfig := registryFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if *selfTest {
+		if err := selftest.Run(); err != nil {
+			log.Error("self-test failed", zap.Error(err))
+			os.Exit(1)
+		}
+		if !*jsonOut {
+			fmt.Fprintf(os.Stdout, "%-11s %s\n", "Self-test:", "ok")
+		}
+		if fs.NArg() == 0 {
+			return
+		}
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		log.Error("registry and an optional repository[:tag] to sample required")
+		os.Exit(1)
+	}
+	registry := loginRegistry(fs.Arg(0))
+
+	config := registryConfig()
+	var repo, ref string
+	if fs.NArg() == 2 {
+		parsed, err := config.ParseReference(registry + "/" + fs.Arg(1))
+		if err != nil {
+			log.Error("invalid repository", zap.Error(err))
+			os.Exit(1)
+		}
+		repo, ref = parsed.Repository, parsed.Ref()
+	}
+
+	client := oci.NewClient()
+	client.SetConfig(config)
+	client.SetAuth(oci.NewRegistryAuth())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	caps, err := client.Probe(ctx, registry, repo, ref)
+	if err != nil {
+		log.Error("probe failed", zap.String("registry", registry), zap.Error(err))
+		os.Exit(1)
+	}
+	printDoctor(os.Stdout, caps, *jsonOut)
+	if !caps.V2 {
+		os.Exit(1)
+	}
+}
+
+// printDoctor writes caps as a table of capabilities, or as JSON.
+func printDoctor(w io.Writer, caps *oci.Capabilities, jsonOut bool) {
+	if jsonOut {
+		_ = json.NewEncoder(w).Encode(caps)
+		return
+	}
+
+	yesNo := func(ok bool) string {
+		if ok {
+			return "yes"
+		}
+		return "no"
+	}
+	auth := caps.AuthScheme
+	if auth == "" {
+		auth = "none"
+	}
+
+	fmt.Fprintf(w, "%-11s %s\n", "Registry:", caps.Registry)
+	fmt.Fprintf(w, "%-11s %s\n", "v2:", yesNo(caps.V2))
+	if !caps.V2 {
+		return
+	}
+	fmt.Fprintf(w, "%-11s %s\n", "Auth:", auth)
+	if caps.Repository == "" {
+		fmt.Fprintf(w, "%-11s %s\n", "Blobs:", "skipped, no repository given")
+		return
+	}
+	fmt.Fprintf(w, "%-11s %s@%s\n", "Sample:", caps.Repository, caps.Blob)
+	fmt.Fprintf(w, "%-11s %s\n", "HEAD:", yesNo(caps.Head))
+	fmt.Fprintf(w, "%-11s %s\n", "Range:", yesNo(caps.Range))
+	fmt.Fprintf(w, "%-11s %s\n", "Referrers:", yesNo(caps.Referrers))
+}
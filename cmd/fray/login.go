@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func cmdLogin(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	username := fs.String("u", "", "username (prompted if empty)")
+	passwordStdin := fs.Bool("password-stdin", false, "read password from stdin")
+	authFile := fs.String("authfile", "", "auth file path (default: ~/.config/containers/auth.json)")
+	insecure := fs.Bool("insecure", false, "use HTTP instead of HTTPS")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 1 {
+		log.Error("registry required")
+		os.Exit(1)
+	}
+	registry := loginRegistry(fs.Arg(0))
+
+	path, err := authFilePath(*authFile)
+	if err != nil {
+		log.Error("locate auth file failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		if *passwordStdin {
+			log.Error("-u is required with --password-stdin")
+			os.Exit(1)
+		}
+		fmt.Print("Username: ")
+		if *username, err = readLine(stdin); err != nil {
+			log.Error("read username failed", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	var password string
+	if *passwordStdin {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			log.Error("read password failed", zap.Error(err))
+			os.Exit(1)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	} else {
+		fmt.Print("Password: ")
+		password, err = readPassword(stdin)
+		fmt.Println()
+		if err != nil {
+			log.Error("read password failed", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	if *username == "" || password == "" {
+		log.Error("username and password required")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	auth := oci.NewRegistryAuth()
+	auth.SetInsecure(registry, *insecure)
+	if err := auth.Login(ctx, registry, *username, password); err != nil {
+		log.Error("login failed", zap.String("registry", registry), zap.Error(err))
+		os.Exit(1)
+	}
+
+	if err := oci.SaveCredentials(path, registry, *username, password); err != nil {
+		log.Error("save credentials failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	fmt.Println("Login succeeded")
+}
+
+func cmdLogout(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	authFile := fs.String("authfile", "", "auth file path (default: ~/.config/containers/auth.json)")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 1 {
+		log.Error("registry required")
+		os.Exit(1)
+	}
+	registry := loginRegistry(fs.Arg(0))
+
+	path, err := authFilePath(*authFile)
+	if err != nil {
+		log.Error("locate auth file failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	if err := oci.RemoveCredentials(path, registry); err != nil {
+		if errors.Is(err, oci.ErrCredentialsNotFound) {
+			log.Error("not logged in", zap.String("registry", oci.AuthKey(registry)))
+		} else {
+			log.Error("remove credentials failed", zap.Error(err))
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("Removed login credentials for %s\n", oci.AuthKey(registry))
+}
+
+// loginRegistry maps the Docker Hub alias to the registry host.
+func loginRegistry(registry string) string {
+	registry = strings.TrimSuffix(strings.TrimPrefix(registry, "https://"), "/")
+	if registry == oci.DockerHubAlias {
+		return oci.DockerHubRegistry
+	}
+	return registry
+}
+
+func authFilePath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	return oci.DefaultAuthFile()
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
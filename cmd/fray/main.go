@@ -3,18 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/hexfusion/fray/internal/prune"
+	"github.com/hexfusion/fray/internal/selftest"
 	"github.com/hexfusion/fray/internal/version"
 	"github.com/hexfusion/fray/pkg/logging"
 	"github.com/hexfusion/fray/pkg/oci"
@@ -26,6 +31,9 @@ const (
 	rootCacheDir     = "/var/lib/containers/fray"
 	rootlessCacheDir = ".local/share/containers/fray"
 	cacheEnvVar      = "FRAY_CACHE_DIR"
+
+	rootStorageDir     = "/var/lib/containers/storage"
+	rootlessStorageDir = ".local/share/containers/storage"
 )
 
 func main() {
@@ -42,10 +50,26 @@ func main() {
 		cmdPull(log, os.Args[2:])
 	case "proxy":
 		cmdProxy(os.Args[2:])
+	case "serve-layout":
+		cmdServeLayout(log, os.Args[2:])
 	case "status":
 		cmdStatus(log, os.Args[2:])
 	case "prune":
 		cmdPrune(log, os.Args[2:])
+	case "tag":
+		cmdTag(log, os.Args[2:])
+	case "inspect":
+		cmdInspect(log, os.Args[2:])
+	case "reindex":
+		cmdReindex(log, os.Args[2:])
+	case "import":
+		cmdImport(log, os.Args[2:])
+	case "doctor":
+		cmdDoctor(log, os.Args[2:])
+	case "login":
+		cmdLogin(log, os.Args[2:])
+	case "logout":
+		cmdLogout(log, os.Args[2:])
 	case "version":
 		cmdVersion(os.Args[2:])
 	case "help", "-h", "--help":
@@ -69,17 +93,36 @@ func defaultCacheDir() string {
 	return "./fray-cache"
 }
 
+// defaultStorageDir is where podman keeps images for the current user.
+func defaultStorageDir() string {
+	if os.Getuid() == 0 {
+		return rootStorageDir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, rootlessStorageDir)
+	}
+	return rootStorageDir
+}
+
 func printUsage() {
 	fmt.Println("fray - edge-native OCI image puller")
 	fmt.Println()
 	fmt.Println("Usage: fray <command> [options]")
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Println("  pull     Pull image to OCI layout")
-	fmt.Println("  proxy    Run pull-through caching proxy")
-	fmt.Println("  status   Show layout status")
-	fmt.Println("  prune    Remove incomplete downloads and temp files")
-	fmt.Println("  version  Show version information")
+	fmt.Println("  pull         Pull image to OCI layout")
+	fmt.Println("  proxy        Run pull-through caching proxy")
+	fmt.Println("  serve-layout Serve a layout as a read-only registry")
+	fmt.Println("  status       Show layout status")
+	fmt.Println("  tag          Add a reference to a cached image")
+	fmt.Println("  inspect      Show an image's manifest and config")
+	fmt.Println("  prune        Remove incomplete downloads and temp files")
+	fmt.Println("  reindex      Rebuild index.json from stored manifests")
+	fmt.Println("  import       Copy blobs from a local containers/storage")
+	fmt.Println("  doctor       Report what a registry supports")
+	fmt.Println("  login        Save registry credentials")
+	fmt.Println("  logout       Remove registry credentials")
+	fmt.Println("  version      Show version information")
 	fmt.Println()
 	fmt.Println("Run 'fray <command> -h' for command options")
 }
@@ -108,46 +151,257 @@ func cmdVersion(args []string) {
 	fmt.Printf("  platform:  %s\n", info.Platform)
 }
 
+// retryFlags registers retry policy flags and returns a func to read them after parsing.
+func retryFlags(fs *flag.FlagSet) func() (oci.RetryPolicy, error) {
+	def := oci.DefaultRetryPolicy()
+	retries := fs.Int("retries", def.MaxRetries, "retries per chunk request")
+	baseDelay := fs.Duration("retry-base-delay", def.BaseDelay, "delay before the first retry, doubled each attempt")
+	maxDelay := fs.Duration("retry-max-delay", def.MaxDelay, "maximum delay between retries")
+	jitter := def.Jitter
+	fs.Func("retry-jitter", "randomize retry delays: none, full or equal (default equal)", func(v string) error {
+		jitter = oci.Jitter(v)
+		if v == "none" {
+			jitter = oci.JitterNone
+		}
+		return nil
+	})
+
+	return func() (oci.RetryPolicy, error) {
+		p := oci.RetryPolicy{
+			MaxRetries: *retries,
+			BaseDelay:  *baseDelay,
+			MaxDelay:   *maxDelay,
+			Jitter:     jitter,
+		}
+		return p, p.Validate()
+	}
+}
+
+// newRegistryClient creates the client pull and proxy reach registries
+// with, retrying by the policy from retryFlags.
+func newRegistryClient(config *oci.RegistryConfig, retry oci.RetryPolicy) *oci.Client {
+	client := oci.NewClient()
+	client.SetConfig(config)
+	client.SetAuth(oci.NewRegistryAuth())
+	client.SetRetryPolicy(retry)
+	return client
+}
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	maxHeaderBytes           = 64 * 1024
+)
+
+// serverFlags registers connection timeout flags and returns a func that
+// builds the http.Server after parsing. Read and write timeouts are off by
+// default: they bound the whole request, which would cut off large blob
+// transfers and pushes on slow links.
+func serverFlags(fs *flag.FlagSet) func(addr string, handler http.Handler) *http.Server {
+	readHeaderTimeout := fs.Duration("read-header-timeout", defaultReadHeaderTimeout, "time allowed to read request headers")
+	readTimeout := fs.Duration("read-timeout", 0, "time allowed to read a whole request, including the body (0 disables)")
+	writeTimeout := fs.Duration("write-timeout", 0, "time allowed to write a response (0 disables)")
+	idleTimeout := fs.Duration("idle-timeout", defaultIdleTimeout, "how long an idle keep-alive connection is kept open")
+
+	return func(addr string, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: *readHeaderTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+			MaxHeaderBytes:    maxHeaderBytes,
+		}
+	}
+}
+
+// registryFlags registers registry connection flags and returns a func that
+// builds the config after parsing.
+func registryFlags(fs *flag.FlagSet) func() *oci.RegistryConfig {
+	var insecure []string
+	mirrors := make(map[string][]string)
+	namespaces := make(map[string]string)
+
+	fs.Func("insecure-registry", "registry reached over plain HTTP (repeatable)", func(v string) error {
+		insecure = append(insecure, v)
+		return nil
+	})
+	fs.Func("mirror", "registry=host mirror tried before the registry for pulls (repeatable)", func(v string) error {
+		registry, host, ok := strings.Cut(v, "=")
+		if !ok || registry == "" || host == "" {
+			return fmt.Errorf("expected registry=host, got %q", v)
+		}
+		mirrors[registry] = append(mirrors[registry], host)
+		return nil
+	})
+	fs.Func("default-namespace", "registry=namespace prepended to single-component repositories (repeatable)", func(v string) error {
+		registry, namespace, ok := strings.Cut(v, "=")
+		if !ok || registry == "" || namespace == "" {
+			return fmt.Errorf("expected registry=namespace, got %q", v)
+		}
+		namespaces[registry] = namespace
+		return nil
+	})
+	plainManifests := fs.Bool("no-manifest-compression", false, "request manifests uncompressed, saving CPU on slow devices")
+	var platforms []string
+	fs.Func("platform-preference", "os/arch[/variant] picked from multi-arch images before the host's (repeatable, in order)", func(v string) error {
+		if err := oci.ValidatePlatform(v); err != nil {
+			return err
+		}
+		platforms = append(platforms, v)
+		return nil
+	})
+	osVersion := fs.String("os-version", "", "prefer multi-arch entries built for this OS version, such as a Windows build")
+
+	return func() *oci.RegistryConfig {
+		cfg := oci.NewRegistryConfig()
+		for _, registry := range insecure {
+			cfg.SetInsecure(registry, true)
+		}
+		for registry, hosts := range mirrors {
+			cfg.SetMirrors(registry, hosts...)
+		}
+		for registry, namespace := range namespaces {
+			cfg.SetDefaultNamespace(registry, namespace)
+		}
+		cfg.SetManifestCompression(!*plainManifests)
+		// validated as parsed
+		_ = cfg.SetPlatformPreference(platforms...)
+		cfg.SetOSVersion(*osVersion)
+		return cfg
+	}
+}
+
 func cmdPull(log logging.Logger, args []string) {
 	fs := flag.NewFlagSet("pull", flag.ExitOnError)
-	output := fs.String("o", defaultCacheDir(), "output directory")
-	chunkSize := fs.Int("c", 1024*1024, "chunk size in bytes")
+	output := fs.String("o", defaultCacheDir(), "output directory, or the archive or rootfs with --output-format")
+	outputFormat := fs.String("output-format", outputOCI, "write an OCI layout (oci), an OCI archive (tar) or an extracted rootfs (dir)")
+	layoutDir := fs.String("d", defaultCacheDir(), "layout to pull into for tar and dir output")
+	chunkSize := fs.Int("c", 0, "chunk size in bytes, 0 picks one per layer")
 	parallel := fs.Int("p", 4, "parallel downloads")
+	jobs := fs.Int("j", 2, "concurrent image pulls when given multiple images")
+	maxConcurrency := fs.Int("max-concurrency", 0, "blob requests in flight across all images (0 is unlimited)")
+	maxLayers := fs.Int("max-layers", 0, "refuse images with more layers (0 is unlimited)")
+	maxSize := fs.Int64("max-size", 0, "refuse images declaring more bytes (0 is unlimited)")
 	silent := fs.Bool("s", false, "silent mode, suppress progress output")
+	jsonOut := fs.Bool("json", false, "print a JSON result per image to stdout")
+	tempDir := fs.String("temp-dir", "", "scratch directory for blob downloads, on the same filesystem as the output")
+	check := fs.Bool("check", false, "check each image can be pulled without downloading layers")
+	resumeOnly := fs.Bool("resume-only", false, "only finish pulls left in progress, of the given images or all")
+	fromLayout := fs.String("from-layout", "", "pull from this layout instead of the registry")
+	retryPolicy := retryFlags(fs)
+	registryConfig := registryFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		os.Exit(1)
 	}
 
-	if fs.NArg() < 1 {
+	retry, err := retryPolicy()
+	if err != nil {
+		log.Error("invalid retry flags", zap.Error(err))
+		os.Exit(1)
+	}
+
+	if fs.NArg() < 1 && !*resumeOnly {
 		log.Error("image reference required")
 		os.Exit(1)
 	}
 
-	image := fs.Arg(0)
+	config := registryConfig()
+	images := fs.Args()
+	for i, image := range images {
+		ref, err := config.ParseReference(image)
+		if err != nil {
+			log.Error("invalid image reference", zap.Error(err))
+			os.Exit(1)
+		}
+		images[i] = ref.String()
+	}
+
+	if err := validateOutputFormat(*outputFormat, images, *resumeOnly); err != nil {
+		log.Error("invalid output", zap.Error(err))
+		os.Exit(1)
+	}
+	dir := *output
+	if *outputFormat != outputOCI {
+		if filepath.Clean(*output) == filepath.Clean(*layoutDir) {
+			log.Error("-o must name the archive or rootfs to write, apart from the layout in -d")
+			os.Exit(1)
+		}
+		dir = *layoutDir
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
-	l, err := store.Open(*output)
+	l, err := store.Open(dir)
 	if err != nil {
 		log.Error("open layout failed", zap.Error(err))
 		os.Exit(1)
 	}
+	if err := l.SetTempDir(*tempDir); err != nil {
+		log.Error("invalid temp dir", zap.Error(err))
+		os.Exit(1)
+	}
 
-	client := oci.NewClient()
-	client.SetAuth(oci.NewRegistryAuth())
+	if *resumeOnly {
+		images, err = resumableImages(l, images)
+		if err != nil {
+			log.Error("read pull state failed", zap.Error(err))
+			os.Exit(1)
+		}
+		if len(images) == 0 {
+			log.Info("no pulls in progress")
+			return
+		}
+	}
+
+	// keep a connection per chunk worker across every concurrent pull
+	config.SetTransportOptions(oci.TransportOptions{MaxIdleConnsPerHost: *parallel * max(*jobs, 1)})
+
+	client := newRegistryClient(config, retry)
+
+	var source store.BlobClient = client
+	if *fromLayout != "" {
+		upstream, err := openSourceLayout(*fromLayout)
+		if err != nil {
+			log.Error("open source layout failed", zap.Error(err))
+			os.Exit(1)
+		}
+		source = store.NewLayoutSource(upstream)
+	}
+
+	if *check {
+		puller := store.NewPuller(l, source, log, store.PullOptions{
+			Retry:        &retry,
+			MaxLayers:    *maxLayers,
+			MaxTotalSize: *maxSize,
+		})
+		results, errs := puller.CheckAll(ctx, images, *jobs)
+		if !printCheck(os.Stdout, images, results, errs, *jsonOut) {
+			os.Exit(1)
+		}
+		return
+	}
 
 	log.Info("pulling",
-		zap.String("image", image),
+		zap.Strings("images", images),
 		zap.String("output", *output),
+		zap.String("format", *outputFormat),
 	)
 
+	// a terminal gets a live line per layer; otherwise a single image
+	// shows overall progress
+	liveView := !*silent && !*jsonOut && isTerminal(os.Stdout)
+	showProgress := !*silent && !*jsonOut && !liveView && len(images) == 1
+
 	var progress float64
 	var done bool
 	spinner := []rune{'|', '/', '-', '\\'}
 
 	// spinner goroutine
-	if !*silent {
+	if showProgress {
 		go func() {
 			i := 0
 			for !done {
@@ -159,42 +413,230 @@ func cmdPull(log logging.Logger, args []string) {
 	}
 
 	opts := store.PullOptions{
-		ChunkSize: *chunkSize,
-		Parallel:  *parallel,
-		OnProgress: func(current, total int, layerProgress float64) {
+		ChunkSize:      *chunkSize,
+		Parallel:       *parallel,
+		Retry:          &retry,
+		MaxConcurrency: *maxConcurrency,
+		MaxLayers:      *maxLayers,
+		MaxTotalSize:   *maxSize,
+	}
+	if showProgress {
+		opts.OnProgress = func(current, total int, layerProgress float64) {
 			progress = (float64(current) + layerProgress) / float64(total) * 100
-		},
+		}
+	}
+
+	var view *layerView
+	stopView := make(chan struct{})
+	viewDone := make(chan struct{})
+	if liveView {
+		view = newLayerView(os.Stdout)
+		opts.OnLayerProgress = view.update
+		go func() {
+			defer close(viewDone)
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stopView:
+					view.render()
+					return
+				case <-ticker.C:
+					view.render()
+				}
+			}
+		}()
 	}
 
-	puller := store.NewPuller(l, client, log, opts)
+	puller := store.NewPuller(l, source, log, opts)
 	start := time.Now()
 
-	result, err := puller.Pull(ctx, image)
+	results, err := puller.PullAll(ctx, images, *jobs)
 	done = true
-	if !*silent {
+	if view != nil {
+		close(stopView)
+		<-viewDone
+	}
+	if showProgress {
 		fmt.Printf("\r100%%    \n") // clear spinner and show complete
 	}
+	closeLayout(log, l)
+
+	elapsed := time.Since(start)
+	enc := json.NewEncoder(os.Stdout)
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+
+		if *jsonOut {
+			if err := enc.Encode(newPullReport(images[i], result, elapsed)); err != nil {
+				log.Error("write json result failed", zap.Error(err))
+			}
+			continue
+		}
+
+		fields := []zap.Field{
+			zap.String("image", images[i]),
+			zap.String("digest", result.Digest),
+			zap.Int("layers", result.Layers),
+			zap.Int64("total_bytes", result.TotalSize),
+			zap.Int64("downloaded_bytes", result.Downloaded),
+			zap.Int64("cached_bytes", result.Cached),
+			zap.Bool("unchanged", result.Unchanged),
+			zap.Duration("elapsed", elapsed),
+		}
+
+		if result.Downloaded > 0 {
+			speed := float64(result.Downloaded) / elapsed.Seconds()
+			fields = append(fields, zap.Float64("bytes_per_sec", speed))
+		}
+
+		log.Info("pull complete", fields...)
+	}
+
 	if err != nil {
 		log.Error("pull failed", zap.Error(err))
 		os.Exit(1)
 	}
 
-	elapsed := time.Since(start)
-	fields := []zap.Field{
-		zap.String("digest", result.Digest),
-		zap.Int("layers", result.Layers),
-		zap.Int64("total_bytes", result.TotalSize),
-		zap.Int64("downloaded_bytes", result.Downloaded),
-		zap.Int64("cached_bytes", result.Cached),
-		zap.Duration("elapsed", elapsed),
+	if *outputFormat != outputOCI {
+		if err := writePullOutput(l, *outputFormat, *output, images); err != nil {
+			log.Error("write output failed", zap.String("output", *output), zap.Error(err))
+			os.Exit(1)
+		}
+		log.Info("wrote output", zap.String("output", *output), zap.String("format", *outputFormat))
 	}
+}
+
+// pull --output-format values.
+const (
+	outputOCI = "oci"
+	outputTar = "tar"
+	outputDir = "dir"
+)
+
+// validateOutputFormat checks images can be written as format. A rootfs
+// holds a single image's filesystem, so dir takes exactly one.
+func validateOutputFormat(format string, images []string, resumeOnly bool) error {
+	switch format {
+	case outputOCI:
+		return nil
+	case outputTar, outputDir:
+	default:
+		return fmt.Errorf("unknown output format %q, want oci, tar or dir", format)
+	}
+	if resumeOnly {
+		return fmt.Errorf("--resume-only only writes an oci layout")
+	}
+	if format == outputDir && len(images) != 1 {
+		return fmt.Errorf("dir output extracts exactly one image, got %d", len(images))
+	}
+	return nil
+}
 
-	if result.Downloaded > 0 {
-		speed := float64(result.Downloaded) / elapsed.Seconds()
-		fields = append(fields, zap.Float64("bytes_per_sec", speed))
+// writePullOutput writes the images pulled into l to path as an OCI
+// archive or an extracted rootfs. An archive is written to a temporary
+// file and renamed into place, so path never holds a partial one.
+func writePullOutput(l *store.Layout, format, path string, images []string) error {
+	if format == outputDir {
+		return l.ExtractRootfs(images[0], path)
 	}
 
-	log.Info("pull complete", fields...)
+	f, err := os.CreateTemp(filepath.Dir(path), ".fray-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := l.Export(f, images...); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// checkReport is a result printed by pull --check --json.
+type checkReport struct {
+	Image string `json:"image"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	*store.CheckResult
+}
+
+// printCheck writes an OK or FAIL line per image, or one JSON object per
+// image, and reports whether every check passed.
+func printCheck(w io.Writer, images []string, results []*store.CheckResult, errs []error, jsonOut bool) bool {
+	enc := json.NewEncoder(w)
+	ok := true
+	for i, image := range images {
+		if errs[i] != nil {
+			ok = false
+		}
+
+		if jsonOut {
+			report := checkReport{Image: image, OK: errs[i] == nil, CheckResult: results[i]}
+			if errs[i] != nil {
+				report.Error = errs[i].Error()
+			}
+			_ = enc.Encode(report)
+			continue
+		}
+
+		if errs[i] != nil {
+			fmt.Fprintf(w, "FAIL  %s: %v\n", image, errs[i])
+			continue
+		}
+		rangeNote := ""
+		if !results[i].RangeSupported {
+			rangeNote = ", no range requests"
+		}
+		fmt.Fprintf(w, "OK    %s  %s (%d layers, %s%s)\n", image, results[i].Digest,
+			results[i].Layers, prune.HumanBytes(results[i].TotalSize), rangeNote)
+	}
+	return ok
+}
+
+// pullReport is the result printed by pull --json.
+type pullReport struct {
+	Image           string  `json:"image"`
+	Digest          string  `json:"digest"`
+	Platform        string  `json:"platform,omitempty"`
+	Layers          int     `json:"layers"`
+	TotalBytes      int64   `json:"total_bytes"`
+	DownloadedBytes int64   `json:"downloaded_bytes"`
+	CachedBytes     int64   `json:"cached_bytes"`
+	ElapsedSeconds  float64 `json:"elapsed_seconds"`
+	BytesPerSec     float64 `json:"bytes_per_sec"`
+	Unchanged       bool    `json:"unchanged"`
+}
+
+func newPullReport(image string, result *store.PullResult, elapsed time.Duration) pullReport {
+	report := pullReport{
+		Image:           image,
+		Digest:          result.Digest,
+		Platform:        result.Platform,
+		Layers:          result.Layers,
+		TotalBytes:      result.TotalSize,
+		DownloadedBytes: result.Downloaded,
+		CachedBytes:     result.Cached,
+		Unchanged:       result.Unchanged,
+		ElapsedSeconds:  elapsed.Seconds(),
+	}
+	if result.Downloaded > 0 && elapsed > 0 {
+		report.BytesPerSec = float64(result.Downloaded) / elapsed.Seconds()
+	}
+	return report
 }
 
 func cmdProxy(args []string) {
@@ -207,6 +649,19 @@ func cmdProxy(args []string) {
 	logLevel := fs.String("log-level", "info", "log level")
 	logMaxSize := fs.Int("log-max-size", 100, "max log file size in MB")
 	logMaxBackups := fs.Int("log-max-backups", 3, "max rotated log files")
+	writable := fs.Bool("writable", false, "accept pushes and store them locally")
+	forward := fs.Bool("forward-pushes", false, "also push accepted images upstream (implies --writable)")
+	manifestTTL := fs.Duration("manifest-ttl", 0, "re-resolve cached tags upstream after this long (0 never revalidates)")
+	tempDir := fs.String("temp-dir", "", "scratch directory for blob downloads, on the same filesystem as the data dir")
+	manifestCache := fs.Int("manifest-cache", proxy.DefaultManifestCacheEntries, "manifests kept in memory (negative disables)")
+	adminToken := fs.String("admin-token", os.Getenv("FRAY_ADMIN_TOKEN"), "bearer token required by /admin/ endpoints")
+	pushToken := fs.String("push-token", os.Getenv("FRAY_PUSH_TOKEN"), "token pushes must carry, required by --writable")
+	upstreamLayout := fs.String("upstream-layout", "", "pull from this layout instead of upstream registries")
+	selfTest := fs.Bool("selftest", false, "check this machine hashes correctly before serving")
+	serveIndexes := fs.Bool("serve-indexes", false, "answer tags with the upstream image index so clients choose their platform")
+	retryPolicy := retryFlags(fs)
+	registryConfig := registryFlags(fs)
+	httpServer := serverFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		os.Exit(1)
@@ -227,25 +682,117 @@ func cmdProxy(args []string) {
 	}
 	defer func() { _ = log.Sync() }()
 
+	if *selfTest {
+		if err := selftest.Run(); err != nil {
+			log.Error("refusing to serve", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	// anyone who can reach the proxy could otherwise push, and have it
+	// forwarded with the operator's credentials
+	if (*writable || *forward) && *pushToken == "" {
+		log.Error("--writable and --forward-pushes need --push-token")
+		os.Exit(1)
+	}
+
+	retry, err := retryPolicy()
+	if err != nil {
+		log.Error("invalid retry flags", zap.Error(err))
+		os.Exit(1)
+	}
+
 	l, err := store.Open(*dataDir)
 	if err != nil {
 		log.Error("open cache failed", zap.Error(err))
 		os.Exit(1)
 	}
+	if err := l.SetTempDir(*tempDir); err != nil {
+		log.Error("invalid temp dir", zap.Error(err))
+		os.Exit(1)
+	}
 
-	client := oci.NewClient()
-	client.SetAuth(oci.NewRegistryAuth())
+	// concurrent pulls share the pool, so it is never smaller than the default
+	config := registryConfig()
+	config.SetTransportOptions(oci.TransportOptions{MaxIdleConnsPerHost: max(*parallel, oci.DefaultMaxIdleConnsPerHost)})
+
+	client := newRegistryClient(config, retry)
+
+	var upstream store.BlobClient
+	if *upstreamLayout != "" {
+		source, err := openSourceLayout(*upstreamLayout)
+		if err != nil {
+			log.Error("open upstream layout failed", zap.Error(err))
+			os.Exit(1)
+		}
+		upstream = store.NewLayoutSource(source)
+	}
 
 	server := proxy.New(l, client, log, proxy.Options{
-		ChunkSize: *chunkSize,
-		Parallel:  *parallel,
+		ChunkSize:     *chunkSize,
+		Parallel:      *parallel,
+		Retry:         &retry,
+		Writable:      *writable || *forward,
+		ForwardPushes: *forward,
+		ManifestTTL:   *manifestTTL,
+		AdminToken:    *adminToken,
+		PushToken:     *pushToken,
+		Upstream:      upstream,
+		ServeIndexes:  *serveIndexes,
+
+		ManifestCacheEntries: *manifestCache,
 	})
 
-	httpServer := &http.Server{
-		Addr:    *listen,
-		Handler: server,
+	log.Info("proxy starting",
+		zap.String("listen", *listen),
+		zap.String("cache", *dataDir),
+		zap.Int("chunk_kb", *chunkSize/1024),
+		zap.Int("parallel", *parallel),
+	)
+
+	serve(log, httpServer(*listen, server))
+	closeLayout(log, l)
+}
+
+func cmdServeLayout(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("serve-layout", flag.ExitOnError)
+	listen := fs.String("l", ":5000", "listen address")
+	dir := fs.String("d", defaultCacheDir(), "layout directory")
+	manifestCache := fs.Int("manifest-cache", proxy.DefaultManifestCacheEntries, "manifests kept in memory (negative disables)")
+	registryConfig := registryFlags(fs)
+	httpServer := serverFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	l, err := store.Open(*dir)
+	if err != nil {
+		log.Error("open layout failed", zap.Error(err))
+		os.Exit(1)
 	}
 
+	// the client only normalizes references; nothing is fetched upstream
+	client := oci.NewClient()
+	client.SetConfig(registryConfig())
+
+	server := proxy.New(l, client, log, proxy.Options{
+		ReadOnly: true,
+
+		ManifestCacheEntries: *manifestCache,
+	})
+
+	log.Info("serving layout",
+		zap.String("listen", *listen),
+		zap.String("layout", *dir),
+	)
+
+	serve(log, httpServer(*listen, server))
+	closeLayout(log, l)
+}
+
+// serve runs httpServer until SIGINT or SIGTERM, then shuts it down.
+func serve(log logging.Logger, httpServer *http.Server) {
 	done := make(chan struct{})
 	go func() {
 		sigCh := make(chan os.Signal, 1)
@@ -262,13 +809,6 @@ func cmdProxy(args []string) {
 		close(done)
 	}()
 
-	log.Info("proxy starting",
-		zap.String("listen", *listen),
-		zap.String("cache", *dataDir),
-		zap.Int("chunk_kb", *chunkSize/1024),
-		zap.Int("parallel", *parallel),
-	)
-
 	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
 		log.Error("server error", zap.Error(err))
 		os.Exit(1)
@@ -277,6 +817,22 @@ func cmdProxy(args []string) {
 	<-done
 }
 
+// openSourceLayout opens an existing layout to pull from. Unlike
+// store.Open it never creates one, so a mistyped path fails.
+func openSourceLayout(dir string) (*store.Layout, error) {
+	if _, err := os.Stat(filepath.Join(dir, store.LayoutFile)); err != nil {
+		return nil, fmt.Errorf("not an OCI layout: %w", err)
+	}
+	return store.Open(dir)
+}
+
+// closeLayout flushes l's writes to disk before the command exits.
+func closeLayout(log logging.Logger, l *store.Layout) {
+	if err := l.Close(); err != nil {
+		log.Error("close layout failed", zap.Error(err))
+	}
+}
+
 func cmdStatus(log logging.Logger, args []string) {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 	if err := fs.Parse(args); err != nil {
@@ -294,7 +850,7 @@ func cmdStatus(log logging.Logger, args []string) {
 		os.Exit(1)
 	}
 
-	index, err := l.GetIndex()
+	images, err := l.Images()
 	if err != nil {
 		log.Error("read index failed", zap.Error(err))
 		os.Exit(1)
@@ -308,28 +864,264 @@ func cmdStatus(log logging.Logger, args []string) {
 
 	log.Info("layout",
 		zap.String("path", dir),
-		zap.Int("images", len(index.Manifests)),
+		zap.Int("images", len(images)),
 		zap.Int("blobs", stats.BlobCount),
 		zap.Int64("total_bytes", stats.TotalSize),
 	)
 
-	for _, m := range index.Manifests {
-		name := m.Annotations["org.opencontainers.image.ref.name"]
+	for _, img := range images {
+		name := img.Ref
 		if name == "" {
 			name = "(untagged)"
 		}
 		log.Info("image",
 			zap.String("ref", name),
-			zap.String("digest", m.Digest),
-			zap.Int64("size", m.Size),
+			zap.String("digest", img.Digest),
+			zap.Int64("size", img.Size),
+			zap.Time("last_access", img.LastAccess),
 		)
 	}
 
-	stateDir := filepath.Join(dir, ".fray")
-	if entries, err := os.ReadDir(stateDir); err == nil && len(entries) > 0 {
-		for _, e := range entries {
-			log.Info("in_progress", zap.String("state", e.Name()))
+	layers, err := l.InProgress("")
+	if err != nil {
+		log.Error("read pull state failed", zap.Error(err))
+		os.Exit(1)
+	}
+	logInProgress(log, layers)
+}
+
+// logInProgress logs each interrupted layer download and how far it got.
+func logInProgress(log logging.Logger, layers []store.InProgressLayer) {
+	for _, layer := range layers {
+		image := layer.Image
+		if image == "" {
+			image = "(unknown)"
+		}
+		log.Info("in_progress",
+			zap.String("image", image),
+			zap.String("layer", layer.Digest),
+			zap.String("progress", fmt.Sprintf("%d%%", int(layer.Progress()*100))),
+			zap.Int64("total_bytes", layer.TotalSize),
+			zap.Bool("resumable", layer.Resumable),
+		)
+	}
+}
+
+// resumableImages returns the images with pulls in progress in l, limited
+// to requested when any are given.
+func resumableImages(l *store.Layout, requested []string) ([]string, error) {
+	layers, err := l.InProgress("")
+	if err != nil {
+		return nil, err
+	}
+	images := store.InProgressImages(layers)
+	if len(requested) == 0 {
+		return images, nil
+	}
+	return slices.DeleteFunc(slices.Clone(requested), func(image string) bool {
+		return !slices.Contains(images, image)
+	}), nil
+}
+
+func cmdTag(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	dir := fs.String("d", defaultCacheDir(), "layout directory")
+	force := fs.Bool("f", false, "overwrite an existing reference")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 2 {
+		log.Error("source and target references required")
+		os.Exit(1)
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	l, err := store.Open(*dir)
+	if err != nil {
+		log.Error("open layout failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	if err := l.CopyImage(src, dst, *force); err != nil {
+		log.Error("tag failed", zap.Error(err))
+		os.Exit(1)
+	}
+	closeLayout(log, l)
+
+	log.Info("tagged", zap.String("source", src), zap.String("target", dst))
+}
+
+func cmdReindex(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	dir := fs.String("d", defaultCacheDir(), "layout directory")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	l, err := store.Open(*dir)
+	if err != nil {
+		log.Error("open layout failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	added, err := l.Reindex()
+	if err != nil {
+		log.Error("reindex failed", zap.Error(err))
+		os.Exit(1)
+	}
+	closeLayout(log, l)
+
+	log.Info("reindexed", zap.String("path", *dir), zap.Int("recovered", added))
+}
+
+func cmdImport(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("d", defaultCacheDir(), "layout directory")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	src := defaultStorageDir()
+	if fs.NArg() > 0 {
+		src = fs.Arg(0)
+	}
+
+	l, err := store.Open(*dir)
+	if err != nil {
+		log.Error("open layout failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	result, err := l.Import(src)
+	if err != nil {
+		log.Error("import failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// imported manifests are found by digest until tagged
+	added, err := l.Reindex()
+	if err != nil {
+		log.Error("reindex failed", zap.Error(err))
+		os.Exit(1)
+	}
+	closeLayout(log, l)
+
+	log.Info("imported",
+		zap.String("source", src),
+		zap.Int("blobs", result.Blobs),
+		zap.Int("linked", result.Linked),
+		zap.Int64("bytes", result.Bytes),
+		zap.Int("existing", result.Existing),
+		zap.Int("skipped", result.Skipped),
+		zap.Int("images", added))
+}
+
+func cmdInspect(log logging.Logger, args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	dir := fs.String("d", defaultCacheDir(), "layout directory")
+	jsonOut := fs.Bool("json", false, "print the raw manifest and config as JSON")
+	remote := fs.Bool("remote", false, "fetch the manifest and config from the registry if the image isn't cached")
+	registryConfig := registryFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 1 {
+		log.Error("image reference required")
+		os.Exit(1)
+	}
+	ref := fs.Arg(0)
+
+	l, err := store.Open(*dir)
+	if err != nil {
+		log.Error("open layout failed", zap.Error(err))
+		os.Exit(1)
+	}
+
+	img, err := l.Inspect(ref)
+	if errors.Is(err, store.ErrImageNotFound) && *remote {
+		client := oci.NewClient()
+		client.SetConfig(registryConfig())
+		client.SetAuth(oci.NewRegistryAuth())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		img, err = store.InspectRemote(ctx, client, ref)
+	}
+	if err != nil {
+		log.Error("inspect failed", zap.String("image", ref), zap.Error(err))
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		report := inspectReport{Manifest: img.RawManifest, Config: img.RawConfig}
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Error("write json failed", zap.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	printInspect(os.Stdout, img)
+}
+
+// inspectReport is the output of inspect --json.
+type inspectReport struct {
+	Manifest json.RawMessage `json:"manifest"`
+	Config   json.RawMessage `json:"config"`
+}
+
+func printInspect(w io.Writer, img *store.ImageInspect) {
+	config := img.Config.Config
+
+	fmt.Fprintf(w, "Ref:         %s\n", img.Ref)
+	if img.Digest != "" {
+		fmt.Fprintf(w, "Digest:      %s\n", img.Digest)
+	}
+	if platform := img.Config.Platform(); platform != "" {
+		fmt.Fprintf(w, "Platform:    %s\n", platform)
+	}
+	if img.Manifest.ArtifactType != "" {
+		fmt.Fprintf(w, "Artifact:    %s\n", img.Manifest.ArtifactType)
+	}
+	if img.Config.Created != nil {
+		fmt.Fprintf(w, "Created:     %s\n", img.Config.Created.UTC().Format(time.RFC3339))
+	}
+	if len(config.Entrypoint) > 0 {
+		fmt.Fprintf(w, "Entrypoint:  %s\n", strings.Join(config.Entrypoint, " "))
+	}
+	if len(config.Cmd) > 0 {
+		fmt.Fprintf(w, "Cmd:         %s\n", strings.Join(config.Cmd, " "))
+	}
+	if config.WorkingDir != "" {
+		fmt.Fprintf(w, "WorkingDir:  %s\n", config.WorkingDir)
+	}
+	if config.User != "" {
+		fmt.Fprintf(w, "User:        %s\n", config.User)
+	}
+	if len(config.Env) > 0 {
+		fmt.Fprintf(w, "Env:\n")
+		for _, env := range config.Env {
+			fmt.Fprintf(w, "  %s\n", env)
+		}
+	}
+
+	var total int64
+	for _, layer := range img.Manifest.Layers {
+		total += layer.Size
+	}
+	fmt.Fprintf(w, "Layers:      %d (%s)\n", len(img.Manifest.Layers), prune.HumanBytes(total))
+	for _, layer := range img.Manifest.Layers {
+		if img.Manifest.IsArtifact() {
+			fmt.Fprintf(w, "  %s  %s  %s\n", layer.Digest, prune.HumanBytes(layer.Size), layer.MediaType)
+			continue
 		}
+		fmt.Fprintf(w, "  %s  %s\n", layer.Digest, prune.HumanBytes(layer.Size))
 	}
 }
 
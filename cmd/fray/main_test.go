@@ -0,0 +1,483 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/merkle"
+	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+func TestRetryFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    oci.RetryPolicy
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			args: nil,
+			want: oci.DefaultRetryPolicy(),
+		},
+		{
+			name: "custom",
+			args: []string{"--retries", "7", "--retry-base-delay", "250ms", "--retry-max-delay", "10s", "--retry-jitter", "full"},
+			want: oci.RetryPolicy{MaxRetries: 7, BaseDelay: 250 * time.Millisecond, MaxDelay: 10 * time.Second, Jitter: oci.JitterFull},
+		},
+		{
+			name: "no retries",
+			args: []string{"--retries", "0"},
+			want: oci.RetryPolicy{MaxRetries: 0, BaseDelay: oci.DefaultRetryBaseDelay, MaxDelay: oci.DefaultRetryMaxDelay, Jitter: oci.DefaultRetryJitter},
+		},
+		{
+			name: "retries off",
+			args: []string{"--retries", "0", "--retry-base-delay", "0", "--retry-max-delay", "0", "--retry-jitter", "none"},
+			want: oci.RetryPolicy{},
+		},
+		{
+			name: "no jitter",
+			args: []string{"--retry-jitter", "none"},
+			want: oci.RetryPolicy{MaxRetries: oci.DefaultMaxRetries, BaseDelay: oci.DefaultRetryBaseDelay, MaxDelay: oci.DefaultRetryMaxDelay},
+		},
+		{
+			name:    "unknown jitter",
+			args:    []string{"--retry-jitter", "decorrelated"},
+			wantErr: true,
+		},
+		{
+			name:    "negative retries",
+			args:    []string{"--retries", "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "negative delay",
+			args:    []string{"--retry-base-delay", "-1s"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			retryPolicy := retryFlags(fs)
+			require.NoError(fs.Parse(tt.args))
+
+			policy, err := retryPolicy()
+			if tt.wantErr {
+				require.True(errors.Is(err, oci.ErrInvalidRetryPolicy))
+				return
+			}
+			require.NoError(err)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+
+			client := newRegistryClient(oci.NewRegistryConfig(), policy)
+			require.Equal(tt.want, client.RetryPolicy())
+			puller := store.NewPuller(l, client, logging.Nop(), store.PullOptions{Retry: &policy})
+			require.Equal(tt.want, puller.RetryPolicy())
+			require.Equal(tt.want, store.New(t.TempDir(), store.WithRetryPolicy(policy)).RetryPolicy())
+		})
+	}
+}
+
+func TestPullReportJSON(t *testing.T) {
+	require := require.New(t)
+
+	result := &store.PullResult{
+		Digest:     "sha256:" + strings.Repeat("a", 64),
+		Platform:   "linux/arm64",
+		Layers:     3,
+		TotalSize:  3000,
+		Downloaded: 2000,
+		Cached:     1000,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(json.NewEncoder(&buf).Encode(newPullReport("quay.io/test/app:v1", result, 2*time.Second)))
+
+	var got map[string]any
+	require.NoError(json.Unmarshal(buf.Bytes(), &got))
+	require.Equal(map[string]any{
+		"image":            "quay.io/test/app:v1",
+		"digest":           result.Digest,
+		"platform":         "linux/arm64",
+		"layers":           float64(3),
+		"total_bytes":      float64(3000),
+		"downloaded_bytes": float64(2000),
+		"cached_bytes":     float64(1000),
+		"elapsed_seconds":  float64(2),
+		"bytes_per_sec":    float64(1000),
+		"unchanged":        false,
+	}, got)
+}
+
+func TestPrintCheck(t *testing.T) {
+	images := []string{"quay.io/test/app:v1", "quay.io/test/private:v1"}
+	results := []*store.CheckResult{{Digest: "sha256:" + strings.Repeat("a", 64), Layers: 2, TotalSize: 2048}, nil}
+	errs := []error{nil, fmt.Errorf("get manifest: %w", oci.ErrUnauthorized)}
+
+	tests := []struct {
+		name    string
+		jsonOut bool
+		want    string
+	}{
+		{
+			name: "text",
+			want: "OK    quay.io/test/app:v1  sha256:" + strings.Repeat("a", 64) + " (2 layers, 2.0 KB, no range requests)\n" +
+				"FAIL  quay.io/test/private:v1: get manifest: unauthorized\n",
+		},
+		{
+			name:    "json",
+			jsonOut: true,
+			want: `{"image":"quay.io/test/app:v1","ok":true,"digest":"sha256:` + strings.Repeat("a", 64) + `","layers":2,"total_bytes":2048,"range_supported":false}` + "\n" +
+				`{"image":"quay.io/test/private:v1","ok":false,"error":"get manifest: unauthorized"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var buf bytes.Buffer
+			require.False(printCheck(&buf, images, results, errs, tt.jsonOut))
+			require.Equal(tt.want, buf.String())
+		})
+	}
+}
+
+func TestPrintDoctor(t *testing.T) {
+	blob := "sha256:" + strings.Repeat("a", 64)
+
+	tests := []struct {
+		name    string
+		caps    oci.Capabilities
+		jsonOut bool
+		want    string
+	}{
+		{
+			name: "sampled",
+			caps: oci.Capabilities{Registry: "quay.io", V2: true, AuthScheme: "bearer", Repository: "test/app", Blob: blob, Head: true, Range: true},
+			want: "Registry:   quay.io\nv2:         yes\nAuth:       bearer\nSample:     test/app@" + blob + "\n" +
+				"HEAD:       yes\nRange:      yes\nReferrers:  no\n",
+		},
+		{
+			name: "no repository",
+			caps: oci.Capabilities{Registry: "localhost:5000", V2: true},
+			want: "Registry:   localhost:5000\nv2:         yes\nAuth:       none\nBlobs:      skipped, no repository given\n",
+		},
+		{
+			name: "not a registry",
+			caps: oci.Capabilities{Registry: "example.com"},
+			want: "Registry:   example.com\nv2:         no\n",
+		},
+		{
+			name:    "json",
+			caps:    oci.Capabilities{Registry: "quay.io", V2: true, AuthScheme: "bearer", Repository: "test/app", Blob: blob, Referrers: true},
+			jsonOut: true,
+			want:    `{"registry":"quay.io","v2":true,"auth_scheme":"bearer","repository":"test/app","blob":"` + blob + `","head":false,"range":false,"referrers":true}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printDoctor(&buf, &tt.caps, tt.jsonOut)
+			require.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestPrintInspect(t *testing.T) {
+	require := require.New(t)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	img := &store.ImageInspect{
+		Ref:    "quay.io/test/app:v1",
+		Digest: "sha256:" + strings.Repeat("a", 64),
+		Manifest: oci.Manifest{Layers: []oci.Blob{
+			{Digest: "sha256:" + strings.Repeat("b", 64), Size: 512},
+			{Digest: "sha256:" + strings.Repeat("c", 64), Size: 2048},
+		}},
+	}
+	img.Config.Created = &created
+	img.Config.OS = "linux"
+	img.Config.Architecture = "amd64"
+	img.Config.Config.Entrypoint = []string{"/app", "--serve"}
+	img.Config.Config.Env = []string{"PATH=/usr/bin"}
+
+	var buf bytes.Buffer
+	printInspect(&buf, img)
+
+	require.Equal(`Ref:         quay.io/test/app:v1
+Digest:      sha256:`+strings.Repeat("a", 64)+`
+Platform:    linux/amd64
+Created:     2024-05-01T12:00:00Z
+Entrypoint:  /app --serve
+Env:
+  PATH=/usr/bin
+Layers:      2 (2.5 KB)
+  sha256:`+strings.Repeat("b", 64)+`  512 B
+  sha256:`+strings.Repeat("c", 64)+`  2.0 KB
+`, buf.String())
+}
+
+func TestLayerView(t *testing.T) {
+	require := require.New(t)
+
+	first := "sha256:" + strings.Repeat("a", 64)
+	second := "sha256:" + strings.Repeat("b", 64)
+
+	var buf bytes.Buffer
+	view := newLayerView(&buf)
+
+	view.update(store.LayerProgress{Digest: first, CompletedBytes: 512, TotalBytes: 2048})
+	view.update(store.LayerProgress{Digest: second, Index: 1, CompletedBytes: 0, TotalBytes: 1024})
+	view.render()
+	require.Equal("\r\x1b[Kaaaaaaaaaaaa:  25%  512 B/2.0 KB\n"+
+		"\r\x1b[Kbbbbbbbbbbbb:   0%  0 B/1.0 KB\n", buf.String())
+
+	// redraws move back over the previous frame, keeping layer order
+	buf.Reset()
+	view.update(store.LayerProgress{Digest: second, Index: 1, CompletedBytes: 1024, TotalBytes: 1024})
+	view.update(store.LayerProgress{Digest: first, CompletedBytes: 1024, TotalBytes: 2048})
+	view.render()
+	require.Equal("\x1b[2A"+
+		"\r\x1b[Kaaaaaaaaaaaa:  50%  1.0 KB/2.0 KB\n"+
+		"\r\x1b[Kbbbbbbbbbbbb: complete  1.0 KB\n", buf.String())
+
+	// the config is labelled, so it isn't taken for a layer
+	buf.Reset()
+	view.update(store.LayerProgress{Digest: "sha256:" + strings.Repeat("c", 64), Index: -1, Config: true, CompletedBytes: 100, TotalBytes: 100})
+	view.render()
+	require.Equal("\x1b[2A"+
+		"\r\x1b[Kaaaaaaaaaaaa:  50%  1.0 KB/2.0 KB\n"+
+		"\r\x1b[Kbbbbbbbbbbbb: complete  1.0 KB\n"+
+		"\r\x1b[Kcccccccccccc (config): complete  100 B\n", buf.String())
+}
+
+func TestServerFlagsSlowHeader(t *testing.T) {
+	require := require.New(t)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	httpServer := serverFlags(fs)
+	require.NoError(fs.Parse([]string{"--read-header-timeout", "100ms"}))
+
+	srv := httpServer("", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	require.Equal(100*time.Millisecond, srv.ReadHeaderTimeout)
+	require.Equal(defaultIdleTimeout, srv.IdleTimeout)
+	require.Zero(srv.WriteTimeout)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(err)
+	defer conn.Close()
+
+	// the request line arrives but the headers never finish
+	_, err = conn.Write([]byte("GET /v2/ HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(err)
+
+	start := time.Now()
+	require.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(err, io.EOF)
+	require.Less(time.Since(start), 2*time.Second)
+}
+
+func TestResumeInProgress(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	l, err := store.Open(dir)
+	require.NoError(err)
+	require.NoError(os.MkdirAll(filepath.Join(dir, ".fray"), 0755))
+
+	states := []store.PullState{
+		{Image: "quay.io/test/a:v1", Digest: "sha256:" + strings.Repeat("a", 64)},
+		{Image: "quay.io/test/b:v1", Digest: "sha256:" + strings.Repeat("b", 64)},
+		{Image: "quay.io/test/a:v1", Digest: "sha256:" + strings.Repeat("c", 64)},
+	}
+	for _, state := range states {
+		tree := merkle.New(4096, 1024)
+		require.NoError(tree.SetChunk(0, make([]byte, 1024)))
+		state.State = *tree.Serialize()
+		path := filepath.Join(dir, ".fray", strings.TrimPrefix(state.Digest, "sha256:")+store.StateFileExt)
+		require.NoError(merkle.WriteJSONFile(path, &state))
+	}
+
+	images, err := resumableImages(l, nil)
+	require.NoError(err)
+	require.ElementsMatch([]string{"quay.io/test/a:v1", "quay.io/test/b:v1"}, images)
+
+	images, err = resumableImages(l, []string{"quay.io/test/b:v1", "quay.io/test/other:v1"})
+	require.NoError(err)
+	require.Equal([]string{"quay.io/test/b:v1"}, images)
+
+	layers, err := l.InProgress("")
+	require.NoError(err)
+	core, logs := observer.New(zap.InfoLevel)
+	logInProgress(logging.Wrap(zap.New(core)), layers)
+
+	require.Equal(3, logs.Len())
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		require.Equal("in_progress", entry.Message)
+		require.Equal("25%", fields["progress"])
+		require.Equal(false, fields["resumable"])
+		require.Contains([]string{"quay.io/test/a:v1", "quay.io/test/b:v1"}, fields["image"])
+	}
+}
+
+// newSmallImage stores a linux image with one layer holding etc/hostname
+// and tags it as ref.
+func newSmallImage(t *testing.T, l *store.Layout, ref string) {
+	t.Helper()
+	require := require.New(t)
+
+	write := func(data []byte) string {
+		d := digest.FromBytes(data).String()
+		_, err := l.WriteBlobVerified(d, bytes.NewReader(data))
+		require.NoError(err)
+		return d
+	}
+
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gz)
+	require.NoError(tw.WriteHeader(&tar.Header{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("small"))
+	require.NoError(err)
+	require.NoError(tw.Close())
+	require.NoError(gz.Close())
+
+	config := []byte(`{"os":"linux","architecture":"amd64"}`)
+	manifest, err := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config:        oci.Blob{Digest: write(config), Size: int64(len(config))},
+		Layers:        []oci.Blob{{MediaType: store.MediaTypeLayerGzip, Digest: write(layer.Bytes()), Size: int64(layer.Len())}},
+	})
+	require.NoError(err)
+	require.NoError(l.SetTag(ref, store.Descriptor{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Digest:    write(manifest),
+		Size:      int64(len(manifest)),
+	}))
+}
+
+func TestWritePullOutput(t *testing.T) {
+	const image = "quay.io/test/small:v1"
+
+	t.Run("tar", func(t *testing.T) {
+		require := require.New(t)
+
+		l, err := store.Open(t.TempDir())
+		require.NoError(err)
+		newSmallImage(t, l, image)
+
+		path := filepath.Join(t.TempDir(), "small.tar")
+		require.NoError(writePullOutput(l, outputTar, path, []string{image}))
+
+		f, err := os.Open(path)
+		require.NoError(err)
+		defer f.Close()
+
+		names := map[string][]byte{}
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(err)
+			data, err := io.ReadAll(tr)
+			require.NoError(err)
+			names[hdr.Name] = data
+		}
+		require.Contains(names, store.LayoutFile)
+
+		var index store.Index
+		require.NoError(json.Unmarshal(names[store.IndexFile], &index))
+		require.Len(index.Manifests, 1)
+		require.Equal(image, index.Manifests[0].Annotations[store.AnnotationRefName])
+		// the manifest, config and layer
+		blobs := 0
+		for name := range names {
+			if strings.HasPrefix(name, "blobs/sha256/") && name != "blobs/sha256/" {
+				blobs++
+			}
+		}
+		require.Equal(3, blobs)
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		require := require.New(t)
+
+		l, err := store.Open(t.TempDir())
+		require.NoError(err)
+		newSmallImage(t, l, image)
+
+		rootfs := filepath.Join(t.TempDir(), "rootfs")
+		require.NoError(writePullOutput(l, outputDir, rootfs, []string{image}))
+
+		data, err := os.ReadFile(filepath.Join(rootfs, "etc/hostname"))
+		require.NoError(err)
+		require.Equal("small", string(data))
+	})
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		images     []string
+		resumeOnly bool
+		wantErr    bool
+	}{
+		{"oci with many images", outputOCI, []string{"a", "b"}, false, false},
+		{"oci resuming", outputOCI, nil, true, false},
+		{"tar with many images", outputTar, []string{"a", "b"}, false, false},
+		{"dir with one image", outputDir, []string{"a"}, false, false},
+		{"dir with many images", outputDir, []string{"a", "b"}, false, true},
+		{"tar resuming", outputTar, nil, true, true},
+		{"unknown format", "zip", []string{"a"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOutputFormat(tt.format, tt.images, tt.resumeOnly)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
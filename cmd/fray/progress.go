@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/hexfusion/fray/internal/prune"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+// layerView draws one line per layer and redraws them in place, like
+// docker pull.
+type layerView struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	order  []string
+	layers map[string]store.LayerProgress
+	// drawn is how many lines the last render wrote.
+	drawn int
+}
+
+func newLayerView(w io.Writer) *layerView {
+	return &layerView{w: w, layers: make(map[string]store.LayerProgress)}
+}
+
+func (v *layerView) update(p store.LayerProgress) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.layers[p.Digest]; !ok {
+		v.order = append(v.order, p.Digest)
+	}
+	v.layers[p.Digest] = p
+}
+
+// render moves the cursor back over the previous frame and rewrites it.
+func (v *layerView) render() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var b strings.Builder
+	if v.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", v.drawn)
+	}
+	for _, digest := range v.order {
+		b.WriteString("\r\x1b[K")
+		b.WriteString(layerLine(v.layers[digest]))
+		b.WriteByte('\n')
+	}
+	v.drawn = len(v.order)
+
+	_, _ = io.WriteString(v.w, b.String())
+}
+
+func layerLine(p store.LayerProgress) string {
+	id := p.Digest
+	if _, hex, ok := strings.Cut(id, ":"); ok && len(hex) >= 12 {
+		id = hex[:12]
+	}
+	if p.Config {
+		id += " (config)"
+	}
+	if p.CompletedBytes >= p.TotalBytes {
+		return fmt.Sprintf("%s: complete  %s", id, prune.HumanBytes(p.TotalBytes))
+	}
+	return fmt.Sprintf("%s: %3d%%  %s/%s", id, p.CompletedBytes*100/p.TotalBytes,
+		prune.HumanBytes(p.CompletedBytes), prune.HumanBytes(p.TotalBytes))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readPassword reads a line from stdin with terminal echo disabled. If stdin
+// is not a terminal the line is read as-is.
+func readPassword(r *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return readLine(r)
+	}
+
+	noEcho := *old
+	noEcho.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return readLine(r)
+	}
+	defer func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, old) }()
+
+	return readLine(r)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
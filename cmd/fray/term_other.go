@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// readPassword reads a line from stdin. Echo is only suppressed on Linux.
+func readPassword(r *bufio.Reader) (string, error) {
+	return readLine(r)
+}
+
+// isTerminal reports false; the live layer view is only drawn on Linux.
+func isTerminal(*os.File) bool {
+	return false
+}
@@ -117,16 +117,19 @@ func generateMessageValidation(g *protogen.GeneratedFile, msg *protogen.Message,
 	msgName := msg.GoIdent.GoName
 	msgRules := getMessageRules(msg)
 
-	g.P("// Validate checks validation rules for ", msgName, ".")
+	g.P("// Validate checks validation rules for ", msgName, ". A failure is a")
+	g.P("// *cel.ValidationResult listing every violated field.")
 	g.P("func (x *", msgName, ") Validate() error {")
 	g.P("	if x == nil {")
 	g.P("		return nil")
 	g.P("	}")
 	g.P()
+	g.P("	var v cel.ValidationResult")
+	g.P()
 
 	if msgRules != nil && len(msgRules.Validate) > 0 {
 		for _, rule := range msgRules.Validate {
-			generateCELValidateCheck(g, rule, msgName)
+			generateCELValidateCheck(g, rule, "")
 		}
 		g.P()
 	}
@@ -140,7 +143,7 @@ func generateMessageValidation(g *protogen.GeneratedFile, msg *protogen.Message,
 		generateNestedValidation(g, field)
 	}
 
-	g.P("	return nil")
+	g.P("	return v.Err()")
 	g.P("}")
 	g.P()
 
@@ -267,20 +270,23 @@ func generateFieldValidation(g *protogen.GeneratedFile, field *protogen.Field, p
 	fieldAccess := "x." + fieldName
 	kind := field.Desc.Kind().String()
 
+	// a missing required field reports only that it is required
+	required := false
 	if rules.Required {
 		switch kind {
 		case "string":
 			g.P(`	if `, fieldAccess, ` == "" {`)
-			g.P(`		return fmt.Errorf("`, fieldName, ` is required")`)
-			g.P(`	}`)
+			required = true
 		case "bytes":
 			g.P(`	if len(`, fieldAccess, `) == 0 {`)
-			g.P(`		return fmt.Errorf("`, fieldName, ` is required")`)
-			g.P(`	}`)
+			required = true
 		case "message":
 			g.P(`	if `, fieldAccess, ` == nil {`)
-			g.P(`		return fmt.Errorf("`, fieldName, ` is required")`)
-			g.P(`	}`)
+			required = true
+		}
+		if required {
+			g.P(`		v.Add("`, fieldName, `", "is required")`)
+			g.P(`	} else {`)
 		}
 	}
 
@@ -289,50 +295,50 @@ func generateFieldValidation(g *protogen.GeneratedFile, field *protogen.Field, p
 		if formatFunc != "" {
 			if !rules.Required && kind == "string" {
 				g.P(`	if `, fieldAccess, ` != "" {`)
-				g.P(`		if err := cel.`, formatFunc, `(`, fieldAccess, `); err != nil {`)
-				g.P(`			return fmt.Errorf("`, fieldName, `: %w", err)`)
-				g.P(`		}`)
+				g.P(`		v.Check("`, fieldName, `", cel.`, formatFunc, `(`, fieldAccess, `))`)
 				g.P(`	}`)
 			} else {
-				g.P(`	if err := cel.`, formatFunc, `(`, fieldAccess, `); err != nil {`)
-				g.P(`		return fmt.Errorf("`, fieldName, `: %w", err)`)
-				g.P(`	}`)
+				g.P(`	v.Check("`, fieldName, `", cel.`, formatFunc, `(`, fieldAccess, `))`)
 			}
 		}
 	}
 
 	if rules.Min != nil {
 		g.P(`	if `, fieldAccess, ` < `, *rules.Min, ` {`)
-		g.P(`		return fmt.Errorf("`, fieldName, ` must be >= %d", `, *rules.Min, `)`)
+		g.P(`		v.Add("`, fieldName, `", "must be >= `, *rules.Min, `")`)
 		g.P(`	}`)
 	}
 	if rules.Max != nil {
 		g.P(`	if `, fieldAccess, ` > `, *rules.Max, ` {`)
-		g.P(`		return fmt.Errorf("`, fieldName, ` must be <= %d", `, *rules.Max, `)`)
+		g.P(`		v.Add("`, fieldName, `", "must be <= `, *rules.Max, `")`)
 		g.P(`	}`)
 	}
 
 	if rules.MinLen != nil {
 		g.P(`	if len(`, fieldAccess, `) < `, *rules.MinLen, ` {`)
-		g.P(`		return fmt.Errorf("`, fieldName, ` must have at least %d elements/characters", `, *rules.MinLen, `)`)
+		g.P(`		v.Add("`, fieldName, `", "must have at least `, *rules.MinLen, ` elements/characters")`)
 		g.P(`	}`)
 	}
 	if rules.MaxLen != nil {
 		g.P(`	if len(`, fieldAccess, `) > `, *rules.MaxLen, ` {`)
-		g.P(`		return fmt.Errorf("`, fieldName, ` must have at most %d elements/characters", `, *rules.MaxLen, `)`)
+		g.P(`		v.Add("`, fieldName, `", "must have at most `, *rules.MaxLen, ` elements/characters")`)
 		g.P(`	}`)
 	}
 
 	if rules.Pattern != nil && *rules.Pattern != "" {
 		varName := patternVarName(field)
 		g.P(`	if !`, varName, `.MatchString(`, fieldAccess, `) {`)
-		g.P(`		return fmt.Errorf("`, fieldName, ` must match pattern")`)
+		g.P(`		v.Add("`, fieldName, `", "must match pattern")`)
 		g.P(`	}`)
 	}
 
 	for _, rule := range rules.Validate {
 		generateCELValidateCheck(g, rule, fieldName)
 	}
+
+	if required {
+		g.P(`	}`)
+	}
 }
 
 func generateNestedValidation(g *protogen.GeneratedFile, field *protogen.Field) {
@@ -350,16 +356,12 @@ func generateNestedValidation(g *protogen.GeneratedFile, field *protogen.Field)
 	if field.Desc.IsList() {
 		// Repeated message field
 		g.P(`	for i, item := range `, fieldAccess, ` {`)
-		g.P(`		if err := item.Validate(); err != nil {`)
-		g.P(`			return fmt.Errorf("`, fieldName, `[%d]: %w", i, err)`)
-		g.P(`		}`)
+		g.P(`		v.Nested(fmt.Sprintf("`, fieldName, `[%d]", i), item.Validate())`)
 		g.P(`	}`)
 	} else {
 		// Singular message field
 		g.P(`	if `, fieldAccess, ` != nil {`)
-		g.P(`		if err := `, fieldAccess, `.Validate(); err != nil {`)
-		g.P(`			return fmt.Errorf("`, fieldName, `: %w", err)`)
-		g.P(`		}`)
+		g.P(`		v.Nested("`, fieldName, `", `, fieldAccess, `.Validate())`)
 		g.P(`	}`)
 	}
 }
@@ -392,7 +394,9 @@ func collectMessagePatterns(msg *protogen.Message, patterns []patternInfo) []pat
 	return patterns
 }
 
-func generateCELValidateCheck(g *protogen.GeneratedFile, rule *celext.Rule, context string) {
+// generateCELValidateCheck records a failed rule against field, or against
+// the message when field is empty.
+func generateCELValidateCheck(g *protogen.GeneratedFile, rule *celext.Rule, field string) {
 	if rule.Expr == "" {
 		return
 	}
@@ -408,7 +412,7 @@ func generateCELValidateCheck(g *protogen.GeneratedFile, rule *celext.Rule, cont
 	expr = strings.ReplaceAll(expr, `"`, `\"`)
 
 	g.P(`	if err := cel.EvalProtoValidateRule("`, expr, `", x); err != nil {`)
-	g.P(`		return fmt.Errorf("`, context, `: `, msg, `")`)
+	g.P(`		v.Add("`, field, `", "`, msg, `")`)
 	g.P(`	}`)
 }
 
@@ -20,62 +20,54 @@ var (
 	_ = cel.EvalTransitionRule
 )
 
-// Validate checks validation rules for Order.
+// Validate checks validation rules for Order. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *Order) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	// cel: size(self.items) > 0
 	if err := cel.EvalProtoValidateRule("size(self.items) > 0", x); err != nil {
-		return fmt.Errorf("Order: order must have at least one item")
+		v.Add("", "order must have at least one item")
 	}
 
 	if x.Id == "" {
-		return fmt.Errorf("Id is required")
-	}
-	if err := cel.ValidateUUID(x.Id); err != nil {
-		return fmt.Errorf("Id: %w", err)
+		v.Add("Id", "is required")
+	} else {
+		v.Check("Id", cel.ValidateUUID(x.Id))
 	}
 	if x.CustomerId == "" {
-		return fmt.Errorf("CustomerId is required")
-	}
-	if err := cel.ValidateUUID(x.CustomerId); err != nil {
-		return fmt.Errorf("CustomerId: %w", err)
+		v.Add("CustomerId", "is required")
+	} else {
+		v.Check("CustomerId", cel.ValidateUUID(x.CustomerId))
 	}
 	if x.CustomerEmail == "" {
-		return fmt.Errorf("CustomerEmail is required")
-	}
-	if err := cel.ValidateEmail(x.CustomerEmail); err != nil {
-		return fmt.Errorf("CustomerEmail: %w", err)
+		v.Add("CustomerEmail", "is required")
+	} else {
+		v.Check("CustomerEmail", cel.ValidateEmail(x.CustomerEmail))
 	}
 	if x.TotalCents < 0 {
-		return fmt.Errorf("TotalCents must be >= %d", 0)
+		v.Add("TotalCents", "must be >= 0")
 	}
 	if x.Version < 1 {
-		return fmt.Errorf("Version must be >= %d", 1)
+		v.Add("Version", "must be >= 1")
 	}
 	if x.CreatedAt != "" {
-		if err := cel.ValidateDatetime(x.CreatedAt); err != nil {
-			return fmt.Errorf("CreatedAt: %w", err)
-		}
+		v.Check("CreatedAt", cel.ValidateDatetime(x.CreatedAt))
 	}
 	if x.UpdatedAt != "" {
-		if err := cel.ValidateDatetime(x.UpdatedAt); err != nil {
-			return fmt.Errorf("UpdatedAt: %w", err)
-		}
+		v.Check("UpdatedAt", cel.ValidateDatetime(x.UpdatedAt))
 	}
 	if x.ShippingZone != "" {
-		if err := cel.ValidateDNSSubdomain(x.ShippingZone); err != nil {
-			return fmt.Errorf("ShippingZone: %w", err)
-		}
+		v.Check("ShippingZone", cel.ValidateDNSSubdomain(x.ShippingZone))
 	}
 	for i, item := range x.Items {
-		if err := item.Validate(); err != nil {
-			return fmt.Errorf("Items[%d]: %w", i, err)
-		}
+		v.Nested(fmt.Sprintf("Items[%d]", i), item.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -189,40 +181,43 @@ func (x *Order) ValidateUpdate(old *Order) error {
 	return nil
 }
 
-// Validate checks validation rules for OrderItem.
+// Validate checks validation rules for OrderItem. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *OrderItem) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.ProductId == "" {
-		return fmt.Errorf("ProductId is required")
-	}
-	if err := cel.ValidateUUID(x.ProductId); err != nil {
-		return fmt.Errorf("ProductId: %w", err)
+		v.Add("ProductId", "is required")
+	} else {
+		v.Check("ProductId", cel.ValidateUUID(x.ProductId))
 	}
 	if x.Quantity < 1 {
-		return fmt.Errorf("Quantity must be >= %d", 1)
+		v.Add("Quantity", "must be >= 1")
 	}
 	if x.Quantity > 1000 {
-		return fmt.Errorf("Quantity must be <= %d", 1000)
+		v.Add("Quantity", "must be <= 1000")
 	}
 	if x.PriceCents < 0 {
-		return fmt.Errorf("PriceCents must be >= %d", 0)
+		v.Add("PriceCents", "must be >= 0")
 	}
 	if x.Sku == "" {
-		return fmt.Errorf("Sku is required")
-	}
-	if len(x.Sku) < 3 {
-		return fmt.Errorf("Sku must have at least %d elements/characters", 3)
-	}
-	if len(x.Sku) > 20 {
-		return fmt.Errorf("Sku must have at most %d elements/characters", 20)
-	}
-	if !patternSku.MatchString(x.Sku) {
-		return fmt.Errorf("Sku must match pattern")
+		v.Add("Sku", "is required")
+	} else {
+		if len(x.Sku) < 3 {
+			v.Add("Sku", "must have at least 3 elements/characters")
+		}
+		if len(x.Sku) > 20 {
+			v.Add("Sku", "must have at most 20 elements/characters")
+		}
+		if !patternSku.MatchString(x.Sku) {
+			v.Add("Sku", "must match pattern")
+		}
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -240,18 +235,19 @@ func (x *OrderItem) ValidateUpdate(old *OrderItem) error {
 	return nil
 }
 
-// Validate checks validation rules for CreateOrderRequest.
+// Validate checks validation rules for CreateOrderRequest. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *CreateOrderRequest) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Order != nil {
-		if err := x.Order.Validate(); err != nil {
-			return fmt.Errorf("Order: %w", err)
-		}
+		v.Nested("Order", x.Order.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -266,18 +262,19 @@ func (x *CreateOrderRequest) ValidateUpdate(old *CreateOrderRequest) error {
 	return nil
 }
 
-// Validate checks validation rules for CreateOrderResponse.
+// Validate checks validation rules for CreateOrderResponse. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *CreateOrderResponse) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Order != nil {
-		if err := x.Order.Validate(); err != nil {
-			return fmt.Errorf("Order: %w", err)
-		}
+		v.Nested("Order", x.Order.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -292,19 +289,21 @@ func (x *CreateOrderResponse) ValidateUpdate(old *CreateOrderResponse) error {
 	return nil
 }
 
-// Validate checks validation rules for GetOrderRequest.
+// Validate checks validation rules for GetOrderRequest. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *GetOrderRequest) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Id == "" {
-		return fmt.Errorf("Id is required")
-	}
-	if err := cel.ValidateUUID(x.Id); err != nil {
-		return fmt.Errorf("Id: %w", err)
+		v.Add("Id", "is required")
+	} else {
+		v.Check("Id", cel.ValidateUUID(x.Id))
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -319,18 +318,19 @@ func (x *GetOrderRequest) ValidateUpdate(old *GetOrderRequest) error {
 	return nil
 }
 
-// Validate checks validation rules for GetOrderResponse.
+// Validate checks validation rules for GetOrderResponse. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *GetOrderResponse) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Order != nil {
-		if err := x.Order.Validate(); err != nil {
-			return fmt.Errorf("Order: %w", err)
-		}
+		v.Nested("Order", x.Order.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -345,18 +345,19 @@ func (x *GetOrderResponse) ValidateUpdate(old *GetOrderResponse) error {
 	return nil
 }
 
-// Validate checks validation rules for UpdateOrderRequest.
+// Validate checks validation rules for UpdateOrderRequest. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *UpdateOrderRequest) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Order != nil {
-		if err := x.Order.Validate(); err != nil {
-			return fmt.Errorf("Order: %w", err)
-		}
+		v.Nested("Order", x.Order.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -371,18 +372,19 @@ func (x *UpdateOrderRequest) ValidateUpdate(old *UpdateOrderRequest) error {
 	return nil
 }
 
-// Validate checks validation rules for UpdateOrderResponse.
+// Validate checks validation rules for UpdateOrderResponse. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *UpdateOrderResponse) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.Order != nil {
-		if err := x.Order.Validate(); err != nil {
-			return fmt.Errorf("Order: %w", err)
-		}
+		v.Nested("Order", x.Order.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -397,19 +399,22 @@ func (x *UpdateOrderResponse) ValidateUpdate(old *UpdateOrderResponse) error {
 	return nil
 }
 
-// Validate checks validation rules for ListOrdersRequest.
+// Validate checks validation rules for ListOrdersRequest. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *ListOrdersRequest) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	if x.PageSize < 1 {
-		return fmt.Errorf("PageSize must be >= %d", 1)
+		v.Add("PageSize", "must be >= 1")
 	}
 	if x.PageSize > 100 {
-		return fmt.Errorf("PageSize must be <= %d", 100)
+		v.Add("PageSize", "must be <= 100")
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -424,18 +429,19 @@ func (x *ListOrdersRequest) ValidateUpdate(old *ListOrdersRequest) error {
 	return nil
 }
 
-// Validate checks validation rules for ListOrdersResponse.
+// Validate checks validation rules for ListOrdersResponse. A failure is a
+// *cel.ValidationResult listing every violated field.
 func (x *ListOrdersResponse) Validate() error {
 	if x == nil {
 		return nil
 	}
 
+	var v cel.ValidationResult
+
 	for i, item := range x.Orders {
-		if err := item.Validate(); err != nil {
-			return fmt.Errorf("Orders[%d]: %w", i, err)
-		}
+		v.Nested(fmt.Sprintf("Orders[%d]", i), item.Validate())
 	}
-	return nil
+	return v.Err()
 }
 
 // ValidateUpdate checks transition rules against old value.
@@ -52,40 +52,19 @@ func Run(dir string, opts Options) (*Result, error) {
 			if !strings.HasSuffix(e.Name(), ".partial") {
 				continue
 			}
-
-			path := filepath.Join(blobDir, e.Name())
-			info, err := e.Info()
-			if err != nil {
-				continue
-			}
-
-			item := Item{
-				Path:  path,
-				Bytes: info.Size(),
-				IsDir: false,
-			}
-
-			result.Files++
-			result.Bytes += info.Size()
-
-			if opts.OnItem != nil {
-				opts.OnItem(item)
-			}
-
-			if !opts.DryRun {
-				err := os.Remove(path)
-				if opts.OnDelete != nil {
-					opts.OnDelete(item, err)
-				}
-			}
+			pruneFile(filepath.Join(blobDir, e.Name()), e, opts, result)
 		}
 	}
 
-	// clean layer state directories
+	// clean layer state: the puller's flat <digest>.state files and
+	// per-layer directories
 	stateDir := filepath.Join(dir, ".fray")
 	if entries, err := os.ReadDir(stateDir); err == nil {
 		for _, e := range entries {
 			if !e.IsDir() {
+				if strings.HasSuffix(e.Name(), ".state") {
+					pruneFile(filepath.Join(stateDir, e.Name()), e, opts, result)
+				}
 				continue
 			}
 
@@ -118,6 +97,34 @@ func Run(dir string, opts Options) (*Result, error) {
 	return result, nil
 }
 
+// pruneFile reports and, unless dry-running, removes a single file.
+func pruneFile(path string, e os.DirEntry, opts Options, result *Result) {
+	info, err := e.Info()
+	if err != nil {
+		return
+	}
+
+	item := Item{
+		Path:  path,
+		Bytes: info.Size(),
+		IsDir: false,
+	}
+
+	result.Files++
+	result.Bytes += info.Size()
+
+	if opts.OnItem != nil {
+		opts.OnItem(item)
+	}
+
+	if !opts.DryRun {
+		err := os.Remove(path)
+		if opts.OnDelete != nil {
+			opts.OnDelete(item, err)
+		}
+	}
+}
+
 func calcDirSize(path string) (int64, int) {
 	var size int64
 	var count int
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -81,6 +82,54 @@ func TestRunCallbacks(t *testing.T) {
 	require.Len(deleted, 1)
 }
 
+func TestRunStateLayouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		kept  []string
+	}{
+		{
+			name:  "puller state files",
+			files: []string{".fray/" + strings.Repeat("a", 64) + ".state", ".fray/abcdef012345.state"},
+		},
+		{
+			name:  "store layer directories",
+			files: []string{".fray/layers/" + strings.Repeat("b", 64) + "/tree.json", ".fray/layers/" + strings.Repeat("b", 64) + "/chunk-00000"},
+		},
+		{
+			name:  "other state kept",
+			files: []string{".fray/" + strings.Repeat("c", 64) + ".state"},
+			kept:  []string{".fray/diffids.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			dir := t.TempDir()
+			setupLayout(t, dir)
+			for _, name := range append(tt.files, tt.kept...) {
+				path := filepath.Join(dir, name)
+				require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(os.WriteFile(path, []byte("state"), 0644))
+			}
+
+			result, err := Run(dir, Options{})
+			require.NoError(err)
+			require.Equal(len(tt.files), result.Files)
+
+			for _, name := range tt.files {
+				_, err := os.Stat(filepath.Join(dir, name))
+				require.True(os.IsNotExist(err), name)
+			}
+			for _, name := range tt.kept {
+				require.FileExists(filepath.Join(dir, name))
+			}
+		})
+	}
+}
+
 func TestRunMissingDir(t *testing.T) {
 	require := require.New(t)
 
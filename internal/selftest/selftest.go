@@ -0,0 +1,100 @@
+// Package selftest checks that the hashes fray trusts compute known values
+// on this machine. A CPU whose accelerated hash paths are broken would
+// otherwise store chunks and blobs under wrong sums, silently corrupting
+// the cache.
+package selftest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/merkle"
+)
+
+var ErrMismatch = errors.New("hash self-test failed")
+
+// Hasher is a hash under test.
+type Hasher struct {
+	Name string
+	// Sum returns the hex sum of data.
+	Sum func(data []byte) string
+	// Want is the expected sum of each of the vectors, in order.
+	Want []string
+}
+
+// vectors returns the inputs hashed. The last is large enough to run
+// through the vectorized block paths rather than the scalar tail.
+func vectors() [][]byte {
+	large := make([]byte, 1<<20)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	return [][]byte{
+		nil,
+		[]byte("abc"),
+		[]byte("The quick brown fox jumps over the lazy dog"),
+		large,
+	}
+}
+
+// Hashers returns the hashes fray relies on: xxHash64 for merkle chunk
+// state and SHA-256 for blob digests.
+func Hashers() []Hasher {
+	return []Hasher{
+		{
+			Name: "xxhash64",
+			Sum:  func(data []byte) string { return merkle.HashData(data).String() },
+			Want: []string{
+				"ef46db3751d8e999",
+				"44bc2cf5ad770999",
+				"0b242d361fda71bc",
+				"89ac0399c4464a31",
+			},
+		},
+		{
+			Name: "sha256",
+			Sum: func(data []byte) string {
+				return digest.SHA256.FromBytes(data).Encoded()
+			},
+			Want: []string{
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+				"d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592",
+				"631b84027d6b9e52b539c4e8373622d23032dfadc64d60af87339c9037e4f769",
+			},
+		},
+	}
+}
+
+// Run checks the hashes fray relies on.
+func Run() error {
+	return Check(Hashers())
+}
+
+// Check hashes each vector twice with every hasher and fails with
+// ErrMismatch if a sum isn't the expected one, or differs between runs.
+func Check(hashers []Hasher) error {
+	inputs := vectors()
+
+	var failed []string
+	for _, h := range hashers {
+		if len(h.Want) != len(inputs) {
+			return fmt.Errorf("%s: %d expected sums for %d vectors", h.Name, len(h.Want), len(inputs))
+		}
+		for i, input := range inputs {
+			first, second := h.Sum(input), h.Sum(input)
+			switch {
+			case first != second:
+				failed = append(failed, fmt.Sprintf("%s vector %d: got %s then %s", h.Name, i, first, second))
+			case first != h.Want[i]:
+				failed = append(failed, fmt.Sprintf("%s vector %d: got %s, want %s", h.Name, i, first, h.Want[i]))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrMismatch, strings.Join(failed, "; "))
+	}
+	return nil
+}
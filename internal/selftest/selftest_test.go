@@ -0,0 +1,64 @@
+package selftest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	require.NoError(t, Run())
+}
+
+func TestCheck(t *testing.T) {
+	good := Hashers()
+
+	// flipped returns a hasher whose sum of the large vector is wrong
+	flipped := func(h Hasher) Hasher {
+		sum := h.Sum
+		h.Sum = func(data []byte) string {
+			s := sum(data)
+			if len(data) > 1024 {
+				return strings.Repeat("0", len(s))
+			}
+			return s
+		}
+		return h
+	}
+
+	calls := 0
+	flaky := good[1]
+	flaky.Sum = func(data []byte) string {
+		calls++
+		if calls%2 == 0 {
+			return "flaky"
+		}
+		return good[1].Sum(data)
+	}
+
+	tests := []struct {
+		name    string
+		hashers []Hasher
+		wantErr string
+	}{
+		{name: "healthy", hashers: good},
+		{name: "broken xxhash", hashers: []Hasher{flipped(good[0]), good[1]}, wantErr: "xxhash64 vector 3"},
+		{name: "broken sha256", hashers: []Hasher{good[0], flipped(good[1])}, wantErr: "sha256 vector 3"},
+		{name: "nondeterministic", hashers: []Hasher{flaky}, wantErr: "sha256 vector 0: got e3b0"},
+		{name: "missing sums", hashers: []Hasher{{Name: "short", Sum: good[0].Sum, Want: good[0].Want[:1]}}, wantErr: "1 expected sums for 4 vectors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			err := Check(tt.hashers)
+			if tt.wantErr == "" {
+				require.NoError(err)
+				return
+			}
+			require.ErrorContains(err, tt.wantErr)
+		})
+	}
+}
@@ -40,3 +40,8 @@ func Get() Info {
 func (i Info) String() string {
 	return fmt.Sprintf("%s (%s)", i.Version, i.Commit)
 }
+
+// UserAgent returns the product token for HTTP User-Agent and Server headers.
+func UserAgent() string {
+	return "fray/" + version
+}
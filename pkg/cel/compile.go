@@ -0,0 +1,156 @@
+package cel
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/proto"
+)
+
+// RuleKind selects the variables a compiled rule can reference.
+type RuleKind int
+
+const (
+	// RuleValidate rules see the value as 'self', or 'this'.
+	RuleValidate RuleKind = iota
+	// RuleTransition rules see the new value as 'self' and the previous
+	// one as 'oldSelf'.
+	RuleTransition
+)
+
+func (k RuleKind) String() string {
+	switch k {
+	case RuleValidate:
+		return "validate"
+	case RuleTransition:
+		return "transition"
+	default:
+		return fmt.Sprintf("RuleKind(%d)", int(k))
+	}
+}
+
+// CompiledRule is a rule compiled once, typically at startup, and evaluated
+// many times without the program cache lookups done by the Eval helpers. It
+// is safe for concurrent use.
+type CompiledRule struct {
+	expr string
+	kind RuleKind
+	prog cel.Program
+}
+
+// Compile compiles expr for evaluation against Go values, so a bad
+// expression fails here rather than on first use.
+func Compile(expr string, kind RuleKind) (*CompiledRule, error) {
+	var (
+		env *cel.Env
+		err error
+	)
+	switch kind {
+	case RuleValidate:
+		env, err = getValidateEnv()
+	case RuleTransition:
+		env, err = getMsgTransitionEnv()
+	default:
+		return nil, fmt.Errorf("compile cel %q: unknown rule kind %s", expr, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compileRule(env, expr, kind)
+}
+
+// CompileProto compiles expr for evaluation against messages of the same
+// type as msg, with field access type-checked against its descriptor.
+func CompileProto(expr string, kind RuleKind, msg proto.Message) (*CompiledRule, error) {
+	if kind != RuleValidate && kind != RuleTransition {
+		return nil, fmt.Errorf("compile cel %q: unknown rule kind %s", expr, kind)
+	}
+	env, err := getOrCreateProtoEnv(msg, kind == RuleTransition)
+	if err != nil {
+		return nil, err
+	}
+	return compileRule(env, expr, kind)
+}
+
+func compileRule(env *cel.Env, expr string, kind RuleKind) (*CompiledRule, error) {
+	prog, err := newProgram(env, expr)
+	if err != nil {
+		return nil, fmt.Errorf("compile cel %q: %w", expr, err)
+	}
+	return &CompiledRule{expr: expr, kind: kind, prog: prog}, nil
+}
+
+// Expr returns the source expression.
+func (r *CompiledRule) Expr() string {
+	return r.expr
+}
+
+// Kind returns the kind the rule was compiled for.
+func (r *CompiledRule) Kind() RuleKind {
+	return r.kind
+}
+
+// Validate evaluates a RuleValidate rule against val.
+func (r *CompiledRule) Validate(val any) error {
+	if r.kind != RuleValidate {
+		return fmt.Errorf("cel %q: validate called on %s rule", r.expr, r.kind)
+	}
+
+	act := simpleValidateActivationPool.Get().(*simpleValidateActivation)
+	act.val = val
+
+	out, _, err := r.prog.Eval(act)
+
+	act.val = nil
+	simpleValidateActivationPool.Put(act)
+
+	if err != nil {
+		return fmt.Errorf("eval cel %q: %w", r.expr, err)
+	}
+	if out.Value() != true {
+		return ErrValidationFailed
+	}
+	return nil
+}
+
+// Transition evaluates a RuleTransition rule against the new and old values.
+func (r *CompiledRule) Transition(newVal, oldVal any) error {
+	if r.kind != RuleTransition {
+		return fmt.Errorf("cel %q: transition called on %s rule", r.expr, r.kind)
+	}
+
+	act := messageTransitionActivationPool.Get().(*messageTransitionActivation)
+	act.self = newVal
+	act.oldSelf = oldVal
+
+	out, _, err := r.prog.Eval(act)
+
+	act.self = nil
+	act.oldSelf = nil
+	messageTransitionActivationPool.Put(act)
+
+	if err != nil {
+		return fmt.Errorf("eval cel %q: %w", r.expr, err)
+	}
+	if out.Value() != true {
+		return ErrTransitionFailed
+	}
+	return nil
+}
+
+// newProgram compiles and plans expr in env.
+func newProgram(env *cel.Env, expr string) (cel.Program, error) {
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("compile: %w", issues.Err())
+	}
+
+	prog, err := env.Program(ast,
+		cel.EvalOptions(cel.OptOptimize),
+		cel.OptimizeRegex(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("program: %w", err)
+	}
+	return prog, nil
+}
@@ -0,0 +1,148 @@
+package cel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+)
+
+func TestCompile(t *testing.T) {
+	rule, err := Compile("format('dns_label', self) && size(self) <= 10", RuleValidate)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if rule.Kind() != RuleValidate {
+		t.Errorf("Kind() = %s, want %s", rule.Kind(), RuleValidate)
+	}
+
+	tests := []struct {
+		val     string
+		wantErr error
+	}{
+		{"web", nil},
+		{"api-1", nil},
+		{"Web", ErrValidationFailed},
+		{"much-too-long", ErrValidationFailed},
+	}
+
+	// compile once, evaluate many times
+	for i := 0; i < 100; i++ {
+		for _, tt := range tests {
+			err := rule.Validate(tt.val)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Validate(%q) unexpected error = %v", tt.val, err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate(%q) error = %v, want %v", tt.val, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestCompileTransition(t *testing.T) {
+	rule, err := Compile("self.version > oldSelf.version", RuleTransition)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newVal := map[string]any{"version": int64(i + 1)}
+			oldVal := map[string]any{"version": int64(i)}
+			if err := rule.Transition(newVal, oldVal); err != nil {
+				t.Errorf("Transition() unexpected error = %v", err)
+			}
+			if err := rule.Transition(oldVal, newVal); !errors.Is(err, ErrTransitionFailed) {
+				t.Errorf("Transition() error = %v, want %v", err, ErrTransitionFailed)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompileProto(t *testing.T) {
+	rule, err := CompileProto("self.file_name.endsWith('.proto')", RuleValidate, &sourcecontextpb.SourceContext{})
+	if err != nil {
+		t.Fatalf("CompileProto() unexpected error = %v", err)
+	}
+	if err := rule.Validate(&sourcecontextpb.SourceContext{FileName: "order.proto"}); err != nil {
+		t.Errorf("Validate() unexpected error = %v", err)
+	}
+	if err := rule.Validate(&sourcecontextpb.SourceContext{FileName: "order.go"}); !errors.Is(err, ErrValidationFailed) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrValidationFailed)
+	}
+
+	transition, err := CompileProto("self > oldSelf", RuleTransition, &durationpb.Duration{})
+	if err != nil {
+		t.Fatalf("CompileProto() unexpected error = %v", err)
+	}
+	if err := transition.Transition(durationpb.New(10), durationpb.New(5)); err != nil {
+		t.Errorf("Transition() unexpected error = %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		compile func() (*CompiledRule, error)
+		want    string
+	}{
+		{
+			name:    "syntax error",
+			compile: func() (*CompiledRule, error) { return Compile("broken expr !!!", RuleValidate) },
+			want:    "compile cel",
+		},
+		{
+			name:    "undeclared variable",
+			compile: func() (*CompiledRule, error) { return Compile("oldSelf > 0", RuleValidate) },
+			want:    "undeclared reference to 'oldSelf'",
+		},
+		{
+			name: "unknown proto field",
+			compile: func() (*CompiledRule, error) {
+				return CompileProto("self.no_such_field == ''", RuleValidate, &sourcecontextpb.SourceContext{})
+			},
+			want: "no_such_field",
+		},
+		{
+			name:    "unknown kind",
+			compile: func() (*CompiledRule, error) { return Compile("true", RuleKind(7)) },
+			want:    "unknown rule kind RuleKind(7)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tt.compile()
+			if err == nil {
+				t.Fatalf("expected compile error, got rule %q", rule.Expr())
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompiledRuleKindMismatch(t *testing.T) {
+	rule, err := Compile("self > 0", RuleValidate)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	err = rule.Transition(int64(1), int64(0))
+	if err == nil || errors.Is(err, ErrTransitionFailed) {
+		t.Errorf("Transition() on validate rule error = %v, want kind error", err)
+	}
+	if want := fmt.Sprintf("transition called on %s rule", RuleValidate); err != nil && !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+}
@@ -146,17 +146,9 @@ func getOrCompileProgram(expr string, newVal, oldVal any) (cel.Program, error) {
 		return nil, err
 	}
 
-	ast, issues := env.Compile(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("compile: %w", issues.Err())
-	}
-
-	prog, err := env.Program(ast,
-		cel.EvalOptions(cel.OptOptimize),
-		cel.OptimizeRegex(),
-	)
+	prog, err := newProgram(env, expr)
 	if err != nil {
-		return nil, fmt.Errorf("program: %w", err)
+		return nil, err
 	}
 
 	progCache.Store(cacheKey, prog)
@@ -175,6 +167,7 @@ func createEnv(newVal, oldVal any) (*cel.Env, error) {
 	env, err := cel.NewEnv(
 		cel.Variable(varThis, thisType),
 		cel.Variable(varOldSelf, oldSelfType),
+		customFunctions(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("create env: %w", err)
@@ -203,6 +196,24 @@ func goTypeToCelType(v any) *cel.Type {
 	}
 }
 
+// customFunctions declares the functions fray adds to every CEL env.
+func customFunctions() cel.EnvOption {
+	return combineEnvOptions(formatLib(), semverLib(), unitsLib(), netLib())
+}
+
+// combineEnvOptions applies opts in order as a single option.
+func combineEnvOptions(opts ...cel.EnvOption) cel.EnvOption {
+	return func(e *cel.Env) (*cel.Env, error) {
+		var err error
+		for _, opt := range opts {
+			if e, err = opt(e); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+}
+
 // EvalValidateRule evaluates a validation rule using 'this' or 'self'.
 func EvalValidateRule(expr string, val any) error {
 	prog, err := getOrCompileValidateProgram(expr)
@@ -239,17 +250,9 @@ func getOrCompileValidateProgram(expr string) (cel.Program, error) {
 		return nil, err
 	}
 
-	ast, issues := env.Compile(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("compile: %w", issues.Err())
-	}
-
-	prog, err := env.Program(ast,
-		cel.EvalOptions(cel.OptOptimize),
-		cel.OptimizeRegex(),
-	)
+	prog, err := newProgram(env, expr)
 	if err != nil {
-		return nil, fmt.Errorf("program: %w", err)
+		return nil, err
 	}
 
 	progCache.Store(cacheKey, prog)
@@ -262,6 +265,7 @@ func getValidateEnv() (*cel.Env, error) {
 		validateEnv, envErr = cel.NewEnv(
 			cel.Variable(varThis, cel.DynType),
 			cel.Variable(varSelf, cel.DynType),
+			customFunctions(),
 		)
 	})
 	if envErr != nil {
@@ -308,17 +312,9 @@ func getOrCompileMsgTransitionProgram(expr string) (cel.Program, error) {
 		return nil, err
 	}
 
-	ast, issues := env.Compile(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("compile: %w", issues.Err())
-	}
-
-	prog, err := env.Program(ast,
-		cel.EvalOptions(cel.OptOptimize),
-		cel.OptimizeRegex(),
-	)
+	prog, err := newProgram(env, expr)
 	if err != nil {
-		return nil, fmt.Errorf("program: %w", err)
+		return nil, err
 	}
 
 	progCache.Store(cacheKey, prog)
@@ -331,6 +327,7 @@ func getMsgTransitionEnv() (*cel.Env, error) {
 		msgTransitionEnv, envErr = cel.NewEnv(
 			cel.Variable(varSelf, cel.DynType),
 			cel.Variable(varOldSelf, cel.DynType),
+			customFunctions(),
 		)
 	})
 	if envErr != nil {
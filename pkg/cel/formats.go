@@ -6,6 +6,8 @@ import (
 	"net/mail"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -50,15 +52,20 @@ func ValidateURIRef(s string) error {
 	return nil
 }
 
-// ValidateHostname validates an RFC 1123 hostname.
+// ValidateHostname validates an RFC 1123 hostname. A single trailing dot,
+// as in the fully qualified "example.com.", is allowed.
 func ValidateHostname(s string) error {
 	if s == "" {
 		return &FormatError{Format: "hostname", Value: s, Reason: "empty"}
 	}
-	if len(s) > 253 {
+	name := strings.TrimSuffix(s, ".")
+	if name == "" {
+		return &FormatError{Format: "hostname", Value: s, Reason: "no labels"}
+	}
+	if len(name) > 253 {
 		return &FormatError{Format: "hostname", Value: s, Reason: "exceeds 253 characters"}
 	}
-	if !hostnameRegex.MatchString(s) {
+	if !hostnameRegex.MatchString(name) {
 		return &FormatError{Format: "hostname", Value: s, Reason: "invalid format"}
 	}
 	return nil
@@ -105,6 +112,40 @@ func ValidateIP(s string) error {
 	return nil
 }
 
+// ValidateIPInCIDR validates that ip is an IPv4 or IPv6 address within cidr.
+func ValidateIPInCIDR(ip, cidr string) error {
+	in, err := ipInCIDR(ip, cidr)
+	if err != nil {
+		return err
+	}
+	if !in {
+		return &FormatError{Format: "ip", Value: ip, Reason: "not in " + cidr}
+	}
+	return nil
+}
+
+func ipInCIDR(ip, cidr string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, &FormatError{Format: "cidr", Value: cidr, Reason: "invalid CIDR"}
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, &FormatError{Format: "ip", Value: ip, Reason: "invalid IP address"}
+	}
+	return network.Contains(addr), nil
+}
+
+// isPrivateIP reports whether ip is in an RFC 1918 range or, for IPv6, the
+// RFC 4193 unique local range fc00::/7.
+func isPrivateIP(ip string) (bool, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, &FormatError{Format: "ip", Value: ip, Reason: "invalid IP address"}
+	}
+	return addr.IsPrivate(), nil
+}
+
 // ValidateUUID validates an RFC 4122 UUID.
 func ValidateUUID(s string) error {
 	if s == "" {
@@ -116,18 +157,39 @@ func ValidateUUID(s string) error {
 	return nil
 }
 
+// ValidateUUIDv1 validates a UUID version 1.
+func ValidateUUIDv1(s string) error {
+	return ValidateUUIDVersion(s, 1)
+}
+
 // ValidateUUIDv4 validates a UUID version 4.
 func ValidateUUIDv4(s string) error {
+	return ValidateUUIDVersion(s, 4)
+}
+
+// ValidateUUIDv7 validates a time-ordered UUID version 7.
+func ValidateUUIDv7(s string) error {
+	return ValidateUUIDVersion(s, 7)
+}
+
+// ValidateUUIDVersion validates a UUID of the given version, 1 through 8,
+// with the RFC 4122 variant.
+func ValidateUUIDVersion(s string, version int) error {
+	format := "uuid_v" + strconv.Itoa(version)
+	if version < 1 || version > 8 {
+		return &FormatError{Format: format, Value: s, Reason: "unknown version"}
+	}
 	if err := ValidateUUID(s); err != nil {
 		return err
 	}
-	// Version 4 has 4 in position 14 and 8,9,a,b in position 19
-	if len(s) != 36 || s[14] != '4' {
-		return &FormatError{Format: "uuid_v4", Value: s, Reason: "not version 4"}
+	// the version is the nibble at position 14, the variant 10xx at 19
+	if s[14] != byte('0'+version) {
+		return &FormatError{Format: format, Value: s, Reason: "not version " + strconv.Itoa(version)}
 	}
-	c := s[19]
-	if c != '8' && c != '9' && c != 'a' && c != 'b' && c != 'A' && c != 'B' {
-		return &FormatError{Format: "uuid_v4", Value: s, Reason: "invalid variant"}
+	switch s[19] {
+	case '8', '9', 'a', 'b', 'A', 'B':
+	default:
+		return &FormatError{Format: format, Value: s, Reason: "invalid variant"}
 	}
 	return nil
 }
@@ -198,6 +260,43 @@ func ValidateImageRef(s string) error {
 	return nil
 }
 
+// ValidateImageRepository validates a repository name: slash-separated
+// lowercase path components as in the distribution spec.
+func ValidateImageRepository(s string) error {
+	if s == "" {
+		return &FormatError{Format: "repository", Value: s, Reason: "empty"}
+	}
+	for _, c := range strings.Split(s, "/") {
+		if !pathComponentRegex.MatchString(c) {
+			return &FormatError{Format: "repository", Value: s, Reason: "invalid path component " + strconv.Quote(c)}
+		}
+	}
+	return nil
+}
+
+// ValidateRegistryHost validates a registry host: a hostname or IP address
+// with an optional port.
+func ValidateRegistryHost(s string) error {
+	if s == "" {
+		return &FormatError{Format: "registry_host", Value: s, Reason: "empty"}
+	}
+
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return &FormatError{Format: "registry_host", Value: s, Reason: "invalid port"}
+		}
+		host = h
+	}
+
+	// "quay.io." would name the same registry as "quay.io" under another key
+	if strings.HasSuffix(host, ".") || ValidateHostname(host) != nil && ValidateIP(host) != nil {
+		return &FormatError{Format: "registry_host", Value: s, Reason: "invalid host"}
+	}
+	return nil
+}
+
 // ValidateImageTag validates a container image tag.
 func ValidateImageTag(s string) error {
 	if s == "" {
@@ -270,14 +369,48 @@ func ValidateSemver(s string) error {
 	return nil
 }
 
-// ValidateBase64 validates a base64-encoded string.
+const (
+	base64StdChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	base64URLChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// ValidateBase64 validates standard base64, padded or unpadded.
 func ValidateBase64(s string) error {
+	return validateBase64("base64", s, base64StdChars, "-_", base64.StdEncoding, base64.RawStdEncoding)
+}
+
+// ValidateBase64URL validates URL-safe base64 as used by JWTs, padded or
+// unpadded.
+func ValidateBase64URL(s string) error {
+	return validateBase64("base64url", s, base64URLChars, "+/", base64.URLEncoding, base64.RawURLEncoding)
+}
+
+// validateBase64 checks s against alphabet, naming characters that belong
+// to the other base64 alphabet, then decodes it with padded if it has
+// padding and raw if not.
+func validateBase64(format, s, alphabet, other string, padded, raw *base64.Encoding) error {
 	if s == "" {
-		return &FormatError{Format: "base64", Value: s, Reason: "empty"}
+		return &FormatError{Format: format, Value: s, Reason: "empty"}
 	}
-	_, err := base64.StdEncoding.DecodeString(s)
-	if err != nil {
-		return &FormatError{Format: "base64", Value: s, Reason: "invalid base64 encoding"}
+
+	for _, c := range s {
+		if c == '=' || strings.ContainsRune(alphabet, c) {
+			continue
+		}
+		if strings.ContainsRune(other, c) {
+			return &FormatError{Format: format, Value: s, Reason: "character " + strconv.QuoteRune(c) + " is from the other base64 alphabet"}
+		}
+		return &FormatError{Format: format, Value: s, Reason: "invalid character " + strconv.QuoteRune(c)}
+	}
+
+	if !strings.Contains(s, "=") {
+		if _, err := raw.DecodeString(s); err != nil {
+			return &FormatError{Format: format, Value: s, Reason: "invalid length"}
+		}
+		return nil
+	}
+	if _, err := padded.DecodeString(s); err != nil {
+		return &FormatError{Format: format, Value: s, Reason: "invalid padding"}
 	}
 	return nil
 }
@@ -293,6 +426,280 @@ func ValidatePEM(s string) error {
 	return nil
 }
 
+// ValidateHexColor validates a "#RGB", "#RRGGBB" or "#RRGGBBAA" color, in
+// either case.
+func ValidateHexColor(s string) error {
+	if s == "" {
+		return &FormatError{Format: "hex_color", Value: s, Reason: "empty"}
+	}
+	digits, ok := strings.CutPrefix(s, "#")
+	if !ok {
+		return &FormatError{Format: "hex_color", Value: s, Reason: "missing '#'"}
+	}
+	for _, r := range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return &FormatError{Format: "hex_color", Value: s, Reason: "invalid hex digit " + strconv.QuoteRune(r)}
+		}
+	}
+	switch len(digits) {
+	case 3, 6, 8:
+		return nil
+	default:
+		return &FormatError{Format: "hex_color", Value: s, Reason: "must have 3, 6 or 8 hex digits"}
+	}
+}
+
+// cssLengthUnits are the CSS length units ValidateCSSLength accepts.
+var cssLengthUnits = map[string]bool{
+	"%": true, "px": true, "em": true, "rem": true, "ex": true, "ch": true,
+	"vw": true, "vh": true, "vmin": true, "vmax": true,
+	"cm": true, "mm": true, "q": true, "in": true, "pt": true, "pc": true,
+}
+
+var cssLengthRegex = regexp.MustCompile(`^([+-]?(?:\d+(?:\.\d+)?|\.\d+))([a-zA-Z%]*)$`)
+
+// ValidateCSSLength validates a CSS length or percentage such as "10px",
+// "1.5rem" or "50%". A bare "0" needs no unit.
+func ValidateCSSLength(s string) error {
+	if s == "" {
+		return &FormatError{Format: "css_length", Value: s, Reason: "empty"}
+	}
+	m := cssLengthRegex.FindStringSubmatch(s)
+	if m == nil {
+		return &FormatError{Format: "css_length", Value: s, Reason: "invalid number"}
+	}
+	num, unit := m[1], m[2]
+	if unit == "" {
+		if f, _ := strconv.ParseFloat(num, 64); f == 0 {
+			return nil
+		}
+		return &FormatError{Format: "css_length", Value: s, Reason: "missing unit"}
+	}
+	if !cssLengthUnits[strings.ToLower(unit)] {
+		return &FormatError{Format: "css_length", Value: s, Reason: "unsupported unit " + strconv.Quote(unit)}
+	}
+	return nil
+}
+
+var (
+	langSubtagRegex    = regexp.MustCompile(`^([a-z]{2,3}|[a-z]{5,8})$`)
+	extlangSubtagRegex = regexp.MustCompile(`^[a-z]{3}$`)
+	scriptSubtagRegex  = regexp.MustCompile(`^[a-zA-Z]{4}$`)
+	regionSubtagRegex  = regexp.MustCompile(`^([a-zA-Z]{2}|[0-9]{3})$`)
+	variantSubtagRegex = regexp.MustCompile(`^([a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3})$`)
+	alnumRegex         = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+)
+
+// ValidateLanguageTag validates a BCP 47 language tag in canonical case,
+// such as "en", "en-US", "zh-Hant-CN" or "de-CH-1996". Subtags are checked
+// against the RFC 5646 grammar rather than the IANA registry.
+func ValidateLanguageTag(s string) error {
+	fail := func(reason string) error {
+		return &FormatError{Format: "language_tag", Value: s, Reason: reason}
+	}
+	if s == "" {
+		return fail("empty")
+	}
+
+	subtags := strings.Split(s, "-")
+	if slices.Contains(subtags, "") {
+		return fail("empty subtag")
+	}
+	if strings.EqualFold(subtags[0], "x") {
+		return validatePrivateUse(subtags, fail)
+	}
+
+	lang := subtags[0]
+	if !langSubtagRegex.MatchString(strings.ToLower(lang)) {
+		return fail("invalid language subtag " + strconv.Quote(lang))
+	}
+	if lang != strings.ToLower(lang) {
+		return fail("language subtag must be lowercase")
+	}
+	i := 1
+
+	// up to three extended language subtags follow a 2-3 letter language
+	for n := 0; len(lang) <= 3 && n < 3 && i < len(subtags) && extlangSubtagRegex.MatchString(strings.ToLower(subtags[i])); n++ {
+		if subtags[i] != strings.ToLower(subtags[i]) {
+			return fail("extended language subtag must be lowercase")
+		}
+		i++
+	}
+
+	if i < len(subtags) && scriptSubtagRegex.MatchString(subtags[i]) {
+		if want := strings.ToUpper(subtags[i][:1]) + strings.ToLower(subtags[i][1:]); subtags[i] != want {
+			return fail("script subtag must be title case, like " + strconv.Quote(want))
+		}
+		i++
+	}
+
+	if i < len(subtags) && regionSubtagRegex.MatchString(subtags[i]) {
+		if subtags[i] != strings.ToUpper(subtags[i]) {
+			return fail("region subtag must be uppercase")
+		}
+		i++
+	}
+
+	seen := make(map[string]bool)
+	for ; i < len(subtags) && variantSubtagRegex.MatchString(subtags[i]); i++ {
+		if subtags[i] != strings.ToLower(subtags[i]) {
+			return fail("variant subtag must be lowercase")
+		}
+		if seen[subtags[i]] {
+			return fail("duplicate variant " + strconv.Quote(subtags[i]))
+		}
+		seen[subtags[i]] = true
+	}
+
+	for i < len(subtags) && len(subtags[i]) == 1 && !strings.EqualFold(subtags[i], "x") {
+		singleton := subtags[i]
+		if !alnumRegex.MatchString(singleton) {
+			return fail("invalid extension singleton " + strconv.Quote(singleton))
+		}
+		if seen[singleton] {
+			return fail("duplicate extension " + strconv.Quote(singleton))
+		}
+		seen[singleton] = true
+		i++
+
+		start := i
+		for ; i < len(subtags) && len(subtags[i]) >= 2 && len(subtags[i]) <= 8 && alnumRegex.MatchString(subtags[i]); i++ {
+		}
+		if i == start {
+			return fail("extension " + strconv.Quote(singleton) + " has no subtags")
+		}
+		if ext := strings.Join(subtags[start-1:i], "-"); ext != strings.ToLower(ext) {
+			return fail("extension subtags must be lowercase")
+		}
+	}
+
+	if i < len(subtags) && strings.EqualFold(subtags[i], "x") {
+		return validatePrivateUse(subtags[i:], fail)
+	}
+	if i < len(subtags) {
+		return fail("unexpected subtag " + strconv.Quote(subtags[i]))
+	}
+	return nil
+}
+
+// validatePrivateUse checks an "x" singleton and its 1-8 character subtags.
+func validatePrivateUse(subtags []string, fail func(string) error) error {
+	if len(subtags) < 2 {
+		return fail("private use has no subtags")
+	}
+	for _, sub := range subtags[1:] {
+		if len(sub) > 8 || !alnumRegex.MatchString(sub) {
+			return fail("invalid private use subtag " + strconv.Quote(sub))
+		}
+	}
+	if pu := strings.Join(subtags, "-"); pu != strings.ToLower(pu) {
+		return fail("private use subtags must be lowercase")
+	}
+	return nil
+}
+
+// ValidateMIMEType validates a media type such as "text/plain" or
+// "text/html; charset=utf-8". Parameter values may be tokens or quoted
+// strings.
+func ValidateMIMEType(s string) error {
+	fail := func(reason string) error {
+		return &FormatError{Format: "mime_type", Value: s, Reason: reason}
+	}
+	if s == "" {
+		return fail("empty")
+	}
+
+	mediaType, params, hasParams := strings.Cut(s, ";")
+	typ, subtype, ok := strings.Cut(strings.TrimRight(mediaType, " \t"), "/")
+	if !ok {
+		return fail("missing subtype")
+	}
+	if !isMIMEToken(typ) {
+		return fail("invalid type " + strconv.Quote(typ))
+	}
+	if !isMIMEToken(subtype) {
+		return fail("invalid subtype " + strconv.Quote(subtype))
+	}
+	if !hasParams {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	rest := params
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return fail("empty parameter")
+		}
+
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return fail("parameter missing '='")
+		}
+		if !isMIMEToken(key) {
+			return fail("invalid parameter name " + strconv.Quote(key))
+		}
+		key = strings.ToLower(key)
+		if seen[key] {
+			return fail("duplicate parameter " + strconv.Quote(key))
+		}
+		seen[key] = true
+
+		var n int
+		if strings.HasPrefix(value, `"`) {
+			n = quotedStringLen(value)
+			if n < 0 {
+				return fail("unterminated quoted string")
+			}
+		} else {
+			n = strings.IndexAny(value, "; \t")
+			if n < 0 {
+				n = len(value)
+			}
+			if !isMIMEToken(value[:n]) {
+				return fail("invalid value for parameter " + strconv.Quote(key))
+			}
+		}
+
+		rest = strings.TrimLeft(value[n:], " \t")
+		if rest == "" {
+			return nil
+		}
+		if rest[0] != ';' {
+			return fail("unexpected character after parameter " + strconv.Quote(key))
+		}
+		rest = rest[1:]
+	}
+}
+
+// isMIMEToken reports whether s is an RFC 2045 token: one or more printable
+// ASCII characters other than space and tspecials.
+func isMIMEToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?=`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// quotedStringLen returns the length of the quoted string at the start of s,
+// including both quotes, or -1 if it is not terminated.
+func quotedStringLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
 // FormatError represents a format validation failure.
 type FormatError struct {
 	Format string
@@ -321,4 +728,6 @@ var (
 	imageTagRegex    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
 	imageDigestRegex = regexp.MustCompile(`^[a-z0-9]+:[a-f0-9]+$`)
 	semverRegex      = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*)?(\+[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*)?$`)
+	// pathComponentRegex matches one repository path component.
+	pathComponentRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
 )
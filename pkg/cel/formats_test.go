@@ -2,6 +2,7 @@ package cel
 
 import (
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -102,6 +103,15 @@ func TestValidateHostname(t *testing.T) {
 		{"starts with hyphen", "-example.com", true},
 		{"ends with hyphen", "example-.com", true},
 		{"too long", string(make([]byte, 254)), true},
+		{"fqdn trailing dot", "example.com.", false},
+		{"fqdn nested", "a.b.c.", false},
+		{"fqdn single label", "localhost.", false},
+		{"fqdn max length", strings.Repeat("a.", 126) + "a.", false},
+		{"fqdn too long", strings.Repeat("a.", 127) + "a.", true},
+		{"lone dot", ".", true},
+		{"two trailing dots", "example.com..", true},
+		{"empty label", "example..com", true},
+		{"leading dot", ".example.com", true},
 	}
 
 	for _, tt := range tests {
@@ -141,6 +151,33 @@ func TestValidateIPv4(t *testing.T) {
 	}
 }
 
+func TestValidateIPInCIDR(t *testing.T) {
+	tests := []struct {
+		name   string
+		ip     string
+		cidr   string
+		reason string
+	}{
+		{"v4 in range", "10.1.2.3", "10.0.0.0/8", ""},
+		{"v4 network address", "192.168.0.0", "192.168.0.0/24", ""},
+		{"v4 last address", "192.168.0.255", "192.168.0.0/24", ""},
+		{"v4 out of range", "192.168.1.0", "192.168.0.0/24", "not in 192.168.0.0/24"},
+		{"v4 host bits in cidr", "172.16.5.4", "172.16.5.1/16", ""},
+		{"v6 in range", "2001:db8::1", "2001:db8::/32", ""},
+		{"v6 out of range", "2001:db9::1", "2001:db8::/32", "not in 2001:db8::/32"},
+		{"v4 in v6 range", "10.0.0.1", "2001:db8::/32", "not in 2001:db8::/32"},
+		{"invalid ip", "10.0.0", "10.0.0.0/8", "invalid IP address"},
+		{"invalid cidr", "10.0.0.1", "10.0.0.0", "invalid CIDR"},
+		{"invalid prefix", "10.0.0.1", "10.0.0.0/33", "invalid CIDR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateIPInCIDR(tt.ip, tt.cidr), tt.reason)
+		})
+	}
+}
+
 func TestValidateIPv6(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -239,6 +276,57 @@ func TestValidateUUIDv4(t *testing.T) {
 	}
 }
 
+func TestValidateUUIDVersion(t *testing.T) {
+	const (
+		v1 = "c232ab00-9414-11ec-b3c8-9f6bdeced846"
+		v4 = "f47ac10b-58cc-4372-a567-0e02b2c3d479"
+		v7 = "017f22e2-79b0-7cc3-98c4-dc0c0c07398f"
+	)
+
+	tests := []struct {
+		name    string
+		input   string
+		version int
+		wantErr string
+	}{
+		{"v1", v1, 1, ""},
+		{"v4", v4, 4, ""},
+		{"v7", v7, 7, ""},
+		{"v7 uppercase", strings.ToUpper(v7), 7, ""},
+		{"v4 is not v7", v4, 7, "not version 7"},
+		{"v7 is not v1", v7, 1, "not version 1"},
+		{"wrong variant", "017f22e2-79b0-7cc3-c8c4-dc0c0c07398f", 7, "invalid variant"},
+		{"invalid format", "017f22e2-79b0-7cc3-98c4", 7, "invalid UUID format"},
+		{"empty", "", 1, "empty"},
+		{"unknown version", v4, 9, "unknown version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUUIDVersion(tt.input, tt.version)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateUUIDVersion(%q, %d) error = %v", tt.input, tt.version, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateUUIDVersion(%q, %d) error = %v, want %q", tt.input, tt.version, err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := ValidateUUIDv1(v1); err != nil {
+		t.Errorf("ValidateUUIDv1(%q) error = %v", v1, err)
+	}
+	if err := ValidateUUIDv7(v7); err != nil {
+		t.Errorf("ValidateUUIDv7(%q) error = %v", v7, err)
+	}
+	if err := ValidateUUIDv7(v1); err == nil {
+		t.Errorf("ValidateUUIDv7(%q) accepted a v1 UUID", v1)
+	}
+}
+
 func TestValidateDNSLabel(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -495,24 +583,74 @@ func TestValidateBase64(t *testing.T) {
 	tests := []struct {
 		name    string
 		input   string
-		wantErr bool
+		wantErr string
 	}{
-		{"valid", "SGVsbG8gV29ybGQ=", false},
-		{"valid with padding", "SGVsbG9Xb3JsZA==", false},
-		{"empty", "", true},
-		{"invalid chars", "SGVsbG8!", true},
+		{"valid", "SGVsbG8gV29ybGQ=", ""},
+		{"valid with padding", "SGVsbG9Xb3JsZA==", ""},
+		{"unpadded", "SGVsbG9Xb3JsZA", ""},
+		{"standard chars", "+/+/", ""},
+		{"empty", "", "empty"},
+		{"invalid chars", "SGVsbG8!", "invalid character '!'"},
+		{"url-safe chars", "-_-_", "from the other base64 alphabet"},
+		{"bad padding", "SGVsbG9Xb3JsZA=", "invalid padding"},
+		{"padding in the middle", "SG=sbG8g", "invalid padding"},
+		{"bad length", "SGVsb", "invalid length"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ValidateBase64(tt.input)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ValidateBase64(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
-			}
+			checkFormatReason(t, ValidateBase64(tt.input), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateBase64URL(t *testing.T) {
+	// a JWT header, which is unpadded base64url
+	const jwtHeader = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9"
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"jwt header", jwtHeader, ""},
+		{"url-safe chars", "-_-_", ""},
+		{"padded", "SGVsbG9Xb3JsZA==", ""},
+		{"unpadded", "SGVsbG9Xb3JsZA", ""},
+		{"empty", "", "empty"},
+		{"standard chars", "+/+/", "from the other base64 alphabet"},
+		{"invalid chars", "abc$", "invalid character '$'"},
+		{"bad padding", "SGVsbG9Xb3JsZA=", "invalid padding"},
+		{"bad length", "SGVsb", "invalid length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateBase64URL(tt.input), tt.wantErr)
 		})
 	}
 }
 
+// checkFormatReason fails unless err is nil when want is empty, or a
+// FormatError whose reason contains want.
+func checkFormatReason(t *testing.T, err error, want string) {
+	t.Helper()
+
+	if want == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+	var fe *FormatError
+	if !errors.As(err, &fe) {
+		t.Fatalf("expected FormatError containing %q, got %v", want, err)
+	}
+	if !strings.Contains(fe.Reason, want) {
+		t.Errorf("reason = %q, want %q", fe.Reason, want)
+	}
+}
+
 func TestValidatePEM(t *testing.T) {
 	validPEM := `-----BEGIN CERTIFICATE-----
 MIIBkTCB+wIJAKHBfpA5Q5T0MA0GCSqGSIb3DQEBCwUAMBExDzANBgNVBAMMBnRl
@@ -540,6 +678,147 @@ MIIBkTCB+wIJAKHBfpA5Q5T0MA0GCSqGSIb3DQEBCwUAMBExDzANBgNVBAMMBnRl
 	}
 }
 
+func TestValidateLanguageTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+	}{
+		{"language", "en", ""},
+		{"three letter language", "haw", ""},
+		{"language region", "en-US", ""},
+		{"numeric region", "es-419", ""},
+		{"script region", "zh-Hant-CN", ""},
+		{"script only", "sr-Latn", ""},
+		{"extlang", "zh-yue-HK", ""},
+		{"variant", "de-CH-1996", ""},
+		{"multiple variants", "sl-Latn-IT-rozaj-nedis", ""},
+		{"extension", "en-US-u-ca-gregory", ""},
+		{"private use suffix", "en-x-custom", ""},
+		{"private use only", "x-whatever", ""},
+		{"empty", "", "empty"},
+		{"empty subtag", "en--US", "empty subtag"},
+		{"trailing hyphen", "en-", "empty subtag"},
+		{"language too short", "e", "invalid language subtag"},
+		{"reserved four letter language", "abcd", "invalid language subtag"},
+		{"digit in language", "e1", "invalid language subtag"},
+		{"uppercase language", "EN", "language subtag must be lowercase"},
+		{"lowercase region", "en-us", "region subtag must be uppercase"},
+		{"uppercase script", "zh-HANT", "script subtag must be title case"},
+		{"lowercase script", "zh-hant-CN", "script subtag must be title case"},
+		{"uppercase variant", "sl-IT-ROZAJ", "variant subtag must be lowercase"},
+		{"uppercase extension", "en-U-ca-gregory", "extension subtags must be lowercase"},
+		{"region before script", "zh-CN-Hant", "unexpected subtag"},
+		{"duplicate variant", "sl-rozaj-rozaj", "duplicate variant"},
+		{"extension without subtags", "en-u", "has no subtags"},
+		{"private use without subtags", "en-x", "private use has no subtags"},
+		{"long private use subtag", "x-abcdefghi", "invalid private use subtag"},
+		{"underscore separator", "en_US", "invalid language subtag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateLanguageTag(tt.input), tt.reason)
+		})
+	}
+}
+
+func TestValidateMIMEType(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+	}{
+		{"simple", "text/plain", ""},
+		{"vendor tree", "application/vnd.oci.image.manifest.v1+json", ""},
+		{"mixed case", "Text/HTML", ""},
+		{"parameter", "text/html; charset=utf-8", ""},
+		{"parameter without space", "text/html;charset=utf-8", ""},
+		{"multiple parameters", "multipart/form-data; boundary=abc123; charset=utf-8", ""},
+		{"quoted parameter", `multipart/mixed; boundary="simple boundary; with semicolon"`, ""},
+		{"escaped quote", `text/plain; title="say \"hi\""`, ""},
+		{"space before parameters", "text/plain ; charset=utf-8", ""},
+		{"empty", "", "empty"},
+		{"missing subtype", "text", "missing subtype"},
+		{"empty subtype", "text/", "invalid subtype"},
+		{"empty type", "/plain", "invalid type"},
+		{"extra slash", "text/plain/extra", "invalid subtype"},
+		{"space in type", "te xt/plain", "invalid type"},
+		{"trailing semicolon", "text/plain;", "empty parameter"},
+		{"parameter without value", "text/plain; charset", "parameter missing '='"},
+		{"empty parameter value", "text/plain; charset=", "invalid value"},
+		{"empty parameter name", "text/plain; =utf-8", "invalid parameter name"},
+		{"duplicate parameter", "text/plain; charset=utf-8; Charset=ascii", "duplicate parameter"},
+		{"unterminated quote", `text/plain; title="oops`, "unterminated quoted string"},
+		{"junk after value", `text/plain; title="a"b`, "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateMIMEType(tt.input), tt.reason)
+		})
+	}
+}
+
+func TestValidateHexColor(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+	}{
+		{"3 digit", "#fff", ""},
+		{"6 digit", "#1a2b3c", ""},
+		{"8 digit with alpha", "#1a2b3c80", ""},
+		{"uppercase", "#FFAA00", ""},
+		{"mixed case", "#AbC", ""},
+		{"empty", "", "empty"},
+		{"missing hash", "ffffff", "missing '#'"},
+		{"hash only", "#", "must have 3, 6 or 8 hex digits"},
+		{"4 digits", "#ffff", "must have 3, 6 or 8 hex digits"},
+		{"5 digits", "#fffff", "must have 3, 6 or 8 hex digits"},
+		{"9 digits", "#123456789", "must have 3, 6 or 8 hex digits"},
+		{"non hex digit", "#ggg", "invalid hex digit 'g'"},
+		{"named color", "red", "missing '#'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateHexColor(tt.input), tt.reason)
+		})
+	}
+}
+
+func TestValidateCSSLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+	}{
+		{"pixels", "10px", ""},
+		{"decimal rem", "1.5rem", ""},
+		{"percentage", "50%", ""},
+		{"leading dot", ".5em", ""},
+		{"negative", "-2px", ""},
+		{"viewport", "100vh", ""},
+		{"uppercase unit", "10PX", ""},
+		{"bare zero", "0", ""},
+		{"zero with decimal", "0.0", ""},
+		{"empty", "", "empty"},
+		{"missing unit", "10", "missing unit"},
+		{"unsupported unit", "10furlongs", `unsupported unit "furlongs"`},
+		{"unit only", "px", "invalid number"},
+		{"space before unit", "10 px", "invalid number"},
+		{"trailing dot", "10.px", "invalid number"},
+		{"calc", "calc(100% - 10px)", "invalid number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFormatReason(t, ValidateCSSLength(tt.input), tt.reason)
+		})
+	}
+}
+
 func TestFormatError(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -582,3 +861,57 @@ func containsAt(s, substr string) bool {
 	}
 	return false
 }
+
+func TestValidateImageRepository(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"single component", "nginx", false},
+		{"nested", "library/nginx", false},
+		{"separators", "my_org/my-app.v2", false},
+		{"empty", "", true},
+		{"empty component", "library//nginx", true},
+		{"parent segment", "library/../nginx", true},
+		{"uppercase", "Library/nginx", true},
+		{"tag suffix", "nginx:latest", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateImageRepository(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateImageRepository(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRegistryHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"hostname", "quay.io", false},
+		{"localhost with port", "localhost:5000", false},
+		{"ipv4 with port", "127.0.0.1:5000", false},
+		{"ipv6 with port", "[::1]:5000", false},
+		{"empty", "", true},
+		{"port out of range", "quay.io:99999", true},
+		{"non-numeric port", "quay.io:http", true},
+		{"path characters", "quay.io?x=", true},
+		{"parent segment", "..", true},
+		{"trailing dot", "quay.io.", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRegistryHost(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRegistryHost(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package cel
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// netLib declares ipInCidr(ip, cidr) and isPrivateIP(ip). An invalid
+// address or CIDR is an evaluation error.
+func netLib() cel.EnvOption {
+	return combineEnvOptions(
+		cel.Function("ipInCidr",
+			cel.Overload("ipInCidr_string_string",
+				[]*cel.Type{cel.StringType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(func(ip, cidr ref.Val) ref.Val {
+					i, ok := ip.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(ip)
+					}
+					c, ok := cidr.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(cidr)
+					}
+					in, err := ipInCIDR(string(i), string(c))
+					if err != nil {
+						return types.WrapErr(fmt.Errorf("ip in cidr: %w", err))
+					}
+					return types.Bool(in)
+				}),
+			),
+		),
+		cel.Function("isPrivateIP",
+			cel.Overload("isPrivateIP_string",
+				[]*cel.Type{cel.StringType},
+				cel.BoolType,
+				cel.UnaryBinding(func(ip ref.Val) ref.Val {
+					i, ok := ip.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(ip)
+					}
+					private, err := isPrivateIP(string(i))
+					if err != nil {
+						return types.WrapErr(fmt.Errorf("private ip: %w", err))
+					}
+					return types.Bool(private)
+				}),
+			),
+		),
+	)
+}
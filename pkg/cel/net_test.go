@@ -0,0 +1,71 @@
+package cel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNetFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		val     any
+		wantErr error
+	}{
+		{"v4 in cidr", "ipInCidr(self, '10.0.0.0/8')", "10.20.30.40", nil},
+		{"v4 not in cidr", "ipInCidr(self, '10.0.0.0/8')", "11.0.0.1", ErrValidationFailed},
+		{"v6 in cidr", "ipInCidr(self, 'fd00::/8')", "fd12:3456::1", nil},
+		{"v6 not in cidr", "ipInCidr(self, 'fd00::/8')", "fe80::1", ErrValidationFailed},
+		{"rfc1918 10/8", "isPrivateIP(self)", "10.0.0.1", nil},
+		{"rfc1918 172.16/12", "isPrivateIP(self)", "172.31.255.255", nil},
+		{"rfc1918 192.168/16", "isPrivateIP(self)", "192.168.1.1", nil},
+		{"just outside 172.16/12", "isPrivateIP(self)", "172.32.0.1", ErrValidationFailed},
+		{"public v4", "isPrivateIP(self)", "8.8.8.8", ErrValidationFailed},
+		{"loopback is not private", "isPrivateIP(self)", "127.0.0.1", ErrValidationFailed},
+		{"v6 ula fc00::/7", "isPrivateIP(self)", "fd00::1", nil},
+		{"v6 ula lower half", "isPrivateIP(self)", "fc00::1", nil},
+		{"public v6", "isPrivateIP(self)", "2001:4860:4860::8888", ErrValidationFailed},
+		{"public only", "!isPrivateIP(self)", "1.1.1.1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvalValidateRule(tt.expr, tt.val)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("EvalValidateRule() unexpected error = %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EvalValidateRule() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetFunctionsInvalidInput(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		val  string
+	}{
+		{"invalid ip", "ipInCidr(self, '10.0.0.0/8')", "10.0.0"},
+		{"invalid cidr", "ipInCidr('10.0.0.1', self)", "10.0.0.0/99"},
+		{"invalid private ip", "isPrivateIP(self)", "localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvalValidateRule(tt.expr, tt.val)
+			if err == nil {
+				t.Fatal("expected error for invalid input")
+			}
+			if errors.Is(err, ErrValidationFailed) {
+				t.Error("invalid input should be an eval error, not ErrValidationFailed")
+			}
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Errorf("expected *FormatError, got %v", err)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package cel
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/ext"
@@ -13,6 +15,9 @@ import (
 var (
 	protoEnvCache     sync.Map
 	protoProgramCache sync.Map
+
+	// protoCompiles counts programs compiled into protoProgramCache.
+	protoCompiles atomic.Int64
 )
 
 var (
@@ -62,6 +67,19 @@ func (a *transitionActivation) Parent() cel.Activation {
 	return nil
 }
 
+// PrecompileProto compiles expr against the type of msg and caches the env
+// and program, so a service can warm its rules at startup instead of on the
+// first request. A transition rule is compiled with 'oldSelf' declared.
+func PrecompileProto(expr string, msg proto.Message, transition bool) error {
+	if msg == nil {
+		return errors.New("precompile cel: nil message")
+	}
+	if _, err := getOrCompileProtoProgram(expr, msg, transition); err != nil {
+		return fmt.Errorf("compile cel %q: %w", expr, err)
+	}
+	return nil
+}
+
 // EvalProtoValidateRule evaluates a validation rule using 'self'.
 func EvalProtoValidateRule(expr string, msg proto.Message) error {
 	if msg == nil {
@@ -187,19 +205,12 @@ func getOrCompileProtoProgram(expr string, msg proto.Message, hasOldSelf bool) (
 		return nil, err
 	}
 
-	ast, issues := env.Compile(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("compile: %w", issues.Err())
-	}
-
-	prog, err := env.Program(ast,
-		cel.EvalOptions(cel.OptOptimize),
-		cel.OptimizeRegex(),
-	)
+	prog, err := newProgram(env, expr)
 	if err != nil {
-		return nil, fmt.Errorf("program: %w", err)
+		return nil, err
 	}
 
+	protoCompiles.Add(1)
 	protoProgramCache.Store(cacheKey, prog)
 	return prog, nil
 }
@@ -221,6 +232,7 @@ func getOrCreateProtoEnv(msg proto.Message, hasOldSelf bool) (*cel.Env, error) {
 		cel.Types(msg),
 		ext.Strings(),
 		ext.Encoders(),
+		customFunctions(),
 	}
 
 	msgType := cel.ObjectType(msgName)
@@ -6,6 +6,7 @@ import (
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -204,3 +205,74 @@ func TestProtoProgramCaching(t *testing.T) {
 		t.Fatalf("second call failed: %v", err)
 	}
 }
+
+func TestPrecompileProto(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		transition bool
+		eval       func(expr string) error
+	}{
+		{
+			name: "validate",
+			expr: "self > duration('2s')",
+			eval: func(expr string) error {
+				return EvalProtoValidateRule(expr, durationpb.New(3000000000))
+			},
+		},
+		{
+			name:       "transition",
+			expr:       "self >= oldSelf + duration('2s')",
+			transition: true,
+			eval: func(expr string) error {
+				return EvalProtoTransitionRule(expr, durationpb.New(5000000000), durationpb.New(1000000000))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := protoCompiles.Load()
+			if err := PrecompileProto(tt.expr, &durationpb.Duration{}, tt.transition); err != nil {
+				t.Fatalf("PrecompileProto() unexpected error = %v", err)
+			}
+			if got := protoCompiles.Load() - before; got != 1 {
+				t.Fatalf("PrecompileProto() compiled %d programs, want 1", got)
+			}
+
+			// warming again and the first real eval hit the cache
+			if err := PrecompileProto(tt.expr, &durationpb.Duration{}, tt.transition); err != nil {
+				t.Fatalf("PrecompileProto() unexpected error = %v", err)
+			}
+			if err := tt.eval(tt.expr); err != nil {
+				t.Fatalf("eval unexpected error = %v", err)
+			}
+			if got := protoCompiles.Load() - before; got != 1 {
+				t.Errorf("eval after warmup compiled %d more programs", got-1)
+			}
+		})
+	}
+}
+
+func TestPrecompileProtoErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		msg        proto.Message
+		transition bool
+	}{
+		{"syntax error", "self >", &durationpb.Duration{}, false},
+		{"oldSelf in validate rule", "self > oldSelf", &durationpb.Duration{}, false},
+		{"type mismatch", "self > 5", &durationpb.Duration{}, false},
+		{"unknown field", "self.no_such_field == ''", &sourcecontextpb.SourceContext{}, false},
+		{"nil message", "true", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := PrecompileProto(tt.expr, tt.msg, tt.transition); err == nil {
+				t.Error("expected compile error")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package cel
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+var quantityNumberRegex = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+
+// quantitySuffixes maps Kubernetes quantity suffixes to their multipliers.
+var quantitySuffixes = map[string]float64{
+	"":   1,
+	"n":  1e-9,
+	"u":  1e-6,
+	"m":  1e-3,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// ParseQuantity parses a Kubernetes resource quantity such as "100m",
+// "2Gi", "1.5G" or "5e3" into its numeric value.
+func ParseQuantity(s string) (float64, error) {
+	if s == "" {
+		return 0, &FormatError{Format: "quantity", Value: s, Reason: "empty"}
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("+-0123456789.", r)
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	num, suffix := s[:i], s[i:]
+	if !quantityNumberRegex.MatchString(num) {
+		return 0, &FormatError{Format: "quantity", Value: s, Reason: "invalid number"}
+	}
+
+	mult, ok := quantitySuffixes[suffix]
+	if !ok {
+		exp, ok := quantityExponent(suffix)
+		if !ok {
+			return 0, &FormatError{Format: "quantity", Value: s, Reason: "unknown suffix " + strconv.Quote(suffix)}
+		}
+		mult = math.Pow10(exp)
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, &FormatError{Format: "quantity", Value: s, Reason: "invalid number"}
+	}
+	return f * mult, nil
+}
+
+// quantityExponent parses a decimal exponent suffix such as "e3" or "E-2".
+func quantityExponent(suffix string) (int, bool) {
+	if len(suffix) < 2 || (suffix[0] != 'e' && suffix[0] != 'E') {
+		return 0, false
+	}
+	exp, err := strconv.Atoi(suffix[1:])
+	if err != nil {
+		return 0, false
+	}
+	return exp, true
+}
+
+// ValidateQuantity validates a Kubernetes resource quantity.
+func ValidateQuantity(s string) error {
+	_, err := ParseQuantity(s)
+	return err
+}
+
+// unitsLib declares parseQuantity(s), returning a double, and
+// parseDuration(s), returning a duration. Invalid input is an evaluation
+// error.
+func unitsLib() cel.EnvOption {
+	return combineEnvOptions(
+		cel.Function("parseQuantity",
+			cel.Overload("parseQuantity_string",
+				[]*cel.Type{cel.StringType},
+				cel.DoubleType,
+				cel.UnaryBinding(func(val ref.Val) ref.Val {
+					s, ok := val.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(val)
+					}
+					q, err := ParseQuantity(string(s))
+					if err != nil {
+						return types.WrapErr(fmt.Errorf("parse quantity: %w", err))
+					}
+					return types.Double(q)
+				}),
+			),
+		),
+		cel.Function("parseDuration",
+			cel.Overload("parseDuration_string",
+				[]*cel.Type{cel.StringType},
+				cel.DurationType,
+				cel.UnaryBinding(func(val ref.Val) ref.Val {
+					s, ok := val.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(val)
+					}
+					if err := ValidateDuration(string(s)); err != nil {
+						return types.WrapErr(fmt.Errorf("parse duration: %w", err))
+					}
+					d, _ := time.ParseDuration(string(s))
+					return types.Duration{Duration: d}
+				}),
+			),
+		),
+	)
+}
@@ -0,0 +1,152 @@
+package cel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100m", 0.1},
+		{"1.5", 1.5},
+		{"250u", 0.00025},
+		{"2k", 2000},
+		{"1M", 1e6},
+		{"3G", 3e9},
+		{"1T", 1e12},
+		{"1Ki", 1024},
+		{"128Mi", 128 * 1024 * 1024},
+		{"2Gi", 2 * 1024 * 1024 * 1024},
+		{"0.5Gi", 512 * 1024 * 1024},
+		{"1Ti", 1 << 40},
+		{"5e3", 5000},
+		{"5E-3", 0.005},
+		{"+1k", 1000},
+		{"-1k", -1000},
+		{".5", 0.5},
+		{"1.", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseQuantity(tt.in)
+			if err != nil {
+				t.Fatalf("ParseQuantity() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseQuantity(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuantityInvalid(t *testing.T) {
+	tests := []struct {
+		in     string
+		reason string
+	}{
+		{"", "empty"},
+		{"Gi", "invalid number"},
+		{"1.2.3", "invalid number"},
+		{"1-2", "invalid number"},
+		{"1gi", `unknown suffix "gi"`},
+		{"1KB", `unknown suffix "KB"`},
+		{"1e", `unknown suffix "e"`},
+		{"1 Gi", `unknown suffix " Gi"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := ParseQuantity(tt.in)
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Fatalf("ParseQuantity(%q) error = %v, want *FormatError", tt.in, err)
+			}
+			if fe.Reason != tt.reason {
+				t.Errorf("ParseQuantity(%q) reason = %q, want %q", tt.in, fe.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestUnitFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		newVal  any
+		oldVal  any
+		wantErr error
+	}{
+		{
+			name:   "memory decrease passes",
+			expr:   "parseQuantity(self.memory) <= parseQuantity(oldSelf.memory)",
+			newVal: map[string]any{"memory": "512Mi"},
+			oldVal: map[string]any{"memory": "1Gi"},
+		},
+		{
+			name:    "memory increase fails",
+			expr:    "parseQuantity(self.memory) <= parseQuantity(oldSelf.memory)",
+			newVal:  map[string]any{"memory": "2G"},
+			oldVal:  map[string]any{"memory": "1Gi"},
+			wantErr: ErrTransitionFailed,
+		},
+		{
+			name:   "milli cpu compares with cores",
+			expr:   "parseQuantity(self.cpu) < parseQuantity(oldSelf.cpu)",
+			newVal: map[string]any{"cpu": "500m"},
+			oldVal: map[string]any{"cpu": "1"},
+		},
+		{
+			name:    "equal duration fails strict increase",
+			expr:    "parseDuration(self.timeout) > parseDuration(oldSelf.timeout)",
+			newVal:  map[string]any{"timeout": "1m30s"},
+			oldVal:  map[string]any{"timeout": "90s"},
+			wantErr: ErrTransitionFailed,
+		},
+		{
+			name:   "duration compares with literal",
+			expr:   "parseDuration(self.timeout) <= duration('1h')",
+			newVal: map[string]any{"timeout": "45m"},
+			oldVal: map[string]any{"timeout": "2h"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvalMessageTransitionRule(tt.expr, tt.newVal, tt.oldVal)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("EvalMessageTransitionRule() unexpected error = %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EvalMessageTransitionRule() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnitFunctionsInvalidInput(t *testing.T) {
+	for _, expr := range []string{
+		"parseQuantity(self) > 0.0",
+		"parseDuration(self) > duration('0s')",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			err := EvalValidateRule(expr, "ten")
+			if err == nil {
+				t.Fatal("expected error for invalid input")
+			}
+			if errors.Is(err, ErrValidationFailed) {
+				t.Error("invalid input should be an eval error, not ErrValidationFailed")
+			}
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Errorf("expected *FormatError, got %v", err)
+			}
+		})
+	}
+}
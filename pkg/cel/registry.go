@@ -0,0 +1,98 @@
+package cel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// ErrUnknownFormat is returned for a format name that is not registered.
+var ErrUnknownFormat = errors.New("unknown format")
+
+var (
+	formatsMu sync.RWMutex
+	// formats maps names, as reported in FormatError.Format, to validators.
+	formats = map[string]func(string) error{
+		"email":          ValidateEmail,
+		"uri":            ValidateURI,
+		"uri_ref":        ValidateURIRef,
+		"hostname":       ValidateHostname,
+		"ipv4":           ValidateIPv4,
+		"ipv6":           ValidateIPv6,
+		"ip":             ValidateIP,
+		"uuid":           ValidateUUID,
+		"uuid_v1":        ValidateUUIDv1,
+		"uuid_v4":        ValidateUUIDv4,
+		"uuid_v7":        ValidateUUIDv7,
+		"dns_label":      ValidateDNSLabel,
+		"dns_subdomain":  ValidateDNSSubdomain,
+		"qualified_name": ValidateQualifiedName,
+		"image_ref":      ValidateImageRef,
+		"image_tag":      ValidateImageTag,
+		"image_digest":   ValidateImageDigest,
+		"repository":     ValidateImageRepository,
+		"registry_host":  ValidateRegistryHost,
+		"date":           ValidateDate,
+		"datetime":       ValidateDatetime,
+		"duration":       ValidateDuration,
+		"semver":         ValidateSemver,
+		"base64":         ValidateBase64,
+		"base64url":      ValidateBase64URL,
+		"pem":            ValidatePEM,
+		"quantity":       ValidateQuantity,
+		"language_tag":   ValidateLanguageTag,
+		"mime_type":      ValidateMIMEType,
+		"hex_color":      ValidateHexColor,
+		"css_length":     ValidateCSSLength,
+	}
+)
+
+// RegisterFormat adds or replaces the validator for a named format, making
+// it available to ValidateFormat and to format() in CEL rules.
+func RegisterFormat(name string, fn func(string) error) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = fn
+}
+
+// ValidateFormat validates value against the named format.
+func ValidateFormat(name, value string) error {
+	formatsMu.RLock()
+	fn, ok := formats[name]
+	formatsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+	}
+	return fn(value)
+}
+
+// formatLib declares format(name, value), which is true when value matches
+// the named format. An unknown name is an evaluation error.
+func formatLib() cel.EnvOption {
+	return cel.Function("format",
+		cel.Overload("format_string_string",
+			[]*cel.Type{cel.StringType, cel.StringType},
+			cel.BoolType,
+			cel.BinaryBinding(func(name, value ref.Val) ref.Val {
+				n, ok := name.(types.String)
+				if !ok {
+					return types.MaybeNoSuchOverloadErr(name)
+				}
+				v, ok := value.(types.String)
+				if !ok {
+					return types.MaybeNoSuchOverloadErr(value)
+				}
+
+				err := ValidateFormat(string(n), string(v))
+				if errors.Is(err, ErrUnknownFormat) {
+					return types.WrapErr(err)
+				}
+				return types.Bool(err == nil)
+			}),
+		),
+	)
+}
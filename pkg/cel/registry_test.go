@@ -0,0 +1,102 @@
+package cel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		value   string
+		wantErr bool
+		unknown bool
+	}{
+		{"valid uuid", "uuid", "550e8400-e29b-41d4-a716-446655440000", false, false},
+		{"invalid uuid", "uuid", "not-a-uuid", true, false},
+		{"valid image ref", "image_ref", "quay.io/fray/app:v1", false, false},
+		{"invalid email", "email", "nobody", true, false},
+		{"unknown format", "nope", "x", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFormat(tt.format, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrUnknownFormat) != tt.unknown {
+				t.Errorf("ValidateFormat() error = %v, unknown %v", err, tt.unknown)
+			}
+			var fe *FormatError
+			if tt.wantErr && !tt.unknown && !errors.As(err, &fe) {
+				t.Errorf("ValidateFormat() error = %T, want *FormatError", err)
+			}
+		})
+	}
+}
+
+func TestFormatFunction(t *testing.T) {
+	RegisterFormat("test_lowercase", func(s string) error {
+		if s != strings.ToLower(s) {
+			return &FormatError{Format: "test_lowercase", Value: s, Reason: "not lowercase"}
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		expr    string
+		val     any
+		wantErr error
+	}{
+		{
+			name: "uuid passes",
+			expr: "format('uuid', self)",
+			val:  "550e8400-e29b-41d4-a716-446655440000",
+		},
+		{
+			name:    "uuid fails",
+			expr:    "format('uuid', self)",
+			val:     "not-a-uuid",
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name: "combined with other checks",
+			expr: "self != '' && format('image_ref', self)",
+			val:  "quay.io/fray/app:v1",
+		},
+		{
+			name: "custom format passes",
+			expr: "format('test_lowercase', self)",
+			val:  "fray",
+		},
+		{
+			name:    "custom format fails",
+			expr:    "format('test_lowercase', self)",
+			val:     "Fray",
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name:    "unknown format errors",
+			expr:    "format('no_such_format', self)",
+			val:     "x",
+			wantErr: ErrUnknownFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvalValidateRule(tt.expr, tt.val)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("EvalValidateRule() unexpected error = %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EvalValidateRule() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
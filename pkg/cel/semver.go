@@ -0,0 +1,153 @@
+package cel
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// Semver is a parsed semantic version. Build metadata is dropped since it
+// does not affect precedence.
+type Semver struct {
+	Major, Minor, Patch uint64
+	Prerelease          []string
+}
+
+// ParseSemver parses a semantic version, with or without a leading "v".
+func ParseSemver(s string) (Semver, error) {
+	if err := ValidateSemver(s); err != nil {
+		return Semver{}, err
+	}
+
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	core, pre, hasPre := strings.Cut(core, "-")
+
+	var v Semver
+	parts := strings.Split(core, ".")
+	for i, p := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.ParseUint(parts[i], 10, 64)
+		if err != nil {
+			return Semver{}, &FormatError{Format: "semver", Value: s, Reason: "version number out of range"}
+		}
+		*p = n
+	}
+	if hasPre {
+		v.Prerelease = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 as v has lower, equal or higher precedence
+// than o.
+func (v Semver) Compare(o Semver) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// a prerelease has lower precedence than the release itself
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.Prerelease), len(o.Prerelease))
+}
+
+// comparePrerelease orders numeric identifiers numerically and below
+// alphanumeric ones, which are ordered lexically.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// CompareSemver parses a and b and compares their precedence.
+func CompareSemver(a, b string) (int, error) {
+	va, err := ParseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// semverLib declares semverCompare(a, b), returning -1, 0 or 1, and
+// semverLess(a, b). An invalid version is an evaluation error.
+func semverLib() cel.EnvOption {
+	compare := func(a, b ref.Val) (int, ref.Val) {
+		as, ok := a.(types.String)
+		if !ok {
+			return 0, types.MaybeNoSuchOverloadErr(a)
+		}
+		bs, ok := b.(types.String)
+		if !ok {
+			return 0, types.MaybeNoSuchOverloadErr(b)
+		}
+		c, err := CompareSemver(string(as), string(bs))
+		if err != nil {
+			return 0, types.WrapErr(fmt.Errorf("compare semver: %w", err))
+		}
+		return c, nil
+	}
+
+	return combineEnvOptions(
+		cel.Function("semverCompare",
+			cel.Overload("semverCompare_string_string",
+				[]*cel.Type{cel.StringType, cel.StringType},
+				cel.IntType,
+				cel.BinaryBinding(func(a, b ref.Val) ref.Val {
+					c, errVal := compare(a, b)
+					if errVal != nil {
+						return errVal
+					}
+					return types.Int(c)
+				}),
+			),
+		),
+		cel.Function("semverLess",
+			cel.Overload("semverLess_string_string",
+				[]*cel.Type{cel.StringType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(func(a, b ref.Val) ref.Val {
+					c, errVal := compare(a, b)
+					if errVal != nil {
+						return errVal
+					}
+					return types.Bool(c < 0)
+				}),
+			),
+		),
+	)
+}
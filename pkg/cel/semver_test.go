@@ -0,0 +1,136 @@
+package cel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"2.1.0", "2.0.9", 1},
+		{"1.2.3", "1.2.10", -1},
+		{"v1.2.3", "1.2.3", 0},
+		// prerelease ordering from semver 2.0.0, section 11
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		// build metadata is ignored
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-alpha+001", "1.0.0-alpha", 0},
+		{"1.0.0+20130313144700", "1.0.1", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			got, err := CompareSemver(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("CompareSemver() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CompareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareSemverInvalid(t *testing.T) {
+	for _, s := range []string{"", "1.0", "01.0.0", "1.0.0-", "99999999999999999999.0.0"} {
+		t.Run(s, func(t *testing.T) {
+			_, err := CompareSemver(s, "1.0.0")
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Errorf("CompareSemver(%q) error = %v, want *FormatError", s, err)
+			}
+		})
+	}
+}
+
+func TestSemverFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		newVal  any
+		oldVal  any
+		wantErr error
+	}{
+		{
+			name:   "upgrade passes",
+			expr:   "semverLess(oldSelf.version, self.version)",
+			newVal: map[string]any{"version": "1.1.0"},
+			oldVal: map[string]any{"version": "1.0.0"},
+		},
+		{
+			name:    "downgrade fails",
+			expr:    "semverLess(oldSelf.version, self.version)",
+			newVal:  map[string]any{"version": "1.0.0-rc.1"},
+			oldVal:  map[string]any{"version": "1.0.0"},
+			wantErr: ErrTransitionFailed,
+		},
+		{
+			name:   "prerelease to release passes",
+			expr:   "semverLess(oldSelf.version, self.version)",
+			newVal: map[string]any{"version": "1.0.0"},
+			oldVal: map[string]any{"version": "1.0.0-alpha"},
+		},
+		{
+			name:    "build metadata only fails",
+			expr:    "semverLess(oldSelf.version, self.version)",
+			newVal:  map[string]any{"version": "1.0.0+build.2"},
+			oldVal:  map[string]any{"version": "1.0.0+build.1"},
+			wantErr: ErrTransitionFailed,
+		},
+		{
+			name:   "compare allows equal",
+			expr:   "semverCompare(self.version, oldSelf.version) >= 0",
+			newVal: map[string]any{"version": "1.0.0+build.2"},
+			oldVal: map[string]any{"version": "1.0.0+build.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EvalMessageTransitionRule(tt.expr, tt.newVal, tt.oldVal)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("EvalMessageTransitionRule() unexpected error = %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EvalMessageTransitionRule() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSemverFunctionInvalidVersion(t *testing.T) {
+	err := EvalValidateRule("semverCompare(self, '1.0.0') > 0", "not-a-version")
+	if err == nil {
+		t.Fatal("expected error for invalid version")
+	}
+	if errors.Is(err, ErrValidationFailed) {
+		t.Error("invalid version should be an eval error, not ErrValidationFailed")
+	}
+	var fe *FormatError
+	if !errors.As(err, &fe) {
+		t.Errorf("expected *FormatError, got %v", err)
+	}
+}
+
+func TestSemverFieldTransition(t *testing.T) {
+	if err := EvalTransitionRule("semverLess(oldSelf, this)", "1.0.0", "1.0.0-alpha"); err != nil {
+		t.Errorf("EvalTransitionRule() unexpected error = %v", err)
+	}
+	if err := EvalTransitionRule("semverLess(oldSelf, this)", "1.0.0-alpha", "1.0.0"); !errors.Is(err, ErrTransitionFailed) {
+		t.Errorf("EvalTransitionRule() error = %v, want %v", err, ErrTransitionFailed)
+	}
+}
@@ -0,0 +1,87 @@
+package cel
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldViolation is one failed rule and the field it applies to.
+type FieldViolation struct {
+	// Field is the path to the field, such as "Items[0].Sku". It is empty
+	// for message rules at the top level.
+	Field       string
+	Description string
+}
+
+// ValidationResult collects every rule that fails during validation, so a
+// caller can report all of them at once. It is an error when returned by
+// Err and matches ErrValidationFailed.
+type ValidationResult struct {
+	Violations []FieldViolation
+}
+
+// Add records a violation of field.
+func (r *ValidationResult) Add(field, description string) {
+	r.Violations = append(r.Violations, FieldViolation{Field: field, Description: description})
+}
+
+// Check records err as a violation of field. A nil err is ignored.
+func (r *ValidationResult) Check(field string, err error) {
+	if err != nil {
+		r.Add(field, err.Error())
+	}
+}
+
+// Nested records the result of validating the message in field. Violations
+// from a nested ValidationResult keep their paths under field; any other
+// error is recorded against field itself.
+func (r *ValidationResult) Nested(field string, err error) {
+	if err == nil {
+		return
+	}
+
+	var nested *ValidationResult
+	if !errors.As(err, &nested) {
+		r.Check(field, err)
+		return
+	}
+	for _, v := range nested.Violations {
+		r.Add(joinFieldPath(field, v.Field), v.Description)
+	}
+}
+
+// Err returns r if any violation was recorded, and nil otherwise.
+func (r *ValidationResult) Err() error {
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (r *ValidationResult) Error() string {
+	msgs := make([]string, len(r.Violations))
+	for i, v := range r.Violations {
+		if v.Field == "" {
+			msgs[i] = v.Description
+			continue
+		}
+		msgs[i] = v.Field + ": " + v.Description
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (r *ValidationResult) Unwrap() error {
+	return ErrValidationFailed
+}
+
+// joinFieldPath appends field to the path of its parent message.
+func joinFieldPath(parent, field string) string {
+	switch {
+	case field == "":
+		return parent
+	case parent == "":
+		return field
+	default:
+		return parent + "." + field
+	}
+}
@@ -0,0 +1,104 @@
+package cel
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidationResult(t *testing.T) {
+	item := &ValidationResult{}
+	item.Add("Sku", "must match pattern")
+	item.Add("Quantity", "must be <= 1000")
+
+	msg := &ValidationResult{}
+	msg.Add("", "order must have at least one item")
+
+	tests := []struct {
+		name    string
+		build   func(r *ValidationResult)
+		want    []FieldViolation
+		wantMsg string
+	}{
+		{
+			name:  "no violations",
+			build: func(r *ValidationResult) {},
+		},
+		{
+			name: "nil check is ignored",
+			build: func(r *ValidationResult) {
+				r.Check("Id", nil)
+			},
+		},
+		{
+			name: "multiple fields",
+			build: func(r *ValidationResult) {
+				r.Add("Id", "is required")
+				r.Check("CustomerEmail", errors.New("invalid email"))
+			},
+			want: []FieldViolation{
+				{Field: "Id", Description: "is required"},
+				{Field: "CustomerEmail", Description: "invalid email"},
+			},
+			wantMsg: "Id: is required; CustomerEmail: invalid email",
+		},
+		{
+			name: "nested paths",
+			build: func(r *ValidationResult) {
+				r.Nested("Items[0]", item.Err())
+				r.Nested("Order", msg.Err())
+			},
+			want: []FieldViolation{
+				{Field: "Items[0].Sku", Description: "must match pattern"},
+				{Field: "Items[0].Quantity", Description: "must be <= 1000"},
+				{Field: "Order", Description: "order must have at least one item"},
+			},
+			wantMsg: "Items[0].Sku: must match pattern; Items[0].Quantity: must be <= 1000; Order: order must have at least one item",
+		},
+		{
+			name: "nested plain error",
+			build: func(r *ValidationResult) {
+				r.Nested("Order", errors.New("bad order"))
+			},
+			want:    []FieldViolation{{Field: "Order", Description: "bad order"}},
+			wantMsg: "Order: bad order",
+		},
+		{
+			name: "message rule",
+			build: func(r *ValidationResult) {
+				r.Nested("", msg.Err())
+			},
+			want:    []FieldViolation{{Field: "", Description: "order must have at least one item"}},
+			wantMsg: "order must have at least one item",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ValidationResult{}
+			tt.build(r)
+
+			err := r.Err()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrValidationFailed) {
+				t.Errorf("expected ErrValidationFailed, got %v", err)
+			}
+			var got *ValidationResult
+			if !errors.As(err, &got) {
+				t.Fatalf("expected *ValidationResult, got %T", err)
+			}
+			if !reflect.DeepEqual(got.Violations, tt.want) {
+				t.Errorf("violations = %+v, want %+v", got.Violations, tt.want)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("message = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Package digest parses, formats and computes OCI content digests of the
+// form algorithm:encoded.
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+var (
+	ErrInvalid              = errors.New("invalid digest")
+	ErrUnsupportedAlgorithm = errors.New("unsupported digest algorithm")
+)
+
+// Algorithm identifies a digest hash function.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	SHA512 Algorithm = "sha512"
+
+	// Canonical is the algorithm fray uses for content it digests itself.
+	Canonical = SHA256
+)
+
+// Available reports whether a is a supported algorithm.
+func (a Algorithm) Available() bool {
+	return a == SHA256 || a == SHA512
+}
+
+// New returns a new hash for a. It panics if a is not available.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	}
+	panic(fmt.Sprintf("digest: unavailable algorithm %q", string(a)))
+}
+
+// FromHash formats the current sum of h, which must have been created by
+// a.New.
+func (a Algorithm) FromHash(h hash.Hash) Digest {
+	return Digest(string(a) + ":" + hex.EncodeToString(h.Sum(nil)))
+}
+
+// FromBytes digests data with a.
+func (a Algorithm) FromBytes(data []byte) Digest {
+	h := a.New()
+	h.Write(data)
+	return a.FromHash(h)
+}
+
+// FromBytes digests data with the canonical algorithm.
+func FromBytes(data []byte) Digest {
+	return Canonical.FromBytes(data)
+}
+
+// Digest is a content digest such as sha256:<hex>.
+type Digest string
+
+// Parse checks that s is algorithm:encoded with an available algorithm and a
+// non-empty lowercase hex encoding. Parsed digests are safe to use as path
+// components.
+func Parse(s string) (Digest, error) {
+	algorithm, encoded, ok := strings.Cut(s, ":")
+	if !ok || algorithm == "" {
+		return "", fmt.Errorf("%w %q: must be algorithm:encoded", ErrInvalid, s)
+	}
+	if !Algorithm(algorithm).Available() {
+		return "", fmt.Errorf("%w %q: %w %q", ErrInvalid, s, ErrUnsupportedAlgorithm, algorithm)
+	}
+	if encoded == "" || strings.Trim(encoded, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w %q: encoding must be lowercase hex", ErrInvalid, s)
+	}
+	return Digest(s), nil
+}
+
+// Normalize lowercases the hex encoding of s before parsing it, so digests
+// from clients that send uppercase hex resolve to the same content.
+func Normalize(s string) (Digest, error) {
+	algorithm, encoded, ok := strings.Cut(s, ":")
+	if ok {
+		s = algorithm + ":" + strings.ToLower(encoded)
+	}
+	return Parse(s)
+}
+
+// Algorithm returns the algorithm part of d.
+func (d Digest) Algorithm() Algorithm {
+	algorithm, _, _ := strings.Cut(string(d), ":")
+	return Algorithm(algorithm)
+}
+
+// Encoded returns the hex part of d.
+func (d Digest) Encoded() string {
+	_, encoded, _ := strings.Cut(string(d), ":")
+	return encoded
+}
+
+func (d Digest) String() string {
+	return string(d)
+}
@@ -0,0 +1,118 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	sha256Hex := strings.Repeat("a", 64)
+
+	tests := []struct {
+		name          string
+		in            string
+		wantAlgorithm Algorithm
+		wantEncoded   string
+		wantErr       error
+	}{
+		{"sha256", "sha256:" + sha256Hex, SHA256, sha256Hex, nil},
+		{"sha512", "sha512:" + strings.Repeat("b", 128), SHA512, strings.Repeat("b", 128), nil},
+		{"unknown algorithm", "md5:d41d8cd98f00b204e9800998ecf8427e", "", "", ErrUnsupportedAlgorithm},
+		{"missing algorithm", sha256Hex, "", "", ErrInvalid},
+		{"empty algorithm", ":" + sha256Hex, "", "", ErrInvalid},
+		{"empty encoding", "sha256:", "", "", ErrInvalid},
+		{"uppercase hex", "sha256:ABC123", "", "", ErrInvalid},
+		{"path in encoding", "sha256:../../escape", "", "", ErrInvalid},
+		{"empty", "", "", "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			d, err := Parse(tt.in)
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				require.True(errors.Is(err, ErrInvalid))
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.wantAlgorithm, d.Algorithm())
+			require.Equal(tt.wantEncoded, d.Encoded())
+			require.Equal(tt.in, d.String())
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	sha256Hex := strings.Repeat("a", 64)
+
+	tests := []struct {
+		name    string
+		in      string
+		want    Digest
+		wantErr error
+	}{
+		{"lowercase", "sha256:" + sha256Hex, Digest("sha256:" + sha256Hex), nil},
+		{"uppercase", "sha256:" + strings.ToUpper(sha256Hex), Digest("sha256:" + sha256Hex), nil},
+		{"mixed case", "sha256:AbC123", "sha256:abc123", nil},
+		{"non-hex", "sha256:XYZ123", "", ErrInvalid},
+		{"path in encoding", "sha256:../../ESCAPE", "", ErrInvalid},
+		{"unknown algorithm", "MD5:d41d8cd98f00b204e9800998ecf8427e", "", ErrUnsupportedAlgorithm},
+		{"missing algorithm", sha256Hex, "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			d, err := Normalize(tt.in)
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.want, d)
+		})
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	data := []byte("fray digest")
+
+	tests := []struct {
+		name string
+		got  Digest
+		want string
+	}{
+		{"canonical", FromBytes(data), fmt.Sprintf("sha256:%x", sha256.Sum256(data))},
+		{"sha256", SHA256.FromBytes(data), fmt.Sprintf("sha256:%x", sha256.Sum256(data))},
+		{"sha512", SHA512.FromBytes(data), fmt.Sprintf("sha512:%x", sha512.Sum512(data))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			require.Equal(tt.want, tt.got.String())
+			parsed, err := Parse(tt.want)
+			require.NoError(err)
+			require.Equal(tt.got, parsed)
+		})
+	}
+}
+
+func TestAlgorithmAvailable(t *testing.T) {
+	require := require.New(t)
+
+	require.True(SHA256.Available())
+	require.True(SHA512.Available())
+	require.False(Algorithm("md5").Available())
+	require.Panics(func() { Algorithm("md5").New() })
+}
@@ -0,0 +1,48 @@
+package merkle
+
+import "fmt"
+
+// UnknownHash marks a chunk a peer has but whose hash it didn't send, as in
+// trees built by TreeFromBitmap. Such trees are only good for presence
+// comparisons like Diff; their Root is meaningless.
+const UnknownHash Hash = ^Hash(0)
+
+// Bitmap returns chunk presence packed one bit per chunk, chunk i at bit
+// i%8 of byte i/8. It is the compact form peers exchange to find which
+// chunks each has.
+func (t *Tree) Bitmap() []byte {
+	bitmap := make([]byte, (t.NumChunks+7)/8)
+	for i := 0; i < t.NumChunks; i++ {
+		if !t.Leaves[i].IsEmpty() {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bitmap
+}
+
+// TreeFromBitmap builds a tree for a blob of totalSize split into chunkSize
+// chunks, with the chunks set in bitmap present and their hashes set to
+// UnknownHash.
+func TreeFromBitmap(totalSize int64, chunkSize int, bitmap []byte) (*Tree, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size %d must be positive", chunkSize)
+	}
+
+	t := New(totalSize, chunkSize)
+	if want := (t.NumChunks + 7) / 8; len(bitmap) != want {
+		return nil, fmt.Errorf("bitmap is %d bytes, %d chunks need %d", len(bitmap), t.NumChunks, want)
+	}
+
+	for i := range len(bitmap) * 8 {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if i >= t.NumChunks {
+			return nil, fmt.Errorf("bitmap marks chunk %d present, beyond %d chunks", i, t.NumChunks)
+		}
+		t.Leaves[i] = UnknownHash
+		t.PresentCount++
+	}
+
+	return t, nil
+}
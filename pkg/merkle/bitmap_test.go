@@ -0,0 +1,88 @@
+package merkle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitmapRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		totalSize  int64
+		present    []int
+		wantBitmap []byte
+	}{
+		{"empty blob", 0, nil, []byte{}},
+		{"none present", 10 * 1024, nil, []byte{0x00, 0x00}},
+		{"first and last", 10 * 1024, []int{0, 9}, []byte{0x01, 0x02}},
+		{"full byte", 8 * 1024, []int{0, 1, 2, 3, 4, 5, 6, 7}, []byte{0xff}},
+		{"partial last chunk", 9*1024 + 1, []int{3, 8, 9}, []byte{0x08, 0x03}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			tree := New(tt.totalSize, 1024)
+			for _, i := range tt.present {
+				require.NoError(tree.SetChunk(i, []byte("data")))
+			}
+
+			bitmap := tree.Bitmap()
+			require.Equal(tt.wantBitmap, bitmap)
+
+			peer, err := TreeFromBitmap(tt.totalSize, 1024, bitmap)
+			require.NoError(err)
+			require.Equal(tree.NumChunks, peer.NumChunks)
+			require.Equal(tree.PresentCount, peer.PresentCount)
+			require.Equal(tree.MissingChunks(), peer.MissingChunks())
+			require.Equal(bitmap, peer.Bitmap())
+			for _, i := range tt.present {
+				require.Equal(UnknownHash, peer.ChunkHash(i))
+			}
+		})
+	}
+}
+
+func TestTreeFromBitmapInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		totalSize int64
+		chunkSize int
+		bitmap    []byte
+	}{
+		{"too short", 10 * 1024, 1024, []byte{0xff}},
+		{"too long", 8 * 1024, 1024, []byte{0xff, 0x00}},
+		{"bit past last chunk", 10 * 1024, 1024, []byte{0x00, 0x04}},
+		{"zero chunk size", 1024, 0, []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := TreeFromBitmap(tt.totalSize, tt.chunkSize, tt.bitmap)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDiffBitmapPeer(t *testing.T) {
+	require := require.New(t)
+
+	local := New(6*1024, 1024)
+	for _, i := range []int{0, 1, 2} {
+		require.NoError(local.SetChunk(i, []byte("local")))
+	}
+
+	// the peer has chunks 2, 3 and 5, and sent only which
+	peer, err := TreeFromBitmap(6*1024, 1024, []byte{0b101100})
+	require.NoError(err)
+
+	toSend, toReceive := local.Diff(peer)
+	require.Equal([]int{0, 1}, toSend)
+	require.Equal([]int{3, 5}, toReceive)
+
+	toSend, toReceive = peer.Diff(local)
+	require.Equal([]int{3, 5}, toSend)
+	require.Equal([]int{0, 1}, toReceive)
+}
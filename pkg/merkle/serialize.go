@@ -57,16 +57,43 @@ func Deserialize(s *State) (*Tree, error) {
 	return t, nil
 }
 
-// SaveToFile saves the tree state to a JSON file.
+// SaveToFile saves the tree state to a JSON file. The state is written to a
+// temp file and renamed over path, so a crash mid-save leaves the previous
+// state intact.
 func (t *Tree) SaveToFile(path string) error {
-	state := t.Serialize()
+	return WriteJSONFile(path, t.Serialize())
+}
 
-	data, err := json.MarshalIndent(state, "", "  ")
+// WriteJSONFile writes v to path as indented JSON the way SaveToFile does,
+// for state files that extend State.
+func WriteJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // LoadFromFile loads a tree from a JSON state file.
@@ -193,6 +193,7 @@ func (t *Tree) Progress() float64 {
 }
 
 // Diff compares this tree with another and returns chunks that differ.
+// Only presence is compared, so other may come from TreeFromBitmap.
 func (t *Tree) Diff(other *Tree) (toSend, toReceive []int) {
 	if t.NumChunks != other.NumChunks {
 		return nil, nil
@@ -319,6 +319,36 @@ func TestFileRoundTrip(t *testing.T) {
 	require.Equal(tree.PresentCount, loaded.PresentCount)
 }
 
+func TestSaveToFileTornWrite(t *testing.T) {
+	require := require.New(t)
+
+	path := t.TempDir() + "/tree.json"
+	good := New(4*1024*1024, 1024*1024)
+	require.NoError(good.SetChunk(0, []byte("chunk 0")))
+	require.NoError(good.SaveToFile(path))
+
+	// a crash mid-save leaves a truncated temp file beside the state
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	require.NoError(os.WriteFile(path+".tmp", data[:len(data)/2], 0644))
+
+	loaded, err := LoadFromFile(path)
+	require.NoError(err)
+	require.Equal(good.Root(), loaded.Root())
+
+	// a save that can't complete must not touch the previous state
+	require.NoError(os.Remove(path + ".tmp"))
+	require.NoError(os.MkdirAll(path+".tmp/busy", 0755))
+	next := New(4*1024*1024, 1024*1024)
+	require.NoError(next.SetChunk(1, []byte("chunk 1")))
+	require.Error(next.SaveToFile(path))
+
+	loaded, err = LoadFromFile(path)
+	require.NoError(err)
+	require.Equal(good.Root(), loaded.Root())
+	require.Equal(1, loaded.PresentCount)
+}
+
 func TestProgress(t *testing.T) {
 	tests := []struct {
 		name         string
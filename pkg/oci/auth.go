@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -13,15 +13,29 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/hexfusion/fray/internal/version"
 )
 
-const tokenCacheTTL = 5 * time.Minute
+const (
+	tokenCacheTTL = 5 * time.Minute
+
+	scopePull = "pull"
+	scopePush = "pull,push"
+
+	// maxTokenResponse caps token responses; real ones are a few KB.
+	maxTokenResponse = 1024 * 1024
+	// maxChallengeHeader caps the WWW-Authenticate header parsed from a
+	// registry; real ones are a few hundred bytes.
+	maxChallengeHeader = 8 * 1024
+)
 
 // RegistryAuth reads credentials from container config files.
 type RegistryAuth struct {
-	mu       sync.RWMutex
-	tokens   map[string]tokenEntry
-	insecure map[string]bool
+	mu     sync.RWMutex
+	tokens map[string]tokenEntry
+	config *RegistryConfig
+	clock  Clock
 }
 
 type tokenEntry struct {
@@ -35,7 +49,10 @@ type authConfig struct {
 	} `json:"auths"`
 }
 
+// challenge is a WWW-Authenticate challenge. scheme is lowercase, such as
+// bearer or basic.
 type challenge struct {
+	scheme  string
 	realm   string
 	service string
 	scope   string
@@ -44,30 +61,47 @@ type challenge struct {
 // NewRegistryAuth creates an auth provider that reads container credentials.
 func NewRegistryAuth() *RegistryAuth {
 	return &RegistryAuth{
-		tokens:   make(map[string]tokenEntry, 8),
-		insecure: make(map[string]bool),
+		tokens: make(map[string]tokenEntry, 8),
+		config: NewRegistryConfig(),
+		clock:  RealClock(),
 	}
 }
 
+// SetClock sets the clock used to expire cached tokens.
+func (r *RegistryAuth) SetClock(clock Clock) {
+	r.clock = clock
+}
+
+// SetConfig sets the registry connection settings. Client.SetAuth calls this
+// so auth requests share the client's config.
+func (r *RegistryAuth) SetConfig(config *RegistryConfig) {
+	r.config = config
+}
+
 // SetInsecure marks a registry as insecure (HTTP instead of HTTPS).
 func (r *RegistryAuth) SetInsecure(registry string, insecure bool) {
-	r.insecure[registry] = insecure
+	r.config.SetInsecure(registry, insecure)
 }
 
 func (r *RegistryAuth) registryURL(registry string) string {
-	scheme := "https"
-	if r.insecure[registry] {
-		scheme = "http"
-	}
-	return fmt.Sprintf("%s://%s", scheme, registry)
+	return r.config.URL(registry)
 }
 
 // GetAuth returns the authorization header for a registry and repo.
 func (r *RegistryAuth) GetAuth(ctx context.Context, registry, repo string) (string, error) {
-	cacheKey := registry + "/" + repo
+	return r.getAuth(ctx, registry, repo, scopePull)
+}
+
+// GetPushAuth returns an authorization header scoped for pushing to repo.
+func (r *RegistryAuth) GetPushAuth(ctx context.Context, registry, repo string) (string, error) {
+	return r.getAuth(ctx, registry, repo, scopePush)
+}
+
+func (r *RegistryAuth) getAuth(ctx context.Context, registry, repo, actions string) (string, error) {
+	cacheKey := registry + "/" + repo + ":" + actions
 
 	r.mu.RLock()
-	if entry, ok := r.tokens[cacheKey]; ok && time.Now().Before(entry.expires) {
+	if entry, ok := r.tokens[cacheKey]; ok && r.clock.Now().Before(entry.expires) {
 		r.mu.RUnlock()
 		return "Bearer " + entry.token, nil
 	}
@@ -76,8 +110,12 @@ func (r *RegistryAuth) GetAuth(ctx context.Context, registry, repo string) (stri
 	username, password := r.loadCredentials(registry)
 
 	ch, err := r.fetchChallenge(ctx, registry)
-	if err == nil && ch.realm != "" {
-		token, err := r.getToken(ctx, ch, repo, username, password)
+	if errors.Is(err, ErrTooLarge) {
+		return "", err
+	}
+	if err == nil && ch != nil && ch.scheme == "bearer" && ch.realm != "" {
+		scope := fmt.Sprintf("repository:%s:%s", repo, actions)
+		token, err := r.getToken(ctx, ch, scope, username, password)
 		if err != nil {
 			return "", err
 		}
@@ -85,13 +123,15 @@ func (r *RegistryAuth) GetAuth(ctx context.Context, registry, repo string) (stri
 		r.mu.Lock()
 		r.tokens[cacheKey] = tokenEntry{
 			token:   token,
-			expires: time.Now().Add(tokenCacheTTL),
+			expires: r.clock.Now().Add(tokenCacheTTL),
 		}
 		r.mu.Unlock()
 
 		return "Bearer " + token, nil
 	}
 
+	// basic challenges, and registries that don't challenge, get the
+	// loaded credentials as they are
 	if username != "" && password != "" {
 		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
 		return "Basic " + auth, nil
@@ -107,45 +147,116 @@ func (r *RegistryAuth) fetchChallenge(ctx context.Context, registry string) (*ch
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("User-Agent", version.UserAgent())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.config.HTTPClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	io.Copy(io.Discard, resp.Body)
+	drainBody(resp.Body)
 
 	if resp.StatusCode == http.StatusUnauthorized {
-		return parseChallenge(resp.Header.Get("WWW-Authenticate")), nil
+		ch, err := parseChallenge(strings.Join(resp.Header.Values("WWW-Authenticate"), ", "))
+		if err != nil {
+			return nil, fmt.Errorf("%s challenge: %w", registry, err)
+		}
+		return ch, nil
 	}
 
 	return nil, nil
 }
 
-func parseChallenge(header string) *challenge {
+// parseChallenge picks the challenge to answer from a WWW-Authenticate
+// header, which may offer several, comma separated: bearer when offered,
+// otherwise the first. Headers over maxChallengeHeader fail with
+// ErrTooLarge rather than being parsed.
+func parseChallenge(header string) (*challenge, error) {
 	if header == "" {
-		return nil
-	}
-
-	header = strings.TrimPrefix(header, "Bearer ")
-	ch := &challenge{}
-
-	for _, part := range strings.Split(header, ",") {
-		part = strings.TrimSpace(part)
-		if k, v, ok := strings.Cut(part, "="); ok {
-			v = strings.Trim(v, "\"")
-			switch k {
-			case "realm":
-				ch.realm = v
-			case "service":
-				ch.service = v
-			case "scope":
-				ch.scope = v
-			}
+		return nil, nil
+	}
+	if len(header) > maxChallengeHeader {
+		return nil, fmt.Errorf("%w: WWW-Authenticate over %d bytes", ErrTooLarge, maxChallengeHeader)
+	}
+
+	challenges := parseChallenges(header)
+	if len(challenges) == 0 {
+		return nil, nil
+	}
+	for i := range challenges {
+		if challenges[i].scheme == "bearer" {
+			return &challenges[i], nil
+		}
+	}
+	return &challenges[0], nil
+}
+
+// parseChallenges splits a WWW-Authenticate header into its challenges. A
+// bare token starts a challenge and name=value pairs are its parameters;
+// quoted values may hold commas, as scopes do.
+func parseChallenges(header string) []challenge {
+	var challenges []challenge
+	for rest := header; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return challenges
+		}
+
+		end := strings.IndexAny(rest, " \t,=")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = strings.TrimLeft(rest[end:], " \t")
+
+		if !strings.HasPrefix(rest, "=") {
+			challenges = append(challenges, challenge{scheme: strings.ToLower(name)})
+			continue
+		}
+
+		var value string
+		value, rest = parseChallengeValue(strings.TrimLeft(rest[1:], " \t"))
+		if len(challenges) == 0 {
+			// bare parameters are taken as a bearer challenge
+			challenges = append(challenges, challenge{scheme: "bearer"})
 		}
+		ch := &challenges[len(challenges)-1]
+		switch strings.ToLower(name) {
+		case "realm":
+			ch.realm = value
+		case "service":
+			ch.service = value
+		case "scope":
+			ch.scope = value
+		}
+	}
+}
+
+// parseChallengeValue reads a token or quoted string from the start of s,
+// returning it and what follows.
+func parseChallengeValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, " \t,")
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
 	}
 
-	return ch
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
 }
 
 func (r *RegistryAuth) loadCredentials(registry string) (string, string) {
@@ -218,7 +329,7 @@ func decodeAuth(encoded string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
-func (r *RegistryAuth) getToken(ctx context.Context, ch *challenge, repo, username, password string) (string, error) {
+func (r *RegistryAuth) getToken(ctx context.Context, ch *challenge, scope, username, password string) (string, error) {
 	u, err := url.Parse(ch.realm)
 	if err != nil {
 		return "", err
@@ -228,30 +339,36 @@ func (r *RegistryAuth) getToken(ctx context.Context, ch *challenge, repo, userna
 	if ch.service != "" {
 		q.Set("service", ch.service)
 	}
-	q.Set("scope", fmt.Sprintf("repository:%s:pull", repo))
+	if scope != "" {
+		q.Set("scope", scope)
+	}
 	u.RawQuery = q.Encode()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return "", err
 	}
+	req.Header.Set("User-Agent", version.UserAgent())
 
 	if username != "" && password != "" {
 		req.SetBasicAuth(username, password)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.config.HTTPClient().Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readLimited(resp.Body, maxTokenResponse)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("read token response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
 		return "", fmt.Errorf("token request failed: %s", string(body))
 	}
 
@@ -1,32 +1,46 @@
 package oci
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestParseChallenge(t *testing.T) {
 	tests := []struct {
-		name      string
-		header    string
-		wantRealm string
-		wantSvc   string
-		wantScope string
+		name       string
+		header     string
+		wantScheme string
+		wantRealm  string
+		wantSvc    string
+		wantScope  string
+		wantErr    error
 	}{
 		{
-			name:      "docker hub challenge",
-			header:    `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
-			wantRealm: "https://auth.docker.io/token",
-			wantSvc:   "registry.docker.io",
-			wantScope: "repository:library/nginx:pull",
+			name:       "docker hub challenge",
+			header:     `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://auth.docker.io/token",
+			wantSvc:    "registry.docker.io",
+			wantScope:  "repository:library/nginx:pull",
 		},
 		{
-			name:      "quay challenge",
-			header:    `Bearer realm="https://quay.io/v2/auth",service="quay.io"`,
-			wantRealm: "https://quay.io/v2/auth",
-			wantSvc:   "quay.io",
-			wantScope: "",
+			name:       "quay challenge",
+			header:     `Bearer realm="https://quay.io/v2/auth",service="quay.io"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://quay.io/v2/auth",
+			wantSvc:    "quay.io",
+			wantScope:  "",
 		},
 		{
 			name:      "empty header",
@@ -36,11 +50,43 @@ func TestParseChallenge(t *testing.T) {
 			wantScope: "",
 		},
 		{
-			name:      "realm only",
-			header:    `Bearer realm="https://example.com/auth"`,
-			wantRealm: "https://example.com/auth",
-			wantSvc:   "",
-			wantScope: "",
+			name:       "realm only",
+			header:     `Bearer realm="https://example.com/auth"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://example.com/auth",
+			wantSvc:    "",
+			wantScope:  "",
+		},
+		{
+			name:       "basic only",
+			header:     `Basic realm="Registry Realm"`,
+			wantScheme: "basic",
+			wantRealm:  "Registry Realm",
+		},
+		{
+			name:       "bearer offered after basic",
+			header:     `Basic realm="Registry Realm", Bearer realm="https://example.com/token",service="example.com"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://example.com/token",
+			wantSvc:    "example.com",
+		},
+		{
+			name:       "bearer offered before basic",
+			header:     `Bearer realm="https://example.com/token", Basic realm="Registry Realm"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://example.com/token",
+		},
+		{
+			name:       "quoted comma in scope",
+			header:     `bearer realm="https://example.com/token",scope="repository:app:pull,push"`,
+			wantScheme: "bearer",
+			wantRealm:  "https://example.com/token",
+			wantScope:  "repository:app:pull,push",
+		},
+		{
+			name:    "oversized",
+			header:  `Bearer realm="https://example.com/auth",` + strings.Repeat(`a="b",`, maxChallengeHeader),
+			wantErr: ErrTooLarge,
 		},
 	}
 
@@ -48,7 +94,12 @@ func TestParseChallenge(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			require := require.New(t)
 
-			ch := parseChallenge(tt.header)
+			ch, err := parseChallenge(tt.header)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+				return
+			}
+			require.NoError(err)
 
 			if tt.header == "" {
 				require.Nil(ch)
@@ -56,6 +107,7 @@ func TestParseChallenge(t *testing.T) {
 			}
 
 			require.NotNil(ch)
+			require.Equal(tt.wantScheme, ch.scheme)
 			require.Equal(tt.wantRealm, ch.realm)
 			require.Equal(tt.wantSvc, ch.service)
 			require.Equal(tt.wantScope, ch.scope)
@@ -120,3 +172,195 @@ func TestDecodeAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTokenTooLarge(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantToken string
+		wantErr   error
+	}{
+		{"token", http.StatusOK, `{"token":"abc"}`, "abc", nil},
+		{"access token", http.StatusOK, `{"access_token":"def"}`, "def", nil},
+		{"oversized", http.StatusOK, `{"token":"` + strings.Repeat("x", maxTokenResponse) + `"}`, "", ErrTooLarge},
+		{"oversized denial", http.StatusForbidden, strings.Repeat("x", maxTokenResponse), "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			r := NewRegistryAuth()
+			token, err := r.getToken(context.Background(), &challenge{realm: server.URL}, "", "", "")
+			if tt.status != http.StatusOK {
+				// the error quotes only the start of the body
+				require.ErrorContains(err, "token request failed")
+				require.Less(len(err.Error()), 2*maxErrorBody)
+				return
+			}
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.wantToken, token)
+		})
+	}
+}
+
+func TestGetAuthOversizedChallenge(t *testing.T) {
+	require := require.New(t)
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	var tokens atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s"`,
+				server.URL, strings.Repeat("x", maxChallengeHeader)))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			tokens.Add(1)
+			w.Write([]byte(`{"token":"abc"}`))
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	r := NewRegistryAuth()
+	r.SetInsecure(host, true)
+	_, err := r.GetAuth(context.Background(), host, "library/app")
+	require.ErrorIs(err, ErrTooLarge)
+	require.ErrorContains(err, "challenge")
+	require.Zero(tokens.Load())
+}
+
+func TestGetAuthChallengeScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		challenges []string
+		wantAuth   string
+		wantTokens int32
+	}{
+		{
+			name:       "basic only",
+			challenges: []string{`Basic realm="Registry Realm"`},
+			wantAuth:   "Basic dXNlcjpwYXNz",
+		},
+		{
+			name:       "bearer only",
+			challenges: []string{`Bearer realm="%s/token",service="test"`},
+			wantAuth:   "Bearer abc",
+			wantTokens: 1,
+		},
+		{
+			name:       "both in one header",
+			challenges: []string{`Basic realm="Registry Realm", Bearer realm="%s/token",service="test"`},
+			wantAuth:   "Bearer abc",
+			wantTokens: 1,
+		},
+		{
+			name:       "both in separate headers",
+			challenges: []string{`Basic realm="Registry Realm"`, `Bearer realm="%s/token",service="test"`},
+			wantAuth:   "Bearer abc",
+			wantTokens: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var tokens atomic.Int32
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v2/":
+					for _, ch := range tt.challenges {
+						if strings.Contains(ch, "%s") {
+							ch = fmt.Sprintf(ch, server.URL)
+						}
+						w.Header().Add("WWW-Authenticate", ch)
+					}
+					w.WriteHeader(http.StatusUnauthorized)
+				case "/token":
+					tokens.Add(1)
+					w.Write([]byte(`{"token":"abc"}`))
+				}
+			}))
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("XDG_RUNTIME_DIR", "")
+			require.NoError(os.MkdirAll(filepath.Join(home, ".docker"), 0700))
+			require.NoError(os.WriteFile(filepath.Join(home, ".docker/config.json"),
+				[]byte(fmt.Sprintf(`{"auths":{%q:{"auth":"dXNlcjpwYXNz"}}}`, host)), 0600))
+
+			r := NewRegistryAuth()
+			r.SetInsecure(host, true)
+			auth, err := r.GetAuth(context.Background(), host, "library/app")
+			require.NoError(err)
+			require.Equal(tt.wantAuth, auth)
+			require.Equal(tt.wantTokens, tokens.Load())
+		})
+	}
+}
+
+func TestRegistryAuthTokenExpiry(t *testing.T) {
+	require := require.New(t)
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	var issued atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			fmt.Fprintf(w, `{"token":"t%d"}`, issued.Add(1))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	clock := newFakeClock()
+	r := NewRegistryAuth()
+	r.SetInsecure(host, true)
+	r.SetClock(clock)
+
+	ctx := context.Background()
+	auth, err := r.GetAuth(ctx, host, "library/app")
+	require.NoError(err)
+	require.Equal("Bearer t1", auth)
+
+	// cached until the ttl runs out
+	clock.Advance(tokenCacheTTL - time.Second)
+	auth, err = r.GetAuth(ctx, host, "library/app")
+	require.NoError(err)
+	require.Equal("Bearer t1", auth)
+	require.Equal(int32(1), issued.Load())
+
+	// refreshed once it has expired
+	clock.Advance(time.Second)
+	auth, err = r.GetAuth(ctx, host, "library/app")
+	require.NoError(err)
+	require.Equal("Bearer t2", auth)
+	require.Equal(int32(2), issued.Load())
+}
@@ -1,6 +1,7 @@
 package oci
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,25 +9,54 @@ import (
 	"io"
 	"net/http"
 	"runtime"
+	"slices"
 	"strings"
+
+	"github.com/hexfusion/fray/internal/version"
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
-	ErrUnauthorized = errors.New("unauthorized")
-	ErrNotFound     = errors.New("not found")
-	ErrNoManifest   = errors.New("no matching manifest")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrNotFound        = errors.New("not found")
+	ErrNoManifest      = errors.New("no matching manifest")
+	ErrManifestDepth   = errors.New("manifest index nesting too deep")
+	ErrInvalidPlatform = errors.New("invalid platform")
+	ErrInvalidManifest = errors.New("invalid manifest")
+	// ErrTooLarge is returned when a registry response exceeds its size cap.
+	ErrTooLarge = errors.New("response too large")
+	// ErrTransient marks failures worth retrying: network errors, 429 and 5xx.
+	ErrTransient = errors.New("transient registry error")
 )
 
 const (
 	DockerHubRegistry = "registry-1.docker.io"
 	DockerHubAlias    = "docker.io"
+
+	// maxManifestDepth bounds nested index resolution against malicious lists.
+	maxManifestDepth = 4
+
+	// DefaultMaxManifestSize caps manifests read into memory. Real manifests
+	// and indexes are a few KB; the OCI distribution spec suggests
+	// registries accept at least 4MB.
+	DefaultMaxManifestSize = 4 * 1024 * 1024
+	// maxErrorBody caps how much of an error response is read for messages.
+	maxErrorBody = 4096
 )
 
 // Client fetches OCI artifacts from registries.
 type Client struct {
-	httpClient *http.Client
-	auth       AuthProvider
-	insecure   map[string]bool
+	config    *RegistryConfig
+	auth      AuthProvider
+	userAgent string
+	// uploadChunkSize is the PATCH size for blob uploads.
+	uploadChunkSize int
+	// retry bounds retries of transient manifest fetch failures.
+	retry RetryPolicy
+	// maxManifestSize caps manifest responses.
+	maxManifestSize int64
+	clock           Clock
 }
 
 // AuthProvider provides authentication for registry requests.
@@ -37,37 +67,147 @@ type AuthProvider interface {
 // NewClient creates a new OCI client.
 func NewClient() *Client {
 	return &Client{
-		httpClient: http.DefaultClient,
-		insecure:   make(map[string]bool),
+		config:    NewRegistryConfig(),
+		userAgent: version.UserAgent(),
+
+		uploadChunkSize: DefaultUploadChunkSize,
+		retry:           DefaultRetryPolicy(),
+		maxManifestSize: DefaultMaxManifestSize,
+		clock:           RealClock(),
 	}
 }
 
-// SetAuth sets the authentication provider.
+// SetUserAgent overrides the User-Agent sent with registry requests.
+func (c *Client) SetUserAgent(ua string) {
+	c.userAgent = ua
+}
+
+// SetRetryPolicy sets the retry policy for manifest fetches.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// RetryPolicy returns the retry policy for manifest fetches.
+func (c *Client) RetryPolicy() RetryPolicy {
+	return c.retry
+}
+
+// SetClock sets the clock used to wait between manifest fetch retries.
+func (c *Client) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+// SetMaxManifestSize caps manifest responses at n bytes; larger ones fail
+// with ErrTooLarge. Zero restores DefaultMaxManifestSize.
+func (c *Client) SetMaxManifestSize(n int64) {
+	if n <= 0 {
+		n = DefaultMaxManifestSize
+	}
+	c.maxManifestSize = n
+}
+
+// SetAuth sets the authentication provider. Providers that accept a
+// RegistryConfig are given the client's so both reach registries the same way.
 func (c *Client) SetAuth(auth AuthProvider) {
 	c.auth = auth
+	if cfg, ok := auth.(interface{ SetConfig(*RegistryConfig) }); ok {
+		cfg.SetConfig(c.config)
+	}
+}
+
+// Config returns the client's registry connection settings.
+func (c *Client) Config() *RegistryConfig {
+	return c.config
+}
+
+// SetConfig replaces the client's registry connection settings, sharing
+// them with the auth provider when it accepts a RegistryConfig.
+func (c *Client) SetConfig(config *RegistryConfig) {
+	c.config = config
+	if c.auth != nil {
+		c.SetAuth(c.auth)
+	}
 }
 
 // SetInsecure marks a registry as insecure (HTTP instead of HTTPS).
 func (c *Client) SetInsecure(registry string, insecure bool) {
-	c.insecure[registry] = insecure
+	c.config.SetInsecure(registry, insecure)
 }
 
 func (c *Client) registryURL(registry string) string {
-	scheme := "https"
-	if c.insecure[registry] {
-		scheme = "http"
+	return c.config.URL(registry)
+}
+
+// fromEndpoints calls fn for each of the registry's mirrors and then the
+// registry itself, returning the first success or the registry's error.
+func fromEndpoints[T any](ctx context.Context, c *Client, registry string, fn func(host string) (T, error)) (T, error) {
+	var (
+		result T
+		err    error
+	)
+	for _, host := range c.config.Endpoints(registry) {
+		result, err = fn(host)
+		if err == nil || ctx.Err() != nil {
+			return result, err
+		}
 	}
-	return fmt.Sprintf("%s://%s", scheme, registry)
+	return result, err
 }
 
+const (
+	// MediaTypeEmptyJSON is the config media type of artifacts that have
+	// no config.
+	MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+	// EmptyJSONDigest is the digest of the empty descriptor's content, "{}".
+	EmptyJSONDigest = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+
+	mediaTypeOCIImageConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeDockerImageConfig = "application/vnd.docker.container.image.v1+json"
+)
+
+// EmptyJSON is the content of the empty descriptor.
+var EmptyJSON = []byte("{}")
+
 // Manifest is an OCI/Docker image manifest.
 type Manifest struct {
 	SchemaVersion int    `json:"schemaVersion"`
 	MediaType     string `json:"mediaType"`
+	ArtifactType  string `json:"artifactType,omitempty"`
 	Config        Blob   `json:"config"`
 	Layers        []Blob `json:"layers"`
 }
 
+// IsArtifact reports whether m is an OCI artifact, such as a Helm chart or
+// an SBOM, rather than a runnable image. Its layers need not be tar
+// archives and its config need not be an image config.
+func (m *Manifest) IsArtifact() bool {
+	if m.ArtifactType != "" {
+		return true
+	}
+	switch m.Config.MediaType {
+	case "", mediaTypeOCIImageConfig, mediaTypeDockerImageConfig:
+		return false
+	}
+	return true
+}
+
+// Validate checks that m is a schema 2 image manifest with a config, so an
+// index or a schema1 manifest parsed as one isn't mistaken for an image
+// without layers.
+func (m *Manifest) Validate() error {
+	switch {
+	case m.SchemaVersion == 1 || strings.Contains(m.MediaType, "distribution.manifest.v1"):
+		return fmt.Errorf("%w: schema1 manifests are not supported, use a tag pushed as a v2 or OCI manifest", ErrInvalidManifest)
+	case isManifestList(m.MediaType):
+		return fmt.Errorf("%w: %s is an index, not a manifest", ErrInvalidManifest, m.MediaType)
+	case m.SchemaVersion != 2:
+		return fmt.Errorf("%w: unsupported schema version %d", ErrInvalidManifest, m.SchemaVersion)
+	case m.Config.Digest == "":
+		return fmt.Errorf("%w: no config digest", ErrInvalidManifest)
+	}
+	return nil
+}
+
 // Blob is a content-addressable blob reference.
 type Blob struct {
 	MediaType string `json:"mediaType"`
@@ -84,18 +224,70 @@ type ManifestList struct {
 
 // Platform is a platform-specific manifest reference.
 type Platform struct {
-	MediaType string `json:"mediaType"`
-	Digest    string `json:"digest"`
-	Size      int64  `json:"size"`
-	Platform  struct {
-		Architecture string `json:"architecture"`
-		OS           string `json:"os"`
-		Variant      string `json:"variant,omitempty"`
-	} `json:"platform"`
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Size      int64        `json:"size"`
+	Platform  PlatformSpec `json:"platform"`
+}
+
+// PlatformSpec is the platform an index entry runs on.
+type PlatformSpec struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	// OSVersion is the OS build the image needs, set on Windows images.
+	OSVersion string `json:"os.version,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+}
+
+func (p PlatformSpec) String() string {
+	name := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		name += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		name += " " + p.OSVersion
+	}
+	return name
 }
 
 // GetManifest fetches the manifest for an image, resolving manifest lists.
 func (c *Client) GetManifest(ctx context.Context, registry, repo, ref string) (*Manifest, error) {
+	return c.resolveManifest(ctx, registry, repo, ref, "", 0)
+}
+
+// GetPlatformManifest fetches the manifest for an image, resolving manifest
+// lists to platform, given as os/arch[/variant]. An empty platform behaves
+// like GetManifest.
+func (c *Client) GetPlatformManifest(ctx context.Context, registry, repo, ref, platform string) (*Manifest, error) {
+	if platform != "" {
+		if err := ValidatePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+	return c.resolveManifest(ctx, registry, repo, ref, platform, 0)
+}
+
+// GetRawManifest fetches a manifest or index as the registry serves it,
+// without resolving indexes, and returns its bytes and media type. Digest
+// refs are verified.
+func (c *Client) GetRawManifest(ctx context.Context, registry, repo, ref string) ([]byte, string, error) {
+	return c.fetchManifest(ctx, registry, repo, ref)
+}
+
+// ValidatePlatform checks that platform is os/arch or os/arch/variant.
+func ValidatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return fmt.Errorf("%w %q: must be os/arch[/variant]", ErrInvalidPlatform, platform)
+	}
+	return nil
+}
+
+func (c *Client) resolveManifest(ctx context.Context, registry, repo, ref, platform string, depth int) (*Manifest, error) {
+	if depth > maxManifestDepth {
+		return nil, fmt.Errorf("%w: exceeded %d nested indexes at %s", ErrManifestDepth, maxManifestDepth, ref)
+	}
+
 	body, mediaType, err := c.fetchManifest(ctx, registry, repo, ref)
 	if err != nil {
 		return nil, err
@@ -107,15 +299,21 @@ func (c *Client) GetManifest(ctx context.Context, registry, repo, ref string) (*
 			return nil, fmt.Errorf("parse manifest list: %w", err)
 		}
 
-		digest, err := selectPlatform(list)
+		digest, err := selectPlatform(list, platform, c.config.PlatformPreference(), c.config.OSVersion())
 		if err != nil {
 			return nil, err
 		}
 
-		body, _, err = c.fetchManifest(ctx, registry, repo, digest)
+		// don't issue the platform fetch if cancelled while resolving the list
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("fetch platform manifest: %w", err)
+		}
+
+		manifest, err := c.resolveManifest(ctx, registry, repo, digest, platform, depth+1)
 		if err != nil {
 			return nil, fmt.Errorf("fetch platform manifest: %w", err)
 		}
+		return manifest, nil
 	}
 
 	var manifest Manifest
@@ -126,9 +324,77 @@ func (c *Client) GetManifest(ctx context.Context, registry, repo, ref string) (*
 	return &manifest, nil
 }
 
+// fetchManifest fetches one manifest, retrying transient failures so a
+// flaky platform fetch doesn't discard an already resolved index.
 func (c *Client) fetchManifest(ctx context.Context, registry, repo, ref string) ([]byte, string, error) {
-	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.registryURL(registry), repo, ref)
-	return c.doManifestRequest(ctx, url, registry, repo, false)
+	var lastErr error
+	for attempt := 0; attempt <= c.retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, "", fmt.Errorf("fetch cancelled: %w", ctx.Err())
+			case <-c.clock.After(c.retry.Delay(attempt)):
+			}
+		}
+
+		resp, err := fromEndpoints(ctx, c, registry, func(host string) (manifestResponse, error) {
+			url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.registryURL(host), repo, ref)
+			body, mediaType, err := c.doManifestRequest(ctx, url, host, repo, false)
+			return manifestResponse{body, mediaType}, err
+		})
+		if err == nil {
+			if err := verifyManifest(ref, resp.body); err != nil {
+				return nil, "", err
+			}
+			return resp.body, resp.mediaType, nil
+		}
+		if !errors.Is(err, ErrTransient) {
+			return nil, "", err
+		}
+		lastErr = err
+	}
+
+	return nil, "", fmt.Errorf("failed after %d attempts: %w", c.retry.MaxRetries+1, lastErr)
+}
+
+type manifestResponse struct {
+	body      []byte
+	mediaType string
+}
+
+// verifyManifest checks a manifest fetched by digest against it. Tags
+// can't be checked.
+func verifyManifest(ref string, body []byte) error {
+	want, err := digest.Parse(ref)
+	if err != nil {
+		return nil
+	}
+	if got := want.Algorithm().FromBytes(body); got != want {
+		return fmt.Errorf("%w: manifest %s: got %s", ErrDigestMismatch, want, got)
+	}
+	return nil
+}
+
+// decodeBody undoes resp's Content-Encoding.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		return gz, nil
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
 }
 
 func (c *Client) doManifestRequest(ctx context.Context, url, registry, repo string, withAuth bool) ([]byte, string, error) {
@@ -137,12 +403,19 @@ func (c *Client) doManifestRequest(ctx context.Context, url, registry, repo stri
 		return nil, "", err
 	}
 
+	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("Accept", strings.Join([]string{
 		"application/vnd.oci.image.manifest.v1+json",
 		"application/vnd.oci.image.index.v1+json",
 		"application/vnd.docker.distribution.manifest.v2+json",
 		"application/vnd.docker.distribution.manifest.list.v2+json",
 	}, ", "))
+	// set explicitly so the transport doesn't add, and undo, its own gzip
+	if c.config.ManifestCompression() {
+		req.Header.Set("Accept-Encoding", "zstd, gzip")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
 
 	if withAuth && c.auth != nil {
 		authHeader, err := c.auth.GetAuth(ctx, registry, repo)
@@ -154,19 +427,33 @@ func (c *Client) doManifestRequest(ctx context.Context, url, registry, repo stri
 		}
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.config.HTTPClient().Do(req)
 	if err != nil {
-		return nil, "", err
+		if ctx.Err() != nil {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil {
+		drainBody(resp.Body)
+		return c.doManifestRequest(ctx, url, registry, repo, true)
 	}
-	defer resp.Body.Close()
+	defer drainBody(resp.Body)
 
-	body, err := io.ReadAll(resp.Body)
+	decoded, err := decodeBody(resp)
 	if err != nil {
-		return nil, "", err
+		return nil, "", fmt.Errorf("manifest from %s: %w", registry, err)
 	}
+	defer decoded.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil {
-		return c.doManifestRequest(ctx, url, registry, repo, true)
+	// the cap applies after decoding, so a small compressed body can't
+	// expand past it
+	body, err := readLimited(decoded, c.maxManifestSize)
+	if errors.Is(err, ErrTooLarge) {
+		return nil, "", fmt.Errorf("manifest from %s: %w", registry, err)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: read manifest: %w", ErrTransient, err)
 	}
 
 	if resp.StatusCode == http.StatusUnauthorized {
@@ -177,6 +464,10 @@ func (c *Client) doManifestRequest(ctx context.Context, url, registry, repo stri
 		return nil, "", fmt.Errorf("%w: %s", ErrNotFound, url)
 	}
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, "", fmt.Errorf("%w: status %d: %s", ErrTransient, resp.StatusCode, string(body))
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, "", fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
 	}
@@ -186,53 +477,156 @@ func (c *Client) doManifestRequest(ctx context.Context, url, registry, repo stri
 
 // SupportsRange checks if a registry supports HTTP Range requests.
 func (c *Client) SupportsRange(ctx context.Context, registry, repo, digest string) (bool, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(registry), repo, digest)
-	return c.doRangeCheck(ctx, url, registry, repo, false)
+	_, supported, err := c.ProbeRange(ctx, registry, repo, digest)
+	return supported, err
+}
+
+// ProbeRange checks if a registry supports HTTP Range requests for a blob.
+// It also returns the blob's total size from the Content-Range header, or
+// -1 if the registry didn't report one.
+func (c *Client) ProbeRange(ctx context.Context, registry, repo, digest string) (int64, bool, error) {
+	probe, err := fromEndpoints(ctx, c, registry, func(host string) (rangeProbe, error) {
+		url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(host), repo, digest)
+		return c.doRangeCheck(ctx, url, host, repo, false)
+	})
+	if err != nil {
+		return -1, false, err
+	}
+	return probe.size, probe.supported, nil
+}
+
+type rangeProbe struct {
+	size      int64
+	supported bool
 }
 
-func (c *Client) doRangeCheck(ctx context.Context, url, registry, repo string, withAuth bool) (bool, error) {
+func (c *Client) doRangeCheck(ctx context.Context, url, registry, repo string, withAuth bool) (rangeProbe, error) {
+	probe := rangeProbe{size: -1}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return false, err
+		return probe, err
 	}
 
+	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("Range", "bytes=0-0")
 
 	if withAuth && c.auth != nil {
 		authHeader, err := c.auth.GetAuth(ctx, registry, repo)
 		if err != nil && !strings.Contains(err.Error(), "DENIED") {
-			return false, fmt.Errorf("get auth: %w", err)
+			return probe, fmt.Errorf("get auth: %w", err)
 		}
 		if authHeader != "" {
 			req.Header.Set("Authorization", authHeader)
 		}
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.config.HTTPClient().Do(req)
 	if err != nil {
-		return false, err
+		return probe, err
 	}
-	defer resp.Body.Close()
-	io.Copy(io.Discard, resp.Body)
+	// a registry that ignores the range sends the whole blob
+	drainBody(resp.Body)
 
 	if resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil {
 		return c.doRangeCheck(ctx, url, registry, repo, true)
 	}
 
-	return resp.StatusCode == http.StatusPartialContent, nil
+	probe.supported = resp.StatusCode == http.StatusPartialContent
+	if probe.supported {
+		if size, err := parseContentRangeTotal(resp.Header.Get("Content-Range")); err == nil {
+			probe.size = size
+		}
+	}
+	return probe, nil
+}
+
+// StatBlob returns a blob's size from a HEAD request, falling back to a
+// one-byte range probe when the registry doesn't answer HEAD.
+func (c *Client) StatBlob(ctx context.Context, registry, repo, digest string) (int64, error) {
+	return fromEndpoints(ctx, c, registry, func(host string) (int64, error) {
+		url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(host), repo, digest)
+
+		size, headErr := c.doBlobStat(ctx, url, host, repo, false, false)
+		if headErr == nil || errors.Is(headErr, ErrNotFound) || errors.Is(headErr, ErrUnauthorized) {
+			return size, headErr
+		}
+
+		size, err := c.doBlobStat(ctx, url, host, repo, true, false)
+		if err != nil {
+			return 0, errors.Join(headErr, err)
+		}
+		return size, nil
+	})
+}
+
+// doBlobStat sizes a blob with HEAD, or with a bytes=0-0 GET when probe is
+// set.
+func (c *Client) doBlobStat(ctx context.Context, url, registry, repo string, probe, withAuth bool) (int64, error) {
+	method := http.MethodHead
+	if probe {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	if probe {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	if withAuth && c.auth != nil {
+		authHeader, err := c.auth.GetAuth(ctx, registry, repo)
+		if err != nil && !strings.Contains(err.Error(), "DENIED") {
+			return 0, fmt.Errorf("get auth: %w", err)
+		}
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+	}
+
+	resp, err := c.config.HTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// the body is never needed, even if the probe's range was ignored
+	drainBody(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil:
+		return c.doBlobStat(ctx, url, registry, repo, probe, true)
+	case resp.StatusCode == http.StatusUnauthorized:
+		return 0, fmt.Errorf("%w: %s", ErrUnauthorized, registry)
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, url)
+	case resp.StatusCode == http.StatusPartialContent && probe:
+		return parseContentRangeTotal(resp.Header.Get("Content-Range"))
+	case resp.StatusCode == http.StatusOK && resp.ContentLength >= 0:
+		return resp.ContentLength, nil
+	case resp.StatusCode == http.StatusOK:
+		return 0, ErrNoContentLength
+	}
+	return 0, fmt.Errorf("%s blob: unexpected status: %d", method, resp.StatusCode)
 }
 
 // GetBlob downloads a complete blob.
 func (c *Client) GetBlob(ctx context.Context, registry, repo, digest string) (io.ReadCloser, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(registry), repo, digest)
-	return c.doBlobRequest(ctx, url, registry, repo, "", false)
+	return c.getBlob(ctx, registry, repo, digest, "")
 }
 
 // GetBlobRange downloads a byte range from a blob.
 func (c *Client) GetBlobRange(ctx context.Context, registry, repo, digest string, start, end int64) (io.ReadCloser, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(registry), repo, digest)
-	rangeHeader := fmt.Sprintf("bytes=%d-%d", start, end)
-	return c.doBlobRequest(ctx, url, registry, repo, rangeHeader, false)
+	return c.getBlob(ctx, registry, repo, digest, fmt.Sprintf("bytes=%d-%d", start, end))
+}
+
+func (c *Client) getBlob(ctx context.Context, registry, repo, digest, rangeHeader string) (io.ReadCloser, error) {
+	return fromEndpoints(ctx, c, registry, func(host string) (io.ReadCloser, error) {
+		url := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(host), repo, digest)
+		return c.doBlobRequest(ctx, url, host, repo, rangeHeader, false)
+	})
 }
 
 func (c *Client) doBlobRequest(ctx context.Context, url, registry, repo, rangeHeader string, withAuth bool) (io.ReadCloser, error) {
@@ -241,6 +635,7 @@ func (c *Client) doBlobRequest(ctx context.Context, url, registry, repo, rangeHe
 		return nil, err
 	}
 
+	req.Header.Set("User-Agent", c.userAgent)
 	if rangeHeader != "" {
 		req.Header.Set("Range", rangeHeader)
 	}
@@ -255,86 +650,147 @@ func (c *Client) doBlobRequest(ctx context.Context, url, registry, repo, rangeHe
 		}
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.config.HTTPClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil {
-		resp.Body.Close()
+		drainBody(resp.Body)
 		return c.doBlobRequest(ctx, url, registry, repo, rangeHeader, true)
 	}
 
 	if resp.StatusCode == http.StatusUnauthorized {
-		resp.Body.Close()
+		drainBody(resp.Body)
 		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, registry)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		drainBody(resp.Body)
 		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
 	}
 
 	return resp.Body, nil
 }
 
+// drainBody reads what's left of a response body, up to maxErrorBody, and
+// closes it, so its connection goes back to the pool for reuse. A body
+// left longer than that is abandoned; reading it costs more than a new
+// connection.
+func drainBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxErrorBody))
+	body.Close()
+}
+
 func isManifestList(mediaType string) bool {
 	return strings.Contains(mediaType, "manifest.list") || strings.Contains(mediaType, "image.index")
 }
 
-func selectPlatform(list ManifestList) (string, error) {
-	targetOS := runtime.GOOS
-	targetArch := runtime.GOARCH
+// selectPlatform picks the manifest for platform from a list. An empty
+// platform tries prefs in order, then the host's platform, then
+// linux/amd64. Among entries for the same platform, one built for
+// osVersion is preferred.
+func selectPlatform(list ManifestList, platform string, prefs []string, osVersion string) (string, error) {
+	if platform != "" {
+		if digest, ok := matchPlatform(list, platform, osVersion); ok {
+			return digest, nil
+		}
+		return "", fmt.Errorf("%w for %s, available: %v", ErrNoManifest, platform, availablePlatforms(list))
+	}
 
-	for _, m := range list.Manifests {
-		if m.Platform.OS == targetOS && m.Platform.Architecture == targetArch {
-			return m.Digest, nil
+	host := runtime.GOOS + "/" + runtime.GOARCH
+	for _, p := range append(slices.Clone(prefs), host, "linux/amd64") {
+		if digest, ok := matchPlatform(list, p, osVersion); ok {
+			return digest, nil
 		}
 	}
+	return "", fmt.Errorf("%w for %s, available: %v", ErrNoManifest, host, availablePlatforms(list))
+}
+
+// SelectPlatform returns the digest of the manifest for platform,
+// os/arch[/variant], in list, or for the host's platform if it is empty.
+func SelectPlatform(list ManifestList, platform string) (string, error) {
+	return selectPlatform(list, platform, nil, "")
+}
+
+// SelectPlatform is like the package's SelectPlatform, but an empty
+// platform tries the client's platform preference first, and entries
+// built for its OS version are preferred, as GetPlatformManifest does.
+func (c *Client) SelectPlatform(list ManifestList, platform string) (string, error) {
+	return selectPlatform(list, platform, c.config.PlatformPreference(), c.config.OSVersion())
+}
+
+// matchPlatform finds the entry for os/arch[/variant]. Without a variant
+// any variant of os/arch matches. Of several matches the one closest to
+// osVersion wins, then the first listed.
+func matchPlatform(list ManifestList, platform, osVersion string) (string, bool) {
+	want := strings.Split(platform, "/")
+	if len(want) < 2 {
+		return "", false
+	}
 
+	found, best := "", -1
 	for _, m := range list.Manifests {
-		if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
-			return m.Digest, nil
+		p := m.Platform
+		if p.OS != want[0] || p.Architecture != want[1] || (len(want) > 2 && p.Variant != want[2]) {
+			continue
 		}
+		if rank := osVersionRank(p.OSVersion, osVersion); rank > best {
+			found, best = m.Digest, rank
+		}
+	}
+	return found, best >= 0
+}
+
+// osVersionRank scores how well an entry's os.version suits want: the same
+// version, then the same build, such as 10.0.17763 for any 10.0.17763.x
+// revision, then anything else.
+func osVersionRank(have, want string) int {
+	switch {
+	case want == "":
+		return 0
+	case have == want:
+		return 2
+	case have != "" && osBuild(have) == osBuild(want):
+		return 1
 	}
+	return 0
+}
+
+// osBuild trims an os.version to major.minor.build.
+func osBuild(version string) string {
+	parts := strings.SplitN(version, ".", 4)
+	return strings.Join(parts[:min(len(parts), 3)], ".")
+}
 
+func availablePlatforms(list ManifestList) []string {
 	available := make([]string, 0, len(list.Manifests))
 	for _, m := range list.Manifests {
-		available = append(available, fmt.Sprintf("%s/%s", m.Platform.OS, m.Platform.Architecture))
+		available = append(available, m.Platform.String())
 	}
-
-	return "", fmt.Errorf("%w for %s/%s, available: %v", ErrNoManifest, targetOS, targetArch, available)
+	return available
 }
 
 // ParseImageRef parses an image reference into registry, repo, and tag/digest.
+// Malformed references yield empty strings; use ParseReference for the error.
 func ParseImageRef(image string) (registry, repo, ref string) {
-	ref = "latest"
-
-	if idx := strings.LastIndex(image, "@"); idx != -1 {
-		ref = image[idx+1:]
-		image = image[:idx]
-	} else if idx := strings.LastIndex(image, ":"); idx != -1 {
-		if !strings.Contains(image[idx:], "/") {
-			ref = image[idx+1:]
-			image = image[:idx]
-		}
+	r, err := ParseReference(image)
+	if err != nil {
+		return "", "", ""
 	}
+	return r.Registry, r.Repository, r.Ref()
+}
 
-	parts := strings.SplitN(image, "/", 2)
-	if len(parts) == 1 {
-		registry = DockerHubRegistry
-		repo = "library/" + parts[0]
-	} else if strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":") {
-		registry = parts[0]
-		repo = parts[1]
-		if registry == DockerHubAlias {
-			registry = DockerHubRegistry
-		}
-	} else {
-		registry = DockerHubRegistry
-		repo = image
+// readLimited reads r, failing with ErrTooLarge rather than buffering more
+// than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
 	}
-
-	return
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrTooLarge, limit)
+	}
+	return data, nil
 }
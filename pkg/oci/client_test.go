@@ -1,9 +1,27 @@
 package oci
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/internal/version"
+	"github.com/hexfusion/fray/pkg/digest"
 )
 
 func TestParseImageRef(t *testing.T) {
@@ -127,6 +145,28 @@ func TestIsManifestList(t *testing.T) {
 	}
 }
 
+func TestManifestIsArtifact(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest Manifest
+		want     bool
+	}{
+		{"oci image", Manifest{Config: Blob{MediaType: "application/vnd.oci.image.config.v1+json"}}, false},
+		{"docker image", Manifest{Config: Blob{MediaType: "application/vnd.docker.container.image.v1+json"}}, false},
+		{"no config media type", Manifest{}, false},
+		{"empty config", Manifest{Config: Blob{MediaType: MediaTypeEmptyJSON}}, true},
+		{"helm chart", Manifest{Config: Blob{MediaType: "application/vnd.cncf.helm.config.v1+json"}}, true},
+		{"artifact type", Manifest{ArtifactType: "application/spdx+json", Config: Blob{MediaType: "application/vnd.oci.image.config.v1+json"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			require.Equal(tt.want, tt.manifest.IsArtifact())
+		})
+	}
+}
+
 func TestSelectPlatform(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -138,16 +178,8 @@ func TestSelectPlatform(t *testing.T) {
 			name: "finds linux/amd64",
 			list: ManifestList{
 				Manifests: []Platform{
-					{Digest: "sha256:arm", Platform: struct {
-						Architecture string `json:"architecture"`
-						OS           string `json:"os"`
-						Variant      string `json:"variant,omitempty"`
-					}{"arm64", "linux", ""}},
-					{Digest: "sha256:amd64", Platform: struct {
-						Architecture string `json:"architecture"`
-						OS           string `json:"os"`
-						Variant      string `json:"variant,omitempty"`
-					}{"amd64", "linux", ""}},
+					{Digest: "sha256:arm", Platform: PlatformSpec{Architecture: "arm64", OS: "linux"}},
+					{Digest: "sha256:amd64", Platform: PlatformSpec{Architecture: "amd64", OS: "linux"}},
 				},
 			},
 			wantDigest: "sha256:amd64",
@@ -167,7 +199,7 @@ func TestSelectPlatform(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			require := require.New(t)
 
-			digest, err := selectPlatform(tt.list)
+			digest, err := selectPlatform(tt.list, "", nil, "")
 
 			if tt.wantErr {
 				require.Error(err)
@@ -178,3 +210,622 @@ func TestSelectPlatform(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectExplicitPlatform(t *testing.T) {
+	var list ManifestList
+	require.NoError(t, json.Unmarshal([]byte(`{"manifests":[
+		{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}},
+		{"digest":"sha256:armv6","platform":{"os":"linux","architecture":"arm","variant":"v6"}},
+		{"digest":"sha256:armv7","platform":{"os":"linux","architecture":"arm","variant":"v7"}}
+	]}`), &list))
+
+	tests := []struct {
+		platform   string
+		wantDigest string
+		wantErr    error
+	}{
+		{"linux/amd64", "sha256:amd64", nil},
+		{"linux/arm/v7", "sha256:armv7", nil},
+		{"linux/arm", "sha256:armv6", nil},
+		{"linux/arm64", "", ErrNoManifest},
+		{"windows/amd64", "", ErrNoManifest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			require := require.New(t)
+
+			digest, err := selectPlatform(list, tt.platform, nil, "")
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.wantDigest, digest)
+		})
+	}
+}
+
+func TestSelectPlatformPreference(t *testing.T) {
+	var list ManifestList
+	require.NoError(t, json.Unmarshal([]byte(`{"manifests":[
+		{"digest":"sha256:armv7","platform":{"os":"linux","architecture":"arm","variant":"v7"}},
+		{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64"}},
+		{"digest":"sha256:ltsc2019","platform":{"os":"windows","architecture":"amd64","os.version":"10.0.17763.5936"}},
+		{"digest":"sha256:ltsc2022","platform":{"os":"windows","architecture":"amd64","os.version":"10.0.20348.2527"}},
+		{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}}
+	]}`), &list))
+
+	tests := []struct {
+		name       string
+		platform   string
+		prefs      []string
+		osVersion  string
+		wantDigest string
+	}{
+		{"first preference", "", []string{"linux/arm64", "linux/arm/v7"}, "", "sha256:arm64"},
+		{"preference order", "", []string{"linux/arm/v7", "linux/arm64"}, "", "sha256:armv7"},
+		{"unlisted preference skipped", "", []string{"linux/s390x", "linux/arm64"}, "", "sha256:arm64"},
+		{"explicit platform wins", "linux/amd64", []string{"linux/arm64"}, "", "sha256:amd64"},
+		{"windows exact version", "windows/amd64", nil, "10.0.20348.2527", "sha256:ltsc2022"},
+		{"windows same build", "windows/amd64", nil, "10.0.17763.1000", "sha256:ltsc2019"},
+		{"windows first without a version", "windows/amd64", nil, "", "sha256:ltsc2019"},
+		{"windows preferred", "", []string{"windows/amd64"}, "10.0.20348.1", "sha256:ltsc2022"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			digest, err := selectPlatform(list, tt.platform, tt.prefs, tt.osVersion)
+			require.NoError(err)
+			require.Equal(tt.wantDigest, digest)
+		})
+	}
+}
+
+func TestValidatePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		wantErr  bool
+	}{
+		{"linux/amd64", false},
+		{"linux/arm/v7", false},
+		{"linux", true},
+		{"linux/", true},
+		{"/amd64", true},
+		{"linux/arm/v7/extra", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			err := ValidatePlatform(tt.platform)
+			if tt.wantErr {
+				require.True(t, errors.Is(err, ErrInvalidPlatform), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func testIndex(digest string) string {
+	return `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` +
+		`{"digest":"` + digest + `","platform":{"architecture":"` + runtime.GOARCH + `","os":"` + runtime.GOOS + `"}}]}`
+}
+
+func TestGetManifestSelfReferentialIndex(t *testing.T) {
+	require := require.New(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		w.Write([]byte(testIndex("sha256:self")))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	c := NewClient()
+	c.SetInsecure(host, true)
+
+	_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+	require.True(errors.Is(err, ErrManifestDepth))
+	require.Equal(int32(maxManifestDepth+1), requests.Load())
+}
+
+func TestGetManifestCancelledBeforePlatformFetch(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		cancel()
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		w.Write([]byte(testIndex("sha256:platform")))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	c := NewClient()
+	c.SetInsecure(host, true)
+
+	_, err := c.GetManifest(ctx, host, "test/repo", "latest")
+	require.Error(err)
+	require.Equal(int32(1), requests.Load())
+}
+
+func TestClientUserAgent(t *testing.T) {
+	require := require.New(t)
+
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Write([]byte(`{"schemaVersion":2}`))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	c := NewClient()
+	c.SetInsecure(host, true)
+
+	_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+	require.NoError(err)
+	require.Equal(version.UserAgent(), got)
+
+	c.SetUserAgent("custom/1.0")
+	_, err = c.GetManifest(context.Background(), host, "test/repo", "latest")
+	require.NoError(err)
+	require.Equal("custom/1.0", got)
+}
+
+func TestGetManifestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		failures     int32
+		wantErr      error
+		wantRequests int32
+	}{
+		{"transient 503 recovers", http.StatusServiceUnavailable, 2, nil, 4},
+		{"429 recovers", http.StatusTooManyRequests, 1, nil, 3},
+		{"404 is permanent", http.StatusNotFound, 1, ErrNotFound, 2},
+		{"503 exhausts retries", http.StatusServiceUnavailable, 10, ErrTransient, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var requests, failures atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if strings.HasSuffix(r.URL.Path, "/latest") {
+					w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+					w.Write([]byte(testIndex("sha256:platform")))
+					return
+				}
+				if failures.Add(1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				w.Write([]byte(`{"schemaVersion":2}`))
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond})
+
+			_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr))
+			} else {
+				require.NoError(err)
+			}
+			require.Equal(tt.wantRequests, requests.Load())
+		})
+	}
+}
+
+func TestGetManifestTooLarge(t *testing.T) {
+	manifest := `{"schemaVersion":2,"annotations":{"pad":"` + strings.Repeat("x", 1000) + `"}}`
+
+	tests := []struct {
+		name    string
+		limit   int64
+		chunked bool
+		wantErr error
+	}{
+		{"within limit", int64(len(manifest)), false, nil},
+		{"over limit", 512, false, ErrTooLarge},
+		{"over limit without content length", 512, true, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				if !tt.chunked {
+					w.Write([]byte(manifest))
+					return
+				}
+				for i := 0; i < len(manifest); i += 100 {
+					w.Write([]byte(manifest[i:min(i+100, len(manifest))]))
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond})
+			c.SetMaxManifestSize(tt.limit)
+
+			_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				require.False(errors.Is(err, ErrTransient))
+			} else {
+				require.NoError(err)
+			}
+			require.Equal(int32(1), requests.Load(), "oversized manifests are not retried")
+		})
+	}
+}
+
+func TestGetManifestEncoding(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"sha256:00"},"layers":[]}`)
+	manifestDigest := digest.FromBytes(manifest).String()
+
+	var gzipped, zstded bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(manifest)
+	gw.Close()
+	zw, err := zstd.NewWriter(&zstded)
+	require.NoError(t, err)
+	zw.Write(manifest)
+	zw.Close()
+
+	tests := []struct {
+		name        string
+		compression bool
+		encoding    string
+		body        []byte
+		ref         string
+		wantAccept  string
+		wantErr     error
+	}{
+		{"gzip", true, "gzip", gzipped.Bytes(), manifestDigest, "zstd, gzip", nil},
+		{"zstd", true, "zstd", zstded.Bytes(), manifestDigest, "zstd, gzip", nil},
+		{"identity", true, "", manifest, manifestDigest, "zstd, gzip", nil},
+		{"disabled", false, "", manifest, "latest", "identity", nil},
+		{"digest checked after decoding", true, "zstd", zstded.Bytes(), digest.FromBytes([]byte("other")).String(), "zstd, gzip", ErrDigestMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(tt.wantAccept, r.Header.Get("Accept-Encoding"))
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.Config().SetManifestCompression(tt.compression)
+
+			m, err := c.GetManifest(context.Background(), host, "test/repo", tt.ref)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+				return
+			}
+			require.NoError(err)
+			require.Equal("sha256:00", m.Config.Digest)
+		})
+	}
+}
+
+// staticAuth answers every auth request with the same header.
+type staticAuth string
+
+func (a staticAuth) GetAuth(context.Context, string, string) (string, error) {
+	return string(a), nil
+}
+
+func TestStatBlob(t *testing.T) {
+	const size = 12345
+
+	tests := []struct {
+		name string
+		// respond answers a blob request; probe is a bytes=0-0 GET
+		respond      func(w http.ResponseWriter, r *http.Request)
+		wantSize     int64
+		wantErr      error
+		wantRequests []string
+	}{
+		{
+			name: "head supported",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(size))
+			},
+			wantSize:     size,
+			wantRequests: []string{"HEAD"},
+		},
+		{
+			name: "head unsupported",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				w.Header().Set("Content-Range", "bytes 0-0/"+strconv.Itoa(size))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("x"))
+			},
+			wantSize:     size,
+			wantRequests: []string{"HEAD", "GET bytes=0-0"},
+		},
+		{
+			name: "head requires auth",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(size))
+			},
+			wantSize:     size,
+			wantRequests: []string{"HEAD", "HEAD"},
+		},
+		{
+			name: "missing blob is not probed",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			wantErr:      ErrNotFound,
+			wantRequests: []string{"HEAD"},
+		},
+		{
+			name: "probe fails too",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			},
+			wantRequests: []string{"HEAD", "GET bytes=0-0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var mu sync.Mutex
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, strings.TrimSpace(r.Method+" "+r.Header.Get("Range")))
+				mu.Unlock()
+				require.Equal("/v2/test/repo/blobs/sha256:abc", r.URL.Path)
+				tt.respond(w, r)
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.SetAuth(staticAuth("Bearer token"))
+
+			size, err := c.StatBlob(context.Background(), host, "test/repo", "sha256:abc")
+			require.Equal(tt.wantRequests, requests)
+			switch {
+			case tt.wantErr != nil:
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+			case tt.wantSize == 0:
+				require.Error(err)
+			default:
+				require.NoError(err)
+				require.Equal(tt.wantSize, size)
+			}
+		})
+	}
+}
+
+// bodyTracker is a transport that records every response body, so tests can
+// check each was read to the end and closed, freeing its connection.
+type bodyTracker struct {
+	mu     sync.Mutex
+	bodies []*trackedBody
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	url    string
+	eof    bool
+	closed bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return b.ReadCloser.Close()
+}
+
+func (tr *bodyTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body := &trackedBody{ReadCloser: resp.Body, url: req.Method + " " + req.URL.Path, eof: resp.Body == http.NoBody}
+	tr.mu.Lock()
+	tr.bodies = append(tr.bodies, body)
+	tr.mu.Unlock()
+	resp.Body = body
+	return resp, nil
+}
+
+// leaked lists the requests whose bodies weren't drained and closed.
+func (tr *bodyTracker) leaked() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	var leaked []string
+	for _, b := range tr.bodies {
+		if !b.closed || !b.eof {
+			leaked = append(leaked, b.url)
+		}
+	}
+	return leaked
+}
+
+func TestResponseBodiesDrained(t *testing.T) {
+	blob := []byte("blob data")
+
+	tests := []struct {
+		name string
+		// respond answers every request after auth is checked
+		respond func(w http.ResponseWriter, r *http.Request)
+		// auth is the header the registry accepts; empty accepts none
+		auth string
+		call func(c *Client, host string) error
+	}{
+		{
+			name: "manifest after auth",
+			auth: "Bearer token",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:00"},"layers":[]}`))
+			},
+			call: func(c *Client, host string) error {
+				_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+				return err
+			},
+		},
+		{
+			name: "manifest unauthorized",
+			auth: "Bearer other",
+			call: func(c *Client, host string) error {
+				_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+				return err
+			},
+		},
+		{
+			name: "manifest server error",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			call: func(c *Client, host string) error {
+				_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+				return err
+			},
+		},
+		{
+			name: "blob unauthorized",
+			auth: "Bearer other",
+			call: func(c *Client, host string) error {
+				_, err := c.GetBlob(context.Background(), host, "test/repo", "sha256:abc")
+				return err
+			},
+		},
+		{
+			name: "blob not found",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "blob unknown", http.StatusNotFound)
+			},
+			call: func(c *Client, host string) error {
+				_, err := c.GetBlob(context.Background(), host, "test/repo", "sha256:abc")
+				return err
+			},
+		},
+		{
+			name: "range probe ignored",
+			auth: "Bearer token",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(blob)
+			},
+			call: func(c *Client, host string) error {
+				_, _, err := c.ProbeRange(context.Background(), host, "test/repo", "sha256:abc")
+				return err
+			},
+		},
+		{
+			name: "stat falls back to probe",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				w.Header().Set("Content-Range", "bytes 0-0/9")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(blob[:1])
+			},
+			call: func(c *Client, host string) error {
+				_, err := c.StatBlob(context.Background(), host, "test/repo", "sha256:abc")
+				return err
+			},
+		},
+		{
+			name: "blob exists after auth",
+			auth: "Bearer token",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "9")
+			},
+			call: func(c *Client, host string) error {
+				_, err := c.BlobExists(context.Background(), host, "test/repo", "sha256:abc")
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.auth != "" && r.Header.Get("Authorization") != tt.auth {
+					http.Error(w, `{"errors":[{"code":"UNAUTHORIZED"}]}`, http.StatusUnauthorized)
+					return
+				}
+				tt.respond(w, r)
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.SetAuth(staticAuth("Bearer token"))
+			c.SetRetryPolicy(RetryPolicy{})
+
+			tracker := &bodyTracker{}
+			c.config.transport = &TransportOptions{}
+			c.config.client = &http.Client{Transport: tracker}
+
+			_ = tt.call(c, host)
+			require.NotEmpty(tracker.bodies)
+			require.Empty(tracker.leaked())
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package oci
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to. After fires at once
+// and advances the clock by d, so backoff runs without real sleeps while
+// the requested delays are recorded.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	afters []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.afters = append(c.afters, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Afters returns the delays passed to After so far.
+func (c *fakeClock) Afters() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.afters...)
+}
@@ -0,0 +1,217 @@
+package oci
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RegistryConfig holds per-registry connection settings. A Client and its
+// RegistryAuth share one config so a registry configured once is reached the
+// same way for auth challenges, tokens, manifests and blobs.
+type RegistryConfig struct {
+	mu       sync.RWMutex
+	insecure map[string]bool
+	mirrors  map[string][]string
+	// namespaces holds default namespaces for single-component repositories.
+	namespaces map[string]string
+	tls        *tls.Config
+	proxy      *url.URL
+	transport  *TransportOptions
+	// plainManifests disables compressed manifest responses.
+	plainManifests bool
+	// platforms is tried in order before the host platform in indexes.
+	platforms []string
+	// osVersion prefers index entries built for that OS version.
+	osVersion string
+	// client is built from tls and proxy on first use; nil means rebuild.
+	client *http.Client
+}
+
+// NewRegistryConfig creates a config that uses HTTPS and the default
+// transport for every registry.
+func NewRegistryConfig() *RegistryConfig {
+	return &RegistryConfig{
+		insecure: make(map[string]bool),
+		mirrors:  make(map[string][]string),
+
+		namespaces: make(map[string]string),
+	}
+}
+
+// SetInsecure marks a registry as insecure (HTTP instead of HTTPS).
+func (c *RegistryConfig) SetInsecure(registry string, insecure bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insecure[registry] = insecure
+}
+
+// Insecure reports whether a registry is reached over HTTP.
+func (c *RegistryConfig) Insecure(registry string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.insecure[registry]
+}
+
+// SetMirrors sets hosts tried in order before registry for reads.
+func (c *RegistryConfig) SetMirrors(registry string, mirrors ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mirrors[registry] = slices.Clone(mirrors)
+}
+
+// Endpoints returns the hosts to read registry content from: its mirrors
+// followed by the registry itself.
+func (c *RegistryConfig) Endpoints(registry string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append(slices.Clone(c.mirrors[registry]), registry)
+}
+
+// SetDefaultNamespace sets the namespace prepended to single-component
+// repositories on registry, like library/ on Docker Hub. An empty namespace
+// restores the default.
+func (c *RegistryConfig) SetDefaultNamespace(registry, namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.namespaces[registry] = strings.Trim(namespace, "/")
+}
+
+// ParseReference parses and normalizes an image reference, applying the
+// configured default namespaces.
+func (c *RegistryConfig) ParseReference(image string) (Reference, error) {
+	return parseReference(image, func(registry string) string {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.namespaces[registry]
+	})
+}
+
+// SetTLSConfig sets the TLS config used for HTTPS registries.
+func (c *RegistryConfig) SetTLSConfig(cfg *tls.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tls = cfg
+	c.client = nil
+}
+
+// SetProxy routes registry requests through an HTTP proxy. Nil uses the
+// proxy from the environment.
+func (c *RegistryConfig) SetProxy(proxy *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proxy = proxy
+	c.client = nil
+}
+
+// SetTransportOptions tunes the connection pool used for registry requests,
+// typically to keep an idle connection for each parallel chunk fetch.
+func (c *RegistryConfig) SetTransportOptions(opts TransportOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transport = &opts
+	c.client = nil
+}
+
+// SetManifestCompression sets whether manifests are requested zstd or gzip
+// encoded. It is on by default; turning it off saves decompression CPU at
+// the cost of larger transfers.
+func (c *RegistryConfig) SetManifestCompression(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.plainManifests = !enabled
+}
+
+// ManifestCompression reports whether manifests are requested compressed.
+func (c *RegistryConfig) ManifestCompression() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.plainManifests
+}
+
+// SetPlatformPreference sets os/arch[/variant] platforms to pick from image
+// indexes, in order, before the host's, such as linux/arm64 ahead of
+// linux/arm/v7 on hosts that run both. A platform asked for explicitly
+// still takes precedence.
+func (c *RegistryConfig) SetPlatformPreference(platforms ...string) error {
+	for _, p := range platforms {
+		if err := ValidatePlatform(p); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.platforms = slices.Clone(platforms)
+	return nil
+}
+
+// PlatformPreference returns the platforms set by SetPlatformPreference.
+func (c *RegistryConfig) PlatformPreference() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.platforms)
+}
+
+// SetOSVersion sets the OS version, such as a Windows build like
+// 10.0.17763.1234, whose images are preferred when an index has entries
+// for several versions of the same platform.
+func (c *RegistryConfig) SetOSVersion(version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.osVersion = version
+}
+
+// OSVersion returns the version set by SetOSVersion.
+func (c *RegistryConfig) OSVersion() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.osVersion
+}
+
+// URL returns the base URL for a registry.
+func (c *RegistryConfig) URL(registry string) string {
+	scheme := "https"
+	if c.Insecure(registry) {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, registry)
+}
+
+// HTTPClient returns the client for registry requests. Without TLS, proxy or
+// transport settings this is http.DefaultClient.
+func (c *RegistryConfig) HTTPClient() *http.Client {
+	c.mu.RLock()
+	if c.tls == nil && c.proxy == nil && c.transport == nil {
+		c.mu.RUnlock()
+		return http.DefaultClient
+	}
+	if client := c.client; client != nil {
+		c.mu.RUnlock()
+		return client
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.tls != nil {
+		transport.TLSClientConfig = c.tls.Clone()
+	}
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	if c.transport != nil {
+		c.transport.apply(transport)
+	}
+	c.client = &http.Client{Transport: transport}
+
+	return c.client
+}
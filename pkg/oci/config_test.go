@@ -0,0 +1,184 @@
+package oci
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenRegistry serves one blob behind bearer auth and counts requests
+// for the challenge and the blob.
+func newTokenRegistry(t *testing.T, newServer func(http.Handler) *httptest.Server) (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+
+	// keep real credential files out of the auth flow
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	var challenges, blobs atomic.Int32
+	var srv *httptest.Server
+	srv = newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			fmt.Fprint(w, `{"token":"t"}`)
+		case r.Header.Get("Authorization") != "Bearer t":
+			if r.URL.Path == "/v2/" {
+				challenges.Add(1)
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/blobs/sha256:abc"):
+			blobs.Add(1)
+			fmt.Fprint(w, "blob data")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &challenges, &blobs
+}
+
+func readBlob(t *testing.T, c *Client, registry string) string {
+	t.Helper()
+
+	rc, err := c.GetBlob(context.Background(), registry, "test/repo", "sha256:abc")
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRegistryConfigInsecurePropagates(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Client, host string)
+	}{
+		{"client before auth", func(c *Client, host string) {
+			c.SetInsecure(host, true)
+			c.SetAuth(NewRegistryAuth())
+		}},
+		{"client after auth", func(c *Client, host string) {
+			c.SetAuth(NewRegistryAuth())
+			c.SetInsecure(host, true)
+		}},
+		{"shared config", func(c *Client, host string) {
+			cfg := NewRegistryConfig()
+			cfg.SetInsecure(host, true)
+			c.SetAuth(NewRegistryAuth())
+			c.SetConfig(cfg)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			srv, challenges, blobs := newTokenRegistry(t, httptest.NewServer)
+			host := strings.TrimPrefix(srv.URL, "http://")
+
+			c := NewClient()
+			tt.configure(c, host)
+
+			require.Equal("blob data", readBlob(t, c, host))
+			require.Equal(int32(1), challenges.Load())
+			require.Equal(int32(1), blobs.Load())
+		})
+	}
+}
+
+func TestRegistryConfigTLS(t *testing.T) {
+	require := require.New(t)
+
+	srv, challenges, _ := newTokenRegistry(t, httptest.NewTLSServer)
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	c := NewClient()
+	c.SetAuth(NewRegistryAuth())
+
+	// the test CA is unknown until configured
+	_, err := c.GetBlob(context.Background(), host, "test/repo", "sha256:abc")
+	require.Error(err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	c.Config().SetTLSConfig(&tls.Config{RootCAs: pool})
+
+	require.Equal("blob data", readBlob(t, c, host))
+	require.Equal(int32(1), challenges.Load())
+}
+
+func TestRegistryConfigMirrors(t *testing.T) {
+	require := require.New(t)
+
+	upstream, _, upstreamBlobs := newTokenRegistry(t, httptest.NewServer)
+	mirror, _, mirrorBlobs := newTokenRegistry(t, httptest.NewServer)
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	mirrorHost := strings.TrimPrefix(mirror.URL, "http://")
+
+	empty := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(empty.Close)
+	emptyHost := strings.TrimPrefix(empty.URL, "http://")
+
+	c := NewClient()
+	c.SetAuth(NewRegistryAuth())
+	for _, host := range []string{upstreamHost, mirrorHost, emptyHost} {
+		c.SetInsecure(host, true)
+	}
+
+	c.Config().SetMirrors(upstreamHost, mirrorHost)
+	require.Equal("blob data", readBlob(t, c, upstreamHost))
+	require.Equal(int32(1), mirrorBlobs.Load())
+	require.Equal(int32(0), upstreamBlobs.Load())
+
+	// a mirror without the blob falls through to the registry
+	c.Config().SetMirrors(upstreamHost, emptyHost)
+	require.Equal("blob data", readBlob(t, c, upstreamHost))
+	require.Equal(int32(1), upstreamBlobs.Load())
+}
+
+func TestRegistryConfigPlatformPreference(t *testing.T) {
+	require := require.New(t)
+
+	index := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[
+		{"digest":"sha256:armv7","platform":{"os":"linux","architecture":"arm","variant":"v7"}},
+		{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64"}}
+	]}`
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"sha256:config"},"layers":[]}`
+
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := path.Base(r.URL.Path)
+		fetched = append(fetched, ref)
+		if ref == "latest" {
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Write([]byte(index))
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Write([]byte(manifest))
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c := NewClient()
+	c.SetInsecure(host, true)
+	require.ErrorIs(c.Config().SetPlatformPreference("linux"), ErrInvalidPlatform)
+	require.NoError(c.Config().SetPlatformPreference("linux/s390x", "linux/arm64", "linux/arm/v7"))
+
+	_, err := c.GetManifest(context.Background(), host, "test/repo", "latest")
+	require.NoError(err)
+	require.Equal([]string{"latest", "sha256:arm64"}, fetched)
+}
@@ -0,0 +1,165 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/hexfusion/fray/internal/version"
+)
+
+var ErrCredentialsNotFound = errors.New("credentials not found")
+
+// DefaultAuthFile returns the containers auth file that login writes to.
+func DefaultAuthFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("home dir: %w", err)
+	}
+	return filepath.Join(home, ".config/containers/auth.json"), nil
+}
+
+// AuthKey returns the auth.json key for a registry, using docker.io for
+// Docker Hub as podman and docker do.
+func AuthKey(registry string) string {
+	if registry == DockerHubRegistry {
+		return DockerHubAlias
+	}
+	return registry
+}
+
+// SaveCredentials stores a base64 user:password entry for registry in the
+// auth file at path. Other entries and fields written by other tools are kept.
+func SaveCredentials(path, registry, username, password string) error {
+	config, auths, err := readAuthFile(path)
+	if err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	})
+	if err != nil {
+		return err
+	}
+	auths[AuthKey(registry)] = entry
+
+	return writeAuthFile(path, config, auths)
+}
+
+// RemoveCredentials deletes the entry for registry from the auth file at path.
+func RemoveCredentials(path, registry string) error {
+	config, auths, err := readAuthFile(path)
+	if err != nil {
+		return err
+	}
+
+	key := AuthKey(registry)
+	if _, ok := auths[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrCredentialsNotFound, key)
+	}
+	delete(auths, key)
+
+	return writeAuthFile(path, config, auths)
+}
+
+// readAuthFile decodes the file loosely so unknown fields survive a rewrite.
+// A missing file yields empty maps.
+func readAuthFile(path string) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	config := make(map[string]json.RawMessage)
+	auths := make(map[string]json.RawMessage)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, auths, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read auth file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("parse auth file: %w", err)
+	}
+	if raw, ok := config["auths"]; ok {
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return nil, nil, fmt.Errorf("parse auths: %w", err)
+		}
+	}
+
+	return config, auths, nil
+}
+
+func writeAuthFile(path string, config, auths map[string]json.RawMessage) error {
+	raw, err := json.Marshal(auths)
+	if err != nil {
+		return err
+	}
+	config["auths"] = raw
+
+	data, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create auth dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".auth-*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write auth file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close auth file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename auth file: %w", err)
+	}
+	return nil
+}
+
+// Login checks username and password against registry, using a token
+// request for bearer-auth registries and basic auth on /v2/ otherwise.
+func (r *RegistryAuth) Login(ctx context.Context, registry, username, password string) error {
+	ch, err := r.fetchChallenge(ctx, registry)
+	if err != nil {
+		return fmt.Errorf("ping registry: %w", err)
+	}
+
+	if ch != nil && ch.scheme == "bearer" && ch.realm != "" {
+		if _, err := r.getToken(ctx, ch, "", username, password); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrUnauthorized, registry, err)
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", r.registryURL(registry)+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.SetBasicAuth(username, password)
+
+	resp, err := r.config.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("ping registry: %w", err)
+	}
+	drainBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: status %d", ErrUnauthorized, registry, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newAuthServer serves a token-auth registry that accepts user:secret.
+func newAuthServer(t *testing.T) string {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+				http.Error(w, `{"errors":[{"code":"DENIED"}]}`, http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"abc"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"valid credentials", "secret", false},
+		{"wrong password", "wrong", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			host := newAuthServer(t)
+			auth := NewRegistryAuth()
+			auth.SetInsecure(host, true)
+
+			err := auth.Login(context.Background(), host, "user", tt.password)
+			if tt.wantErr {
+				require.True(errors.Is(err, ErrUnauthorized))
+				return
+			}
+			require.NoError(err)
+		})
+	}
+}
+
+func TestCredentialsRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	host := newAuthServer(t)
+	path := filepath.Join(t.TempDir(), "containers", "auth.json")
+
+	// entries from other tools must survive
+	existing := `{"auths":{"quay.io":{"auth":"b3RoZXI6cGFzcw==","identitytoken":"tok"}},"credHelpers":{"gcr.io":"gcloud"}}`
+	require.NoError(os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(os.WriteFile(path, []byte(existing), 0600))
+
+	auth := NewRegistryAuth()
+	auth.SetInsecure(host, true)
+	require.NoError(auth.Login(context.Background(), host, "user", "secret"))
+	require.NoError(SaveCredentials(path, host, "user", "secret"))
+
+	username, password, err := auth.loadFromFile(path, host)
+	require.NoError(err)
+	require.Equal("user", username)
+	require.Equal("secret", password)
+
+	info, err := os.Stat(path)
+	require.NoError(err)
+	require.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	var config struct {
+		Auths       map[string]map[string]string `json:"auths"`
+		CredHelpers map[string]string            `json:"credHelpers"`
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	require.NoError(json.Unmarshal(data, &config))
+	require.Equal("tok", config.Auths["quay.io"]["identitytoken"])
+	require.Equal("gcloud", config.CredHelpers["gcr.io"])
+
+	require.NoError(RemoveCredentials(path, host))
+	username, _, err = auth.loadFromFile(path, host)
+	require.NoError(err)
+	require.Empty(username)
+
+	err = RemoveCredentials(path, host)
+	require.True(errors.Is(err, ErrCredentialsNotFound))
+
+	username, _, err = auth.loadFromFile(path, "quay.io")
+	require.NoError(err)
+	require.Equal("other", username)
+}
+
+func TestSaveCredentialsDockerHub(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(SaveCredentials(path, DockerHubRegistry, "user", "secret"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	require.Contains(string(data), `"docker.io"`)
+
+	username, _, err := NewRegistryAuth().loadFromFile(path, DockerHubRegistry)
+	require.NoError(err)
+	require.Equal("user", username)
+}
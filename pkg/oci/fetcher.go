@@ -2,27 +2,112 @@ package oci
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/hexfusion/fray/internal/version"
+)
+
+var (
+	ErrNoContentLength = errors.New("no content length")
+	ErrBadContentRange = errors.New("invalid content-range")
+	// ErrRangeUnsupported is returned in strict mode when a server answers a
+	// range request with the whole resource.
+	ErrRangeUnsupported = errors.New("server ignored range request")
+	// ErrIdleTimeout is returned when a fetch receives no bytes for the idle
+	// timeout. It also matches ErrTransient, so the fetch is retried.
+	ErrIdleTimeout = errors.New("idle timeout")
+	// ErrSizeUnknown is returned by HeadSize when neither HEAD nor a range
+	// probe reports a size, as with chunked responses. The resource can
+	// still be streamed whole, just not fetched in chunks.
+	ErrSizeUnknown = errors.New("size unknown")
 )
 
+// defaultFetchTimeout bounds a whole range request when no idle timeout is
+// set.
+const defaultFetchTimeout = 30 * time.Second
+
 // Fetcher fetches byte ranges from HTTP endpoints.
 type Fetcher struct {
 	client     *http.Client
 	maxRetries int
 	retryDelay time.Duration
+	maxDelay   time.Duration
+	jitter     Jitter
+	// strict limits retries to transient failures and rejects full responses.
+	strict bool
+	// idleTimeout fails a fetch that receives no bytes for this long.
+	idleTimeout time.Duration
+	clock       Clock
 }
 
 // NewFetcher creates a Fetcher with default settings.
 func NewFetcher() *Fetcher {
 	return &Fetcher{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: defaultFetchTimeout,
 		},
-		maxRetries: 3,
-		retryDelay: time.Second,
+		maxRetries: DefaultMaxRetries,
+		retryDelay: DefaultRetryBaseDelay,
+		maxDelay:   DefaultRetryMaxDelay,
+		jitter:     DefaultRetryJitter,
+		clock:      RealClock(),
+	}
+}
+
+// SetTransport sets the transport range requests are sent with. Fetchers
+// that share one reuse each other's connections; by default a Fetcher uses
+// http.DefaultTransport.
+func (f *Fetcher) SetTransport(rt http.RoundTripper) {
+	f.client.Transport = rt
+}
+
+// SetClock sets the clock used to wait between retries.
+func (f *Fetcher) SetClock(clock Clock) {
+	f.clock = clock
+}
+
+// SetRetryPolicy sets the retry policy for range fetches.
+func (f *Fetcher) SetRetryPolicy(p RetryPolicy) {
+	f.maxRetries = p.MaxRetries
+	f.retryDelay = p.BaseDelay
+	f.maxDelay = p.MaxDelay
+	f.jitter = p.Jitter
+}
+
+// SetStrict controls strict range handling. In strict mode FetchRange only
+// retries connection errors and 5xx responses, fails with ErrRangeUnsupported
+// when the server returns 200 instead of 206, and rejects a Content-Range
+// that doesn't match the request.
+func (f *Fetcher) SetStrict(strict bool) {
+	f.strict = strict
+}
+
+// SetIdleTimeout fails a range fetch, and so retries it, when no bytes
+// arrive for d while waiting for headers or reading the body. Setting it
+// replaces the total request timeout, so slow transfers that keep making
+// progress are not cut off. Zero restores the total timeout.
+func (f *Fetcher) SetIdleTimeout(d time.Duration) {
+	f.idleTimeout = d
+	if d > 0 {
+		f.client.Timeout = 0
+	} else {
+		f.client.Timeout = defaultFetchTimeout
+	}
+}
+
+// RetryPolicy returns the effective retry policy.
+func (f *Fetcher) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: f.maxRetries,
+		BaseDelay:  f.retryDelay,
+		MaxDelay:   f.maxDelay,
+		Jitter:     f.jitter,
 	}
 }
 
@@ -32,11 +117,11 @@ func (f *Fetcher) FetchRange(ctx context.Context, url string, start, end int64)
 
 	for attempt := 0; attempt <= f.maxRetries; attempt++ {
 		if attempt > 0 {
-			delay := f.retryDelay * time.Duration(1<<(attempt-1))
+			delay := f.RetryPolicy().Delay(attempt)
 			select {
 			case <-ctx.Done():
 				return nil, fmt.Errorf("fetch cancelled: %w", ctx.Err())
-			case <-time.After(delay):
+			case <-f.clock.After(delay):
 			}
 		}
 
@@ -44,6 +129,9 @@ func (f *Fetcher) FetchRange(ctx context.Context, url string, start, end int64)
 		if err == nil {
 			return data, nil
 		}
+		if f.strict && !errors.Is(err, ErrTransient) {
+			return nil, err
+		}
 		lastErr = err
 	}
 
@@ -51,26 +139,60 @@ func (f *Fetcher) FetchRange(ctx context.Context, url string, start, end int64)
 }
 
 func (f *Fetcher) fetchRangeOnce(ctx context.Context, url string, start, end int64) ([]byte, error) {
+	var idle *time.Timer
+	if f.idleTimeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+
+		idleErr := fmt.Errorf("%w: %w: no data for %s", ErrTransient, ErrIdleTimeout, f.idleTimeout)
+		idle = time.AfterFunc(f.idleTimeout, func() { cancel(idleErr) })
+		defer idle.Stop()
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Set("User-Agent", version.UserAgent())
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
 
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return nil, err
+		if cause := context.Cause(ctx); errors.Is(cause, ErrIdleTimeout) {
+			return nil, cause
+		}
+		return nil, fmt.Errorf("%w: %w", ErrTransient, err)
 	}
-	defer resp.Body.Close()
+	defer drainBody(resp.Body)
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("%w: unexpected status: %d", ErrTransient, resp.StatusCode)
+	case resp.StatusCode == http.StatusOK && f.strict:
+		return nil, fmt.Errorf("%w: bytes %d-%d", ErrRangeUnsupported, start, end-1)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
 		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	if f.strict {
+		if err := checkContentRange(resp.Header.Get("Content-Range"), start, end); err != nil {
+			return nil, err
+		}
+	}
+
+	var body io.Reader = resp.Body
+	if idle != nil {
+		body = &idleReader{r: resp.Body, timer: idle, timeout: f.idleTimeout}
+	}
+
+	data, err := io.ReadAll(body)
 	if err != nil {
-		return nil, err
+		if cause := context.Cause(ctx); errors.Is(cause, ErrIdleTimeout) {
+			return nil, cause
+		}
+		return nil, fmt.Errorf("%w: %w", ErrTransient, err)
 	}
 
 	if resp.StatusCode == http.StatusPartialContent {
@@ -83,12 +205,44 @@ func (f *Fetcher) fetchRangeOnce(ctx context.Context, url string, start, end int
 	return data, nil
 }
 
+// idleReader restarts the idle timer whenever a read returns data.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err //nolint:wrapcheck // io.EOF must pass through unwrapped
+}
+
 // HeadSize returns the content-length of a resource via HEAD request.
+// Falls back to a one-byte range probe when HEAD is unsupported or sends
+// no length. If the probe can't tell the size either, the error matches
+// ErrSizeUnknown.
 func (f *Fetcher) HeadSize(ctx context.Context, url string) (int64, error) {
+	size, headErr := f.headSizeOnce(ctx, url)
+	if headErr == nil {
+		return size, nil
+	}
+
+	size, err := f.probeSize(ctx, url)
+	if err != nil {
+		return 0, errors.Join(headErr, err)
+	}
+	return size, nil
+}
+
+func (f *Fetcher) headSizeOnce(ctx context.Context, url string) (int64, error) {
 	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return 0, err
 	}
+	req.Header.Set("User-Agent", version.UserAgent())
 
 	resp, err := f.client.Do(req)
 	if err != nil {
@@ -100,5 +254,73 @@ func (f *Fetcher) HeadSize(ctx context.Context, url string) (int64, error) {
 		return 0, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 	}
 
+	if resp.ContentLength < 0 {
+		return 0, ErrNoContentLength
+	}
+
 	return resp.ContentLength, nil
 }
+
+// probeSize issues a bytes=0-0 range request and parses the total from
+// Content-Range. A server that ignores the range is sized by its
+// Content-Length; without either it's ErrSizeUnknown.
+func (f *Fetcher) probeSize(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer drainBody(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK && resp.ContentLength >= 0:
+		return resp.ContentLength, nil
+	case resp.StatusCode == http.StatusOK:
+		return 0, fmt.Errorf("range probe: %w: range ignored and no content length", ErrSizeUnknown)
+	case resp.StatusCode != http.StatusPartialContent:
+		return 0, fmt.Errorf("range probe: unexpected status: %d", resp.StatusCode)
+	}
+
+	header := resp.Header.Get("Content-Range")
+	if header == "" || strings.HasSuffix(header, "/*") {
+		return 0, fmt.Errorf("range probe: %w: content-range %q", ErrSizeUnknown, header)
+	}
+	return parseContentRangeTotal(header)
+}
+
+// checkContentRange verifies a 206's "bytes first-last/total" covers exactly
+// [start, end). A missing header is accepted.
+func checkContentRange(header string, start, end int64) error {
+	if header == "" {
+		return nil
+	}
+	span, _, ok := strings.Cut(strings.TrimPrefix(header, "bytes "), "/")
+	if !ok || !strings.HasPrefix(header, "bytes ") {
+		return fmt.Errorf("%w: %q", ErrBadContentRange, header)
+	}
+	if span != fmt.Sprintf("%d-%d", start, end-1) {
+		return fmt.Errorf("%w: %q for bytes %d-%d", ErrBadContentRange, header, start, end-1)
+	}
+	return nil
+}
+
+// parseContentRangeTotal extracts the total size from "bytes 0-0/12345".
+func parseContentRangeTotal(header string) (int64, error) {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok || !strings.HasPrefix(header, "bytes ") || total == "*" {
+		return 0, fmt.Errorf("%w: %q", ErrBadContentRange, header)
+	}
+
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrBadContentRange, header)
+	}
+	return size, nil
+}
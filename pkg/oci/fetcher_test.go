@@ -2,9 +2,12 @@ package oci
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -121,6 +124,122 @@ func TestHeadSizeError(t *testing.T) {
 	_, err := f.HeadSize(ctx, server.URL)
 	require.Error(err)
 	require.Contains(err.Error(), "404")
+	require.NotErrorIs(err, ErrSizeUnknown)
+}
+
+func TestHeadSizeRangeFallback(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		require.Equal("bytes=0-0", r.Header.Get("Range"))
+		w.Header().Set("Content-Range", "bytes 0-0/12345")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("x"))
+	}))
+	defer server.Close()
+
+	f := NewFetcher()
+
+	size, err := f.HeadSize(context.Background(), server.URL)
+	require.NoError(err)
+	require.Equal(int64(12345), size)
+}
+
+func TestHeadSizeChunked(t *testing.T) {
+	tests := []struct {
+		name        string
+		probe       func(w http.ResponseWriter)
+		want        int64
+		wantUnknown bool
+	}{
+		{
+			name: "probe total",
+			probe: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Range", "bytes 0-0/12345")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("x"))
+			},
+			want: 12345,
+		},
+		{
+			name: "range ignored",
+			probe: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("hello "))
+				w.(http.Flusher).Flush()
+				w.Write([]byte("world"))
+			},
+			wantUnknown: true,
+		},
+		{
+			name: "unknown total",
+			probe: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Range", "bytes 0-0/*")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("x"))
+			},
+			wantUnknown: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					// a chunked response carries no length
+					w.Header().Set("Transfer-Encoding", "chunked")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				tt.probe(w)
+			}))
+			defer server.Close()
+
+			size, err := NewFetcher().HeadSize(context.Background(), server.URL)
+			if tt.wantUnknown {
+				require.ErrorIs(err, ErrSizeUnknown)
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.want, size)
+		})
+	}
+}
+
+func TestParseContentRangeTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    int64
+		wantErr bool
+	}{
+		{"valid", "bytes 0-0/12345", 12345, false},
+		{"zero", "bytes 0-0/0", 0, false},
+		{"unknown total", "bytes 0-0/*", 0, true},
+		{"missing total", "bytes 0-0", 0, true},
+		{"wrong unit", "items 0-0/10", 0, true},
+		{"empty", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			got, err := parseContentRangeTotal(tt.header)
+			if tt.wantErr {
+				require.True(errors.Is(err, ErrBadContentRange))
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.want, got)
+		})
+	}
 }
 
 func TestFetchRangeCancellation(t *testing.T) {
@@ -141,6 +260,195 @@ func TestFetchRangeCancellation(t *testing.T) {
 	require.Error(err)
 }
 
+func TestFetchRangeBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "doubling",
+			policy: RetryPolicy{MaxRetries: 3, BaseDelay: time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:   "capped",
+			policy: RetryPolicy{MaxRetries: 4, BaseDelay: 10 * time.Second, MaxDelay: 30 * time.Second},
+			want:   []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name:   "no retries",
+			policy: RetryPolicy{MaxRetries: 0, BaseDelay: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			clock := newFakeClock()
+			f := NewFetcher()
+			f.SetRetryPolicy(tt.policy)
+			f.SetClock(clock)
+
+			start := time.Now()
+			_, err := f.FetchRange(context.Background(), server.URL, 0, 10)
+			require.Error(err)
+			require.Equal(tt.policy.MaxRetries+1, int(attempts.Load()))
+			require.Equal(tt.want, clock.Afters())
+			require.Less(time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestFetchRangeIdleTimeout(t *testing.T) {
+	content := "0123456789"
+
+	tests := []struct {
+		name string
+		// delay is the pause before each byte after the first
+		delay        time.Duration
+		wantErr      error
+		wantAttempts int
+	}{
+		{"slow but steady", 10 * time.Millisecond, nil, 1},
+		{"stalled", time.Hour, ErrIdleTimeout, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.Header().Set("Content-Range", "bytes 0-9/10")
+				w.WriteHeader(http.StatusPartialContent)
+				for i := range len(content) {
+					if i > 0 {
+						select {
+						case <-r.Context().Done():
+							return
+						case <-time.After(tt.delay):
+						}
+					}
+					w.Write([]byte{content[i]})
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer server.Close()
+
+			f := NewFetcher()
+			f.SetRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond})
+			f.SetIdleTimeout(50 * time.Millisecond)
+
+			start := time.Now()
+			data, err := f.FetchRange(context.Background(), server.URL, 0, 10)
+			require.Equal(tt.wantAttempts, int(attempts.Load()))
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				require.True(errors.Is(err, ErrTransient))
+				require.Less(time.Since(start), 5*time.Second)
+				return
+			}
+			require.NoError(err)
+			require.Equal(content, string(data))
+		})
+	}
+}
+
+func TestFetchRangeStrict(t *testing.T) {
+	content := "0123456789abcdefghij"
+
+	tests := []struct {
+		name string
+		// respond answers the given attempt, starting at 1
+		respond      func(w http.ResponseWriter, attempt int)
+		wantFail     bool
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name: "503 is retried",
+			respond: func(w http.ResponseWriter, attempt int) {
+				if attempt == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Range", "bytes 5-9/20")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(content[5:10]))
+			},
+			wantAttempts: 2,
+		},
+		{
+			name: "range ignored is not retried",
+			respond: func(w http.ResponseWriter, _ int) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(content))
+			},
+			wantFail:     true,
+			wantErr:      ErrRangeUnsupported,
+			wantAttempts: 1,
+		},
+		{
+			name: "wrong content range is not retried",
+			respond: func(w http.ResponseWriter, _ int) {
+				w.Header().Set("Content-Range", "bytes 0-4/20")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(content[0:5]))
+			},
+			wantFail:     true,
+			wantErr:      ErrBadContentRange,
+			wantAttempts: 1,
+		},
+		{
+			name: "client error is not retried",
+			respond: func(w http.ResponseWriter, _ int) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			wantFail:     true,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				tt.respond(w, attempts)
+			}))
+			defer server.Close()
+
+			f := NewFetcher()
+			f.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond})
+			f.SetStrict(true)
+
+			data, err := f.FetchRange(context.Background(), server.URL, 5, 10)
+			require.Equal(tt.wantAttempts, attempts)
+			if tt.wantFail {
+				require.Error(err)
+				if tt.wantErr != nil {
+					require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				}
+				return
+			}
+			require.NoError(err)
+			require.Equal("56789", string(data))
+		})
+	}
+}
+
 func parseRange(header string, start, end *int) {
 	*start = 0
 	*end = 0
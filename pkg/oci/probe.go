@@ -0,0 +1,157 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// Capabilities is what a registry supports, as found by Probe.
+type Capabilities struct {
+	Registry string `json:"registry"`
+	// V2 is whether /v2/ answers as a distribution API.
+	V2 bool `json:"v2"`
+	// AuthScheme is the scheme /v2/ challenges with, such as bearer or
+	// basic, or empty if it allows anonymous access. Bearer is reported
+	// when it is one of several offered.
+	AuthScheme string `json:"auth_scheme"`
+	// Repository and Blob are the sample the blob checks ran against. Both
+	// are empty when no repository was given and the checks were skipped.
+	Repository string `json:"repository,omitempty"`
+	Blob       string `json:"blob,omitempty"`
+	Head       bool   `json:"head"`
+	Range      bool   `json:"range"`
+	Referrers  bool   `json:"referrers"`
+}
+
+// Probe reports what registry supports: the v2 API and how it
+// authenticates, and, given a repo and ref to sample, whether it answers
+// HEAD and Range requests for a blob and serves the referrers API. It
+// checks the registry itself, never its mirrors. A registry without the v2
+// API isn't probed further.
+func (c *Client) Probe(ctx context.Context, registry, repo, ref string) (*Capabilities, error) {
+	caps := &Capabilities{Registry: registry}
+	base := c.registryURL(registry)
+
+	if err := c.probeV2(ctx, base, caps); err != nil {
+		return nil, err
+	}
+	if !caps.V2 || repo == "" {
+		return caps, nil
+	}
+
+	// referrers are looked up by the digest ref names; the config of the
+	// image it resolves to is a small blob to sample
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/", base, repo)
+	body, mediaType, err := c.doManifestRequest(ctx, manifestURL+ref, registry, repo, false)
+	if err != nil {
+		return nil, fmt.Errorf("get sample manifest: %w", err)
+	}
+	manifestDigest := digest.FromBytes(body).String()
+	if isManifestList(mediaType) {
+		var list ManifestList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("parse manifest list: %w", err)
+		}
+		platform, err := selectPlatform(list, "", c.config.PlatformPreference(), c.config.OSVersion())
+		if err != nil {
+			return nil, err
+		}
+		if body, _, err = c.doManifestRequest(ctx, manifestURL+platform, registry, repo, false); err != nil {
+			return nil, fmt.Errorf("get sample manifest: %w", err)
+		}
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	caps.Repository = repo
+	caps.Blob = manifest.Config.Digest
+
+	blobURL := fmt.Sprintf("%s/v2/%s/blobs/%s", base, repo, caps.Blob)
+	_, err = c.doBlobStat(ctx, blobURL, registry, repo, false, false)
+	caps.Head = err == nil
+
+	probe, err := c.doRangeCheck(ctx, blobURL, registry, repo, false)
+	if err != nil {
+		return nil, fmt.Errorf("range request: %w", err)
+	}
+	caps.Range = probe.supported
+
+	caps.Referrers, err = c.doReferrersCheck(ctx, fmt.Sprintf("%s/v2/%s/referrers/%s", base, repo, manifestDigest), registry, repo, false)
+	if err != nil {
+		return nil, fmt.Errorf("referrers request: %w", err)
+	}
+	return caps, nil
+}
+
+// probeV2 checks /v2/ anonymously, recording whether it answers and the
+// scheme of its challenge.
+func (c *Client) probeV2(ctx context.Context, base string, caps *Capabilities) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.config.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	drainBody(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		caps.V2 = true
+	case http.StatusUnauthorized:
+		caps.V2 = true
+		ch, err := parseChallenge(strings.Join(resp.Header.Values("WWW-Authenticate"), ", "))
+		if err != nil {
+			return err
+		}
+		if ch != nil {
+			caps.AuthScheme = ch.scheme
+		}
+	}
+	return nil
+}
+
+// doReferrersCheck reports whether the referrers API answers for a
+// manifest. Registries without it return 404 rather than an empty index.
+func (c *Client) doReferrersCheck(ctx context.Context, url, registry, repo string, withAuth bool) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/vnd.oci.image.index.v1+json")
+
+	if withAuth && c.auth != nil {
+		authHeader, err := c.auth.GetAuth(ctx, registry, repo)
+		if err != nil && !strings.Contains(err.Error(), "DENIED") {
+			return false, fmt.Errorf("get auth: %w", err)
+		}
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+	}
+
+	resp, err := c.config.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	drainBody(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && !withAuth && c.auth != nil:
+		return c.doReferrersCheck(ctx, url, registry, repo, true)
+	case resp.StatusCode == http.StatusUnauthorized:
+		return false, fmt.Errorf("%w: %s", ErrUnauthorized, registry)
+	}
+	return resp.StatusCode == http.StatusOK, nil
+}
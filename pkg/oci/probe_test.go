@@ -0,0 +1,143 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// mockRegistry serves one image, test/repo:latest, with the capabilities
+// its fields turn on.
+type mockRegistry struct {
+	// challenge is the WWW-Authenticate header; empty allows anonymous
+	// access
+	challenge string
+	noV2      bool
+	head      bool
+	ranges    bool
+	referrers bool
+	// index serves the tag as an index of the image
+	index bool
+}
+
+func (m mockRegistry) handler(requests *[]string) http.Handler {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configDigest := digest.FromBytes(config).String()
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"` + configDigest + `"},"layers":[]}`)
+	manifestDigest := digest.FromBytes(manifest).String()
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[{"digest":%q,"platform":{"os":%q,"architecture":%q}}]}`,
+		manifestDigest, runtime.GOOS, runtime.GOARCH))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Method+" "+r.URL.Path)
+
+		if m.noV2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if m.challenge != "" && r.Header.Get("Authorization") != "Bearer token" {
+			w.Header().Set("WWW-Authenticate", m.challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch path := r.URL.Path; {
+		case path == "/v2/":
+		case path == "/v2/test/repo/manifests/latest" && m.index:
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Write(index)
+		case path == "/v2/test/repo/manifests/latest", path == "/v2/test/repo/manifests/"+manifestDigest:
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Write(manifest)
+		case path == "/v2/test/repo/blobs/"+configDigest:
+			switch {
+			case r.Method == http.MethodHead && !m.head:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			case r.Header.Get("Range") != "" && m.ranges:
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", len(config)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(config[:1])
+			default:
+				w.Write(config)
+			}
+		case strings.HasPrefix(path, "/v2/test/repo/referrers/") && m.referrers:
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Write([]byte(`{"schemaVersion":2,"manifests":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestProbe(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configDigest := digest.FromBytes(config).String()
+
+	tests := []struct {
+		name     string
+		registry mockRegistry
+		repo     string
+		want     Capabilities
+	}{
+		{
+			name:     "full support behind bearer auth",
+			registry: mockRegistry{challenge: `Bearer realm="https://auth.example.com/token"`, head: true, ranges: true, referrers: true},
+			repo:     "test/repo",
+			want:     Capabilities{V2: true, AuthScheme: "bearer", Repository: "test/repo", Blob: configDigest, Head: true, Range: true, Referrers: true},
+		},
+		{
+			name:     "anonymous without head, range or referrers",
+			registry: mockRegistry{},
+			repo:     "test/repo",
+			want:     Capabilities{V2: true, Repository: "test/repo", Blob: configDigest},
+		},
+		{
+			name:     "basic auth and an index",
+			registry: mockRegistry{challenge: `Basic realm="registry"`, head: true, index: true},
+			repo:     "test/repo",
+			want:     Capabilities{V2: true, AuthScheme: "basic", Repository: "test/repo", Blob: configDigest, Head: true},
+		},
+		{
+			name:     "no repository skips blob checks",
+			registry: mockRegistry{head: true, ranges: true, referrers: true},
+			want:     Capabilities{V2: true},
+		},
+		{
+			name:     "not a registry",
+			registry: mockRegistry{noV2: true, head: true},
+			repo:     "test/repo",
+			want:     Capabilities{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var requests []string
+			server := httptest.NewServer(tt.registry.handler(&requests))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			c := NewClient()
+			c.SetInsecure(host, true)
+			c.SetAuth(staticAuth("Bearer token"))
+
+			caps, err := c.Probe(context.Background(), host, tt.repo, "latest")
+			require.NoError(err)
+			tt.want.Registry = host
+			require.Equal(tt.want, *caps)
+			if !tt.want.V2 || tt.repo == "" {
+				require.Equal([]string{"GET /v2/"}, requests)
+			}
+		})
+	}
+}
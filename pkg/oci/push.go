@@ -0,0 +1,244 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrNoUploadLocation = errors.New("upload response missing location")
+
+// DefaultUploadChunkSize is the PATCH size for chunked blob uploads.
+const DefaultUploadChunkSize = 5 * 1024 * 1024
+
+// PushAuthProvider provides push-scoped authentication. Auth providers that
+// don't implement it fall back to GetAuth for writes.
+type PushAuthProvider interface {
+	GetPushAuth(ctx context.Context, registry, repo string) (string, error)
+}
+
+// SetUploadChunkSize sets the PATCH size for blob uploads. Blobs no larger
+// than this are uploaded in a single PUT.
+func (c *Client) SetUploadChunkSize(n int) {
+	if n > 0 {
+		c.uploadChunkSize = n
+	}
+}
+
+// BlobExists reports whether a blob is already present in repo.
+func (c *Client) BlobExists(ctx context.Context, registry, repo, digest string) (bool, error) {
+	u := fmt.Sprintf("%s/v2/%s/blobs/%s", c.registryURL(registry), repo, digest)
+	w := &writeSession{client: c, registry: registry, repo: repo}
+
+	resp, err := w.do(ctx, http.MethodHead, u, "", nil)
+	if err != nil {
+		return false, err
+	}
+	drainBody(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized:
+		return false, fmt.Errorf("%w: %s", ErrUnauthorized, registry)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+// PushBlob uploads size bytes from r as digest. Blobs larger than the upload
+// chunk size are sent as PATCH chunks; smaller ones use a monolithic PUT.
+func (c *Client) PushBlob(ctx context.Context, registry, repo, digest string, size int64, r io.Reader) error {
+	w := &writeSession{client: c, registry: registry, repo: repo}
+
+	start := fmt.Sprintf("%s/v2/%s/blobs/uploads/", c.registryURL(registry), repo)
+	resp, err := w.do(ctx, http.MethodPost, start, "", nil)
+	if err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+	location, err := uploadLocation(resp, w.registry)
+	if err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+
+	var body []byte
+	if size <= int64(c.uploadChunkSize) {
+		body, err = io.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+	} else {
+		location, err = w.uploadChunks(ctx, location, r, c.uploadChunkSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("parse location: %w", err)
+	}
+	q := u.Query()
+	q.Set("digest", digest)
+	u.RawQuery = q.Encode()
+
+	resp, err = w.do(ctx, http.MethodPut, u.String(), "application/octet-stream", body)
+	if err != nil {
+		return fmt.Errorf("complete upload: %w", err)
+	}
+	if err := checkStatus(resp, registry, http.StatusCreated); err != nil {
+		return fmt.Errorf("complete upload: %w", err)
+	}
+
+	return nil
+}
+
+func (w *writeSession) uploadChunks(ctx context.Context, location string, r io.Reader, chunkSize int) (string, error) {
+	buf := make([]byte, chunkSize)
+	var offset int64
+
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			header := http.Header{}
+			header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+
+			resp, err := w.doHeader(ctx, http.MethodPatch, location, "application/octet-stream", header, buf[:n])
+			if err != nil {
+				return "", fmt.Errorf("upload chunk at %d: %w", offset, err)
+			}
+			location, err = uploadLocation(resp, w.registry)
+			if err != nil {
+				return "", fmt.Errorf("upload chunk at %d: %w", offset, err)
+			}
+			offset += int64(n)
+		}
+
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return location, nil
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("read blob: %w", readErr)
+		}
+	}
+}
+
+// PutManifest uploads a manifest under ref, which may be a tag or digest.
+func (c *Client) PutManifest(ctx context.Context, registry, repo, ref, mediaType string, body []byte) error {
+	u := fmt.Sprintf("%s/v2/%s/manifests/%s", c.registryURL(registry), repo, ref)
+	w := &writeSession{client: c, registry: registry, repo: repo}
+
+	resp, err := w.do(ctx, http.MethodPut, u, mediaType, body)
+	if err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+	if err := checkStatus(resp, registry, http.StatusCreated); err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+
+	return nil
+}
+
+// writeSession sends write requests for one repo, requesting push-scoped
+// auth on the first 401 and reusing it for the rest of the session.
+type writeSession struct {
+	client   *Client
+	registry string
+	repo     string
+	auth     string
+}
+
+func (w *writeSession) do(ctx context.Context, method, u, contentType string, body []byte) (*http.Response, error) {
+	return w.doHeader(ctx, method, u, contentType, nil, body)
+}
+
+func (w *writeSession) doHeader(ctx context.Context, method, u, contentType string, header http.Header, body []byte) (*http.Response, error) {
+	resp, err := w.send(ctx, method, u, contentType, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && w.auth == "" && w.client.auth != nil {
+		drainBody(resp.Body)
+
+		auth, err := w.pushAuth(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get auth: %w", err)
+		}
+		if auth == "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnauthorized, w.registry)
+		}
+		w.auth = auth
+
+		return w.send(ctx, method, u, contentType, header, body)
+	}
+
+	return resp, nil
+}
+
+func (w *writeSession) send(ctx context.Context, method, u, contentType string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", w.client.userAgent)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if w.auth != "" {
+		req.Header.Set("Authorization", w.auth)
+	}
+
+	return w.client.config.HTTPClient().Do(req)
+}
+
+func (w *writeSession) pushAuth(ctx context.Context) (string, error) {
+	if p, ok := w.client.auth.(PushAuthProvider); ok {
+		return p.GetPushAuth(ctx, w.registry, w.repo)
+	}
+	return w.client.auth.GetAuth(ctx, w.registry, w.repo)
+}
+
+// uploadLocation checks for 202 Accepted and resolves the Location header
+// against the request URL, since registries may return relative paths.
+func uploadLocation(resp *http.Response, registry string) (string, error) {
+	if err := checkStatus(resp, registry, http.StatusAccepted); err != nil {
+		return "", err
+	}
+
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return "", ErrNoUploadLocation
+	}
+
+	u, err := resp.Request.URL.Parse(loc)
+	if err != nil {
+		return "", fmt.Errorf("parse location: %w", err)
+	}
+	return u.String(), nil
+}
+
+func checkStatus(resp *http.Response, registry string, want int) error {
+	defer drainBody(resp.Body)
+
+	if resp.StatusCode == want {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %s", ErrUnauthorized, registry)
+	}
+	return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,233 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pushRegistry implements the distribution upload API behind token auth
+// that only accepts push-scoped tokens.
+type pushRegistry struct {
+	server *httptest.Server
+	host   string
+
+	mu        sync.Mutex
+	nextID    int
+	uploads   map[string][]byte
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	patches   int
+	scopes    []string
+}
+
+func newPushRegistry(t *testing.T) *pushRegistry {
+	t.Helper()
+
+	// keep real credential files out of the auth flow
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	reg := &pushRegistry{
+		uploads:   make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serveHTTP))
+	t.Cleanup(reg.server.Close)
+	reg.host = strings.TrimPrefix(reg.server.URL, "http://")
+
+	return reg
+}
+
+func (reg *pushRegistry) client() *Client {
+	auth := NewRegistryAuth()
+	auth.SetInsecure(reg.host, true)
+
+	c := NewClient()
+	c.SetInsecure(reg.host, true)
+	c.SetAuth(auth)
+	return c
+}
+
+func (reg *pushRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		scope := r.URL.Query().Get("scope")
+		reg.scopes = append(reg.scopes, scope)
+		token := "pull-token"
+		if strings.HasSuffix(scope, ":pull,push") {
+			token = "push-token"
+		}
+		fmt.Fprintf(w, `{"token":%q}`, token)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer push-token" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := r.URL.Path
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/blobs/uploads/"):
+		reg.nextID++
+		id := strconv.Itoa(reg.nextID)
+		reg.uploads[id] = nil
+		w.Header().Set("Location", path+id)
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == http.MethodPatch && strings.Contains(path, "/blobs/uploads/"):
+		id := path[strings.LastIndex(path, "/")+1:]
+		start, _, _ := strings.Cut(r.Header.Get("Content-Range"), "-")
+		if start != strconv.Itoa(len(reg.uploads[id])) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		reg.uploads[id] = append(reg.uploads[id], body...)
+		reg.patches++
+		w.Header().Set("Location", path)
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == http.MethodPut && strings.Contains(path, "/blobs/uploads/"):
+		id := path[strings.LastIndex(path, "/")+1:]
+		data := append(reg.uploads[id], body...)
+		digest := r.URL.Query().Get("digest")
+		if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != digest {
+			http.Error(w, "DIGEST_INVALID", http.StatusBadRequest)
+			return
+		}
+		delete(reg.uploads, id)
+		reg.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodHead && strings.Contains(path, "/blobs/"):
+		if _, ok := reg.blobs[path[strings.LastIndex(path, "/")+1:]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPut && strings.Contains(path, "/manifests/"):
+		ref := path[strings.LastIndex(path, "/")+1:]
+		reg.manifests[ref] = body
+		reg.types[ref] = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPushBlob(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		chunkSize   int
+		wantPatches int
+	}{
+		{"monolithic", 100, 1024, 0},
+		{"exact chunk is monolithic", 1024, 1024, 0},
+		{"chunked", 2500, 1024, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			reg := newPushRegistry(t)
+			c := reg.client()
+			c.SetUploadChunkSize(tt.chunkSize)
+
+			data := bytes.Repeat([]byte("p"), tt.size)
+			digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+			exists, err := c.BlobExists(context.Background(), reg.host, "test/repo", digest)
+			require.NoError(err)
+			require.False(exists)
+
+			err = c.PushBlob(context.Background(), reg.host, "test/repo", digest, int64(len(data)), bytes.NewReader(data))
+			require.NoError(err)
+			require.Equal(data, reg.blobs[digest])
+			require.Equal(tt.wantPatches, reg.patches)
+			require.Contains(reg.scopes, "repository:test/repo:pull,push")
+
+			exists, err = c.BlobExists(context.Background(), reg.host, "test/repo", digest)
+			require.NoError(err)
+			require.True(exists)
+		})
+	}
+}
+
+func TestPushBlobDigestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	reg := newPushRegistry(t)
+	c := reg.client()
+
+	data := []byte("layer data")
+	err := c.PushBlob(context.Background(), reg.host, "test/repo", "sha256:0000", int64(len(data)), bytes.NewReader(data))
+	require.Error(err)
+	require.Contains(err.Error(), "DIGEST_INVALID")
+	require.Empty(reg.blobs)
+}
+
+func TestPutManifest(t *testing.T) {
+	require := require.New(t)
+
+	reg := newPushRegistry(t)
+	c := reg.client()
+
+	body := []byte(`{"schemaVersion":2}`)
+	mediaType := "application/vnd.oci.image.manifest.v1+json"
+
+	require.NoError(c.PutManifest(context.Background(), reg.host, "test/repo", "v1", mediaType, body))
+	require.Equal(body, reg.manifests["v1"])
+	require.Equal(mediaType, reg.types["v1"])
+}
+
+func TestPushUnauthorizedWithoutAuth(t *testing.T) {
+	require := require.New(t)
+
+	reg := newPushRegistry(t)
+	c := NewClient()
+	c.SetInsecure(reg.host, true)
+
+	err := c.PutManifest(context.Background(), reg.host, "test/repo", "v1", "application/json", []byte("{}"))
+	require.True(errors.Is(err, ErrUnauthorized))
+}
+
+func TestPushErrorBodyBounded(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c := NewClient()
+	c.SetInsecure(host, true)
+
+	err := c.PutManifest(context.Background(), host, "test/repo", "v1", "application/json", []byte("{}"))
+	require.ErrorContains(err, "status 500")
+	require.Less(len(err.Error()), 2*maxErrorBody)
+}
@@ -0,0 +1,134 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hexfusion/fray/pkg/cel"
+)
+
+var ErrInvalidReference = errors.New("invalid reference")
+
+const (
+	DefaultTag       = "latest"
+	dockerHubLibrary = "library/"
+	localhost        = "localhost"
+)
+
+// registryAliases maps alternate hostnames to the registry fray talks to.
+// Other registries are used as written: ECR, MCR, GHCR and the rest serve
+// single-segment repositories without a default namespace, so only Docker
+// Hub gets library/ prepended.
+var registryAliases = map[string]string{
+	DockerHubAlias:            DockerHubRegistry,
+	"index.docker.io":         DockerHubRegistry,
+	"registry.hub.docker.com": DockerHubRegistry,
+}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses and normalizes an image reference.
+func ParseReference(image string) (Reference, error) {
+	return parseReference(image, nil)
+}
+
+// parseReference parses image, prefixing single-component repositories with
+// the namespace returned by defaultNamespace. Docker Hub defaults to library.
+func parseReference(image string, defaultNamespace func(registry string) string) (Reference, error) {
+	var r Reference
+
+	if image == "" {
+		return r, fmt.Errorf("%w: empty", ErrInvalidReference)
+	}
+
+	name := image
+	if idx := strings.Index(name, "@"); idx != -1 {
+		r.Digest = name[idx+1:]
+		name = name[:idx]
+		if err := cel.ValidateImageDigest(r.Digest); err != nil {
+			return Reference{}, fmt.Errorf("%w %q: %w", ErrInvalidReference, image, err)
+		}
+	}
+
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx:], "/") {
+		r.Tag = name[idx+1:]
+		name = name[:idx]
+		if err := cel.ValidateImageTag(r.Tag); err != nil {
+			return Reference{}, fmt.Errorf("%w %q: %w", ErrInvalidReference, image, err)
+		}
+	}
+
+	// hostnames are case-insensitive, repositories are not
+	first, rest, hasSlash := strings.Cut(name, "/")
+	if host := strings.ToLower(first); hasSlash && isRegistryHost(host) {
+		r.Registry = host
+		r.Repository = rest
+	} else {
+		r.Registry = DockerHubRegistry
+		r.Repository = name
+	}
+
+	if canonical, ok := registryAliases[r.Registry]; ok {
+		r.Registry = canonical
+	}
+
+	if !strings.Contains(r.Repository, "/") {
+		namespace := ""
+		if r.Registry == DockerHubRegistry {
+			namespace = dockerHubLibrary
+		}
+		if defaultNamespace != nil {
+			if ns := defaultNamespace(r.Registry); ns != "" {
+				namespace = ns + "/"
+			}
+		}
+		r.Repository = namespace + r.Repository
+	}
+
+	if err := cel.ValidateImageRepository(r.Repository); err != nil {
+		return Reference{}, fmt.Errorf("%w %q: %w", ErrInvalidReference, image, err)
+	}
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DefaultTag
+	}
+
+	return r, nil
+}
+
+// isRegistryHost reports whether the first path segment names a registry.
+func isRegistryHost(s string) bool {
+	return s == localhost || strings.ContainsAny(s, ".:")
+}
+
+// Ref returns the digest if set, otherwise the tag.
+func (r Reference) Ref() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// String returns the fully-qualified normalized reference.
+func (r Reference) String() string {
+	var b strings.Builder
+	b.WriteString(r.Registry)
+	b.WriteByte('/')
+	b.WriteString(r.Repository)
+	if r.Tag != "" {
+		b.WriteByte(':')
+		b.WriteString(r.Tag)
+	}
+	if r.Digest != "" {
+		b.WriteByte('@')
+		b.WriteString(r.Digest)
+	}
+	return b.String()
+}
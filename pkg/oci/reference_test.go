@@ -0,0 +1,249 @@
+package oci
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/cel"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		want       Reference
+		wantString string
+	}{
+		{
+			name:       "bare localhost",
+			image:      "localhost/foo",
+			want:       Reference{Registry: "localhost", Repository: "foo", Tag: "latest"},
+			wantString: "localhost/foo:latest",
+		},
+		{
+			name:       "localhost with port",
+			image:      "localhost:5000/foo/bar:v1",
+			want:       Reference{Registry: "localhost:5000", Repository: "foo/bar", Tag: "v1"},
+			wantString: "localhost:5000/foo/bar:v1",
+		},
+		{
+			name:       "docker.io single segment gets library",
+			image:      "docker.io/nginx",
+			want:       Reference{Registry: DockerHubRegistry, Repository: "library/nginx", Tag: "latest"},
+			wantString: DockerHubRegistry + "/library/nginx:latest",
+		},
+		{
+			name:       "docker hub namespaced has no library",
+			image:      "myuser/myapp:v1",
+			want:       Reference{Registry: DockerHubRegistry, Repository: "myuser/myapp", Tag: "v1"},
+			wantString: DockerHubRegistry + "/myuser/myapp:v1",
+		},
+		{
+			name:       "non docker hub single segment has no library",
+			image:      "quay.io/busybox",
+			want:       Reference{Registry: "quay.io", Repository: "busybox", Tag: "latest"},
+			wantString: "quay.io/busybox:latest",
+		},
+		{
+			name:       "index.docker.io is docker hub",
+			image:      "index.docker.io/nginx:1.27",
+			want:       Reference{Registry: DockerHubRegistry, Repository: "library/nginx", Tag: "1.27"},
+			wantString: DockerHubRegistry + "/library/nginx:1.27",
+		},
+		{
+			name:       "registry.hub.docker.com is docker hub",
+			image:      "registry.hub.docker.com/myuser/myapp",
+			want:       Reference{Registry: DockerHubRegistry, Repository: "myuser/myapp", Tag: "latest"},
+			wantString: DockerHubRegistry + "/myuser/myapp:latest",
+		},
+		{
+			name:       "registry host is case-insensitive",
+			image:      "GHCR.io/owner/repo:v1",
+			want:       Reference{Registry: "ghcr.io", Repository: "owner/repo", Tag: "v1"},
+			wantString: "ghcr.io/owner/repo:v1",
+		},
+		{
+			name:       "ghcr owner and repo",
+			image:      "ghcr.io/owner/repo",
+			want:       Reference{Registry: "ghcr.io", Repository: "owner/repo", Tag: "latest"},
+			wantString: "ghcr.io/owner/repo:latest",
+		},
+		{
+			name:       "mcr namespaced",
+			image:      "mcr.microsoft.com/dotnet/sdk:8.0",
+			want:       Reference{Registry: "mcr.microsoft.com", Repository: "dotnet/sdk", Tag: "8.0"},
+			wantString: "mcr.microsoft.com/dotnet/sdk:8.0",
+		},
+		{
+			name:       "mcr single segment has no library",
+			image:      "mcr.microsoft.com/powershell",
+			want:       Reference{Registry: "mcr.microsoft.com", Repository: "powershell", Tag: "latest"},
+			wantString: "mcr.microsoft.com/powershell:latest",
+		},
+		{
+			name:       "ecr private single segment",
+			image:      "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v2",
+			want:       Reference{Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Repository: "app", Tag: "v2"},
+			wantString: "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v2",
+		},
+		{
+			name:       "ecr public nested",
+			image:      "public.ecr.aws/docker/library/nginx",
+			want:       Reference{Registry: "public.ecr.aws", Repository: "docker/library/nginx", Tag: "latest"},
+			wantString: "public.ecr.aws/docker/library/nginx:latest",
+		},
+		{
+			name:       "host with port and single segment",
+			image:      "registry.local:5000/app@sha256:abc123",
+			want:       Reference{Registry: "registry.local:5000", Repository: "app", Digest: "sha256:abc123"},
+			wantString: "registry.local:5000/app@sha256:abc123",
+		},
+		{
+			name:       "tag and digest",
+			image:      "quay.io/foo/bar:v1@sha256:abc123",
+			want:       Reference{Registry: "quay.io", Repository: "foo/bar", Tag: "v1", Digest: "sha256:abc123"},
+			wantString: "quay.io/foo/bar:v1@sha256:abc123",
+		},
+		{
+			name:       "digest only",
+			image:      "nginx@sha256:abc123",
+			want:       Reference{Registry: DockerHubRegistry, Repository: "library/nginx", Digest: "sha256:abc123"},
+			wantString: DockerHubRegistry + "/library/nginx@sha256:abc123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			ref, err := ParseReference(tt.image)
+			require.NoError(err)
+			require.Equal(tt.want, ref)
+			require.Equal(tt.wantString, ref.String())
+		})
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+	}{
+		{"empty", ""},
+		{"uppercase repo", "quay.io/Foo/bar"},
+		{"empty tag", "nginx:"},
+		{"empty digest", "nginx@"},
+		{"digest without algorithm", "nginx@abc123"},
+		{"missing repository", "quay.io/"},
+		{"double slash", "quay.io/foo//bar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			_, err := ParseReference(tt.image)
+			require.True(errors.Is(err, ErrInvalidReference))
+		})
+	}
+}
+
+func TestReferenceRef(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal("v1", Reference{Tag: "v1"}.Ref())
+	require.Equal("sha256:abc", Reference{Tag: "v1", Digest: "sha256:abc"}.Ref())
+}
+
+func TestParseReferenceDescriptiveError(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		wantText string
+	}{
+		{"double colon", "FOO::bar", "repository"},
+		{"bad tag", "quay.io/foo/bar:-bad", "image_tag"},
+		{"bad digest", "quay.io/foo/bar@sha256:XYZ", "image_digest"},
+		{"uppercase repo", "quay.io/Foo/bar:v1", `invalid path component "Foo"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			_, err := ParseReference(tt.image)
+			require.True(errors.Is(err, ErrInvalidReference))
+
+			var fe *cel.FormatError
+			require.True(errors.As(err, &fe))
+			require.Contains(err.Error(), tt.wantText)
+		})
+	}
+}
+
+func TestRegistryConfigParseReference(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces map[string]string
+		image      string
+		want       string
+	}{
+		{
+			name:  "unconfigured registry keeps single component",
+			image: "myharbor.io/nginx",
+			want:  "myharbor.io/nginx:latest",
+		},
+		{
+			name:       "configured namespace is prepended",
+			namespaces: map[string]string{"myharbor.io": "library"},
+			image:      "myharbor.io/nginx:1.25",
+			want:       "myharbor.io/library/nginx:1.25",
+		},
+		{
+			name:       "namespaced repository is unchanged",
+			namespaces: map[string]string{"myharbor.io": "library"},
+			image:      "myharbor.io/team/nginx",
+			want:       "myharbor.io/team/nginx:latest",
+		},
+		{
+			name:       "other registries are unaffected",
+			namespaces: map[string]string{"myharbor.io": "library"},
+			image:      "quay.io/busybox",
+			want:       "quay.io/busybox:latest",
+		},
+		{
+			name:  "docker hub defaults to library",
+			image: "nginx",
+			want:  DockerHubRegistry + "/library/nginx:latest",
+		},
+		{
+			name:       "docker hub default can be overridden",
+			namespaces: map[string]string{DockerHubRegistry: "mirrored/"},
+			image:      "docker.io/nginx",
+			want:       DockerHubRegistry + "/mirrored/nginx:latest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			cfg := NewRegistryConfig()
+			for registry, namespace := range tt.namespaces {
+				cfg.SetDefaultNamespace(registry, namespace)
+			}
+
+			r, err := cfg.ParseReference(tt.image)
+			require.NoError(err)
+			require.Equal(tt.want, r.String())
+
+			plain, err := ParseReference(tt.image)
+			require.NoError(err)
+			if len(tt.namespaces) == 0 {
+				require.Equal(plain, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+const (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = time.Second
+	DefaultRetryMaxDelay  = 30 * time.Second
+	DefaultRetryJitter    = JitterEqual
+)
+
+// Jitter randomizes retry delays so parallel chunk workers that fail
+// together don't retry in lockstep.
+type Jitter string
+
+const (
+	// JitterNone retries after exactly the backoff delay.
+	JitterNone Jitter = ""
+	// JitterFull picks a delay between zero and the backoff delay.
+	JitterFull Jitter = "full"
+	// JitterEqual keeps half the backoff delay and randomizes the rest.
+	JitterEqual Jitter = "equal"
+)
+
+// RetryPolicy controls exponential backoff for transfer retries.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled each attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter randomizes each delay below the capped backoff.
+	Jitter Jitter
+}
+
+// DefaultRetryPolicy returns the default retry policy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultRetryBaseDelay,
+		MaxDelay:   DefaultRetryMaxDelay,
+		Jitter:     DefaultRetryJitter,
+	}
+}
+
+// Validate reports whether the policy values are usable.
+func (p RetryPolicy) Validate() error {
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("%w: retries must be >= 0", ErrInvalidRetryPolicy)
+	}
+	if p.BaseDelay < 0 {
+		return fmt.Errorf("%w: base delay must be >= 0", ErrInvalidRetryPolicy)
+	}
+	if p.MaxDelay < 0 {
+		return fmt.Errorf("%w: max delay must be >= 0", ErrInvalidRetryPolicy)
+	}
+	switch p.Jitter {
+	case JitterNone, JitterFull, JitterEqual:
+	default:
+		return fmt.Errorf("%w: unknown jitter %q", ErrInvalidRetryPolicy, p.Jitter)
+	}
+	return nil
+}
+
+// Delay returns the backoff before the given retry attempt (1-based):
+// BaseDelay * 2^(attempt-1), capped at MaxDelay, then randomized by Jitter.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.backoff(attempt)
+	if delay <= 0 {
+		return delay
+	}
+
+	switch p.Jitter {
+	case JitterFull:
+		return time.Duration(rand.Int64N(int64(delay) + 1))
+	case JitterEqual:
+		half := delay / 2
+		return delay - half + time.Duration(rand.Int64N(int64(half)+1))
+	}
+	return delay
+}
+
+// backoff returns the capped exponential delay before attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if attempt < 1 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		if delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,70 @@
+package oci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{60, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, policy.Delay(tt.attempt))
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   Jitter
+		minShare float64
+	}{
+		{"full", JitterFull, 0},
+		{"equal", JitterEqual, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			policy := RetryPolicy{MaxRetries: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: tt.jitter}
+			for attempt := 1; attempt <= 4; attempt++ {
+				backoff := policy.backoff(attempt)
+				seen := make(map[time.Duration]bool)
+				for range 100 {
+					delay := policy.Delay(attempt)
+					require.GreaterOrEqual(delay, time.Duration(float64(backoff)*tt.minShare))
+					require.LessOrEqual(delay, backoff)
+					seen[delay] = true
+				}
+				// workers failing together must not retry together
+				require.Greater(len(seen), 1, "attempt %d", attempt)
+			}
+		})
+	}
+}
+
+func TestFetcherRetryPolicy(t *testing.T) {
+	require := require.New(t)
+
+	f := NewFetcher()
+	require.Equal(DefaultRetryPolicy(), f.RetryPolicy())
+
+	p := RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+	f.SetRetryPolicy(p)
+	require.Equal(p, f.RetryPolicy())
+}
@@ -0,0 +1,47 @@
+package oci
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultMaxIdleConnsPerHost is the idle connections kept per registry when
+// TransportOptions.MaxIdleConnsPerHost is zero. http.DefaultTransport keeps
+// two, so parallel chunk fetches past that open a new connection, and
+// repeat the TLS handshake, for most requests.
+const DefaultMaxIdleConnsPerHost = 16
+
+// TransportOptions tunes the connection pool of a transport shared by
+// parallel fetches.
+type TransportOptions struct {
+	// MaxIdleConnsPerHost should be at least the number of parallel fetches
+	// to one host. Zero uses DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes connections idle for this long. Zero uses
+	// http.DefaultTransport's timeout.
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool
+}
+
+// NewTransport returns a clone of http.DefaultTransport tuned by opts. Share
+// one between fetchers and clients that reach the same hosts so they draw
+// on one connection pool.
+func NewTransport(opts TransportOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	opts.apply(t)
+	return t
+}
+
+func (o TransportOptions) apply(t *http.Transport) {
+	perHost := o.MaxIdleConnsPerHost
+	if perHost <= 0 {
+		perHost = DefaultMaxIdleConnsPerHost
+	}
+	t.MaxIdleConnsPerHost = perHost
+	t.MaxIdleConns = max(t.MaxIdleConns, perHost)
+	if o.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	t.DisableKeepAlives = o.DisableKeepAlives
+}
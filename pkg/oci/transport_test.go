@@ -0,0 +1,51 @@
+package oci
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransport(t *testing.T) {
+	defaultIdle := http.DefaultTransport.(*http.Transport).IdleConnTimeout
+
+	tests := []struct {
+		name        string
+		opts        TransportOptions
+		wantPerHost int
+		wantIdle    time.Duration
+		wantNoKeep  bool
+	}{
+		{"defaults", TransportOptions{}, DefaultMaxIdleConnsPerHost, defaultIdle, false},
+		{"sized to parallelism", TransportOptions{MaxIdleConnsPerHost: 64}, 64, defaultIdle, false},
+		{"idle timeout", TransportOptions{IdleConnTimeout: time.Second}, DefaultMaxIdleConnsPerHost, time.Second, false},
+		{"keep-alives disabled", TransportOptions{DisableKeepAlives: true}, DefaultMaxIdleConnsPerHost, defaultIdle, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			tr := NewTransport(tt.opts)
+			require.Equal(tt.wantPerHost, tr.MaxIdleConnsPerHost)
+			require.GreaterOrEqual(tr.MaxIdleConns, tr.MaxIdleConnsPerHost)
+			require.Equal(tt.wantIdle, tr.IdleConnTimeout)
+			require.Equal(tt.wantNoKeep, tr.DisableKeepAlives)
+		})
+	}
+}
+
+func TestRegistryConfigTransportOptions(t *testing.T) {
+	require := require.New(t)
+
+	c := NewRegistryConfig()
+	require.Same(http.DefaultClient, c.HTTPClient())
+
+	c.SetTransportOptions(TransportOptions{MaxIdleConnsPerHost: 32})
+	tr, ok := c.HTTPClient().Transport.(*http.Transport)
+	require.True(ok)
+	require.Equal(32, tr.MaxIdleConnsPerHost)
+	require.Same(c.HTTPClient(), c.HTTPClient())
+}
@@ -0,0 +1,73 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// ErrDigestMismatch is returned when content does not hash to its digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// verifyReader hashes the bytes read through it and checks them against a
+// digest at EOF or on Close.
+type verifyReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want digest.Digest
+	// err is the verification result once it has been checked.
+	err     error
+	checked bool
+}
+
+// NewVerifyReader returns a reader over r that fails with ErrDigestMismatch
+// from the Read that reaches EOF, or from Close before EOF, when the bytes
+// read don't match d. Closing it closes r if r is an io.Closer.
+func NewVerifyReader(r io.Reader, d string) (io.ReadCloser, error) {
+	want, err := digest.Parse(d)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyReader{r: r, h: want.Algorithm().New(), want: want}, nil
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	if v.checked {
+		if v.err != nil {
+			return 0, v.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err //nolint:wrapcheck // io.EOF must pass through unwrapped
+}
+
+// Close verifies the bytes read so far if EOF was not reached, so a reader
+// abandoned early reports truncated content.
+func (v *verifyReader) Close() error {
+	err := v.verify()
+	if c, ok := v.r.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+func (v *verifyReader) verify() error {
+	if !v.checked {
+		v.checked = true
+		if got := v.want.Algorithm().FromHash(v.h); got != v.want {
+			v.err = fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, v.want, got)
+		}
+	}
+	return v.err
+}
@@ -0,0 +1,89 @@
+package oci
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestVerifyReader(t *testing.T) {
+	content := bytes.Repeat([]byte("verify me "), 1000)
+	corrupted := bytes.Clone(content)
+	corrupted[500] ^= 0xff
+
+	tests := []struct {
+		name    string
+		digest  string
+		data    []byte
+		wantErr error
+	}{
+		{"correct bytes", digest.FromBytes(content).String(), content, nil},
+		{"correct sha512", digest.SHA512.FromBytes(content).String(), content, nil},
+		{"truncated bytes", digest.FromBytes(content).String(), content[:len(content)-1], ErrDigestMismatch},
+		{"corrupted bytes", digest.FromBytes(content).String(), corrupted, ErrDigestMismatch},
+		{"empty content", digest.FromBytes(nil).String(), []byte{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			src := &closeRecorder{Reader: bytes.NewReader(tt.data)}
+			r, err := NewVerifyReader(src, tt.digest)
+			require.NoError(err)
+
+			got, err := io.ReadAll(r)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+				require.ErrorIs(r.Close(), tt.wantErr)
+			} else {
+				require.NoError(err)
+				require.Equal(tt.data, got)
+				require.NoError(r.Close())
+			}
+			require.True(src.closed)
+
+			// the result sticks after verification
+			n, err := r.Read(make([]byte, 1))
+			require.Zero(n)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+			} else {
+				require.ErrorIs(err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestVerifyReaderCloseBeforeEOF(t *testing.T) {
+	require := require.New(t)
+
+	content := []byte("partially read content")
+	r, err := NewVerifyReader(bytes.NewReader(content), digest.FromBytes(content).String())
+	require.NoError(err)
+
+	_, err = io.ReadFull(r, make([]byte, 5))
+	require.NoError(err)
+	require.ErrorIs(r.Close(), ErrDigestMismatch)
+}
+
+func TestVerifyReaderInvalidDigest(t *testing.T) {
+	require := require.New(t)
+
+	_, err := NewVerifyReader(bytes.NewReader(nil), "md5:abc")
+	require.ErrorIs(err, digest.ErrInvalid)
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+const (
+	adminPullPath  = "/admin/pull"
+	adminStatsPath = "/admin/stats"
+	adminGCPath    = "/admin/gc"
+	adminEvictPath = "/admin/evict"
+
+	// adminGCGrace keeps blobs and images touched this recently, so a pull
+	// in flight isn't collected before its manifest is indexed.
+	adminGCGrace = 10 * time.Minute
+
+	// maxAdminBody bounds admin request bodies.
+	maxAdminBody = 64 * 1024
+)
+
+// adminPullRequest is the body of POST /admin/pull.
+type adminPullRequest struct {
+	Image    string `json:"image"`
+	Platform string `json:"platform,omitempty"`
+}
+
+// adminPullResponse reports a completed warm pull.
+type adminPullResponse struct {
+	Image string `json:"image"`
+	*store.PullResult
+}
+
+// adminStatsResponse is the body of GET /admin/stats.
+type adminStatsResponse struct {
+	store.Stats
+	Images []adminImage `json:"images"`
+}
+
+type adminImage struct {
+	Ref        string    `json:"ref,omitempty"`
+	Digest     string    `json:"digest"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// handleAdminPull pulls an image into the cache and returns the PullResult,
+// sharing the pull with any manifest request for the same image.
+func (s *Server) handleAdminPull(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r, http.MethodPost) {
+		return
+	}
+	if s.opts.ReadOnly {
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "proxy is read-only"})
+		return
+	}
+
+	var req adminPullRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	ref, err := s.client.Config().ParseReference(req.Image)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	if req.Platform != "" {
+		if err := oci.ValidatePlatform(req.Platform); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+	}
+
+	image := ref.String()
+	s.log.Info("admin pull", zap.String("image", image), zap.String("platform", req.Platform))
+
+	result, err := s.pullImage(r.Context(), image, req.Platform)
+	if err != nil {
+		s.log.Error("admin pull failed", zap.String("image", image), zap.Error(err))
+		writeJSON(w, http.StatusBadGateway, adminError{Error: fmt.Sprintf("upstream pull failed: %v", err)})
+		return
+	}
+	if req.Platform == "" {
+		s.markValidated(image)
+	}
+
+	writeJSON(w, http.StatusOK, adminPullResponse{Image: image, PullResult: result})
+}
+
+// handleAdminStats reports layout statistics and every cached image with
+// when it was last read.
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r, http.MethodGet) {
+		return
+	}
+
+	stats, err := s.layout.GetStats()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	images, err := s.layout.Images()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+
+	resp := adminStatsResponse{Stats: stats, Images: make([]adminImage, 0, len(images))}
+	for _, img := range images {
+		resp.Images = append(resp.Images, adminImage{
+			Ref:        img.Ref,
+			Digest:     img.Digest,
+			Size:       img.Size,
+			LastAccess: img.LastAccess,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleAdminGC removes blobs no cached image references.
+func (s *Server) handleAdminGC(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r, http.MethodPost) {
+		return
+	}
+
+	result, err := s.layout.GC(adminGCGrace)
+	if err != nil {
+		s.log.Error("admin gc failed", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	s.log.Info("admin gc", zap.Int("blobs", result.Blobs), zap.Int64("bytes", result.Bytes))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAdminEvict evicts least recently used images until the cache fits
+// in the max query parameter, e.g. ?max=2G.
+func (s *Server) handleAdminEvict(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r, http.MethodPost) {
+		return
+	}
+
+	maxBytes, err := parseSize(r.URL.Query().Get("max"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid max: %v", err)})
+		return
+	}
+
+	result, err := s.layout.Evict(maxBytes, adminGCGrace)
+	if err != nil {
+		s.log.Error("admin evict failed", zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	s.log.Info("admin evict",
+		zap.Int64("max", maxBytes),
+		zap.Int("images", result.Images),
+		zap.Int64("bytes", result.Bytes))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// adminAllowed checks the method and token, writing the error response if
+// either is wrong.
+func (s *Server) adminAllowed(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+		return false
+	}
+	if !s.adminAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="fray-admin"`)
+		writeJSON(w, http.StatusUnauthorized, adminError{Error: "unauthorized"})
+		return false
+	}
+	return true
+}
+
+// adminAuthorized checks the bearer token when Options.AdminToken is set.
+func (s *Server) adminAuthorized(r *http.Request) bool {
+	if s.opts.AdminToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// parseSize parses a byte count with an optional binary K, M, G or T suffix.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("size required")
+	}
+
+	shift := 0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	case "T":
+		shift = 40
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,304 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+// newUpstream serves img as test/repo:v1 and returns its host.
+func newUpstream(t *testing.T, img testImage) string {
+	t.Helper()
+
+	blobs := map[string][]byte{
+		sha256Digest(img.config): img.config,
+		sha256Digest(img.layer):  img.layer,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/test/repo/manifests/v1":
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			_, _ = w.Write(img.manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/test/repo/blobs/"):
+			data, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/test/repo/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func newAdminServer(t *testing.T, host string, opts Options) (*store.Layout, *Server) {
+	t.Helper()
+
+	l, err := store.Open(t.TempDir())
+	require.NoError(t, err)
+
+	client := oci.NewClient()
+	client.SetInsecure(host, true)
+	client.SetRetryPolicy(oci.RetryPolicy{})
+	opts.Retry = &oci.RetryPolicy{BaseDelay: time.Millisecond}
+
+	return l, New(l, client, logging.Nop(), opts)
+}
+
+func TestAdminPull(t *testing.T) {
+	require := require.New(t)
+
+	img := newTestImage(t)
+	host := newUpstream(t, img)
+	l, s := newAdminServer(t, host, Options{})
+
+	body := `{"image":"` + host + `/test/repo:v1"}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/pull", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, req)
+
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+	require.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var got adminPullResponse
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+	require.Equal(host+"/test/repo:v1", got.Image)
+	require.Equal(sha256Digest(img.manifest), got.Digest)
+	require.Equal(1, got.Layers)
+	require.Equal(int64(len(img.layer)), got.TotalSize)
+	require.True(l.HasBlob(sha256Digest(img.layer)))
+
+	// the warmed image is served from cache
+	req = httptest.NewRequest(http.MethodGet, "/v2/"+host+"/test/repo/manifests/v1", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal(sha256Digest(img.manifest), w.Header().Get("Docker-Content-Digest"))
+}
+
+func TestAdminPullErrors(t *testing.T) {
+	host := newUpstream(t, newTestImage(t))
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		token      string
+		header     string
+		wantStatus int
+		wantError  string
+	}{
+		{"bad image", http.MethodPost, `{"image":"quay.io/Bad/Repo:v1"}`, "", "", http.StatusBadRequest, "invalid reference"},
+		{"malformed body", http.MethodPost, `{"image":`, "", "", http.StatusBadRequest, "invalid request"},
+		{"bad platform", http.MethodPost, `{"image":"` + host + `/test/repo:v1","platform":"linux"}`, "", "", http.StatusBadRequest, "invalid platform"},
+		{"unknown image", http.MethodPost, `{"image":"` + host + `/test/repo:missing"}`, "", "", http.StatusBadGateway, "upstream pull failed"},
+		{"wrong method", http.MethodGet, "", "", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"missing token", http.MethodPost, `{"image":"` + host + `/test/repo:v1"}`, "secret", "", http.StatusUnauthorized, "unauthorized"},
+		{"wrong token", http.MethodPost, `{"image":"` + host + `/test/repo:v1"}`, "secret", "Bearer guess", http.StatusUnauthorized, "unauthorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			_, s := newAdminServer(t, host, Options{AdminToken: tt.token})
+
+			req := httptest.NewRequest(tt.method, "/admin/pull", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			var got adminError
+			require.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+			require.Contains(got.Error, tt.wantError)
+		})
+	}
+}
+
+func TestAdminPullToken(t *testing.T) {
+	require := require.New(t)
+
+	host := newUpstream(t, newTestImage(t))
+	_, s := newAdminServer(t, host, Options{AdminToken: "secret"})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/pull", strings.NewReader(`{"image":"`+host+`/test/repo:v1"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, req)
+
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+}
+
+// ageBlobs dates every blob in l as last used an hour ago, outside the
+// admin GC grace period.
+func ageBlobs(t *testing.T, l *store.Layout) {
+	t.Helper()
+
+	old := time.Now().Add(-time.Hour)
+	dir := filepath.Join(l.Root(), store.BlobsDir, "sha256")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, e.Name()), old, old))
+	}
+}
+
+func adminPull(t *testing.T, s *Server, image string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/pull", strings.NewReader(`{"image":"`+image+`"}`))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestAdminStats(t *testing.T) {
+	require := require.New(t)
+
+	img := newTestImage(t)
+	host := newUpstream(t, img)
+	_, s := newAdminServer(t, host, Options{})
+	adminPull(t, s, host+"/test/repo:v1")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+	var got adminStatsResponse
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+	require.Equal(3, got.BlobCount)
+	require.Equal(int64(len(img.manifest)+len(img.config)+len(img.layer)), got.TotalSize)
+	require.Len(got.Images, 1)
+	require.Equal(host+"/test/repo:v1", got.Images[0].Ref)
+	require.Equal(sha256Digest(img.manifest), got.Images[0].Digest)
+	require.WithinDuration(time.Now(), got.Images[0].LastAccess, time.Minute)
+}
+
+func TestAdminEvict(t *testing.T) {
+	require := require.New(t)
+
+	img := newTestImage(t)
+	host := newUpstream(t, img)
+	l, s := newAdminServer(t, host, Options{})
+	adminPull(t, s, host+"/test/repo:v1")
+
+	// nothing is unreferenced yet
+	req := httptest.NewRequest(http.MethodPost, "/admin/gc", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+	var gc store.GCResult
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &gc))
+	require.Equal(store.GCResult{}, gc)
+
+	ageBlobs(t, l)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/evict?max=0", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	var got store.GCResult
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+	require.Equal(store.GCResult{
+		Images: 1,
+		Blobs:  3,
+		Bytes:  int64(len(img.manifest) + len(img.config) + len(img.layer)),
+	}, got)
+	require.False(l.HasBlob(sha256Digest(img.layer)))
+}
+
+func TestAdminMaintenanceErrors(t *testing.T) {
+	host := newUpstream(t, newTestImage(t))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		token      string
+		wantStatus int
+		wantError  string
+	}{
+		{"stats wrong method", http.MethodPost, "/admin/stats", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"stats missing token", http.MethodGet, "/admin/stats", "secret", http.StatusUnauthorized, "unauthorized"},
+		{"gc wrong method", http.MethodGet, "/admin/gc", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"gc missing token", http.MethodPost, "/admin/gc", "secret", http.StatusUnauthorized, "unauthorized"},
+		{"evict missing token", http.MethodPost, "/admin/evict?max=2G", "secret", http.StatusUnauthorized, "unauthorized"},
+		{"evict missing max", http.MethodPost, "/admin/evict", "", http.StatusBadRequest, "invalid max"},
+		{"evict bad max", http.MethodPost, "/admin/evict?max=lots", "", http.StatusBadRequest, "invalid max"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			_, s := newAdminServer(t, host, Options{AdminToken: tt.token})
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			var got adminError
+			require.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+			require.Contains(got.Error, tt.wantError)
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"512K", 512 << 10, false},
+		{"2G", 2 << 30, false},
+		{"1t", 1 << 40, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"-1M", 0, true},
+		{"1.5G", 0, true},
+		{"9999999999T", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			require := require.New(t)
+
+			got, err := parseSize(tt.in)
+			if tt.wantErr {
+				require.Error(err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// DefaultManifestCacheEntries is how many manifests the proxy keeps in
+	// memory when Options.ManifestCacheEntries is zero.
+	DefaultManifestCacheEntries = 256
+
+	// manifestCacheMaxBytes bounds the cached manifests' total size, so a
+	// few huge indexes can't take much memory.
+	manifestCacheMaxBytes = 16 * 1024 * 1024
+)
+
+// manifestCache is an LRU of manifest bytes and media types by digest.
+// Entries are content addressed, so they never go stale; a digest is only
+// looked up after the index resolves a reference to it.
+type manifestCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type manifestEntry struct {
+	digest    string
+	data      []byte
+	mediaType string
+}
+
+// newManifestCache returns a cache of at most maxEntries manifests. A
+// negative maxEntries disables it.
+func newManifestCache(maxEntries int) *manifestCache {
+	if maxEntries == 0 {
+		maxEntries = DefaultManifestCacheEntries
+	}
+	return &manifestCache{
+		maxEntries: maxEntries,
+		maxBytes:   manifestCacheMaxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *manifestCache) get(digest string) (*manifestEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[digest]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*manifestEntry), true
+}
+
+func (c *manifestCache) add(digest string, data []byte, mediaType string) {
+	if c.maxEntries < 0 || len(data) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[digest]; ok {
+		return
+	}
+	c.entries[digest] = c.order.PushFront(&manifestEntry{digest: digest, data: data, mediaType: mediaType})
+	c.bytes += len(data)
+
+	for c.order.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *manifestCache) remove(digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[digest]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *manifestCache) removeElement(e *list.Element) {
+	entry := c.order.Remove(e).(*manifestEntry)
+	delete(c.entries, entry.digest)
+	c.bytes -= len(entry.data)
+}
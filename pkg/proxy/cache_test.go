@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestCacheEviction(t *testing.T) {
+	require := require.New(t)
+
+	c := newManifestCache(2)
+	c.add("sha256:a", []byte("a"), "a")
+	c.add("sha256:b", []byte("b"), "b")
+
+	// reading a makes b the least recently used
+	_, ok := c.get("sha256:a")
+	require.True(ok)
+	c.add("sha256:c", []byte("c"), "c")
+
+	_, ok = c.get("sha256:b")
+	require.False(ok)
+	for _, d := range []string{"sha256:a", "sha256:c"} {
+		e, ok := c.get(d)
+		require.True(ok, d)
+		require.Equal(strings.TrimPrefix(d, "sha256:"), e.mediaType)
+	}
+
+	c.remove("sha256:a")
+	_, ok = c.get("sha256:a")
+	require.False(ok)
+	require.Equal(1, c.bytes)
+}
+
+func TestManifestCacheBytes(t *testing.T) {
+	require := require.New(t)
+
+	c := newManifestCache(0)
+	c.maxBytes = 10
+
+	c.add("sha256:big", []byte(strings.Repeat("x", 11)), "")
+	_, ok := c.get("sha256:big")
+	require.False(ok, "entries larger than the budget are not cached")
+
+	c.add("sha256:a", []byte(strings.Repeat("a", 6)), "")
+	c.add("sha256:b", []byte(strings.Repeat("b", 6)), "")
+	_, ok = c.get("sha256:a")
+	require.False(ok)
+	_, ok = c.get("sha256:b")
+	require.True(ok)
+	require.Equal(6, c.bytes)
+}
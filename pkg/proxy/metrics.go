@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+// CacheKind is the kind of content a cache lookup was for.
+type CacheKind string
+
+const (
+	CacheManifest CacheKind = "manifest"
+	CacheBlob     CacheKind = "blob"
+)
+
+// headerCache reports whether a response was served from the layout.
+const headerCache = "X-Cache"
+
+// Metrics receives proxy counters, including those of the pulls the proxy
+// runs. Implementations must be safe for concurrent use.
+type Metrics interface {
+	store.Metrics
+	// IncCacheHit counts a request served from the layout.
+	IncCacheHit(kind CacheKind)
+	// IncCacheMiss counts a request for content not in the layout.
+	IncCacheMiss(kind CacheKind)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AddBytesDownloaded(int64)          {}
+func (nopMetrics) AddBytesCached(int64)              {}
+func (nopMetrics) IncChunkRetries()                  {}
+func (nopMetrics) IncLayersDownloaded()              {}
+func (nopMetrics) ObservePullDuration(time.Duration) {}
+func (nopMetrics) IncCacheHit(CacheKind)             {}
+func (nopMetrics) IncCacheMiss(CacheKind)            {}
+
+// recordCache sets the X-Cache header and counts the hit or miss.
+func (s *Server) recordCache(w http.ResponseWriter, kind CacheKind, hit bool) {
+	if hit {
+		w.Header().Set(headerCache, "HIT")
+		s.opts.Metrics.IncCacheHit(kind)
+		return
+	}
+	w.Header().Set(headerCache, "MISS")
+	s.opts.Metrics.IncCacheMiss(kind)
+}
@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+var (
+	errUploadUnknown = errors.New("upload unknown")
+	errRangeInvalid  = errors.New("range invalid")
+)
+
+// pushAuthorized checks for Options.PushToken as a bearer token or, as
+// registry clients send after a login, a basic password. A proxy without a
+// push token authorizes nothing.
+func (s *Server) pushAuthorized(r *http.Request) bool {
+	if s.opts.PushToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.PushToken)) == 1
+}
+
+// maxManifestSize bounds manifest PUT bodies.
+const maxManifestSize = 4 * 1024 * 1024
+
+// handleUpload serves the blob upload endpoints: POST starts a session (or
+// completes a monolithic upload when ?digest= is set), PATCH appends a chunk
+// and PUT appends the final data and commits the blob.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, registry, repo, id string) {
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		if digest := r.URL.Query().Get("digest"); digest != "" {
+			s.commitBlob(w, r.Body, registry, repo, digest)
+			return
+		}
+		s.startUpload(w, registry, repo)
+	case r.Method == http.MethodPatch && id != "":
+		s.patchUpload(w, r, registry, repo, id)
+	case r.Method == http.MethodPut && id != "":
+		s.finishUpload(w, r, registry, repo, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) startUpload(w http.ResponseWriter, registry, repo string) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		http.Error(w, "create upload failed", http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b[:])
+
+	if err := os.MkdirAll(s.uploadDir, 0755); err != nil {
+		http.Error(w, "create upload failed", http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(s.uploadPath(id))
+	if err != nil {
+		http.Error(w, "create upload failed", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Location", uploadURL(registry, repo, id))
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, registry, repo, id string) {
+	size, err := s.appendUpload(id, r)
+	if err != nil {
+		s.uploadError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Location", uploadURL(registry, repo, id))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request, registry, repo, id string) {
+	if _, err := s.appendUpload(id, r); err != nil {
+		s.uploadError(w, id, err)
+		return
+	}
+
+	path := s.uploadPath(id)
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "open upload failed", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	s.commitBlob(w, f, registry, repo, r.URL.Query().Get("digest"))
+}
+
+// appendUpload appends the request body to an upload session, checking
+// Content-Range against the current size when the client sends one.
+func (s *Server) appendUpload(id string, r *http.Request) (int64, error) {
+	f, err := os.OpenFile(s.uploadPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, errUploadUnknown
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		start, _, _ := strings.Cut(cr, "-")
+		if n, err := strconv.ParseInt(start, 10, 64); err != nil || n != info.Size() {
+			return 0, fmt.Errorf("%w: %s at size %d", errRangeInvalid, cr, info.Size())
+		}
+	}
+
+	n, err := io.Copy(f, r.Body)
+	if err != nil {
+		return 0, fmt.Errorf("write upload: %w", err)
+	}
+
+	return info.Size() + n, nil
+}
+
+func (s *Server) uploadError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, errUploadUnknown):
+		http.Error(w, "blob upload unknown", http.StatusNotFound)
+	case errors.Is(err, errRangeInvalid):
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+	default:
+		s.log.Error("upload failed", zap.String("upload", id), zap.Error(err))
+		http.Error(w, "upload failed", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) commitBlob(w http.ResponseWriter, r io.Reader, registry, repo, digest string) {
+	if err := store.ValidateDigest(digest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.layout.WriteBlobVerified(digest, r); err != nil {
+		if errors.Is(err, store.ErrDigestMismatch) {
+			http.Error(w, fmt.Sprintf("digest invalid: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.log.Error("write blob failed", zap.String("digest", digest), zap.Error(err))
+		http.Error(w, "write blob failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/%s/blobs/%s", registry, repo, digest))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handlePutManifest stores a pushed manifest and indexes it under the
+// reference so later pulls are served locally.
+func (s *Server) handlePutManifest(w http.ResponseWriter, r *http.Request, registry, repo, ref string) {
+	image := fmt.Sprintf("%s/%s:%s", registry, repo, ref)
+	byDigest := strings.Contains(ref, ":")
+	if byDigest {
+		image = fmt.Sprintf("%s/%s@%s", registry, repo, ref)
+	}
+	if _, err := oci.ParseReference(image); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		http.Error(w, "read manifest failed", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxManifestSize {
+		http.Error(w, "manifest too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	manifestDigest := digest.FromBytes(body).String()
+	if byDigest {
+		// a digest reference is verified with its own algorithm
+		manifestDigest = digest.Digest(ref).Algorithm().FromBytes(body).String()
+		if ref != manifestDigest {
+			http.Error(w, fmt.Sprintf("digest invalid: expected %s, got %s", ref, manifestDigest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mediaType := r.Header.Get("Content-Type")
+	if mediaType == "" {
+		mediaType = detectMediaType(body)
+	}
+
+	var manifest oci.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		http.Error(w, fmt.Sprintf("manifest invalid: %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, blob := range manifestBlobs(mediaType, &manifest) {
+		if !s.layout.HasBlob(blob.Digest) {
+			http.Error(w, fmt.Sprintf("manifest blob unknown: %s", blob.Digest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := s.layout.WriteBlob(manifestDigest, bytes.NewReader(body)); err != nil {
+		s.log.Error("write manifest failed", zap.String("digest", manifestDigest), zap.Error(err))
+		http.Error(w, "write manifest failed", http.StatusInternalServerError)
+		return
+	}
+
+	desc := store.Descriptor{
+		MediaType: mediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(body)),
+	}
+	// a pulled entry spelling the tag differently is replaced, not shadowed
+	if err := s.layout.SetTag(image, desc); err != nil {
+		s.log.Error("add manifest failed", zap.String("image", image), zap.Error(err))
+		http.Error(w, "add manifest failed", http.StatusInternalServerError)
+		return
+	}
+
+	if s.opts.ForwardPushes {
+		if err := s.forward(r.Context(), registry, repo, ref, mediaType, body, &manifest); err != nil {
+			// the image is cached locally and can be synced later
+			s.log.Error("forward push failed", zap.String("image", image), zap.Error(err))
+		}
+	}
+
+	s.log.Info("manifest pushed", zap.String("image", image), zap.String("digest", manifestDigest))
+
+	w.Header().Set("Docker-Content-Digest", manifestDigest)
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/%s/manifests/%s", registry, repo, manifestDigest))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// forward pushes a stored manifest and any blobs upstream lacks.
+func (s *Server) forward(ctx context.Context, registry, repo, ref, mediaType string, body []byte, manifest *oci.Manifest) error {
+	for _, blob := range manifestBlobs(mediaType, manifest) {
+		exists, err := s.client.BlobExists(ctx, registry, repo, blob.Digest)
+		if err != nil {
+			return fmt.Errorf("check blob %s: %w", blob.Digest, err)
+		}
+		if exists {
+			continue
+		}
+
+		if err := s.forwardBlob(ctx, registry, repo, blob.Digest); err != nil {
+			return err
+		}
+	}
+
+	return s.client.PutManifest(ctx, registry, repo, ref, mediaType, body)
+}
+
+func (s *Server) forwardBlob(ctx context.Context, registry, repo, digest string) error {
+	f, err := s.layout.OpenBlob(digest)
+	if err != nil {
+		return fmt.Errorf("open blob %s: %w", digest, err)
+	}
+	defer f.Close()
+
+	if err := s.client.PushBlob(ctx, registry, repo, digest, s.layout.BlobSize(digest), f); err != nil {
+		return fmt.Errorf("push blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// manifestBlobs returns the config and layers of an image manifest; indexes
+// reference other manifests and have none.
+func manifestBlobs(mediaType string, m *oci.Manifest) []oci.Blob {
+	if strings.Contains(mediaType, "manifest.list") || strings.Contains(mediaType, "image.index") {
+		return nil
+	}
+
+	blobs := make([]oci.Blob, 0, len(m.Layers)+1)
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config)
+	}
+	return append(blobs, m.Layers...)
+}
+
+func (s *Server) uploadPath(id string) string {
+	return filepath.Join(s.uploadDir, filepath.Base(id))
+}
+
+func uploadURL(registry, repo, id string) string {
+	return fmt.Sprintf("/v2/%s/%s/blobs/uploads/%s", registry, repo, id)
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
+)
+
+type testImage struct {
+	config   []byte
+	layer    []byte
+	manifest []byte
+}
+
+func newTestImage(t *testing.T) testImage {
+	t.Helper()
+
+	img := testImage{
+		config: []byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`),
+		layer:  bytes.Repeat([]byte("layer"), 1000),
+	}
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: oci.Blob{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    sha256Digest(img.config),
+			Size:      int64(len(img.config)),
+		},
+		Layers: []oci.Blob{{
+			MediaType: store.MediaTypeLayerGzip,
+			Digest:    sha256Digest(img.layer),
+			Size:      int64(len(img.layer)),
+		}},
+	}
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	img.manifest = data
+
+	return img
+}
+
+func (img testImage) push(ctx context.Context, c *oci.Client, host, repo, tag string) error {
+	for _, blob := range [][]byte{img.config, img.layer} {
+		if err := c.PushBlob(ctx, host, repo, sha256Digest(blob), int64(len(blob)), bytes.NewReader(blob)); err != nil {
+			return err
+		}
+	}
+	return c.PutManifest(ctx, host, repo, tag, "application/vnd.oci.image.manifest.v1+json", img.manifest)
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// testPushToken authorizes pushes to test proxies.
+const testPushToken = "push-token"
+
+// tokenAuth answers a push challenge with a fixed bearer token.
+type tokenAuth string
+
+func (a tokenAuth) GetAuth(context.Context, string, string) (string, error) {
+	return "Bearer " + string(a), nil
+}
+
+func newWritableProxy(t *testing.T, opts Options) (*store.Layout, *oci.Client, string) {
+	t.Helper()
+
+	l, err := store.Open(t.TempDir())
+	require.NoError(t, err)
+
+	upstream := oci.NewClient()
+	opts.Writable = true
+	opts.PushToken = testPushToken
+	srv := httptest.NewServer(New(l, upstream, logging.Nop(), opts))
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c := oci.NewClient()
+	c.SetInsecure(host, true)
+	c.SetAuth(tokenAuth(testPushToken))
+	// a small chunk size exercises PATCH uploads for the layer
+	c.SetUploadChunkSize(1024)
+
+	return l, c, host
+}
+
+func TestPushAndPull(t *testing.T) {
+	require := require.New(t)
+
+	l, c, host := newWritableProxy(t, Options{})
+	img := newTestImage(t)
+	ctx := context.Background()
+
+	require.NoError(img.push(ctx, c, host, "mirror.local/test/repo", "v1"))
+	require.True(l.HasBlob(sha256Digest(img.layer)))
+
+	index, err := l.GetIndex()
+	require.NoError(err)
+	require.Len(index.Manifests, 1)
+	require.Equal("mirror.local/test/repo:v1", index.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+
+	manifest, err := c.GetManifest(ctx, host, "mirror.local/test/repo", "v1")
+	require.NoError(err)
+	require.Equal(sha256Digest(img.layer), manifest.Layers[0].Digest)
+
+	r, err := c.GetBlob(ctx, host, "mirror.local/test/repo", manifest.Layers[0].Digest)
+	require.NoError(err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(err)
+	require.Equal(img.layer, data)
+}
+
+func TestPushReplacesTag(t *testing.T) {
+	require := require.New(t)
+
+	l, c, host := newWritableProxy(t, Options{})
+	img := newTestImage(t)
+
+	// a pulled entry under a shorter spelling of the same tag
+	require.NoError(l.AddManifest(store.Descriptor{
+		MediaType:   "application/vnd.oci.image.manifest.v1+json",
+		Digest:      sha256Digest([]byte("pulled")),
+		Size:        6,
+		Annotations: map[string]string{store.AnnotationRefName: "docker.io/alpine:v1"},
+	}))
+
+	require.NoError(img.push(context.Background(), c, host, "docker.io/library/alpine", "v1"))
+
+	index, err := l.GetIndex()
+	require.NoError(err)
+	require.Len(index.Manifests, 1)
+	require.Equal(sha256Digest(img.manifest), index.Manifests[0].Digest)
+	require.Equal("docker.io/library/alpine:v1", index.Manifests[0].Annotations[store.AnnotationRefName])
+}
+
+func TestPushDigestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	l, c, host := newWritableProxy(t, Options{})
+
+	data := []byte("some blob")
+	bad := sha256Digest([]byte("other blob"))
+	err := c.PushBlob(context.Background(), host, "mirror.local/test/repo", bad, int64(len(data)), bytes.NewReader(data))
+	require.Error(err)
+	require.Contains(err.Error(), "digest invalid")
+	require.False(l.HasBlob(bad))
+}
+
+func TestPushManifestBlobUnknown(t *testing.T) {
+	require := require.New(t)
+
+	_, c, host := newWritableProxy(t, Options{})
+	img := newTestImage(t)
+
+	err := c.PutManifest(context.Background(), host, "mirror.local/test/repo", "v1", "application/vnd.oci.image.manifest.v1+json", img.manifest)
+	require.Error(err)
+	require.Contains(err.Error(), "manifest blob unknown")
+}
+
+func TestPushReadOnly(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/v2/quay.io/test/repo/blobs/uploads/"},
+		{http.MethodPatch, "/v2/quay.io/test/repo/blobs/uploads/abc"},
+		{http.MethodPut, "/v2/quay.io/test/repo/manifests/v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			s := New(l, oci.NewClient(), logging.Nop(), DefaultOptions())
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			require.Equal(http.StatusMethodNotAllowed, w.Code)
+		})
+	}
+}
+
+func TestPushAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		auth       func(r *http.Request)
+		wantStatus int
+	}{
+		{"no credentials", testPushToken, func(*http.Request) {}, http.StatusUnauthorized},
+		{"wrong bearer token", testPushToken, func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, http.StatusUnauthorized},
+		{"bearer token", testPushToken, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+testPushToken) }, http.StatusAccepted},
+		{"basic password", testPushToken, func(r *http.Request) { r.SetBasicAuth("anyone", testPushToken) }, http.StatusAccepted},
+		{"wrong basic password", testPushToken, func(r *http.Request) { r.SetBasicAuth("anyone", "other") }, http.StatusUnauthorized},
+		{"no token configured", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			s := New(l, oci.NewClient(), logging.Nop(), Options{Writable: true, PushToken: tt.token})
+
+			req := httptest.NewRequest(http.MethodPost, "/v2/quay.io/test/repo/blobs/uploads/", nil)
+			tt.auth(req)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus == http.StatusUnauthorized {
+				require.Equal(`Basic realm="fray"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestPushForwardsUpstream(t *testing.T) {
+	require := require.New(t)
+
+	var mu sync.Mutex
+	blobs := make(map[string]bool)
+	var manifests []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", r.URL.Path+"1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/blobs/uploads/"):
+			blobs[r.URL.Query().Get("digest")] = true
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/"):
+			manifests = append(manifests, r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	l, err := store.Open(t.TempDir())
+	require.NoError(err)
+
+	upstreamClient := oci.NewClient()
+	upstreamClient.SetInsecure(upstreamHost, true)
+	srv := httptest.NewServer(New(l, upstreamClient, logging.Nop(), Options{Writable: true, ForwardPushes: true, PushToken: testPushToken}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c := oci.NewClient()
+	c.SetInsecure(host, true)
+	c.SetAuth(tokenAuth(testPushToken))
+
+	img := newTestImage(t)
+	require.NoError(img.push(context.Background(), c, host, upstreamHost+"/test/repo", "v1"))
+
+	require.True(blobs[sha256Digest(img.config)])
+	require.True(blobs[sha256Digest(img.layer)])
+	require.Equal([]string{"/v2/test/repo/manifests/v1"}, manifests)
+}
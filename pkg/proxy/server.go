@@ -3,10 +3,10 @@ package proxy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -14,6 +14,9 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/hexfusion/fray/internal/version"
+	"github.com/hexfusion/fray/pkg/cel"
+	"github.com/hexfusion/fray/pkg/digest"
 	"github.com/hexfusion/fray/pkg/logging"
 	"github.com/hexfusion/fray/pkg/oci"
 	"github.com/hexfusion/fray/pkg/store"
@@ -26,12 +29,19 @@ type Server struct {
 	log     logging.Logger
 	opts    Options
 	pulling map[string]*pullState
-	mu      sync.Mutex
+	// validated records when each tag was last resolved upstream.
+	validated map[string]time.Time
+	mu        sync.Mutex
+	server    string
+	// uploadDir holds in-progress blob uploads.
+	uploadDir string
+	manifests *manifestCache
 }
 
 type pullState struct {
-	done chan struct{}
-	err  error
+	done   chan struct{}
+	result *store.PullResult
+	err    error
 }
 
 const (
@@ -45,6 +55,41 @@ type Options struct {
 	ChunkSize   int
 	Parallel    int
 	PullTimeout int
+	// Retry controls upstream chunk retries. Nil uses oci.DefaultRetryPolicy.
+	Retry *oci.RetryPolicy
+	// Writable accepts pushes under /v2/ and stores them in the layout.
+	// Pushes must carry PushToken; without one every push is refused.
+	Writable bool
+	// ForwardPushes also pushes accepted manifests and blobs upstream.
+	ForwardPushes bool
+	// PushToken is the token pushes must carry, as a bearer token or as
+	// the password of basic credentials.
+	PushToken string
+	// ManifestTTL is how long a tag resolved upstream is trusted before it
+	// is resolved again. Zero serves cached tags without revalidating.
+	ManifestTTL time.Duration
+	// AdminToken, when set, is the bearer token required by /admin/ endpoints.
+	AdminToken string
+	// Metrics receives cache and pull counters. Nil disables metrics.
+	Metrics Metrics
+	// ManifestCacheEntries is how many manifests are kept in memory. Zero
+	// uses DefaultManifestCacheEntries; negative disables the cache.
+	ManifestCacheEntries int
+	// Upstream, when set, is pulled from instead of the client's
+	// registries, such as another layout through store.LayoutSource for
+	// tiered caching. The client still parses references and forwards
+	// pushes.
+	Upstream store.BlobClient
+	// ReadOnly serves strictly from the layout. Misses are 404 instead of
+	// being pulled, tags are never revalidated, and pushes and /admin/pull
+	// are refused whatever Writable says.
+	ReadOnly bool
+	// ServeIndexes caches a tag's image index as upstream served it and
+	// answers the tag with it, rather than with the manifest for one
+	// platform, so clients choose their own. The manifest each asks for is
+	// then pulled by digest. Upstream, when set, must be a
+	// store.ManifestClient.
+	ServeIndexes bool
 }
 
 // DefaultOptions returns sensible defaults.
@@ -67,12 +112,21 @@ func New(l *store.Layout, client *oci.Client, log logging.Logger, opts Options)
 	if opts.PullTimeout == 0 {
 		opts.PullTimeout = DefaultPullTimeout
 	}
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics{}
+	}
 	return &Server{
 		layout:  l,
 		client:  client,
 		log:     log,
 		opts:    opts,
 		pulling: make(map[string]*pullState),
+		server:  version.UserAgent(),
+
+		validated: make(map[string]time.Time),
+
+		uploadDir: filepath.Join(l.Root(), store.MetadataDir, "uploads"),
+		manifests: newManifestCache(opts.ManifestCacheEntries),
 	}
 }
 
@@ -81,6 +135,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	path := r.URL.Path
 
+	w.Header().Set("Server", s.server)
+
 	defer func() {
 		s.log.Info("request",
 			zap.String("method", r.Method),
@@ -94,6 +150,21 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch path {
+	case adminPullPath:
+		s.handleAdminPull(w, r)
+		return
+	case adminStatsPath:
+		s.handleAdminStats(w, r)
+		return
+	case adminGCPath:
+		s.handleAdminGC(w, r)
+		return
+	case adminEvictPath:
+		s.handleAdminEvict(w, r)
+		return
+	}
+
 	if strings.HasPrefix(path, "/v2/") {
 		parts := strings.Split(strings.TrimPrefix(path, "/v2/"), "/")
 		if len(parts) >= 4 {
@@ -102,12 +173,38 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					registry := parts[0]
 					repo := strings.Join(parts[1:i], "/")
 					ref := strings.Join(parts[i+1:], "/")
+					if err := validateRoute(registry, repo); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if err := validateManifestRef(ref); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if r.Method == http.MethodPut {
+						if s.rejectWrite(w, r) {
+							return
+						}
+						s.handlePutManifest(w, r, registry, repo, ref)
+						return
+					}
 					s.handleManifest(w, r, registry, repo, ref)
 					return
 				}
 				if parts[i] == "blobs" {
 					registry := parts[0]
 					repo := strings.Join(parts[1:i], "/")
+					if err := validateRoute(registry, repo); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if parts[i+1] == "uploads" {
+						if s.rejectWrite(w, r) {
+							return
+						}
+						s.handleUpload(w, r, registry, repo, strings.Join(parts[i+2:], "/"))
+						return
+					}
 					digest := strings.Join(parts[i+1:], "/")
 					s.handleBlob(w, r, registry, repo, digest)
 					return
@@ -119,6 +216,45 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+// validateRoute checks the registry and repository taken from a request
+// path before they reach upstream URLs or image references.
+func validateRoute(registry, repo string) error {
+	if err := cel.ValidateRegistryHost(registry); err != nil {
+		return fmt.Errorf("%w %s/%s: %w", oci.ErrInvalidReference, registry, repo, err)
+	}
+	if err := cel.ValidateImageRepository(repo); err != nil {
+		return fmt.Errorf("%w %s/%s: %w", oci.ErrInvalidReference, registry, repo, err)
+	}
+	return nil
+}
+
+// validateManifestRef checks that a manifest reference is a tag or digest.
+func validateManifestRef(ref string) error {
+	validate := cel.ValidateImageTag
+	if strings.Contains(ref, ":") {
+		validate = store.ValidateDigest
+	}
+	if err := validate(ref); err != nil {
+		return fmt.Errorf("%w %s: %w", oci.ErrInvalidReference, ref, err)
+	}
+	return nil
+}
+
+// rejectWrite answers 405 when pushes are disabled, and 401 when the
+// request doesn't carry the push token.
+func (s *Server) rejectWrite(w http.ResponseWriter, r *http.Request) bool {
+	if !s.opts.Writable || s.opts.ReadOnly {
+		http.Error(w, "proxy is read-only", http.StatusMethodNotAllowed)
+		return true
+	}
+	if !s.pushAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fray"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return true
+	}
+	return false
+}
+
 func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
 	w.Header().Set("Content-Type", "application/json")
@@ -129,14 +265,34 @@ func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
 func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request, registry, repo, ref string) {
 	image := fmt.Sprintf("%s/%s:%s", registry, repo, ref)
 
-	if strings.HasPrefix(ref, "sha256:") {
+	if strings.Contains(ref, ":") {
 		image = fmt.Sprintf("%s/%s@%s", registry, repo, ref)
 	}
 
-	digest, err := s.findManifestDigest(image)
+	parsed, err := s.client.Config().ParseReference(image)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	image = parsed.String()
+
+	// current is false when a stale tag could not be revalidated and the
+	// cached copy is served anyway
+	current := true
+	// hit is false when the manifest was fetched from upstream, including a
+	// revalidated tag that had moved
+	hit := true
+
+	digest, err := s.findManifestDigest(image)
+	switch {
+	case err != nil && s.opts.ReadOnly:
+		s.recordCache(w, CacheManifest, false)
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	case err != nil:
+		hit = false
 		s.log.Info("cache miss, pulling from upstream", zap.String("image", image))
-		if err := s.pullImage(r.Context(), image); err != nil {
+		if _, err := s.pullImage(r.Context(), image, ""); err != nil {
 			s.log.Error("upstream pull failed", zap.String("image", image), zap.Error(err))
 			http.Error(w, fmt.Sprintf("upstream pull failed: %v", err), http.StatusBadGateway)
 			return
@@ -147,20 +303,43 @@ func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request, registry
 			http.Error(w, "manifest not found after pull", http.StatusInternalServerError)
 			return
 		}
+		s.markValidated(image)
 		s.log.Info("pull complete", zap.String("image", image))
-	} else {
+	case parsed.Digest == "" && !s.opts.ReadOnly && s.stale(image):
+		s.log.Info("revalidating tag", zap.String("image", image))
+		result, err := s.pullImage(r.Context(), image, "")
+		if err != nil {
+			s.log.Warn("revalidation failed, serving cached manifest", zap.String("image", image), zap.Error(err))
+			current = false
+			break
+		}
+		if d, err := s.findManifestDigest(image); err == nil && d != digest {
+			// the tag moved, so its old manifest is unlikely to be asked for
+			s.manifests.remove(digest)
+			digest = d
+		}
+		hit = result.Unchanged
+		s.markValidated(image)
+	default:
 		s.log.Debug("cache hit", zap.String("image", image))
 	}
+	s.recordCache(w, CacheManifest, hit)
 
-	data, err := s.layout.ReadBlob(digest)
+	etag := `"` + digest + `"`
+	w.Header().Set("ETag", etag)
+	if current && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, mediaType, err := s.readManifest(digest)
 	if err != nil {
 		s.log.Error("read manifest blob failed", zap.String("digest", digest), zap.Error(err))
 		http.Error(w, "failed to read manifest", http.StatusInternalServerError)
 		return
 	}
 
-	mediaType := detectMediaType(data)
-
 	if r.Method == http.MethodHead {
 		w.Header().Set("Content-Type", mediaType)
 		w.Header().Set("Docker-Content-Digest", digest)
@@ -175,28 +354,44 @@ func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request, registry
 	_, _ = w.Write(data)
 }
 
+// readManifest returns a stored manifest and its media type, from memory
+// when it was served recently.
+func (s *Server) readManifest(digest string) ([]byte, string, error) {
+	if e, ok := s.manifests.get(digest); ok {
+		return e.data, e.mediaType, nil
+	}
+	data, err := s.layout.ReadBlob(digest)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := detectMediaType(data)
+	s.manifests.add(digest, data, mediaType)
+	return data, mediaType, nil
+}
+
 func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request, _, _, digest string) {
-	if !s.layout.HasBlob(digest) {
-		http.Error(w, "blob not found", http.StatusNotFound)
+	digest, err := store.NormalizeDigest(digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	blobPath := filepath.Join(s.layout.Root(), "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
-	info, err := os.Stat(blobPath)
-	if err != nil {
-		http.Error(w, "blob stat failed", http.StatusInternalServerError)
+	size := s.layout.BlobSize(digest)
+	s.recordCache(w, CacheBlob, size >= 0)
+	if size < 0 {
+		http.Error(w, "blob not found", http.StatusNotFound)
 		return
 	}
 
 	if r.Method == http.MethodHead {
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Docker-Content-Digest", digest)
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	f, err := os.Open(blobPath)
+	f, err := s.layout.OpenBlob(digest)
 	if err != nil {
 		http.Error(w, "blob open failed", http.StatusInternalServerError)
 		return
@@ -205,64 +400,147 @@ func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request, _, _, digest
 
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Docker-Content-Digest", digest)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 	w.WriteHeader(http.StatusOK)
 
-	io.Copy(w, f)
+	if err := copyVerified(w, f, digest, size); err != nil {
+		if errors.Is(err, store.ErrDigestMismatch) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			s.log.Error("cached blob is corrupt", zap.String("digest", digest), zap.Int64("size", size), zap.Error(err))
+		}
+		// a response ended short would leave the client waiting for the
+		// rest; aborting closes the connection so it fails fast
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// copyVerified copies size bytes of blob d from r to w. The last byte is
+// held back until the blob has hashed to d, so a client never receives a
+// complete response for a blob that is corrupt or was truncated on disk.
+func copyVerified(w io.Writer, r io.Reader, d string, size int64) error {
+	parsed, err := digest.Parse(d)
+	if err != nil {
+		return err
+	}
+	h := parsed.Algorithm().New()
+	body := io.TeeReader(r, h)
+
+	if size > 0 {
+		if _, err := io.CopyN(w, body, size-1); err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+	}
+	last := make([]byte, min(size, 1))
+	if _, err := io.ReadFull(body, last); err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+	if got := parsed.Algorithm().FromHash(h); got != parsed {
+		return fmt.Errorf("%w: %s: got %s", store.ErrDigestMismatch, d, got)
+	}
+	_, err = w.Write(last)
+	return err
 }
 
 func (s *Server) findManifestDigest(image string) (string, error) {
-	index, err := s.layout.GetIndex()
+	img, err := s.layout.FindByRef(image)
 	if err != nil {
 		return "", err
 	}
+	return img.Digest, nil
+}
 
-	for _, m := range index.Manifests {
-		refName := m.Annotations["org.opencontainers.image.ref.name"]
-		if refName == image {
-			return m.Digest, nil
-		}
+// stale reports whether a cached tag is due to be resolved upstream again.
+func (s *Server) stale(image string) bool {
+	if s.opts.ManifestTTL <= 0 {
+		return false
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.validated[image]
+	return !ok || time.Since(at) > s.opts.ManifestTTL
+}
+
+func (s *Server) markValidated(image string) {
+	s.mu.Lock()
+	s.validated[image] = time.Now()
+	s.mu.Unlock()
+}
 
-	return "", fmt.Errorf("manifest not found: %s", image)
+// etagMatch reports whether an If-None-Match header matches etag.
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Server) pullImage(ctx context.Context, image string) error {
+// pullImage pulls image for platform, sharing one pull between concurrent
+// callers asking for the same image and platform.
+func (s *Server) pullImage(ctx context.Context, image, platform string) (*store.PullResult, error) {
+	key := image
+	if platform != "" {
+		key += " " + platform
+	}
+
 	s.mu.Lock()
-	if state, ok := s.pulling[image]; ok {
+	if state, ok := s.pulling[key]; ok {
 		s.mu.Unlock()
 		<-state.done
-		return state.err
+		return state.result, state.err
 	}
 
 	state := &pullState{done: make(chan struct{})}
-	s.pulling[image] = state
+	s.pulling[key] = state
 	s.mu.Unlock()
 
-	puller := store.NewPuller(s.layout, s.client, s.log, store.PullOptions{
+	var upstream store.BlobClient = s.client
+	if s.opts.Upstream != nil {
+		upstream = s.opts.Upstream
+	}
+	puller := store.NewPuller(s.layout, upstream, s.log, store.PullOptions{
 		ChunkSize: s.opts.ChunkSize,
 		Parallel:  s.opts.Parallel,
+		Retry:     s.opts.Retry,
+		Platform:  platform,
+		Metrics:   s.opts.Metrics,
+		KeepIndex: s.opts.ServeIndexes,
 	})
 
-	_, err := puller.Pull(ctx, image)
-	state.err = err
-	close(state.done)
+	state.result, state.err = puller.Pull(ctx, image)
 
-	go func() {
-		s.mu.Lock()
-		delete(s.pulling, image)
-		s.mu.Unlock()
-	}()
+	// remove the entry before waking waiters so a later caller starts a
+	// fresh pull instead of reusing this result
+	s.mu.Lock()
+	delete(s.pulling, key)
+	s.mu.Unlock()
+	close(state.done)
 
-	return err
+	return state.result, state.err
 }
 
 func detectMediaType(data []byte) string {
 	var m struct {
-		MediaType string `json:"mediaType"`
+		MediaType    string `json:"mediaType"`
+		ArtifactType string `json:"artifactType"`
+		Config       struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "application/vnd.docker.distribution.manifest.v2+json"
 	}
-	if err := json.Unmarshal(data, &m); err == nil && m.MediaType != "" {
+	switch {
+	case m.MediaType != "":
 		return m.MediaType
+	case len(m.Manifests) > 0:
+		// an index stored as served may omit its mediaType
+		return "application/vnd.oci.image.index.v1+json"
+	case m.ArtifactType != "", strings.HasPrefix(m.Config.MediaType, "application/vnd.oci."):
+		// docker manifests always carry a mediaType; artifacts may not
+		return "application/vnd.oci.image.manifest.v1+json"
 	}
 	return "application/vnd.docker.distribution.manifest.v2+json"
 }
@@ -1,13 +1,24 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/hexfusion/fray/internal/version"
 	"github.com/hexfusion/fray/pkg/logging"
 	"github.com/hexfusion/fray/pkg/oci"
 	"github.com/hexfusion/fray/pkg/store"
@@ -85,6 +96,16 @@ func TestDetectMediaType(t *testing.T) {
 			data: `{"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`,
 			want: "application/vnd.docker.distribution.manifest.v2+json",
 		},
+		{
+			name: "artifact without media type",
+			data: `{"schemaVersion":2,"artifactType":"application/spdx+json"}`,
+			want: "application/vnd.oci.image.manifest.v1+json",
+		},
+		{
+			name: "empty config without media type",
+			data: `{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.empty.v1+json"}}`,
+			want: "application/vnd.oci.image.manifest.v1+json",
+		},
 		{
 			name: "no media type field",
 			data: `{"schemaVersion":2}`,
@@ -174,13 +195,14 @@ func TestHandleBlobNotFound(t *testing.T) {
 	client := oci.NewClient()
 	s := New(l, client, logging.Nop(), DefaultOptions())
 
-	req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/blobs/sha256:notexist", nil)
+	req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/blobs/sha256:"+strings.Repeat("0", 64), nil)
 	w := httptest.NewRecorder()
 
 	s.ServeHTTP(w, req)
 
 	require.Equal(http.StatusNotFound, w.Code)
 	require.True(strings.Contains(w.Body.String(), "not found"))
+	require.Equal("MISS", w.Header().Get("X-Cache"))
 }
 
 func TestHandleBlobExists(t *testing.T) {
@@ -190,8 +212,8 @@ func TestHandleBlobExists(t *testing.T) {
 	l, err := store.Open(dir)
 	require.NoError(err)
 
-	digest := "sha256:abc123"
 	content := "blob content here"
+	digest := sha256Digest([]byte(content))
 	_, err = l.WriteBlob(digest, strings.NewReader(content))
 	require.NoError(err)
 
@@ -199,14 +221,15 @@ func TestHandleBlobExists(t *testing.T) {
 	s := New(l, client, logging.Nop(), DefaultOptions())
 
 	// GET request
-	req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/blobs/sha256:abc123", nil)
+	req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/blobs/"+digest, nil)
 	w := httptest.NewRecorder()
 
 	s.ServeHTTP(w, req)
 
 	require.Equal(http.StatusOK, w.Code)
 	require.Equal(content, w.Body.String())
-	require.Equal("sha256:abc123", w.Header().Get("Docker-Content-Digest"))
+	require.Equal(digest, w.Header().Get("Docker-Content-Digest"))
+	require.Equal("HIT", w.Header().Get("X-Cache"))
 }
 
 func TestHandleBlobHead(t *testing.T) {
@@ -216,7 +239,7 @@ func TestHandleBlobHead(t *testing.T) {
 	l, err := store.Open(dir)
 	require.NoError(err)
 
-	digest := "sha256:headtest"
+	digest := "sha256:4ead7e57"
 	content := "head test content"
 	_, err = l.WriteBlob(digest, strings.NewReader(content))
 	require.NoError(err)
@@ -224,13 +247,572 @@ func TestHandleBlobHead(t *testing.T) {
 	client := oci.NewClient()
 	s := New(l, client, logging.Nop(), DefaultOptions())
 
-	req := httptest.NewRequest(http.MethodHead, "/v2/quay.io/test/repo/blobs/sha256:headtest", nil)
+	req := httptest.NewRequest(http.MethodHead, "/v2/quay.io/test/repo/blobs/"+digest, nil)
 	w := httptest.NewRecorder()
 
 	s.ServeHTTP(w, req)
 
 	require.Equal(http.StatusOK, w.Code)
-	require.Equal("sha256:headtest", w.Header().Get("Docker-Content-Digest"))
+	require.Equal(digest, w.Header().Get("Docker-Content-Digest"))
 	require.Equal("17", w.Header().Get("Content-Length"))
 	require.Empty(w.Body.String())
 }
+
+func TestHandleBlobDigestCase(t *testing.T) {
+	digest := sha256Digest([]byte("case"))
+	hex := strings.TrimPrefix(digest, "sha256:")
+
+	tests := []struct {
+		name string
+		ref  string
+	}{
+		{"lowercase", digest},
+		{"uppercase", "sha256:" + strings.ToUpper(hex)},
+		{"mixed case", "sha256:" + strings.ToUpper(hex[:32]) + hex[32:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			_, err = l.WriteBlob(digest, strings.NewReader("case"))
+			require.NoError(err)
+			s := New(l, oci.NewClient(), logging.Nop(), DefaultOptions())
+
+			req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/blobs/"+tt.ref, nil)
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(http.StatusOK, w.Code)
+			require.Equal("case", w.Body.String())
+			require.Equal(digest, w.Header().Get("Docker-Content-Digest"))
+			require.Equal("HIT", w.Header().Get("X-Cache"))
+		})
+	}
+}
+
+func TestHandleBlobCorrupt(t *testing.T) {
+	// larger than the server's write buffer, so headers go out before the
+	// blob is found corrupt
+	content := bytes.Repeat([]byte("layer data "), 16*1024)
+	digest := sha256Digest(content)
+
+	tests := []struct {
+		name   string
+		stored []byte
+	}{
+		{"truncated", content[:len(content)/2]},
+		{"overwritten", bytes.Repeat([]byte("x"), len(content))},
+		{"empty", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			_, err = l.WriteBlob(digest, bytes.NewReader(tt.stored))
+			require.NoError(err)
+
+			server := httptest.NewServer(New(l, oci.NewClient(), logging.Nop(), DefaultOptions()))
+			defer server.Close()
+			client := &http.Client{Timeout: 5 * time.Second}
+
+			// the transfer fails rather than completing or hanging, and
+			// only what is on disk is ever advertised
+			resp, err := client.Get(server.URL + "/v2/quay.io/test/repo/blobs/" + digest)
+			if err == nil {
+				defer resp.Body.Close()
+				require.Equal(int64(len(tt.stored)), resp.ContentLength)
+				_, err = io.ReadAll(resp.Body)
+			}
+			require.Error(err)
+			require.NotErrorIs(err, context.DeadlineExceeded)
+		})
+	}
+}
+
+func TestHandleManifestInvalidReference(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	l, err := store.Open(dir)
+	require.NoError(err)
+
+	client := oci.NewClient()
+	s := New(l, client, logging.Nop(), DefaultOptions())
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/quay.io/Bad/Repo/manifests/latest", nil)
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, req)
+
+	require.Equal(http.StatusBadRequest, w.Code)
+	require.Contains(w.Body.String(), "invalid reference")
+}
+
+func TestServerHeader(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	l, err := store.Open(dir)
+	require.NoError(err)
+
+	s := New(l, oci.NewClient(), logging.Nop(), DefaultOptions())
+
+	for _, path := range []string{"/v2/", "/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+
+		s.ServeHTTP(w, req)
+
+		require.Equal(version.UserAgent(), w.Header().Get("Server"))
+		require.True(strings.HasPrefix(w.Header().Get("Server"), "fray/"))
+	}
+}
+
+func TestHandleBlobTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"parent segments", "/v2/quay.io/test/repo/blobs/sha256:../../../index.json"},
+		{"encoded slashes", "/v2/quay.io/test/repo/blobs/sha256:..%2F..%2F..%2Findex.json"},
+		{"unknown algorithm", "/v2/quay.io/test/repo/blobs/md5:d41d8cd98f00b204e9800998ecf8427e"},
+		{"non-hex", "/v2/quay.io/test/repo/blobs/sha256:XYZ123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			s := New(l, oci.NewClient(), logging.Nop(), DefaultOptions())
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(http.StatusBadRequest, w.Code)
+			require.Contains(w.Body.String(), "invalid digest")
+		})
+	}
+}
+
+func TestServeHTTPRejectsMalformedPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"repo parent segments", http.MethodGet, "/v2/quay.io/test/../../etc/manifests/latest"},
+		{"encoded parent segments", http.MethodGet, "/v2/quay.io/test/..%2F..%2Fetc/manifests/latest"},
+		{"uppercase repo", http.MethodGet, "/v2/quay.io/Test/repo/manifests/latest"},
+		{"empty repo component", http.MethodGet, "/v2/quay.io/test//repo/manifests/latest"},
+		{"host with path characters", http.MethodGet, "/v2/evil.com%3Fx=/test/repo/manifests/latest"},
+		{"host with space", http.MethodGet, "/v2/quay.io%20evil/test/repo/manifests/latest"},
+		{"port out of range", http.MethodGet, "/v2/quay.io:99999/test/repo/manifests/latest"},
+		{"tag with slash", http.MethodGet, "/v2/quay.io/test/repo/manifests/v1/../../x"},
+		{"tag with query characters", http.MethodGet, "/v2/quay.io/test/repo/manifests/v1%3Fx=y"},
+		{"malformed digest ref", http.MethodGet, "/v2/quay.io/test/repo/manifests/sha256:XYZ"},
+		{"blob repo traversal", http.MethodGet, "/v2/quay.io/../test/blobs/sha256:" + strings.Repeat("0", 64)},
+		{"manifest put traversal", http.MethodPut, "/v2/quay.io/../../test/manifests/latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			// every upstream request goes through this proxy, whatever the host
+			var upstream int
+			counter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				upstream++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			t.Cleanup(counter.Close)
+			proxyURL, err := url.Parse(counter.URL)
+			require.NoError(err)
+
+			client := oci.NewClient()
+			client.Config().SetProxy(proxyURL)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			opts := DefaultOptions()
+			opts.Writable = true
+			s := New(l, client, logging.Nop(), opts)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(http.StatusBadRequest, w.Code, w.Body.String())
+			require.Contains(w.Body.String(), "invalid reference")
+			require.Zero(upstream)
+		})
+	}
+}
+
+func TestHandleManifestConditional(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		path        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"tag without validator", 0, "/v2/quay.io/test/repo/manifests/v1", "", http.StatusOK},
+		{"tag with matching etag", 0, "/v2/quay.io/test/repo/manifests/v1", "match", http.StatusNotModified},
+		{"tag with other etag", 0, "/v2/quay.io/test/repo/manifests/v1", `"sha256:other"`, http.StatusOK},
+		{"tag with wildcard", 0, "/v2/quay.io/test/repo/manifests/v1", "*", http.StatusNotModified},
+		{"digest past ttl", time.Nanosecond, "/v2/quay.io/test/repo/manifests/digest", "match", http.StatusNotModified},
+		{"tag past ttl without upstream", time.Nanosecond, "/v2/quay.io/test/repo/manifests/v1", "match", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			// upstream is unreachable so revalidation always fails
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			t.Cleanup(failing.Close)
+			proxyURL, err := url.Parse(failing.URL)
+			require.NoError(err)
+			client := oci.NewClient()
+			client.Config().SetProxy(proxyURL)
+			client.SetRetryPolicy(oci.RetryPolicy{})
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			img := newTestImage(t)
+			digest := sha256Digest(img.manifest)
+			_, err = l.WriteBlob(digest, bytes.NewReader(img.manifest))
+			require.NoError(err)
+			for _, ref := range []string{"quay.io/test/repo:v1", "quay.io/test/repo@" + digest} {
+				require.NoError(l.AddManifest(store.Descriptor{
+					MediaType:   "application/vnd.oci.image.manifest.v1+json",
+					Digest:      digest,
+					Size:        int64(len(img.manifest)),
+					Annotations: map[string]string{store.AnnotationRefName: ref},
+				}))
+			}
+
+			s := New(l, client, logging.Nop(), Options{ManifestTTL: tt.ttl})
+
+			req := httptest.NewRequest(http.MethodGet, strings.Replace(tt.path, "digest", digest, 1), nil)
+			switch tt.ifNoneMatch {
+			case "":
+			case "match":
+				req.Header.Set("If-None-Match", `W/"sha256:other", "`+digest+`"`)
+			default:
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			s.ServeHTTP(w, req)
+
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			require.Equal(`"`+digest+`"`, w.Header().Get("ETag"))
+			if tt.wantStatus == http.StatusNotModified {
+				require.Empty(w.Body.String())
+			} else {
+				require.Equal(string(img.manifest), w.Body.String())
+			}
+		})
+	}
+}
+
+// cacheMetrics counts cache lookups and layers pulled.
+type cacheMetrics struct {
+	nopMetrics
+	mu     sync.Mutex
+	hits   map[CacheKind]int
+	misses map[CacheKind]int
+	layers int
+}
+
+func (m *cacheMetrics) IncCacheHit(kind CacheKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits[kind]++
+}
+
+func (m *cacheMetrics) IncCacheMiss(kind CacheKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses[kind]++
+}
+
+func (m *cacheMetrics) IncLayersDownloaded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.layers++
+}
+
+func TestCacheHeader(t *testing.T) {
+	require := require.New(t)
+
+	img := newTestImage(t)
+	host := newUpstream(t, img)
+	metrics := &cacheMetrics{hits: map[CacheKind]int{}, misses: map[CacheKind]int{}}
+	_, s := newAdminServer(t, host, Options{Metrics: metrics})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// cold manifest is pulled from upstream
+	manifestPath := "/v2/" + host + "/test/repo/manifests/v1"
+	w := get(manifestPath)
+	require.Equal(http.StatusOK, w.Code, w.Body.String())
+	require.Equal("MISS", w.Header().Get("X-Cache"))
+
+	// warm manifest is served from the layout
+	w = get(manifestPath)
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal("HIT", w.Header().Get("X-Cache"))
+
+	// so are the blobs the pull fetched
+	w = get("/v2/" + host + "/test/repo/blobs/" + sha256Digest(img.layer))
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal("HIT", w.Header().Get("X-Cache"))
+
+	require.Equal(map[CacheKind]int{CacheManifest: 1, CacheBlob: 1}, metrics.hits)
+	require.Equal(map[CacheKind]int{CacheManifest: 1}, metrics.misses)
+	require.Equal(1, metrics.layers)
+}
+
+func TestCacheHeaderRevalidated(t *testing.T) {
+	require := require.New(t)
+
+	host := newUpstream(t, newTestImage(t))
+	_, s := newAdminServer(t, host, Options{ManifestTTL: time.Nanosecond})
+
+	path := "/v2/" + host + "/test/repo/manifests/v1"
+	for _, want := range []string{"MISS", "HIT"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(http.StatusOK, w.Code, w.Body.String())
+		// an unchanged tag is still a hit after revalidating upstream
+		require.Equal(want, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestManifestMemoryCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    int
+		wantStatus int
+	}{
+		{"default", 0, http.StatusOK},
+		{"disabled", -1, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			img := newTestImage(t)
+			digest := sha256Digest(img.manifest)
+			_, err = l.WriteBlob(digest, bytes.NewReader(img.manifest))
+			require.NoError(err)
+			require.NoError(l.SetTag("quay.io/test/repo:v1", store.Descriptor{
+				MediaType: "application/vnd.oci.image.manifest.v1+json",
+				Digest:    digest,
+				Size:      int64(len(img.manifest)),
+			}))
+
+			s := New(l, oci.NewClient(), logging.Nop(), Options{ManifestCacheEntries: tt.entries})
+			get := func() *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/quay.io/test/repo/manifests/v1", nil))
+				return w
+			}
+
+			w := get()
+			require.Equal(http.StatusOK, w.Code, w.Body.String())
+			require.Equal(string(img.manifest), w.Body.String())
+
+			// with the blob gone, only the in-memory copy can serve it
+			path := filepath.Join(l.Root(), store.BlobsDir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+			require.NoError(os.Remove(path))
+
+			w = get()
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus == http.StatusOK {
+				require.Equal(string(img.manifest), w.Body.String())
+				require.Equal("application/vnd.oci.image.manifest.v1+json", w.Header().Get("Content-Type"))
+				require.Equal(digest, w.Header().Get("Docker-Content-Digest"))
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"manifest miss", http.MethodGet, "/v2/quay.io/test/other/manifests/v1", "", http.StatusNotFound},
+		{"manifest miss by digest", http.MethodGet, "/v2/quay.io/test/repo/manifests/sha256:" + strings.Repeat("0", 64), "", http.StatusNotFound},
+		{"blob miss", http.MethodGet, "/v2/quay.io/test/repo/blobs/sha256:" + strings.Repeat("0", 64), "", http.StatusNotFound},
+		{"stale tag hit", http.MethodGet, "/v2/quay.io/test/repo/manifests/v1", "", http.StatusOK},
+		{"push", http.MethodPost, "/v2/quay.io/test/repo/blobs/uploads/", "", http.StatusMethodNotAllowed},
+		{"admin pull", http.MethodPost, "/admin/pull", `{"image":"quay.io/test/other:v1"}`, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			// every upstream request goes through this proxy, whatever the host
+			var upstream int
+			counter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				upstream++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			t.Cleanup(counter.Close)
+			proxyURL, err := url.Parse(counter.URL)
+			require.NoError(err)
+
+			client := oci.NewClient()
+			client.Config().SetProxy(proxyURL)
+
+			l, err := store.Open(t.TempDir())
+			require.NoError(err)
+			img := newTestImage(t)
+			digest := sha256Digest(img.manifest)
+			_, err = l.WriteBlob(digest, bytes.NewReader(img.manifest))
+			require.NoError(err)
+			require.NoError(l.SetTag("quay.io/test/repo:v1", store.Descriptor{
+				MediaType: "application/vnd.oci.image.manifest.v1+json",
+				Digest:    digest,
+				Size:      int64(len(img.manifest)),
+			}))
+
+			s := New(l, client, logging.Nop(), Options{ReadOnly: true, Writable: true, ManifestTTL: time.Nanosecond})
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			require.Equal(tt.wantStatus, w.Code, w.Body.String())
+			require.Zero(upstream)
+		})
+	}
+}
+
+func TestServeIndexes(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	otherArch := "arm64"
+	if runtime.GOARCH == otherArch {
+		otherArch = "amd64"
+	}
+	images := map[string]testImage{}
+	var list oci.ManifestList
+	list.SchemaVersion, list.MediaType = 2, "application/vnd.oci.image.index.v1+json"
+	for _, arch := range []string{runtime.GOARCH, otherArch} {
+		img := testImage{
+			config: []byte(`{"os":"` + runtime.GOOS + `","architecture":"` + arch + `"}`),
+			layer:  bytes.Repeat([]byte(arch), 1000),
+		}
+		data, err := json.Marshal(oci.Manifest{
+			SchemaVersion: 2,
+			MediaType:     "application/vnd.oci.image.manifest.v1+json",
+			Config:        oci.Blob{MediaType: "application/vnd.oci.image.config.v1+json", Digest: sha256Digest(img.config), Size: int64(len(img.config))},
+			Layers:        []oci.Blob{{MediaType: store.MediaTypeLayerGzip, Digest: sha256Digest(img.layer), Size: int64(len(img.layer))}},
+		})
+		require.NoError(err)
+		// indented, so a re-encoded copy wouldn't match the digest
+		var indented bytes.Buffer
+		require.NoError(json.Indent(&indented, data, "", "  "))
+		img.manifest = indented.Bytes()
+		images[arch] = img
+		list.Manifests = append(list.Manifests, oci.Platform{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    sha256Digest(img.manifest),
+			Size:      int64(len(img.manifest)),
+			Platform:  oci.PlatformSpec{OS: runtime.GOOS, Architecture: arch},
+		})
+	}
+	index, err := json.MarshalIndent(list, "", "  ")
+	require.NoError(err)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref, isManifest := strings.CutPrefix(r.URL.Path, "/v2/test/repo/manifests/")
+		if isManifest && ref == "v1" {
+			w.Header().Set("Content-Type", list.MediaType)
+			_, _ = w.Write(index)
+			return
+		}
+		for _, img := range images {
+			switch {
+			case isManifest && ref == sha256Digest(img.manifest):
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				_, _ = w.Write(img.manifest)
+				return
+			case r.URL.Path == "/v2/test/repo/blobs/"+sha256Digest(img.config):
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img.config))
+				return
+			case r.URL.Path == "/v2/test/repo/blobs/"+sha256Digest(img.layer):
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img.layer))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	l, s := newAdminServer(t, upstreamHost, Options{ServeIndexes: true})
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c := oci.NewClient()
+	c.SetInsecure(host, true)
+	repo := upstreamHost + "/test/repo"
+
+	// the tag is answered with the index as upstream served it
+	data, mediaType, err := c.GetRawManifest(ctx, host, repo, "v1")
+	require.NoError(err)
+	require.Equal(index, data)
+	require.Equal(list.MediaType, mediaType)
+	img, err := l.FindByRef(repo + ":v1")
+	require.NoError(err)
+	require.Equal(sha256Digest(index), img.Digest)
+
+	// the client picks a platform the proxy didn't pull for itself
+	other := images[otherArch]
+	require.False(l.HasBlob(sha256Digest(other.manifest)))
+	manifest, err := c.GetPlatformManifest(ctx, host, repo, "v1", runtime.GOOS+"/"+otherArch)
+	require.NoError(err)
+	require.Equal(sha256Digest(other.layer), manifest.Layers[0].Digest)
+
+	data, _, err = c.GetRawManifest(ctx, host, repo, sha256Digest(other.manifest))
+	require.NoError(err)
+	require.Equal(other.manifest, data)
+
+	r, err := c.GetBlob(ctx, host, repo, sha256Digest(other.layer))
+	require.NoError(err)
+	defer r.Close()
+	data, err = io.ReadAll(r)
+	require.NoError(err)
+	require.Equal(other.layer, data)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// CheckResult describes an image that Check found pullable.
+type CheckResult struct {
+	Digest    string `json:"digest"`
+	Layers    int    `json:"layers"`
+	TotalSize int64  `json:"total_bytes"`
+	// RangeSupported is whether the registry serves the first layer in
+	// ranges. Without it layers are downloaded whole and can't resume.
+	RangeSupported bool `json:"range_supported"`
+}
+
+// Check confirms image could be pulled without downloading it: the manifest
+// resolves for the configured platform and the first layer can be read. It
+// stores nothing in the layout.
+func (p *Puller) Check(ctx context.Context, image string) (*CheckResult, error) {
+	imageRef, err := oci.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("parse reference: %w", err)
+	}
+	registry, repo := imageRef.Registry, imageRef.Repository
+
+	manifest, err := p.client.GetPlatformManifest(ctx, registry, repo, imageRef.Ref(), p.opts.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("get manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	if err := p.checkLimits(manifest); err != nil {
+		return nil, err
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("marshal manifest: %w", err)
+	}
+
+	result := &CheckResult{
+		Digest: digest.FromBytes(manifestData).String(),
+		Layers: len(manifest.Layers),
+	}
+	for _, layer := range manifest.Layers {
+		result.TotalSize += layer.Size
+	}
+	if len(manifest.Layers) == 0 {
+		return result, nil
+	}
+
+	layer := manifest.Layers[0]
+	if _, err := p.client.StatBlob(ctx, registry, repo, layer.Digest); err != nil {
+		return nil, fmt.Errorf("layer 0: %w", err)
+	}
+
+	size, supported, err := p.client.ProbeRange(ctx, registry, repo, layer.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("layer 0: range check: %w", err)
+	}
+	if supported && size >= 0 && size != layer.Size {
+		return nil, fmt.Errorf("%w: layer 0: manifest declares %d bytes, registry reports %d",
+			ErrSizeMismatch, layer.Size, size)
+	}
+	result.RangeSupported = supported
+
+	return result, nil
+}
+
+// CheckAll checks images concurrently, running at most concurrency checks at
+// once. Every image is checked; failures are returned per image.
+func (p *Puller) CheckAll(ctx context.Context, images []string, concurrency int) ([]*CheckResult, []error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*CheckResult, len(images))
+	errs := make([]error, len(images))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i], errs[i] = p.Check(ctx, image)
+		}()
+	}
+	wg.Wait()
+
+	return results, errs
+}
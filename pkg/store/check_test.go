@@ -0,0 +1,57 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func TestCheckAll(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"check"}`), layer)
+
+	// a private registry rejects every request without credentials
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(private.Close)
+	privateHost := strings.TrimPrefix(private.URL, "http://")
+
+	client := reg.client()
+	client.SetInsecure(privateHost, true)
+	client.SetRetryPolicy(oci.RetryPolicy{})
+
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(err)
+
+	puller := NewPuller(l, client, logging.Nop(), PullOptions{})
+	results, errs := puller.CheckAll(context.Background(), []string{reg.image(), privateHost + "/test/private:v1"}, 2)
+
+	require.NoError(errs[0])
+	require.Equal(reg.manifestDigest, results[0].Digest)
+	require.Equal(1, results[0].Layers)
+	require.Equal(int64(len(layer)), results[0].TotalSize)
+	require.True(results[0].RangeSupported)
+
+	require.Nil(results[1])
+	require.True(errors.Is(errs[1], oci.ErrUnauthorized), "got %v", errs[1])
+
+	// nothing was downloaded
+	entries, err := os.ReadDir(filepath.Join(dir, BlobsDir, "sha256"))
+	require.NoError(err)
+	require.Empty(entries)
+}
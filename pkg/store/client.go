@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"io"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// RangeFetcher fetches byte ranges of a blob URL. *oci.Fetcher implements
+// it; tests can substitute one without HTTP.
+type RangeFetcher interface {
+	// FetchRange returns bytes [start, end) of url.
+	FetchRange(ctx context.Context, url string, start, end int64) ([]byte, error)
+}
+
+// BlobClient is the registry access a Puller needs. *oci.Client implements
+// it; tests can substitute one without HTTP.
+type BlobClient interface {
+	GetPlatformManifest(ctx context.Context, registry, repo, ref, platform string) (*oci.Manifest, error)
+	GetBlob(ctx context.Context, registry, repo, digest string) (io.ReadCloser, error)
+	// GetBlobRange returns bytes [start, end] of a blob; end is inclusive.
+	GetBlobRange(ctx context.Context, registry, repo, digest string, start, end int64) (io.ReadCloser, error)
+	StatBlob(ctx context.Context, registry, repo, digest string) (int64, error)
+	// ProbeRange reports whether the registry serves the blob in ranges
+	// and its total size, or -1 if unknown.
+	ProbeRange(ctx context.Context, registry, repo, digest string) (int64, bool, error)
+}
+
+// ManifestClient is a BlobClient that can also fetch manifests as they're
+// stored, which a Puller needs to keep image indexes.
+type ManifestClient interface {
+	BlobClient
+	// GetRawManifest returns a manifest or index unresolved, and its media
+	// type.
+	GetRawManifest(ctx context.Context, registry, repo, ref string) ([]byte, string, error)
+}
+
+var (
+	_ RangeFetcher   = (*oci.Fetcher)(nil)
+	_ ManifestClient = (*oci.Client)(nil)
+)
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupportedMediaType is returned for a layer media type whose
+// compression isn't known.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+const (
+	// MediaTypeLayer is an uncompressed OCI layer.
+	MediaTypeLayer = "application/vnd.oci.image.layer.v1.tar"
+	// MediaTypeLayerGzip is a gzip-compressed OCI layer.
+	MediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	// MediaTypeLayerZstd is a zstd-compressed OCI layer.
+	MediaTypeLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+	// MediaTypeDockerLayerGzip is a gzip-compressed Docker layer.
+	MediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Compression identifies a layer compression algorithm.
+type Compression int
+
+const (
+	// CompressionNone is an uncompressed tar.
+	CompressionNone Compression = iota
+	// CompressionGzip is gzip.
+	CompressionGzip
+	// CompressionZstd is zstd.
+	CompressionZstd
+)
+
+// CompressionFor returns the compression used by a layer media type.
+func CompressionFor(mediaType string) (Compression, error) {
+	switch {
+	case mediaType == MediaTypeLayer:
+		return CompressionNone, nil
+	case strings.HasSuffix(mediaType, "+gzip"), strings.HasSuffix(mediaType, ".tar.gzip"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(mediaType, "+zstd"):
+		return CompressionZstd, nil
+	case strings.HasSuffix(mediaType, ".tar"):
+		return CompressionNone, nil
+	}
+	return CompressionNone, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+}
+
+// Decompress wraps r with a decompressor chosen by media type.
+func Decompress(r io.Reader, mediaType string) (io.ReadCloser, error) {
+	c, err := CompressionFor(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		return gz, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// OpenBlobDecompressed opens a layer blob and returns its uncompressed content.
+// Blobs are stored compressed, so this decompresses on demand only.
+func (l *Layout) OpenBlobDecompressed(digest, mediaType string) (io.ReadCloser, error) {
+	f, err := l.OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	dr, err := Decompress(f, mediaType)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &decompressReader{ReadCloser: dr, blob: f}, nil
+}
+
+// decompressReader closes both the decompressor and the underlying blob.
+type decompressReader struct {
+	io.ReadCloser
+	blob io.Closer
+}
+
+func (d *decompressReader) Close() error {
+	err := d.ReadCloser.Close()
+	return errors.Join(err, d.blob.Close())
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestOpenBlobDecompressed(t *testing.T) {
+	content := []byte("layer tar content for decompression")
+
+	tests := []struct {
+		name      string
+		mediaType string
+		blob      []byte
+	}{
+		{"oci gzip", MediaTypeLayerGzip, gzipBytes(t, content)},
+		{"docker gzip", MediaTypeDockerLayerGzip, gzipBytes(t, content)},
+		{"oci zstd", MediaTypeLayerZstd, zstdBytes(t, content)},
+		{"uncompressed", MediaTypeLayer, content},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			digest := testDigest(tt.name)
+			_, err = l.WriteBlob(digest, bytes.NewReader(tt.blob))
+			require.NoError(err)
+
+			r, err := l.OpenBlobDecompressed(digest, tt.mediaType)
+			require.NoError(err)
+			defer r.Close()
+
+			got, err := io.ReadAll(r)
+			require.NoError(err)
+			require.Equal(content, got)
+		})
+	}
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	require := require.New(t)
+
+	_, err := Decompress(bytes.NewReader(nil), "application/vnd.oci.image.config.v1+json")
+	require.True(errors.Is(err, ErrUnsupportedMediaType))
+}
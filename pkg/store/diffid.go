@@ -0,0 +1,210 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+var ErrDiffIDMismatch = errors.New("diff id mismatch")
+
+// DiffIDsFile maps compressed layer digests to their diff ids. It lives in
+// the layout's .fray directory and is filled by PullOptions.ComputeDiffIDs.
+const DiffIDsFile = "diffids.json"
+
+// ImageConfig is the subset of an OCI image config needed for verification,
+// extraction and inspection.
+type ImageConfig struct {
+	Created      *time.Time `json:"created,omitempty"`
+	OS           string     `json:"os"`
+	Architecture string     `json:"architecture"`
+	Variant      string     `json:"variant,omitempty"`
+	Config       struct {
+		User       string   `json:"User,omitempty"`
+		Env        []string `json:"Env,omitempty"`
+		Entrypoint []string `json:"Entrypoint,omitempty"`
+		Cmd        []string `json:"Cmd,omitempty"`
+		WorkingDir string   `json:"WorkingDir,omitempty"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// Platform returns os/architecture[/variant], or "" if the config has no os.
+func (c *ImageConfig) Platform() string {
+	if c.OS == "" {
+		return ""
+	}
+	platform := c.OS + "/" + c.Architecture
+	if c.Variant != "" {
+		platform += "/" + c.Variant
+	}
+	return platform
+}
+
+// DiffID computes the uncompressed SHA-256 digest of a layer blob.
+func (l *Layout) DiffID(d, mediaType string) (string, error) {
+	r, err := l.OpenBlobDecompressed(d, mediaType)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := digest.Canonical.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("decompress %s: %w", d, err)
+	}
+
+	return digest.Canonical.FromHash(h).String(), nil
+}
+
+// VerifyDiffIDs checks each layer's uncompressed digest against the config's rootfs.diff_ids.
+func (l *Layout) VerifyDiffIDs(manifest *oci.Manifest) error {
+	data, err := l.ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("%w: config has %d diff ids, manifest has %d layers",
+			ErrDiffIDMismatch, len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	recorded, err := l.DiffIDs()
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range manifest.Layers {
+		diffID, ok := recorded[layer.Digest]
+		if !ok {
+			diffID, err = l.DiffID(layer.Digest, layer.MediaType)
+			if err != nil {
+				return fmt.Errorf("layer %d: %w", i, err)
+			}
+		}
+		if diffID != config.RootFS.DiffIDs[i] {
+			return fmt.Errorf("%w: layer %d expected %s, got %s",
+				ErrDiffIDMismatch, i, config.RootFS.DiffIDs[i], diffID)
+		}
+	}
+
+	return nil
+}
+
+// ComputeDiffIDs records the diff id of each of manifest's layers that
+// DiffIDsFile doesn't list yet.
+func (l *Layout) ComputeDiffIDs(manifest *oci.Manifest) error {
+	recorded, err := l.DiffIDs()
+	if err != nil {
+		return err
+	}
+
+	computed := make(map[string]string)
+	for i, layer := range manifest.Layers {
+		if _, ok := recorded[layer.Digest]; ok {
+			continue
+		}
+		diffID, err := l.DiffID(layer.Digest, layer.MediaType)
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+		computed[layer.Digest] = diffID
+	}
+
+	if len(computed) == 0 {
+		return nil
+	}
+	return l.recordDiffIDs(computed)
+}
+
+// DiffIDs returns the recorded diff ids by compressed layer digest. A layout
+// without DiffIDsFile has none.
+func (l *Layout) DiffIDs() (map[string]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.readDiffIDs()
+}
+
+func (l *Layout) readDiffIDs() (map[string]string, error) {
+	data, err := os.ReadFile(l.diffIDsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read diff ids: %w", err)
+	}
+
+	ids := make(map[string]string)
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("parse diff ids: %w", err)
+	}
+	return ids, nil
+}
+
+// recordDiffIDs merges ids into DiffIDsFile, replacing it with a rename so
+// concurrent readers never see it half written.
+func (l *Layout) recordDiffIDs(ids map[string]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	all, err := l.readDiffIDs()
+	if err != nil {
+		return err
+	}
+	for d, diffID := range ids {
+		all[d] = diffID
+	}
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := l.diffIDsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write diff ids: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write diff ids: %w", err)
+	}
+	return nil
+}
+
+func (l *Layout) diffIDsPath() string {
+	return filepath.Join(l.root, MetadataDir, DiffIDsFile)
+}
+
+// configPlatform returns os/architecture[/variant] from an image config, or
+// "" when the config can't be read.
+func (l *Layout) configPlatform(digest string) string {
+	data, err := l.ReadBlob(digest)
+	if err != nil {
+		return ""
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ""
+	}
+	return config.Platform()
+}
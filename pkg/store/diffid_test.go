@@ -0,0 +1,70 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func TestVerifyDiffIDs(t *testing.T) {
+	layerContent := []byte("uncompressed layer tar")
+	sum := sha256.Sum256(layerContent)
+	goodDiffID := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		diffIDs []string
+		wantErr bool
+	}{
+		{"matching", []string{goodDiffID}, false},
+		{"mismatching", []string{"sha256:" + hex.EncodeToString(make([]byte, 32))}, true},
+		{"count mismatch", []string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			layerBlob := gzipBytes(t, layerContent)
+			layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layerBlob))
+			_, err = l.WriteBlob(layerDigest, bytes.NewReader(layerBlob))
+			require.NoError(err)
+
+			quoted := make([]string, len(tt.diffIDs))
+			for i, d := range tt.diffIDs {
+				quoted[i] = fmt.Sprintf("%q", d)
+			}
+			config := []byte(fmt.Sprintf(`{"rootfs":{"type":"layers","diff_ids":[%s]}}`, strings.Join(quoted, ",")))
+			configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+			_, err = l.WriteBlob(configDigest, bytes.NewReader(config))
+			require.NoError(err)
+
+			manifest := &oci.Manifest{
+				Config: oci.Blob{Digest: configDigest},
+				Layers: []oci.Blob{{MediaType: MediaTypeLayerGzip, Digest: layerDigest}},
+			}
+
+			err = l.VerifyDiffIDs(manifest)
+			if tt.wantErr {
+				require.True(errors.Is(err, ErrDiffIDMismatch))
+				return
+			}
+			require.NoError(err)
+
+			diffID, err := l.DiffID(layerDigest, MediaTypeLayerGzip)
+			require.NoError(err)
+			require.Equal(goodDiffID, diffID)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// Export writes the images indexed under refs to w as an OCI image layout
+// in a tar archive, the oci-archive format skopeo and docker load read.
+// The archive's index names each image by its reference, and holds every
+// blob the images reach, so all of them must be present. Entries are dated
+// to the epoch, so the same images always export to the same bytes.
+func (l *Layout) Export(w io.Writer, refs ...string) error {
+	index := &Index{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	for _, ref := range refs {
+		img, err := l.FindByRef(ref)
+		if err != nil {
+			return err
+		}
+		index.Manifests = append(index.Manifests, Descriptor{
+			MediaType:   img.MediaType,
+			Digest:      img.Digest,
+			Size:        img.Size,
+			Platform:    img.Platform,
+			Annotations: map[string]string{AnnotationRefName: img.Ref},
+		})
+	}
+
+	// a missing blob fails the export before anything is written
+	blobs := slices.Sorted(maps.Keys(l.referencedBlobs(index)))
+	for _, d := range blobs {
+		if !l.HasBlob(d) {
+			return fmt.Errorf("%w: missing blob %s", ErrLayerIncomplete, d)
+		}
+	}
+
+	layoutData, err := json.Marshal(OCILayout{ImageLayoutVersion: OCILayoutVersion})
+	if err != nil {
+		return err
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTarFile(tw, LayoutFile, layoutData); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, IndexFile, indexData); err != nil {
+		return err
+	}
+
+	dirs := map[string]bool{}
+	for _, d := range blobs {
+		parsed, err := digest.Parse(d)
+		if err != nil {
+			return err
+		}
+		dir := BlobsDir + "/" + string(parsed.Algorithm()) + "/"
+		for _, name := range []string{BlobsDir + "/", dir} {
+			if dirs[name] {
+				continue
+			}
+			dirs[name] = true
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755, ModTime: exportTime}); err != nil {
+				return err
+			}
+		}
+		if err := l.exportBlob(tw, d, dir+parsed.Encoded()); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// exportBlob copies blob d into tw as name.
+func (l *Layout) exportBlob(tw *tar.Writer, d, name string) error {
+	path, err := l.blobPath(d)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("export %s: %w", d, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: fi.Size(), ModTime: exportTime}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("export %s: %w", d, err)
+	}
+	return nil
+}
+
+// exportTime dates every entry Export writes.
+var exportTime = time.Unix(0, 0)
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: exportTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		refs    []string
+		remove  func(l *Layout)
+		wantErr error
+	}{
+		{name: "one image", refs: []string{"quay.io/test/app:v1"}},
+		{name: "two images", refs: []string{"quay.io/test/app:v1", "quay.io/test/other:v1"}},
+		{name: "unknown image", refs: []string{"quay.io/test/app:v2"}, wantErr: ErrImageNotFound},
+		{
+			name: "missing layer",
+			refs: []string{"quay.io/test/app:v1"},
+			remove: func(l *Layout) {
+				img, _ := l.Inspect("quay.io/test/app:v1")
+				path, _ := l.blobPath(img.Manifest.Layers[0].Digest)
+				os.Remove(path)
+			},
+			wantErr: ErrLayerIncomplete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			newRootfsImage(t, l, "quay.io/test/app:v1", "linux",
+				[]tarEntry{{name: "etc/hostname", typeflag: tar.TypeReg, body: "app"}})
+			newRootfsImage(t, l, "quay.io/test/other:v1", "linux",
+				[]tarEntry{{name: "etc/hostname", typeflag: tar.TypeReg, body: "other"}})
+			if tt.remove != nil {
+				tt.remove(l)
+			}
+
+			var buf bytes.Buffer
+			err = l.Export(&buf, tt.refs...)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+				require.Zero(buf.Len())
+				return
+			}
+			require.NoError(err)
+
+			// unpacked, the archive is a layout holding just these images
+			dir := t.TempDir()
+			tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(err)
+				require.False(strings.Contains(hdr.Name, ".."))
+				if hdr.Typeflag == tar.TypeDir {
+					require.NoError(os.MkdirAll(filepath.Join(dir, hdr.Name), 0755))
+					continue
+				}
+				data, err := io.ReadAll(tr)
+				require.NoError(err)
+				if name, ok := strings.CutPrefix(hdr.Name, "blobs/sha256/"); ok {
+					require.Equal("sha256:"+name, digest.FromBytes(data).String())
+				}
+				require.NoError(os.WriteFile(filepath.Join(dir, hdr.Name), data, 0644))
+			}
+
+			exported, err := Open(dir)
+			require.NoError(err)
+			images, err := exported.Images()
+			require.NoError(err)
+			require.Len(images, len(tt.refs))
+			for _, ref := range tt.refs {
+				img, err := exported.Inspect(ref)
+				require.NoError(err)
+				for _, layer := range img.Manifest.Layers {
+					require.True(exported.HasBlob(layer.Digest))
+				}
+			}
+
+			// exporting again gives the same archive
+			var again bytes.Buffer
+			require.NoError(l.Export(&again, tt.refs...))
+			require.Equal(buf.Bytes(), again.Bytes())
+		})
+	}
+}
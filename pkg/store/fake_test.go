@@ -0,0 +1,128 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// fakeFetcher serves ranges of in-memory blobs keyed by URL. fail, when
+// set, can fail a request by its start offset.
+type fakeFetcher struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	fail   func(start int64) error
+	starts []int64
+}
+
+func (f *fakeFetcher) FetchRange(_ context.Context, url string, start, end int64) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.starts = append(f.starts, start)
+	if f.fail != nil {
+		if err := f.fail(start); err != nil {
+			return nil, err
+		}
+	}
+	data, ok := f.blobs[url]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", oci.ErrNotFound, url)
+	}
+	return bytes.Clone(data[start:min(end, int64(len(data)))]), nil
+}
+
+// fakeClient serves one manifest and its blobs from memory, whatever the
+// registry and repository. failRange, when set, can fail a range request
+// by its start offset.
+type fakeClient struct {
+	mu        sync.Mutex
+	manifest  *oci.Manifest
+	blobs     map[string][]byte
+	noRange   bool
+	failRange func(digest string, start int64) error
+	ranges    []string
+}
+
+// newFakeClient returns a client serving an image of config and layers.
+func newFakeClient(config []byte, layers ...[]byte) *fakeClient {
+	c := &fakeClient{blobs: make(map[string][]byte)}
+
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	c.blobs[configDigest] = config
+	c.manifest = &oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: oci.Blob{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+	}
+	for _, layer := range layers {
+		d := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+		c.blobs[d] = layer
+		c.manifest.Layers = append(c.manifest.Layers, oci.Blob{MediaType: MediaTypeLayerGzip, Digest: d, Size: int64(len(layer))})
+	}
+	return c
+}
+
+func (c *fakeClient) GetPlatformManifest(context.Context, string, string, string, string) (*oci.Manifest, error) {
+	m := *c.manifest
+	return &m, nil
+}
+
+func (c *fakeClient) GetBlob(_ context.Context, _, _, digest string) (io.ReadCloser, error) {
+	data, err := c.blob(digest)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *fakeClient) GetBlobRange(_ context.Context, _, _, digest string, start, end int64) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.ranges = append(c.ranges, fmt.Sprintf("%d-%d", start, end))
+	fail := c.failRange
+	c.mu.Unlock()
+
+	if fail != nil {
+		if err := fail(digest, start); err != nil {
+			return nil, err
+		}
+	}
+	data, err := c.blob(digest)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data[start:min(end+1, int64(len(data)))])), nil
+}
+
+func (c *fakeClient) StatBlob(_ context.Context, _, _, digest string) (int64, error) {
+	data, err := c.blob(digest)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (c *fakeClient) ProbeRange(_ context.Context, _, _, digest string) (int64, bool, error) {
+	data, err := c.blob(digest)
+	if err != nil || c.noRange {
+		return -1, false, nil
+	}
+	return int64(len(data)), true, nil
+}
+
+func (c *fakeClient) blob(digest string) ([]byte, error) {
+	data, ok := c.blobs[digest]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", oci.ErrNotFound, digest)
+	}
+	return data, nil
+}
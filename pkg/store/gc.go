@@ -0,0 +1,193 @@
+package store
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GCResult reports what GC or Evict removed.
+type GCResult struct {
+	// Images is the number of index entries Evict removed.
+	Images int   `json:"images"`
+	Blobs  int   `json:"blobs"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// GC removes blobs that no indexed image references. Blobs modified within
+// grace are kept so a pull that has written layers but not yet indexed its
+// manifest isn't collected from under it.
+func (l *Layout) GC(grace time.Duration) (GCResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result GCResult
+
+	index, err := l.readIndex()
+	if err != nil {
+		return result, err
+	}
+	refs := l.referencedBlobs(index)
+	cutoff := time.Now().Add(-grace)
+
+	err = l.walkBlobs(func(d, path string, info fs.FileInfo) error {
+		if refs[d] || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		result.Blobs++
+		result.Bytes += info.Size()
+		return nil
+	})
+	return result, err
+}
+
+// referencedBlobs returns every blob reachable from the index: manifests,
+// nested indexes, configs and layers.
+func (l *Layout) referencedBlobs(index *Index) map[string]bool {
+	refs := make(map[string]bool)
+
+	var walk func(d string)
+	walk = func(d string) {
+		if refs[d] {
+			return
+		}
+		refs[d] = true
+
+		path, err := l.blobPath(d)
+		if err != nil {
+			return
+		}
+		data, err := readPreservingAccess(path)
+		if err != nil {
+			return
+		}
+
+		var m struct {
+			Config    *Descriptor  `json:"config"`
+			Layers    []Descriptor `json:"layers"`
+			Manifests []Descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return
+		}
+		if m.Config != nil {
+			refs[m.Config.Digest] = true
+		}
+		for _, layer := range m.Layers {
+			refs[layer.Digest] = true
+		}
+		for _, nested := range m.Manifests {
+			walk(nested.Digest)
+		}
+	}
+
+	for _, m := range index.Manifests {
+		walk(m.Digest)
+	}
+	return refs
+}
+
+// readPreservingAccess reads path and restores its access time, so walking
+// manifests doesn't reset the LRU order Evict relies on.
+func readPreservingAccess(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Chtimes(path, accessTime(fi), fi.ModTime())
+	return data, nil
+}
+
+// Evict removes the least recently accessed images, collecting their blobs,
+// until the blobs fit in maxBytes. Images accessed within grace are never
+// evicted, so the result may still exceed maxBytes.
+func (l *Layout) Evict(maxBytes int64, grace time.Duration) (GCResult, error) {
+	result, err := l.GC(grace)
+	if err != nil {
+		return result, err
+	}
+
+	cutoff := time.Now().Add(-grace)
+	for {
+		stats, err := l.blobStats()
+		if err != nil {
+			return result, err
+		}
+		if stats.TotalSize <= maxBytes {
+			return result, nil
+		}
+
+		images, err := l.Images()
+		if err != nil {
+			return result, err
+		}
+		images = slices.DeleteFunc(images, func(img ImageInfo) bool {
+			return img.LastAccess.After(cutoff)
+		})
+		if len(images) == 0 {
+			return result, nil
+		}
+
+		oldest := slices.MinFunc(images, func(a, b ImageInfo) int {
+			return a.LastAccess.Compare(b.LastAccess)
+		})
+		if err := l.removeImage(oldest); err != nil {
+			return result, err
+		}
+		result.Images++
+
+		collected, err := l.GC(grace)
+		result.Blobs += collected.Blobs
+		result.Bytes += collected.Bytes
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// blobStats totals complete blobs across every algorithm directory.
+func (l *Layout) blobStats() (Stats, error) {
+	var stats Stats
+	err := l.WalkBlobs(func(_ string, size int64) error {
+		stats.BlobCount++
+		stats.TotalSize += size
+		return nil
+	})
+	return stats, err
+}
+
+// completeBlob reports whether e is a finished blob rather than a partial
+// download or an in-progress temp file.
+func completeBlob(e os.DirEntry) bool {
+	name := e.Name()
+	return !e.IsDir() && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".partial")
+}
+
+// removeImage drops the index entry img was read from.
+func (l *Layout) removeImage(img ImageInfo) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	for i, m := range index.Manifests {
+		if m.Digest == img.Digest && normalizeRef(m.Annotations[AnnotationRefName]) == img.Ref {
+			index.Manifests = slices.Delete(index.Manifests, i, i+1)
+			return l.writeIndex(index)
+		}
+	}
+	return nil
+}
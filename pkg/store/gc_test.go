@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// addAgedImage stores a single-layer image under ref with every blob dated
+// age ago, and returns the layer digest.
+func addAgedImage(t *testing.T, l *Layout, ref, layer string, age time.Duration) string {
+	t.Helper()
+	require := require.New(t)
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"diff_ids":[]}}`)
+	configDigest := digest.FromBytes(config).String()
+	layerDigest := digest.FromBytes([]byte(layer)).String()
+
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"config":        Descriptor{Digest: configDigest, Size: int64(len(config))},
+		"layers":        []Descriptor{{Digest: layerDigest, Size: int64(len(layer))}},
+	})
+	require.NoError(err)
+	manifestDigest := digest.FromBytes(manifest).String()
+
+	when := time.Now().Add(-age)
+	for d, data := range map[string][]byte{configDigest: config, layerDigest: []byte(layer), manifestDigest: manifest} {
+		_, err := l.WriteBlob(d, strings.NewReader(string(data)))
+		require.NoError(err)
+		path, err := l.blobPath(d)
+		require.NoError(err)
+		require.NoError(os.Chtimes(path, when, when))
+	}
+
+	require.NoError(l.AddManifest(Descriptor{
+		Digest:      manifestDigest,
+		Size:        int64(len(manifest)),
+		Annotations: map[string]string{AnnotationRefName: ref},
+	}))
+	return layerDigest
+}
+
+func TestGC(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	layer := addAgedImage(t, l, "test/app:v1", "layer data", time.Hour)
+
+	orphan := testDigest("orphan")
+	fresh := testDigest("fresh")
+	_, err = l.WriteBlob(orphan, strings.NewReader("orphaned blob"))
+	require.NoError(err)
+	_, err = l.WriteBlob(fresh, strings.NewReader("fresh"))
+	require.NoError(err)
+	path, err := l.blobPath(orphan)
+	require.NoError(err)
+	old := time.Now().Add(-time.Hour)
+	require.NoError(os.Chtimes(path, old, old))
+
+	// a partial download is never collected
+	partial := filepath.Join(l.Root(), BlobsDir, "sha256", "deadbeef.partial")
+	require.NoError(os.WriteFile(partial, []byte("partial"), 0644))
+	require.NoError(os.Chtimes(partial, old, old))
+
+	result, err := l.GC(time.Minute)
+	require.NoError(err)
+	require.Equal(GCResult{Blobs: 1, Bytes: int64(len("orphaned blob"))}, result)
+
+	require.False(l.HasBlob(orphan))
+	require.True(l.HasBlob(fresh), "blobs within grace are kept")
+	require.True(l.HasBlob(layer))
+	require.FileExists(partial)
+}
+
+func TestEvict(t *testing.T) {
+	oldLayer := strings.Repeat("a", 1000)
+	newLayer := strings.Repeat("b", 1000)
+
+	tests := []struct {
+		name         string
+		maxBytes     int64
+		grace        time.Duration
+		wantImages   int
+		wantOldLayer bool
+	}{
+		{"under limit", 1 << 20, time.Minute, 0, true},
+		{"evicts least recently used", 1500, time.Minute, 1, false},
+		{"grace protects recent images", 0, 4 * time.Hour, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			oldDigest := addAgedImage(t, l, "test/app:old", oldLayer, 3*time.Hour)
+			newDigest := addAgedImage(t, l, "test/app:new", newLayer, time.Hour)
+
+			result, err := l.Evict(tt.maxBytes, tt.grace)
+			require.NoError(err)
+			require.Equal(tt.wantImages, result.Images)
+			require.Equal(tt.wantOldLayer, l.HasBlob(oldDigest))
+			require.True(l.HasBlob(newDigest))
+
+			if tt.wantImages > 0 {
+				// the layer and manifest go; the shared config stays
+				require.Equal(2, result.Blobs)
+				require.Greater(result.Bytes, int64(len(oldLayer)))
+
+				_, err := l.FindByRef("test/app:old")
+				require.ErrorIs(err, ErrImageNotFound)
+			}
+		})
+	}
+}
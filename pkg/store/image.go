@@ -0,0 +1,183 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrRefExists     = errors.New("reference already points to a different image")
+)
+
+// ImageInfo is a normalized view of an index entry.
+type ImageInfo struct {
+	// Ref is the normalized reference, or empty for untagged entries.
+	Ref       string
+	Digest    string
+	MediaType string
+	Size      int64
+	Platform  *Platform
+	// LastAccess is when the manifest blob was last read, where the
+	// filesystem records it, otherwise when it was written.
+	LastAccess time.Time
+}
+
+// Images returns every index entry as an ImageInfo.
+func (l *Layout) Images() ([]ImageInfo, error) {
+	index, err := l.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]ImageInfo, 0, len(index.Manifests))
+	for _, m := range index.Manifests {
+		images = append(images, l.imageInfo(m))
+	}
+	return images, nil
+}
+
+// FindByRef returns the image indexed under ref. Equivalent spellings of a
+// reference match, so "alpine" finds "docker.io/library/alpine:latest".
+func (l *Layout) FindByRef(ref string) (ImageInfo, error) {
+	index, err := l.GetIndex()
+	if err != nil {
+		return ImageInfo{}, err
+	}
+
+	for _, m := range index.Manifests {
+		if refMatches(m.Annotations[AnnotationRefName], ref) {
+			return l.imageInfo(m), nil
+		}
+	}
+	return ImageInfo{}, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+}
+
+// FindByDigest returns every index entry for a manifest digest.
+func (l *Layout) FindByDigest(digest string) ([]ImageInfo, error) {
+	index, err := l.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageInfo
+	for _, m := range index.Manifests {
+		if m.Digest == digest {
+			images = append(images, l.imageInfo(m))
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, digest)
+	}
+	return images, nil
+}
+
+func (l *Layout) imageInfo(d Descriptor) ImageInfo {
+	info := ImageInfo{
+		Ref:       normalizeRef(d.Annotations[AnnotationRefName]),
+		Digest:    d.Digest,
+		MediaType: d.MediaType,
+		Size:      d.Size,
+		Platform:  d.Platform,
+	}
+	if path, err := l.blobPath(d.Digest); err == nil {
+		if fi, err := os.Stat(path); err == nil {
+			info.LastAccess = accessTime(fi)
+		}
+	}
+	return info
+}
+
+// normalizeRef returns the fully qualified form of ref, or ref unchanged if
+// it does not parse.
+func normalizeRef(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	r, err := oci.ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return r.String()
+}
+
+// CopyImage indexes the image at srcRef under dstRef as well. Blobs are
+// shared, so nothing is copied. If dstRef already names a different image
+// the copy fails with ErrRefExists unless force is set.
+func (l *Layout) CopyImage(srcRef, dstRef string, force bool) error {
+	if _, err := oci.ParseReference(dstRef); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	src := -1
+	dst := -1
+	for i, m := range index.Manifests {
+		ref := m.Annotations[AnnotationRefName]
+		if src == -1 && refMatches(ref, srcRef) {
+			src = i
+		}
+		if dst == -1 && refMatches(ref, dstRef) {
+			dst = i
+		}
+	}
+
+	if src == -1 {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, srcRef)
+	}
+
+	desc := index.Manifests[src]
+	desc.Annotations = maps.Clone(desc.Annotations)
+	if desc.Annotations == nil {
+		desc.Annotations = make(map[string]string, 1)
+	}
+	desc.Annotations[AnnotationRefName] = dstRef
+
+	if dst != -1 {
+		if index.Manifests[dst].Digest == desc.Digest {
+			return nil
+		}
+		if !force {
+			return fmt.Errorf("%w: %s is %s", ErrRefExists, dstRef, index.Manifests[dst].Digest)
+		}
+		index.Manifests[dst] = desc
+		return l.writeIndex(index)
+	}
+
+	index.Manifests = append(index.Manifests, desc)
+	return l.writeIndex(index)
+}
+
+// refMatches compares an index ref annotation with a user supplied ref,
+// treating equivalent spellings such as "alpine" and
+// "docker.io/library/alpine:latest" as equal.
+func refMatches(annotation, ref string) bool {
+	if annotation == "" {
+		return false
+	}
+	if annotation == ref {
+		return true
+	}
+
+	a, err := oci.ParseReference(annotation)
+	if err != nil {
+		return false
+	}
+	b, err := oci.ParseReference(ref)
+	if err != nil {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// ImportResult reports what Import added to the layout.
+type ImportResult struct {
+	Blobs int `json:"blobs"`
+	// Linked is how many of Blobs were hardlinked rather than copied.
+	Linked int   `json:"linked"`
+	Bytes  int64 `json:"bytes"`
+	// Existing is blobs the layout already had.
+	Existing int `json:"existing"`
+	// Skipped is files whose digest couldn't be told or didn't match.
+	Skipped int `json:"skipped"`
+}
+
+// Import copies content-addressed blobs from a local store, such as a
+// containers/storage root or an additional image store, into the layout so
+// images already on the host needn't be pulled again. It is best effort:
+// files are recognized by name as sha256/<hex>, sha256:<hex> or
+// containers/storage big data keyed by a digest, and a file named manifest
+// by its content. Every blob is verified before it is added, and hardlinked
+// when dir is on the layout's filesystem. Imported manifests aren't
+// indexed; Reindex adds them.
+func (l *Layout) Import(dir string) (ImportResult, error) {
+	var result ImportResult
+
+	if _, err := os.Stat(dir); err != nil {
+		return result, fmt.Errorf("import: %w", err)
+	}
+	// a containers/storage root keeps image data in <driver>-images; its
+	// layer directories hold unpacked files that mustn't be mistaken for
+	// blobs
+	roots, _ := filepath.Glob(filepath.Join(dir, "*-images"))
+	if len(roots) == 0 {
+		roots = []string{dir}
+	}
+
+	for _, root := range roots {
+		if err := filepath.WalkDir(root, func(path string, e fs.DirEntry, err error) error {
+			return l.importEntry(path, e, err, &result)
+		}); err != nil {
+			return result, fmt.Errorf("import %s: %w", root, err)
+		}
+	}
+	return result, nil
+}
+
+// importEntry imports one file found by Import's walk.
+func (l *Layout) importEntry(path string, e fs.DirEntry, err error, result *ImportResult) error {
+	if err != nil {
+		// unreadable parts of another tool's store are skipped
+		if e != nil && e.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+	if !e.Type().IsRegular() {
+		return nil
+	}
+
+	d, ok := importDigest(path)
+	if !ok {
+		return nil
+	}
+	if d != "" && l.HasBlob(d) {
+		result.Existing++
+		return nil
+	}
+
+	n, linked, err := l.linkBlob(d, path)
+	switch {
+	case errors.Is(err, errBlobExists):
+		result.Existing++
+	case err != nil:
+		result.Skipped++
+	default:
+		result.Blobs++
+		result.Bytes += n
+		if linked {
+			result.Linked++
+		}
+	}
+	return nil
+}
+
+// errBlobExists reports a blob already in the layout once its digest was
+// computed.
+var errBlobExists = errors.New("blob exists")
+
+// importDigest tells the digest of a file from its name. An empty digest
+// with ok set means the file is a manifest to be digested by content.
+func importDigest(path string) (string, bool) {
+	name := filepath.Base(path)
+	parent := filepath.Base(filepath.Dir(path))
+
+	if name == "manifest" {
+		return "", true
+	}
+	if strings.HasPrefix(name, "=") {
+		key, err := base64.StdEncoding.DecodeString(name[1:])
+		if err != nil {
+			return "", false
+		}
+		name = strings.TrimPrefix(string(key), "manifest-")
+	} else if digest.Algorithm(parent).Available() {
+		name = parent + ":" + name
+	}
+
+	d, err := digest.Parse(name)
+	if err != nil {
+		return "", false
+	}
+	return string(d), true
+}
+
+// LinkBlob adds the file at path to the layout as blob d, such as a blob
+// assembled by a Store, without holding its data twice: the file is
+// hardlinked into place, or copied when path is on another filesystem. The
+// result is verified against d either way. It reports whether the file was
+// linked.
+func (l *Layout) LinkBlob(d, path string) (bool, error) {
+	if _, err := digest.Parse(d); err != nil {
+		return false, err
+	}
+	_, linked, err := l.linkBlob(d, path)
+	if errors.Is(err, errBlobExists) {
+		return false, nil
+	}
+	return linked, err
+}
+
+// linkBlob adds the file at path as blob d, or under its computed digest
+// when d is empty. It hardlinks the file into a private directory beside
+// the blobs, falling back to a copy, and verifies what was linked or copied
+// before renaming it into place. Only the rename holds the layout's lock,
+// so a slow copy doesn't stall other writers.
+func (l *Layout) linkBlob(d, path string) (int64, bool, error) {
+	blobDir := filepath.Join(l.root, BlobsDir, string(digest.Canonical))
+	if d != "" {
+		target, err := l.blobPath(d)
+		if err != nil {
+			return 0, false, err
+		}
+		if _, err := os.Stat(target); err == nil {
+			return 0, false, errBlobExists
+		}
+		blobDir = filepath.Dir(target)
+	}
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return 0, false, fmt.Errorf("create blob dir: %w", err)
+	}
+
+	// a link needs a name nothing else holds, so it's made in its own
+	// directory, which gc passes over like the temp files beside it
+	tmpDir, err := os.MkdirTemp(blobDir, ".blob-link-*")
+	if err != nil {
+		return 0, false, fmt.Errorf("create temp: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, "blob")
+
+	linked, err := linkOrCopy(path, tmpPath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	got, n, err := digestFile(tmpPath, digest.Digest(d).Algorithm())
+	if err != nil {
+		return 0, false, err
+	}
+	if d == "" {
+		d = string(got)
+	} else if string(got) != d {
+		return 0, false, fmt.Errorf("%w: %s: got %s", ErrDigestMismatch, d, got)
+	}
+
+	target, err := l.blobPath(d)
+	if err != nil {
+		return 0, false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := os.Stat(target); err == nil {
+		return 0, false, errBlobExists
+	}
+	if err := renameBlob(tmpPath, target); err != nil {
+		return 0, false, fmt.Errorf("rename blob: %w", err)
+	}
+	return n, linked, nil
+}
+
+// linkOrCopy hardlinks from to to, copying instead when a link can't be
+// made: across filesystems, on filesystems without hardlinks, or where
+// protected_hardlinks forbids linking another user's file.
+func linkOrCopy(from, to string) (bool, error) {
+	err := os.Link(from, to)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EPERM) &&
+		!errors.Is(err, syscall.ENOTSUP) && !errors.Is(err, syscall.EMLINK) {
+		return false, fmt.Errorf("link %s: %w", from, err)
+	}
+	return false, copyFile(from, to)
+}
+
+// digestFile hashes the file at path with algorithm, or the canonical one
+// if it's empty.
+func digestFile(path string, algorithm digest.Algorithm) (digest.Digest, int64, error) {
+	if algorithm == "" {
+		algorithm = digest.Canonical
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := algorithm.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	return algorithm.FromHash(h), n, nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("copy %s: %w", from, err)
+	}
+	return dst.Close()
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkBlobUnlocked(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	// a fifo links like a file, but verifying it stalls until it's written,
+	// like a slow copy
+	data := []byte("slow blob")
+	d := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	path := filepath.Join(t.TempDir(), "blob")
+	require.NoError(syscall.Mkfifo(path, 0644))
+
+	type linkResult struct {
+		linked bool
+		err    error
+	}
+	done := make(chan linkResult, 1)
+	go func() {
+		linked, err := l.LinkBlob(d, path)
+		done <- linkResult{linked, err}
+	}()
+
+	// once the fifo is linked, its verification is waiting on it
+	require.Eventually(func() bool {
+		links, _ := filepath.Glob(filepath.Join(l.root, BlobsDir, "sha256", ".blob-link-*"))
+		return len(links) > 0
+	}, 5*time.Second, time.Millisecond)
+
+	// the layout stays usable meanwhile
+	tagged := make(chan error, 1)
+	go func() {
+		tagged <- l.SetTag("quay.io/test/app:v1", Descriptor{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    testDigest("manifest"),
+			Size:      100,
+		})
+	}()
+	select {
+	case err := <-tagged:
+		require.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetTag blocked by an unfinished blob link")
+	}
+
+	// opening for write waits for the link's verification to read
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(err)
+	_, err = f.Write(data)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	result := <-done
+	require.NoError(result.err)
+	require.True(result.linked)
+	require.True(l.HasBlob(d))
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// bigDataName is how containers/storage names a big data file for a key
+// that isn't safe as a file name.
+func bigDataName(key string) string {
+	return "=" + base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+func TestImport(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	manifest := []byte(`{"schemaVersion":2,"config":{"digest":"` + digest.FromBytes(config).String() + `"},"layers":[]}`)
+	layer := []byte("layer content")
+
+	tests := []struct {
+		name string
+		// setup fills a store rooted at dir
+		setup        func(t *testing.T, dir string)
+		wantBlobs    [][]byte
+		wantMissing  [][]byte
+		wantSkipped  int
+		wantExisting int
+	}{
+		{
+			name: "containers storage",
+			setup: func(t *testing.T, dir string) {
+				image := filepath.Join(dir, "overlay-images", "0123abcd")
+				writeFile(t, filepath.Join(image, "manifest"), manifest)
+				writeFile(t, filepath.Join(image, bigDataName("manifest-"+digest.FromBytes(manifest).String())), manifest)
+				writeFile(t, filepath.Join(image, bigDataName(digest.FromBytes(config).String())), config)
+				writeFile(t, filepath.Join(dir, "overlay-images", "images.json"), []byte(`[]`))
+				// unpacked layer files are never blobs
+				writeFile(t, filepath.Join(dir, "overlay", "0123abcd", "diff", "manifest"), layer)
+			},
+			wantBlobs:    [][]byte{manifest, config},
+			wantMissing:  [][]byte{layer},
+			wantExisting: 1,
+		},
+		{
+			name: "blob directory",
+			setup: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "sha256", digest.FromBytes(layer).Encoded()), layer)
+				writeFile(t, filepath.Join(dir, digest.FromBytes(config).String()), config)
+				// named for content it doesn't hold
+				writeFile(t, filepath.Join(dir, "sha256", digest.FromBytes(manifest).Encoded()), []byte("corrupt"))
+			},
+			wantBlobs:   [][]byte{layer, config},
+			wantMissing: [][]byte{manifest},
+			wantSkipped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			src := t.TempDir()
+			tt.setup(t, src)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			result, err := l.Import(src)
+			require.NoError(err)
+			require.Equal(len(tt.wantBlobs), result.Blobs)
+			require.Equal(result.Blobs, result.Linked, "same filesystem blobs are hardlinked")
+			require.Equal(tt.wantSkipped, result.Skipped)
+			require.Equal(tt.wantExisting, result.Existing)
+
+			for _, blob := range tt.wantBlobs {
+				data, err := l.ReadBlob(digest.FromBytes(blob).String())
+				require.NoError(err)
+				require.Equal(blob, data)
+			}
+			for _, blob := range tt.wantMissing {
+				require.False(l.HasBlob(digest.FromBytes(blob).String()))
+			}
+
+			again, err := l.Import(src)
+			require.NoError(err)
+			require.Zero(again.Blobs)
+			require.Equal(len(tt.wantBlobs)+tt.wantExisting, again.Existing)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// maxConfigSize caps image configs read from a registry by InspectRemote.
+const maxConfigSize = 4 * 1024 * 1024
+
+// ImageInspect is an image's manifest and config, parsed and as stored.
+type ImageInspect struct {
+	Ref      string
+	Digest   string
+	Manifest oci.Manifest
+	Config   ImageConfig
+
+	RawManifest []byte
+	RawConfig   []byte
+}
+
+// Inspect reads the manifest and config of the image indexed under ref.
+// Layers don't need to be present.
+func (l *Layout) Inspect(ref string) (*ImageInspect, error) {
+	img, err := l.FindByRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	inspect := &ImageInspect{Ref: img.Ref, Digest: img.Digest}
+
+	inspect.RawManifest, err = l.ReadBlob(img.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if err := json.Unmarshal(inspect.RawManifest, &inspect.Manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if inspect.Manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("%w: %s is not an image manifest", ErrUnsupportedMediaType, img.MediaType)
+	}
+
+	inspect.RawConfig, err = l.ReadBlob(inspect.Manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(inspect.RawConfig, &inspect.Config); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	return inspect, nil
+}
+
+// InspectRemote fetches the manifest and config of image from its registry
+// without storing anything. Manifest lists resolve to the current platform.
+func InspectRemote(ctx context.Context, client *oci.Client, image string) (*ImageInspect, error) {
+	ref, err := client.Config().ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := client.GetManifest(ctx, ref.Registry, ref.Repository, ref.Ref())
+	if err != nil {
+		return nil, fmt.Errorf("get manifest: %w", err)
+	}
+
+	// the resolved manifest's digest isn't known without its original bytes
+	inspect := &ImageInspect{Ref: ref.String(), Manifest: *manifest}
+	inspect.RawManifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+
+	r, err := client.GetBlob(ctx, ref.Registry, ref.Repository, manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("get config: %w", err)
+	}
+	defer r.Close()
+
+	inspect.RawConfig, err = io.ReadAll(io.LimitReader(r, maxConfigSize))
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(inspect.RawConfig, &inspect.Config); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	return inspect, nil
+}
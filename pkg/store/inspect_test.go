@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+const inspectConfig = `{"created":"2024-05-01T12:00:00Z","os":"linux","architecture":"arm64","variant":"v8",` +
+	`"config":{"Env":["PATH=/usr/bin"],"Entrypoint":["/app"],"Cmd":["--serve"],"WorkingDir":"/srv"},` +
+	`"rootfs":{"type":"layers","diff_ids":[]}}`
+
+func TestInspect(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	layers := [][]byte{[]byte("first layer"), make([]byte, 4096)}
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config:        oci.Blob{Digest: writeTestBlob(t, l, []byte(inspectConfig)), Size: int64(len(inspectConfig))},
+	}
+	for _, layer := range layers {
+		// layers are listed from the manifest, not read
+		manifest.Layers = append(manifest.Layers, oci.Blob{
+			MediaType: MediaTypeLayerGzip,
+			Digest:    testDigest(string(layer)),
+			Size:      int64(len(layer)),
+		})
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(err)
+	manifestDigest := writeTestBlob(t, l, data)
+	require.NoError(l.AddManifest(Descriptor{
+		MediaType:   manifest.MediaType,
+		Digest:      manifestDigest,
+		Size:        int64(len(data)),
+		Annotations: map[string]string{AnnotationRefName: "quay.io/test/app:v1"},
+	}))
+
+	img, err := l.Inspect("quay.io/test/app:v1")
+	require.NoError(err)
+	require.Equal("quay.io/test/app:v1", img.Ref)
+	require.Equal(manifestDigest, img.Digest)
+	require.Len(img.Manifest.Layers, 2)
+	require.Equal(int64(11), img.Manifest.Layers[0].Size)
+	require.Equal(int64(4096), img.Manifest.Layers[1].Size)
+	require.Equal("linux/arm64/v8", img.Config.Platform())
+	require.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), *img.Config.Created)
+	require.Equal([]string{"PATH=/usr/bin"}, img.Config.Config.Env)
+	require.Equal([]string{"/app"}, img.Config.Config.Entrypoint)
+	require.Equal([]string{"--serve"}, img.Config.Config.Cmd)
+	require.Equal("/srv", img.Config.Config.WorkingDir)
+	require.Equal(data, img.RawManifest)
+	require.JSONEq(inspectConfig, string(img.RawConfig))
+
+	_, err = l.Inspect("quay.io/test/app:missing")
+	require.ErrorIs(err, ErrImageNotFound)
+}
+
+func TestInspectRemote(t *testing.T) {
+	require := require.New(t)
+
+	layer := make([]byte, 3000)
+	reg := newTestRegistry(t, []byte(inspectConfig), layer)
+
+	img, err := InspectRemote(context.Background(), reg.client(), reg.image())
+	require.NoError(err)
+	require.Equal(reg.image(), img.Ref)
+	require.Len(img.Manifest.Layers, 1)
+	require.Equal(int64(len(layer)), img.Manifest.Layers[0].Size)
+	require.Equal("linux/arm64/v8", img.Config.Platform())
+	require.Equal([]string{"/app"}, img.Config.Config.Entrypoint)
+	require.Equal(inspectConfig, string(img.RawConfig))
+}
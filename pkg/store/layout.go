@@ -5,10 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
 )
 
 const (
@@ -16,12 +22,22 @@ const (
 	BlobsDir         = "blobs"
 	IndexFile        = "index.json"
 	LayoutFile       = "oci-layout"
+
+	// AnnotationRefName holds the image reference of an index entry.
+	AnnotationRefName = "org.opencontainers.image.ref.name"
 )
 
+// ErrCrossDevice is returned when the temp dir and the blobs directory are
+// on different filesystems, so finished blobs can't be renamed into place.
+var ErrCrossDevice = errors.New("temp dir is on a different filesystem than the layout")
+
 // Layout is an OCI Image Layout directory.
 type Layout struct {
 	root string
-	mu   sync.RWMutex
+	// tempDir holds in-progress writes and partial blobs; empty means
+	// next to the final blob.
+	tempDir string
+	mu      sync.RWMutex
 }
 
 // OCILayout is the oci-layout file content.
@@ -38,11 +54,12 @@ type Index struct {
 
 // Descriptor describes a blob.
 type Descriptor struct {
-	MediaType   string            `json:"mediaType"`
-	Digest      string            `json:"digest"`
-	Size        int64             `json:"size"`
-	Annotations map[string]string `json:"annotations,omitempty"`
-	Platform    *Platform         `json:"platform,omitempty"`
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
 }
 
 // Platform describes a manifest's target platform.
@@ -52,7 +69,9 @@ type Platform struct {
 	Variant      string `json:"variant,omitempty"`
 }
 
-// Open opens or creates an OCI Image Layout.
+// Open opens or creates an OCI Image Layout. fray's metadata in an
+// existing layout is migrated to MetadataVersion; a layout written by a
+// newer fray fails with ErrUnsupportedVersion.
 func Open(root string) (*Layout, error) {
 	l := &Layout{root: root}
 
@@ -66,6 +85,9 @@ func Open(root string) (*Layout, error) {
 		if err := json.Unmarshal(data, &layout); err != nil {
 			return nil, fmt.Errorf("parse oci-layout: %w", err)
 		}
+		if err := l.migrate(); err != nil {
+			return nil, err
+		}
 		return l, nil
 	}
 
@@ -78,7 +100,7 @@ func Open(root string) (*Layout, error) {
 func (l *Layout) init() error {
 	dirs := []string{
 		l.root,
-		filepath.Join(l.root, BlobsDir, "sha256"),
+		filepath.Join(l.root, BlobsDir, string(digest.Canonical)),
 	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -108,7 +130,7 @@ func (l *Layout) init() error {
 		return fmt.Errorf("write index.json: %w", err)
 	}
 
-	return nil
+	return l.writeMetadataVersion()
 }
 
 // Root returns the layout root directory.
@@ -116,15 +138,62 @@ func (l *Layout) Root() string {
 	return l.root
 }
 
+// SetTempDir puts in-progress blob writes and partial downloads in dir
+// instead of next to the final blobs, for example to keep scratch space off
+// a small cache volume. Finished blobs are renamed into place, so dir must
+// be on the same filesystem as the layout; SetTempDir fails with
+// ErrCrossDevice if it isn't. Partials there are not found by the prune
+// command, which only scans the layout. An empty dir restores the default.
+func (l *Layout) SetTempDir(dir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if dir == "" {
+		l.tempDir = ""
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir probe: %w", err)
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	blobDir := filepath.Join(l.root, BlobsDir, string(digest.Canonical))
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+	target := filepath.Join(blobDir, filepath.Base(probe.Name()))
+	if err := renameBlob(probe.Name(), target); err != nil {
+		return fmt.Errorf("temp dir %s: %w", dir, err)
+	}
+	os.Remove(target)
+
+	l.tempDir = dir
+	return nil
+}
+
 // HasBlob reports whether a blob exists.
 func (l *Layout) HasBlob(digest string) bool {
-	_, err := os.Stat(l.blobPath(digest))
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
 	return err == nil
 }
 
 // BlobSize returns the size of a blob, or -1 if not found.
 func (l *Layout) BlobSize(digest string) int64 {
-	info, err := os.Stat(l.blobPath(digest))
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return -1
+	}
+	info, err := os.Stat(path)
 	if err != nil {
 		return -1
 	}
@@ -133,26 +202,80 @@ func (l *Layout) BlobSize(digest string) int64 {
 
 // OpenBlob opens a blob for reading.
 func (l *Layout) OpenBlob(digest string) (io.ReadCloser, error) {
-	return os.Open(l.blobPath(digest))
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
 }
 
 // ReadBlob reads the entire blob into memory.
 func (l *Layout) ReadBlob(digest string) ([]byte, error) {
-	return os.ReadFile(l.blobPath(digest))
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
 }
 
 // WriteBlob writes a blob. Returns 0 if blob already exists (deduplication).
 func (l *Layout) WriteBlob(digest string, r io.Reader) (int64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.writeBlob(digest, r, false)
+}
+
+// WriteBlobVerified writes a blob like WriteBlob, but rejects content that
+// does not hash to d with ErrDigestMismatch.
+func (l *Layout) WriteBlobVerified(d string, r io.Reader) (int64, error) {
+	return l.writeBlob(d, r, true)
+}
+
+// WriteBlobProgress writes a blob like WriteBlobVerified, calling fn with
+// the total bytes written after each read. fn is not called if the blob
+// already exists, and may be nil.
+func (l *Layout) WriteBlobProgress(d string, r io.Reader, fn func(written int64)) (int64, error) {
+	if fn != nil {
+		r = &progressReader{r: r, fn: fn}
+	}
+	return l.writeBlob(d, r, true)
+}
 
-	path := l.blobPath(digest)
+// progressReader reports the running total of bytes read through it.
+type progressReader struct {
+	r       io.Reader
+	fn      func(int64)
+	written int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.written += int64(n)
+		p.fn(p.written)
+	}
+	return n, err
+}
 
+// writeBlob copies r to a temp file of its own without holding the layout
+// lock, so a slow or resumed stream doesn't stall other layout operations,
+// and takes the lock only to move the finished blob into place.
+func (l *Layout) writeBlob(d string, r io.Reader, verify bool) (int64, error) {
+	path, err := l.blobPath(d)
+	if err != nil {
+		return 0, err
+	}
 	if _, err := os.Stat(path); err == nil {
 		return 0, nil
 	}
 
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("create blob dir: %w", err)
+	}
 	dir := filepath.Dir(path)
+	l.mu.RLock()
+	if l.tempDir != "" {
+		dir = l.tempDir
+	}
+	l.mu.RUnlock()
 	tmp, err := os.CreateTemp(dir, ".blob-*")
 	if err != nil {
 		return 0, fmt.Errorf("create temp: %w", err)
@@ -167,6 +290,14 @@ func (l *Layout) WriteBlob(digest string, r io.Reader) (int64, error) {
 		}
 	}()
 
+	if verify {
+		vr, err := oci.NewVerifyReader(r, d)
+		if err != nil {
+			return 0, err
+		}
+		r = vr
+	}
+
 	n, err := io.Copy(tmp, r)
 	if err != nil {
 		return 0, fmt.Errorf("write blob: %w", err)
@@ -176,7 +307,13 @@ func (l *Layout) WriteBlob(digest string, r io.Reader) (int64, error) {
 		return 0, fmt.Errorf("close temp: %w", err)
 	}
 
-	if err := os.Rename(tmpPath, path); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		// another writer stored it first
+		return n, nil
+	}
+	if err := renameBlob(tmpPath, path); err != nil {
 		return 0, fmt.Errorf("rename blob: %w", err)
 	}
 
@@ -189,7 +326,14 @@ func (l *Layout) WriteBlobAt(digest string, offset int64, data []byte) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	path := l.blobPath(digest) + ".partial"
+	path, err := l.partialPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
 
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -209,7 +353,10 @@ func (l *Layout) ReadBlobAt(digest string, offset int64, length int) ([]byte, er
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	path := l.blobPath(digest) + ".partial"
+	path, err := l.partialPath(digest)
+	if err != nil {
+		return nil, err
+	}
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -226,13 +373,45 @@ func (l *Layout) ReadBlobAt(digest string, offset int64, length int) ([]byte, er
 	return data[:n], nil
 }
 
+// PartialDigest returns the digest of a partial blob, computed with the
+// algorithm of the digest it is being downloaded as.
+func (l *Layout) PartialDigest(d string) (string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	path, err := l.partialPath(d)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	algorithm := digest.Digest(d).Algorithm()
+	h := algorithm.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash partial: %w", err)
+	}
+
+	return algorithm.FromHash(h).String(), nil
+}
+
 // FinalizeBlob moves a partial blob to its final location.
 func (l *Layout) FinalizeBlob(digest string) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	partialPath := l.blobPath(digest) + ".partial"
-	finalPath := l.blobPath(digest)
+	finalPath, err := l.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	partialPath, err := l.partialPath(digest)
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(partialPath); err != nil {
 		return fmt.Errorf("partial not found: %w", err)
@@ -243,14 +422,18 @@ func (l *Layout) FinalizeBlob(digest string) error {
 		return nil
 	}
 
-	if err := os.Rename(partialPath, finalPath); err != nil {
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+	if err := renameBlob(partialPath, finalPath); err != nil {
 		return fmt.Errorf("finalize: %w", err)
 	}
 
 	return nil
 }
 
-// AddManifest adds or updates a manifest in the index.
+// AddManifest adds or updates a manifest in the index. An entry with the
+// same ref name is replaced; untagged entries are matched by digest.
 func (l *Layout) AddManifest(desc Descriptor) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -260,8 +443,10 @@ func (l *Layout) AddManifest(desc Descriptor) error {
 		return err
 	}
 
+	ref := desc.Annotations[AnnotationRefName]
 	for i, m := range index.Manifests {
-		if m.Digest == desc.Digest {
+		mref := m.Annotations[AnnotationRefName]
+		if (ref != "" && mref == ref) || (ref == "" && mref == "" && m.Digest == desc.Digest) {
 			index.Manifests[i] = desc
 			return l.writeIndex(index)
 		}
@@ -271,6 +456,31 @@ func (l *Layout) AddManifest(desc Descriptor) error {
 	return l.writeIndex(index)
 }
 
+// SetTag points ref at desc. Every entry indexed under ref, in any
+// equivalent spelling, is removed first, so the tag names exactly one
+// manifest.
+func (l *Layout) SetTag(ref string, desc Descriptor) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	desc.Annotations = maps.Clone(desc.Annotations)
+	if desc.Annotations == nil {
+		desc.Annotations = make(map[string]string, 1)
+	}
+	desc.Annotations[AnnotationRefName] = ref
+
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(m Descriptor) bool {
+		return refMatches(m.Annotations[AnnotationRefName], ref)
+	})
+	index.Manifests = append(index.Manifests, desc)
+	return l.writeIndex(index)
+}
+
 // GetIndex returns the current index.
 func (l *Layout) GetIndex() (*Index, error) {
 	l.mu.RLock()
@@ -292,34 +502,150 @@ func (l *Layout) readIndex() (*Index, error) {
 	return &index, nil
 }
 
+// writeIndex replaces index.json through a synced temp file, so a crash
+// leaves either the old index or the new one.
 func (l *Layout) writeIndex(index *Index) error {
 	data, err := json.MarshalIndent(index, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(l.root, IndexFile), data, 0644)
+
+	path := filepath.Join(l.root, IndexFile)
+	tmp, err := os.CreateTemp(l.root, ".index-*.json")
+	if err != nil {
+		return fmt.Errorf("create index temp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync index: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func (l *Layout) blobPath(digest string) string {
-	parts := strings.SplitN(digest, ":", 2)
-	if len(parts) != 2 {
-		return filepath.Join(l.root, BlobsDir, "sha256", digest)
+// Close makes the layout's writes durable: it syncs index.json and the
+// directories blobs and the index are renamed into, which a crash could
+// otherwise lose. A Layout holds no locks or open files between calls, so
+// there is nothing to release and it stays usable after Close.
+func (l *Layout) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	paths := []string{filepath.Join(l.root, IndexFile)}
+	algorithms, err := os.ReadDir(filepath.Join(l.root, BlobsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read blobs dir: %w", err)
+	}
+	for _, e := range algorithms {
+		if e.IsDir() {
+			paths = append(paths, filepath.Join(l.root, BlobsDir, e.Name()))
+		}
+	}
+	paths = append(paths, l.root)
+
+	var errs []error
+	for _, path := range paths {
+		if err := syncPath(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncPath fsyncs the file or directory at path, if it exists.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", path, err)
+	}
+	return nil
+}
+
+// blobPath returns where d is stored. Digests are normalized and parsed first
+// so uppercase hex finds the same blob and a crafted one can't point outside
+// the blobs directory.
+func (l *Layout) blobPath(d string) (string, error) {
+	parsed, err := digest.Normalize(d)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, BlobsDir, string(parsed.Algorithm()), parsed.Encoded()), nil
+}
+
+// partialPath returns where a download of d is assembled: in the temp dir
+// if one is set, otherwise next to the final blob.
+func (l *Layout) partialPath(d string) (string, error) {
+	if l.tempDir == "" {
+		path, err := l.blobPath(d)
+		if err != nil {
+			return "", err
+		}
+		return path + ".partial", nil
+	}
+	parsed, err := digest.Normalize(d)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.tempDir, string(parsed.Algorithm()), parsed.Encoded()+".partial"), nil
+}
+
+// renameBlob moves a finished blob into place, reporting ErrCrossDevice
+// rather than falling back to a copy that could leave a torn blob.
+func renameBlob(from, to string) error {
+	err := os.Rename(from, to)
+	if errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("%w: %w", ErrCrossDevice, err)
+	}
+	return err
+}
+
+// ValidateDigest checks that d uses a known algorithm and a lowercase hex
+// encoding, which makes it safe to use as a blob path.
+func ValidateDigest(d string) error {
+	_, err := digest.Parse(d)
+	return err
+}
+
+// NormalizeDigest lowercases the hex encoding of d and validates the result.
+func NormalizeDigest(d string) (string, error) {
+	parsed, err := digest.Normalize(d)
+	if err != nil {
+		return "", err
 	}
-	return filepath.Join(l.root, BlobsDir, parts[0], parts[1])
+	return parsed.String(), nil
 }
 
 // Stats contains storage statistics.
 type Stats struct {
-	BlobCount     int
-	TotalSize     int64
-	UniqueDigests int
+	BlobCount     int   `json:"blob_count"`
+	TotalSize     int64 `json:"total_bytes"`
+	UniqueDigests int   `json:"unique_digests"`
 }
 
 // GetStats returns storage statistics.
 func (l *Layout) GetStats() (Stats, error) {
 	var stats Stats
 
-	blobDir := filepath.Join(l.root, BlobsDir, "sha256")
+	blobDir := filepath.Join(l.root, BlobsDir, string(digest.Canonical))
 	entries, err := os.ReadDir(blobDir)
 	if err != nil {
 		if os.IsNotExist(err) {
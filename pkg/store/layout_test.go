@@ -1,15 +1,28 @@
 package store
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/oci"
 )
 
+// testDigest returns a well-formed digest named for readability.
+func testDigest(name string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(name)))
+}
+
 func TestLayoutCreate(t *testing.T) {
 	require := require.New(t)
 	dir := t.TempDir()
@@ -52,10 +65,10 @@ func TestBlobOperations(t *testing.T) {
 		digest  string
 		content string
 	}{
-		{"simple content", "sha256:simple", "hello world"},
-		{"empty content", "sha256:empty", ""},
-		{"large content", "sha256:large", strings.Repeat("x", 10000)},
-		{"binary-like", "sha256:binary", "\x00\x01\x02\x03"},
+		{"simple content", testDigest("simple"), "hello world"},
+		{"empty content", testDigest("empty"), ""},
+		{"large content", testDigest("large"), strings.Repeat("x", 10000)},
+		{"binary-like", testDigest("binary"), "\x00\x01\x02\x03"},
 	}
 
 	for _, tt := range tests {
@@ -90,7 +103,7 @@ func TestBlobDeduplication(t *testing.T) {
 	l, err := Open(dir)
 	require.NoError(err)
 
-	digest := "sha256:duplicate"
+	digest := testDigest("duplicate")
 	content := "duplicate content"
 
 	n1, err := l.WriteBlob(digest, strings.NewReader(content))
@@ -106,6 +119,112 @@ func TestBlobDeduplication(t *testing.T) {
 	require.Equal(1, stats.BlobCount)
 }
 
+func TestWriteBlobVerified(t *testing.T) {
+	content := "verified content"
+	good := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+	good512 := fmt.Sprintf("sha512:%x", sha512.Sum512([]byte(content)))
+
+	tests := []struct {
+		name    string
+		digest  string
+		wantErr bool
+	}{
+		{"matching digest", good, false},
+		{"mismatched digest", "sha256:" + strings.Repeat("0", 64), true},
+		{"sha512 digest", good512, false},
+		{"sha512 mismatch", "sha512:abc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			n, err := l.WriteBlobVerified(tt.digest, strings.NewReader(content))
+			if tt.wantErr {
+				require.True(errors.Is(err, ErrDigestMismatch))
+				require.False(l.HasBlob(tt.digest))
+				return
+			}
+			require.NoError(err)
+			require.Equal(int64(len(content)), n)
+			require.True(l.HasBlob(tt.digest))
+		})
+	}
+}
+
+func TestWriteBlobProgress(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	content := strings.Repeat("progress", 100)
+	d := testDigest(content)
+
+	var totals []int64
+	n, err := l.WriteBlobProgress(d, iotest.OneByteReader(strings.NewReader(content)), func(written int64) {
+		totals = append(totals, written)
+	})
+	require.NoError(err)
+	require.Equal(int64(len(content)), n)
+	require.Len(totals, len(content))
+	for i, total := range totals {
+		require.Equal(int64(i+1), total)
+	}
+
+	// an existing blob is not rewritten, so nothing is reported
+	totals = nil
+	n, err = l.WriteBlobProgress(d, strings.NewReader(content), func(written int64) {
+		totals = append(totals, written)
+	})
+	require.NoError(err)
+	require.Zero(n)
+	require.Empty(totals)
+}
+
+func TestWriteBlobUnlocked(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	// a stream that stalls halfway, like one waiting to be resumed
+	data := []byte("stalled blob")
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.WriteBlobVerified(fmt.Sprintf("sha256:%x", sha256.Sum256(data)), pr)
+		done <- err
+	}()
+	_, err = pw.Write(data[:5])
+	require.NoError(err)
+
+	// the layout stays usable meanwhile
+	tagged := make(chan error, 1)
+	go func() {
+		tagged <- l.SetTag("quay.io/test/app:v1", Descriptor{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    testDigest("manifest"),
+			Size:      100,
+		})
+	}()
+	select {
+	case err := <-tagged:
+		require.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetTag blocked by an unfinished blob write")
+	}
+
+	_, err = pw.Write(data[5:])
+	require.NoError(err)
+	require.NoError(pw.Close())
+	require.NoError(<-done)
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(data))))
+}
+
 func TestPartialBlob(t *testing.T) {
 	require := require.New(t)
 	dir := t.TempDir()
@@ -113,7 +232,7 @@ func TestPartialBlob(t *testing.T) {
 	l, err := Open(dir)
 	require.NoError(err)
 
-	digest := "sha256:partial"
+	digest := testDigest("partial")
 
 	require.NoError(l.WriteBlobAt(digest, 0, []byte("chunk0")))
 	require.NoError(l.WriteBlobAt(digest, 6, []byte("chunk1")))
@@ -139,7 +258,7 @@ func TestManifestIndex(t *testing.T) {
 
 	desc := Descriptor{
 		MediaType: "application/vnd.oci.image.manifest.v1+json",
-		Digest:    "sha256:manifest1",
+		Digest:    testDigest("manifest1"),
 		Size:      1234,
 		Annotations: map[string]string{
 			"org.opencontainers.image.ref.name": "latest",
@@ -163,6 +282,46 @@ func TestManifestIndex(t *testing.T) {
 	require.Equal(int64(5678), index.Manifests[0].Size)
 }
 
+func TestSetTag(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	// duplicates left by an older layout, in two spellings
+	manifest := func(digest, ref string) Descriptor {
+		return Descriptor{
+			MediaType:   "application/vnd.oci.image.manifest.v1+json",
+			Digest:      testDigest(digest),
+			Size:        100,
+			Annotations: map[string]string{AnnotationRefName: ref},
+		}
+	}
+	require.NoError(l.writeIndex(&Index{
+		SchemaVersion: 2,
+		Manifests: []Descriptor{
+			manifest("old1", "docker.io/library/alpine:latest"),
+			manifest("other", "quay.io/test/other:v1"),
+			manifest("old2", "alpine"),
+		},
+	}))
+
+	desc := manifest("new", "ignored")
+	require.NoError(l.SetTag("docker.io/library/alpine:latest", desc))
+	require.Equal("ignored", desc.Annotations[AnnotationRefName])
+
+	index, err := l.GetIndex()
+	require.NoError(err)
+	require.Len(index.Manifests, 2)
+	require.Equal(testDigest("other"), index.Manifests[0].Digest)
+	require.Equal(testDigest("new"), index.Manifests[1].Digest)
+	require.Equal("docker.io/library/alpine:latest", index.Manifests[1].Annotations[AnnotationRefName])
+
+	img, err := l.FindByRef("alpine")
+	require.NoError(err)
+	require.Equal(testDigest("new"), img.Digest)
+}
+
 func TestManifestMultiple(t *testing.T) {
 	require := require.New(t)
 	dir := t.TempDir()
@@ -173,13 +332,13 @@ func TestManifestMultiple(t *testing.T) {
 	manifests := []Descriptor{
 		{
 			MediaType:   "application/vnd.oci.image.manifest.v1+json",
-			Digest:      "sha256:manifest1",
+			Digest:      testDigest("manifest1"),
 			Size:        100,
 			Annotations: map[string]string{"org.opencontainers.image.ref.name": "v1.0"},
 		},
 		{
 			MediaType:   "application/vnd.oci.image.manifest.v1+json",
-			Digest:      "sha256:manifest2",
+			Digest:      testDigest("manifest2"),
 			Size:        200,
 			Annotations: map[string]string{"org.opencontainers.image.ref.name": "v2.0"},
 		},
@@ -201,7 +360,7 @@ func TestReadBlob(t *testing.T) {
 	l, err := Open(dir)
 	require.NoError(err)
 
-	digest := "sha256:readblob"
+	digest := testDigest("readblob")
 	content := "read blob test content"
 
 	_, err = l.WriteBlob(digest, strings.NewReader(content))
@@ -224,9 +383,9 @@ func TestStats(t *testing.T) {
 	require.Equal(0, stats.BlobCount)
 	require.Equal(int64(0), stats.TotalSize)
 
-	_, err = l.WriteBlob("sha256:blob1", strings.NewReader("content1"))
+	_, err = l.WriteBlob(testDigest("blob1"), strings.NewReader("content1"))
 	require.NoError(err)
-	_, err = l.WriteBlob("sha256:blob2", strings.NewReader("longer content 2"))
+	_, err = l.WriteBlob(testDigest("blob2"), strings.NewReader("longer content 2"))
 	require.NoError(err)
 
 	stats, err = l.GetStats()
@@ -234,3 +393,322 @@ func TestStats(t *testing.T) {
 	require.Equal(2, stats.BlobCount)
 	require.Equal(int64(len("content1")+len("longer content 2")), stats.TotalSize)
 }
+
+func TestCopyImage(t *testing.T) {
+	const (
+		src = "test/app:staging"
+		dst = "test/app:release"
+	)
+	other := testDigest("other")
+
+	tests := []struct {
+		name       string
+		dstDigest  string
+		force      bool
+		srcRef     string
+		wantErr    error
+		wantDigest string
+	}{
+		{name: "new ref", srcRef: src, wantDigest: testDigest("staging")},
+		{name: "normalized source", srcRef: "docker.io/test/app:staging", wantDigest: testDigest("staging")},
+		{name: "same image is a no-op", srcRef: src, dstDigest: testDigest("staging"), wantDigest: testDigest("staging")},
+		{name: "different image without force", srcRef: src, dstDigest: other, wantErr: ErrRefExists, wantDigest: other},
+		{name: "different image with force", srcRef: src, dstDigest: other, force: true, wantDigest: testDigest("staging")},
+		{name: "missing source", srcRef: "test/app:missing", wantErr: ErrImageNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			_, err = l.WriteBlob(testDigest("staging"), strings.NewReader("manifest"))
+			require.NoError(err)
+			require.NoError(l.AddManifest(Descriptor{
+				MediaType:   "application/vnd.oci.image.manifest.v1+json",
+				Digest:      testDigest("staging"),
+				Size:        8,
+				Annotations: map[string]string{AnnotationRefName: src},
+			}))
+			if tt.dstDigest != "" {
+				require.NoError(l.AddManifest(Descriptor{
+					Digest:      tt.dstDigest,
+					Annotations: map[string]string{AnnotationRefName: dst},
+				}))
+			}
+
+			before, err := l.GetStats()
+			require.NoError(err)
+
+			err = l.CopyImage(tt.srcRef, dst, tt.force)
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr))
+			} else {
+				require.NoError(err)
+			}
+
+			index, err := l.GetIndex()
+			require.NoError(err)
+			digests := make(map[string]string)
+			for _, m := range index.Manifests {
+				digests[m.Annotations[AnnotationRefName]] = m.Digest
+			}
+			require.Equal(testDigest("staging"), digests[src])
+			require.Equal(tt.wantDigest, digests[dst])
+
+			after, err := l.GetStats()
+			require.NoError(err)
+			require.Equal(before.BlobCount, after.BlobCount)
+		})
+	}
+}
+
+// newImagesLayout indexes a tagged, an untagged and a digest-only entry.
+func newImagesLayout(t *testing.T) *Layout {
+	t.Helper()
+
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	entries := []Descriptor{
+		{
+			MediaType:   "application/vnd.oci.image.manifest.v1+json",
+			Digest:      testDigest("tagged"),
+			Size:        10,
+			Annotations: map[string]string{AnnotationRefName: "alpine:3.20"},
+			Platform:    &Platform{OS: "linux", Architecture: "arm64"},
+		},
+		{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    testDigest("untagged"),
+			Size:      20,
+		},
+		{
+			MediaType:   "application/vnd.oci.image.index.v1+json",
+			Digest:      testDigest("tagged"),
+			Size:        10,
+			Annotations: map[string]string{AnnotationRefName: "quay.io/test/app@sha256:tagged"},
+		},
+	}
+	for _, d := range entries {
+		_, err := l.WriteBlob(d.Digest, strings.NewReader("manifest"))
+		require.NoError(t, err)
+		require.NoError(t, l.AddManifest(d))
+	}
+
+	return l
+}
+
+func TestImages(t *testing.T) {
+	require := require.New(t)
+
+	l := newImagesLayout(t)
+
+	images, err := l.Images()
+	require.NoError(err)
+	require.Len(images, 3)
+	require.Equal(oci.DockerHubRegistry+"/library/alpine:3.20", images[0].Ref)
+	require.Equal("arm64", images[0].Platform.Architecture)
+	require.False(images[0].LastAccess.IsZero())
+	require.Empty(images[1].Ref)
+	require.Equal(int64(20), images[1].Size)
+	require.Equal("quay.io/test/app@sha256:tagged", images[2].Ref)
+}
+
+func TestFindByRef(t *testing.T) {
+	l := newImagesLayout(t)
+
+	tests := []struct {
+		name       string
+		ref        string
+		wantDigest string
+		wantErr    error
+	}{
+		{"short form", "alpine:3.20", testDigest("tagged"), nil},
+		{"fully qualified", "docker.io/library/alpine:3.20", testDigest("tagged"), nil},
+		{"digest only", "quay.io/test/app@sha256:tagged", testDigest("tagged"), nil},
+		{"other tag", "alpine:latest", "", ErrImageNotFound},
+		{"empty ref never matches untagged", "", "", ErrImageNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			img, err := l.FindByRef(tt.ref)
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr))
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.wantDigest, img.Digest)
+		})
+	}
+}
+
+func TestFindByDigest(t *testing.T) {
+	require := require.New(t)
+
+	l := newImagesLayout(t)
+
+	images, err := l.FindByDigest(testDigest("tagged"))
+	require.NoError(err)
+	require.Len(images, 2)
+
+	images, err = l.FindByDigest(testDigest("untagged"))
+	require.NoError(err)
+	require.Len(images, 1)
+	require.Empty(images[0].Ref)
+
+	_, err = l.FindByDigest(testDigest("missing"))
+	require.True(errors.Is(err, ErrImageNotFound))
+}
+
+func TestBlobDigestCase(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	lower := testDigest("case")
+	upper := "sha256:" + strings.ToUpper(strings.TrimPrefix(lower, "sha256:"))
+
+	_, err = l.WriteBlob(upper, strings.NewReader("case"))
+	require.NoError(err)
+
+	for _, d := range []string{lower, upper} {
+		require.True(l.HasBlob(d), d)
+		require.Equal(int64(4), l.BlobSize(d))
+		data, err := l.ReadBlob(d)
+		require.NoError(err)
+		require.Equal("case", string(data))
+	}
+
+	var walked []string
+	require.NoError(l.WalkBlobs(func(d string, _ int64) error {
+		walked = append(walked, d)
+		return nil
+	}))
+	require.Equal([]string{lower}, walked)
+
+	normalized, err := NormalizeDigest(upper)
+	require.NoError(err)
+	require.Equal(lower, normalized)
+}
+
+func TestBlobPathTraversal(t *testing.T) {
+	tests := []struct {
+		name   string
+		digest string
+	}{
+		{"parent segments", "sha256:../../../escape"},
+		{"embedded slash", "sha256:abc/../../escape"},
+		{"traversal in algorithm", "../../escape:abc"},
+		{"absolute path", "sha256:/tmp/escape"},
+		{"unknown algorithm", "md5:d41d8cd98f00b204e9800998ecf8427e"},
+		{"non-hex", "sha256:XYZ123"},
+		{"empty encoding", "sha256:"},
+		{"missing algorithm", "abc123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			parent := t.TempDir()
+			l, err := Open(filepath.Join(parent, "layout"))
+			require.NoError(err)
+
+			_, err = l.WriteBlob(tt.digest, strings.NewReader("payload"))
+			require.True(errors.Is(err, ErrInvalidDigest), "got %v", err)
+			require.True(errors.Is(l.WriteBlobAt(tt.digest, 0, []byte("payload")), ErrInvalidDigest))
+			require.True(errors.Is(l.FinalizeBlob(tt.digest), ErrInvalidDigest))
+
+			_, err = l.ReadBlob(tt.digest)
+			require.True(errors.Is(err, ErrInvalidDigest))
+			_, err = l.OpenBlob(tt.digest)
+			require.True(errors.Is(err, ErrInvalidDigest))
+			_, err = l.ReadBlobAt(tt.digest, 0, 1)
+			require.True(errors.Is(err, ErrInvalidDigest))
+
+			require.False(l.HasBlob(tt.digest))
+			require.Equal(int64(-1), l.BlobSize(tt.digest))
+
+			entries, err := os.ReadDir(parent)
+			require.NoError(err)
+			require.Len(entries, 1)
+		})
+	}
+}
+
+func TestSetTempDir(t *testing.T) {
+	require := require.New(t)
+
+	parent := t.TempDir()
+	l, err := Open(filepath.Join(parent, "layout"))
+	require.NoError(err)
+	tempDir := filepath.Join(parent, "scratch")
+	require.NoError(l.SetTempDir(tempDir))
+
+	whole := testDigest("whole")
+	_, err = l.WriteBlob(whole, strings.NewReader("whole"))
+	require.NoError(err)
+	require.True(l.HasBlob(whole))
+
+	content := []byte("partial content")
+	partial := fmt.Sprintf("sha512:%x", sha512.Sum512(content))
+	require.NoError(l.WriteBlobAt(partial, 0, content))
+	require.FileExists(filepath.Join(tempDir, "sha512", strings.TrimPrefix(partial, "sha512:")+".partial"))
+	require.False(l.HasBlob(partial))
+
+	got, err := l.PartialDigest(partial)
+	require.NoError(err)
+	require.Equal(partial, got)
+	require.NoError(l.FinalizeBlob(partial))
+	data, err := l.ReadBlob(partial)
+	require.NoError(err)
+	require.Equal(content, data)
+
+	// nothing is left behind in the scratch space
+	entries, err := os.ReadDir(filepath.Join(tempDir, "sha512"))
+	require.NoError(err)
+	require.Empty(entries)
+	entries, err = os.ReadDir(tempDir)
+	require.NoError(err)
+	require.Len(entries, 1)
+}
+
+func TestLayoutClose(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+
+	l, err := Open(dir)
+	require.NoError(err)
+
+	content := `{"schemaVersion":2}`
+	d := testDigest(content)
+	_, err = l.WriteBlob(d, strings.NewReader(content))
+	require.NoError(err)
+	require.NoError(l.SetTag("docker.io/library/alpine:latest", Descriptor{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Digest:    d,
+		Size:      int64(len(content)),
+	}))
+
+	require.NoError(l.Close())
+	require.NoError(l.Close(), "close is idempotent")
+
+	// the index is replaced through a temp file that mustn't be left behind
+	leftover, err := filepath.Glob(filepath.Join(dir, ".index-*"))
+	require.NoError(err)
+	require.Empty(leftover)
+
+	reopened, err := Open(dir)
+	require.NoError(err)
+	img, err := reopened.FindByRef("alpine")
+	require.NoError(err)
+	require.Equal(d, img.Digest)
+	require.True(reopened.HasBlob(d))
+}
@@ -0,0 +1,25 @@
+package store
+
+import "time"
+
+// Metrics receives pull counters. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// AddBytesDownloaded counts bytes fetched from the registry.
+	AddBytesDownloaded(n int64)
+	// AddBytesCached counts bytes already present in the layout.
+	AddBytesCached(n int64)
+	// IncChunkRetries counts a retried chunk request.
+	IncChunkRetries()
+	// IncLayersDownloaded counts a layer fetched from the registry.
+	IncLayersDownloaded()
+	// ObservePullDuration records the wall time of one Pull call.
+	ObservePullDuration(d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AddBytesDownloaded(int64)          {}
+func (nopMetrics) AddBytesCached(int64)              {}
+func (nopMetrics) IncChunkRetries()                  {}
+func (nopMetrics) IncLayersDownloaded()              {}
+func (nopMetrics) ObservePullDuration(time.Duration) {}
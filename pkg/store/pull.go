@@ -1,18 +1,21 @@
 package store
 
 import (
+	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/hexfusion/fray/pkg/digest"
 	"github.com/hexfusion/fray/pkg/logging"
 	"github.com/hexfusion/fray/pkg/merkle"
 	"github.com/hexfusion/fray/pkg/oci"
@@ -20,81 +23,271 @@ import (
 
 // PullOptions configures a pull operation.
 type PullOptions struct {
-	ChunkSize  int
-	Parallel   int
-	StateDir   string
+	// ChunkSize is the download chunk size. Zero picks one per blob with
+	// AutoChunkSize.
+	ChunkSize int
+	Parallel  int
+	StateDir  string
+	// OnProgress reports progress by layer index. It is kept for existing
+	// callers; OnLayerProgress identifies layers by digest.
 	OnProgress func(current, total int, layerProgress float64)
+	// OnLayerProgress reports each layer's completed bytes as it downloads,
+	// and once for layers that are already cached. The config is reported
+	// the same way, with Config set. It may be called concurrently by
+	// PullAll.
+	OnLayerProgress func(LayerProgress)
+	// VerifyDiffIDs decompresses each layer after download and checks it
+	// against the config's rootfs.diff_ids. CPU-heavy, off by default.
+	VerifyDiffIDs bool
+	// ComputeDiffIDs decompresses each new layer once after download and
+	// records its diff id in the layout's DiffIDsFile, so verification and
+	// rootfs extraction don't recompute it. CPU-heavy, off by default.
+	ComputeDiffIDs bool
+	// Retry controls chunk request retries. Nil uses oci.DefaultRetryPolicy;
+	// a policy with MaxRetries zero disables them.
+	Retry *oci.RetryPolicy
+	// Metrics receives pull counters. Nil disables metrics.
+	Metrics Metrics
+	// Platform selects the os/arch[/variant] manifest from image indexes.
+	// Empty uses the host platform.
+	Platform string
+	// StateSaveInterval is how many downloaded bytes may go unrecorded in
+	// the resume state before it is saved. Zero uses DefaultStateSaveInterval.
+	StateSaveInterval int64
+	// KeepChunks also writes each downloaded chunk to its own file under
+	// StateDir, with a ChunksFile listing them, and keeps them after the
+	// layer is finalized. For debugging corrupt downloads.
+	KeepChunks bool
+	// MaxChunkAttempts is how many times one pull may fetch a chunk when its
+	// layer fails digest verification. Chunks cleared as corrupt are fetched
+	// again until one reaches the limit, then the pull fails with
+	// ErrRetryBudgetExhausted. Zero uses DefaultMaxChunkAttempts.
+	MaxChunkAttempts int
+	// MaxConcurrency caps the blob requests in flight at once across every
+	// layer and image this puller downloads, however many images PullAll
+	// runs together. Zero means no cap.
+	MaxConcurrency int
+	// MaxLayers refuses images whose manifest declares more layers. Zero
+	// means no limit.
+	MaxLayers int
+	// MaxTotalSize refuses images whose manifest declares more bytes of
+	// config and layers. Zero means no limit.
+	MaxTotalSize int64
+	// KeepIndex stores the manifest, and the index it was selected from,
+	// as the registry served them, and tags the image with the index. Its
+	// other platforms' manifests can then be pulled by digest and served
+	// alongside it, as a proxy does for clients choosing their own
+	// platform. The client must be a ManifestClient.
+	KeepIndex bool
+}
+
+// checkLimits refuses a manifest over MaxLayers or MaxTotalSize before
+// anything is downloaded.
+func (p *Puller) checkLimits(manifest *oci.Manifest) error {
+	if p.opts.MaxLayers > 0 && len(manifest.Layers) > p.opts.MaxLayers {
+		return fmt.Errorf("%w: manifest declares %d layers, max is %d",
+			ErrLimitExceeded, len(manifest.Layers), p.opts.MaxLayers)
+	}
+	if p.opts.MaxTotalSize <= 0 {
+		return nil
+	}
+
+	total := max(manifest.Config.Size, 0)
+	for _, layer := range manifest.Layers {
+		// a negative or overflowing size can't be trusted to be small
+		if layer.Size < 0 || total > math.MaxInt64-layer.Size {
+			total = math.MaxInt64
+			break
+		}
+		total += layer.Size
+	}
+	if total > p.opts.MaxTotalSize {
+		return fmt.Errorf("%w: manifest declares %d bytes, max is %d",
+			ErrLimitExceeded, total, p.opts.MaxTotalSize)
+	}
+	return nil
+}
+
+// LayerProgress is how much of one layer, or of the config, is present in
+// the layout.
+type LayerProgress struct {
+	Digest string
+	// Index is the layer's position in the manifest, or -1 for the config.
+	Index int
+	// Config is set for the image config, which downloads before the
+	// layers.
+	Config         bool
+	CompletedBytes int64
+	TotalBytes     int64
 }
 
 // Puller downloads images to an OCI layout with resumable chunked transfers.
 type Puller struct {
 	layout *Layout
-	client *oci.Client
+	client BlobClient
 	log    logging.Logger
 	opts   PullOptions
+
+	mu       sync.Mutex
+	inflight map[string]*blobState
+	// requests holds a token per blob request in flight when
+	// MaxConcurrency is set.
+	requests chan struct{}
+}
+
+// blobState tracks a layer download shared by concurrent pulls.
+type blobState struct {
+	done chan struct{}
+	err  error
 }
 
 // NewPuller creates a puller with the given options.
-func NewPuller(layout *Layout, client *oci.Client, log logging.Logger, opts PullOptions) *Puller {
-	if opts.ChunkSize == 0 {
-		opts.ChunkSize = 1024 * 1024
-	}
+func NewPuller(layout *Layout, client BlobClient, log logging.Logger, opts PullOptions) *Puller {
 	if opts.Parallel == 0 {
 		opts.Parallel = 4
 	}
 	if opts.StateDir == "" {
-		opts.StateDir = filepath.Join(layout.Root(), ".fray")
+		opts.StateDir = filepath.Join(layout.Root(), MetadataDir)
+	}
+	retry := oci.DefaultRetryPolicy()
+	if opts.Retry != nil {
+		retry = *opts.Retry
+	}
+	opts.Retry = &retry
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics{}
+	}
+	if opts.StateSaveInterval == 0 {
+		opts.StateSaveInterval = DefaultStateSaveInterval
 	}
-	return &Puller{
-		layout: layout,
-		client: client,
-		log:    log,
-		opts:   opts,
+	if opts.MaxChunkAttempts <= 0 {
+		opts.MaxChunkAttempts = DefaultMaxChunkAttempts
+	}
+	p := &Puller{
+		layout:   layout,
+		client:   client,
+		log:      log,
+		opts:     opts,
+		inflight: make(map[string]*blobState),
+	}
+	if opts.MaxConcurrency > 0 {
+		p.requests = make(chan struct{}, opts.MaxConcurrency)
+	}
+	return p
+}
+
+// acquire waits for a request slot under MaxConcurrency. The returned func
+// releases it.
+func (p *Puller) acquire(ctx context.Context) (func(), error) {
+	if p.requests == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.requests <- struct{}{}:
+		return func() { <-p.requests }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 // PullResult contains pull operation results.
 type PullResult struct {
-	Digest     string
-	Layers     int
-	TotalSize  int64
-	Downloaded int64
-	Cached     int64
+	Digest string `json:"digest"`
+	// Platform is the os/architecture[/variant] from the image config.
+	Platform   string `json:"platform,omitempty"`
+	Layers     int    `json:"layers"`
+	TotalSize  int64  `json:"total_bytes"`
+	Downloaded int64  `json:"downloaded_bytes"`
+	Cached     int64  `json:"cached_bytes"`
+	// Chunks is the number of range chunks fetched.
+	Chunks int `json:"chunks"`
+	// Retries is the number of chunk requests that were retried.
+	Retries int `json:"retries"`
+	// Unchanged is set when the ref already pointed at this manifest and
+	// every blob was cached, so the pull changed nothing.
+	Unchanged bool `json:"unchanged"`
 }
 
 // Pull downloads an image to the layout.
 func (p *Puller) Pull(ctx context.Context, image string) (*PullResult, error) {
 	result := &PullResult{}
 
-	registry, repo, ref := oci.ParseImageRef(image)
+	start := time.Now()
+	defer func() {
+		p.opts.Metrics.ObservePullDuration(time.Since(start))
+	}()
 
-	manifest, err := p.client.GetManifest(ctx, registry, repo, ref)
+	imageRef, err := oci.ParseReference(image)
 	if err != nil {
-		return nil, fmt.Errorf("get manifest: %w", err)
+		return nil, fmt.Errorf("parse reference: %w", err)
 	}
+	registry, repo := imageRef.Registry, imageRef.Repository
 
-	manifestData, err := json.Marshal(manifest)
+	manifest, manifestData, index, err := p.resolveManifest(ctx, registry, repo, imageRef.Ref())
 	if err != nil {
-		return nil, fmt.Errorf("marshal manifest: %w", err)
+		return nil, err
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
 	}
+	if err := p.checkLimits(manifest); err != nil {
+		return nil, err
+	}
+
+	manifestDigest := digest.FromBytes(manifestData).String()
+	desc := Descriptor{
+		MediaType:    manifest.MediaType,
+		ArtifactType: manifest.ArtifactType,
+		Digest:       manifestDigest,
+		Size:         int64(len(manifestData)),
+	}
+	// a kept index is what the ref names
+	if index != nil {
+		desc = index.desc
+	}
+	result.Digest = desc.Digest
 
-	manifestDigest := fmt.Sprintf("sha256:%x", sha256Sum(manifestData))
-	result.Digest = manifestDigest
+	prior, err := p.layout.FindByRef(image)
+	tagged := err == nil && prior.Digest == desc.Digest
+	// fetched is set once any blob is written to the layout
+	fetched := false
 
-	if _, err := p.layout.WriteBlob(manifestDigest, strings.NewReader(string(manifestData))); err != nil {
+	if _, err := p.layout.WriteBlob(manifestDigest, bytes.NewReader(manifestData)); err != nil {
 		return nil, fmt.Errorf("write manifest: %w", err)
 	}
+	if index != nil {
+		if _, err := p.layout.WriteBlob(index.desc.Digest, bytes.NewReader(index.data)); err != nil {
+			return nil, fmt.Errorf("write index: %w", err)
+		}
+	}
 
 	configDigest := manifest.Config.Digest
-	if !p.layout.HasBlob(configDigest) {
-		if err := p.downloadBlob(ctx, registry, repo, configDigest); err != nil {
+	switch {
+	case configDigest == oci.EmptyJSONDigest && !p.layout.HasBlob(configDigest):
+		// the empty config's content is known, so it isn't fetched
+		if _, err := p.layout.WriteBlobVerified(configDigest, bytes.NewReader(oci.EmptyJSON)); err != nil {
+			return nil, fmt.Errorf("write empty config: %w", err)
+		}
+		fetched = true
+	case !p.layout.HasBlob(configDigest):
+		p.reportConfigProgress(manifest.Config, 0)
+		onWrite := func(written int64) {
+			p.reportConfigProgress(manifest.Config, written)
+		}
+		if _, err := p.downloadBlob(ctx, registry, repo, configDigest, manifest.Config.Size, onWrite); err != nil {
 			return nil, fmt.Errorf("download config: %w", err)
 		}
+		fetched = true
 		result.Downloaded += manifest.Config.Size
-	} else {
+		p.opts.Metrics.AddBytesDownloaded(manifest.Config.Size)
+	default:
+		p.reportConfigProgress(manifest.Config, manifest.Config.Size)
 		result.Cached += manifest.Config.Size
+		p.opts.Metrics.AddBytesCached(manifest.Config.Size)
 	}
 
+	result.Platform = p.layout.configPlatform(configDigest)
+
 	result.Layers = len(manifest.Layers)
 	p.log.Debug("starting layer downloads",
 		zap.Int("layers", len(manifest.Layers)),
@@ -110,21 +303,34 @@ func (p *Puller) Pull(ctx context.Context, image string) (*PullResult, error) {
 			zap.String("digest", layer.Digest),
 			zap.Int64("size", layer.Size))
 
-		if p.layout.HasBlob(layer.Digest) {
+		state, owner, err := p.awaitBlob(ctx, layer.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+
+		// without a claim, awaitBlob found the blob stored
+		if !owner || p.layout.HasBlob(layer.Digest) {
+			if owner {
+				p.removeState(layer.Digest)
+				p.releaseBlob(layer.Digest, state, nil)
+			}
 			p.log.Debug("layer cached",
 				zap.Int("layer", i),
 				zap.String("digest", layer.Digest))
 			result.Cached += layer.Size
-			if p.opts.OnProgress != nil {
-				p.opts.OnProgress(i, totalLayers, 1.0)
-			}
+			p.opts.Metrics.AddBytesCached(layer.Size)
+			p.reportProgress(layer, i, totalLayers, layer.Size)
 			continue
 		}
 
-		downloaded, err := p.downloadLayerResumable(ctx, registry, repo, layer, i, totalLayers)
+		fetched = true
+		downloaded, err := p.downloadLayerResumable(ctx, image, registry, repo, layer, i, totalLayers, result)
+		p.releaseBlob(layer.Digest, state, err)
+		p.opts.Metrics.AddBytesDownloaded(downloaded)
 		if err != nil {
 			return nil, fmt.Errorf("layer %d: %w", i, err)
 		}
+		p.opts.Metrics.IncLayersDownloaded()
 		p.log.Debug("layer downloaded",
 			zap.Int("layer", i),
 			zap.String("digest", layer.Digest),
@@ -132,63 +338,365 @@ func (p *Puller) Pull(ctx context.Context, image string) (*PullResult, error) {
 		result.Downloaded += downloaded
 	}
 
-	desc := Descriptor{
-		MediaType: manifest.MediaType,
-		Digest:    manifestDigest,
-		Size:      int64(len(manifestData)),
-		Annotations: map[string]string{
-			"org.opencontainers.image.ref.name": image,
-		},
-	}
-	if err := p.layout.AddManifest(desc); err != nil {
+	// artifact layers need not be tar archives, so they have no diff ids
+	if p.opts.ComputeDiffIDs && !manifest.IsArtifact() {
+		if err := p.layout.ComputeDiffIDs(manifest); err != nil {
+			return nil, fmt.Errorf("compute diff ids: %w", err)
+		}
+	}
+	if p.opts.VerifyDiffIDs && !manifest.IsArtifact() {
+		p.log.Debug("verifying diff ids", zap.String("image", image))
+		if err := p.layout.VerifyDiffIDs(manifest); err != nil {
+			return nil, fmt.Errorf("verify diff ids: %w", err)
+		}
+	}
+
+	if tagged && !fetched {
+		result.Unchanged = true
+		return result, nil
+	}
+
+	if err := p.layout.SetTag(image, desc); err != nil {
 		return nil, fmt.Errorf("add to index: %w", err)
 	}
 
 	return result, nil
 }
 
-func (p *Puller) downloadBlob(ctx context.Context, registry, repo, digest string) error {
-	r, err := p.client.GetBlob(ctx, registry, repo, digest)
+// maxIndexDepth bounds the indexes a pull follows to reach a manifest, as
+// the registry client does.
+const maxIndexDepth = 4
+
+// keptIndex is an image index stored as the registry served it.
+type keptIndex struct {
+	data []byte
+	desc Descriptor
+}
+
+// resolveManifest fetches the manifest image's ref names for the platform,
+// and the bytes to store it as. A ManifestClient's manifests are stored as
+// served, so their digests and any fields oci.Manifest doesn't model
+// survive; any other client's are stored re-encoded. With KeepIndex, an
+// index ref names is returned as well, to be tagged in place of the
+// manifest.
+func (p *Puller) resolveManifest(ctx context.Context, registry, repo, ref string) (*oci.Manifest, []byte, *keptIndex, error) {
+	client, ok := p.client.(ManifestClient)
+	if !ok {
+		if p.opts.KeepIndex {
+			return nil, nil, nil, fmt.Errorf("keep index: %T can't fetch manifests as stored", p.client)
+		}
+		manifest, err := p.client.GetPlatformManifest(ctx, registry, repo, ref, p.opts.Platform)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("get manifest: %w", err)
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("marshal manifest: %w", err)
+		}
+		return manifest, data, nil, nil
+	}
+	if p.opts.Platform != "" {
+		if err := oci.ValidatePlatform(p.opts.Platform); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	data, mediaType, err := client.GetRawManifest(ctx, registry, repo, ref)
 	if err != nil {
-		return err
+		return nil, nil, nil, fmt.Errorf("get manifest: %w", err)
 	}
-	defer r.Close()
+	var index *keptIndex
+	for depth := 0; ; depth++ {
+		list, ok := parseIndex(data, mediaType)
+		if !ok {
+			break
+		}
+		// only one index is kept, so a kept one can't nest another
+		if depth == maxIndexDepth || (depth > 0 && p.opts.KeepIndex) {
+			return nil, nil, nil, fmt.Errorf("%w: nested index at %s", oci.ErrManifestDepth, ref)
+		}
+		if p.opts.KeepIndex {
+			index = &keptIndex{data: data, desc: Descriptor{
+				MediaType: list.MediaType,
+				Digest:    digest.FromBytes(data).String(),
+				Size:      int64(len(data)),
+			}}
+		}
+		if ref, err = selectPlatform(client, list, p.opts.Platform); err != nil {
+			return nil, nil, nil, err
+		}
+		if data, mediaType, err = client.GetRawManifest(ctx, registry, repo, ref); err != nil {
+			return nil, nil, nil, fmt.Errorf("get manifest: %w", err)
+		}
+	}
+
+	var manifest oci.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = mediaType
+	}
+	return &manifest, data, index, nil
+}
+
+// selectPlatform picks platform's manifest from list, by the client's own
+// preferences if it has them.
+func selectPlatform(client BlobClient, list oci.ManifestList, platform string) (string, error) {
+	if s, ok := client.(interface {
+		SelectPlatform(oci.ManifestList, string) (string, error)
+	}); ok {
+		return s.SelectPlatform(list, platform)
+	}
+	return oci.SelectPlatform(list, platform)
+}
+
+// parseIndex returns data as an index if it is one, by its media type or,
+// for an index that omits it, by its entries.
+func parseIndex(data []byte, mediaType string) (oci.ManifestList, bool) {
+	var list oci.ManifestList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return list, false
+	}
+	if list.MediaType == "" {
+		list.MediaType = mediaType
+	}
+	switch list.MediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerList:
+		return list, true
+	}
+	if len(list.Manifests) > 0 {
+		list.MediaType = mediaTypeOCIIndex
+		return list, true
+	}
+	return list, false
+}
 
-	_, err = p.layout.WriteBlob(digest, r)
-	return err
+// RetryPolicy returns the effective chunk retry policy.
+func (p *Puller) RetryPolicy() oci.RetryPolicy {
+	return *p.opts.Retry
 }
 
-func (p *Puller) downloadLayerFull(ctx context.Context, registry, repo string, layer oci.Blob) (int64, error) {
-	r, err := p.client.GetBlob(ctx, registry, repo, layer.Digest)
+// PullAll pulls images concurrently, running at most concurrency pulls at once.
+// Every image is attempted; failures are joined into the returned error.
+func (p *Puller) PullAll(ctx context.Context, images []string, concurrency int) ([]*PullResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*PullResult, len(images))
+	errs := make([]error, len(images))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, err := p.Pull(ctx, image)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", image, err)
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	return results, errors.Join(errs...)
+}
+
+// claimBlob registers a layer download. Returns owner=false with the existing
+// state if another pull is already fetching the digest.
+func (p *Puller) claimBlob(digest string) (*blobState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state, ok := p.inflight[digest]; ok {
+		return state, false
+	}
+
+	state := &blobState{done: make(chan struct{})}
+	p.inflight[digest] = state
+	return state, true
+}
+
+// awaitBlob claims digest for this pull, waiting out any other pull's
+// claim. It returns once this pull owns the digest, or without a claim once
+// the blob is in the layout. A blob stored by another pull and removed
+// again before this one woke, as by a concurrent prune, is claimed afresh.
+func (p *Puller) awaitBlob(ctx context.Context, digest string) (*blobState, bool, error) {
+	for {
+		state, owner := p.claimBlob(digest)
+		if owner {
+			return state, true, nil
+		}
+		p.log.Debug("layer in flight, waiting", zap.String("digest", digest))
+		select {
+		case <-state.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if state.err != nil {
+			return nil, false, state.err
+		}
+		if p.layout.HasBlob(digest) {
+			return nil, false, nil
+		}
+	}
+}
+
+func (p *Puller) releaseBlob(digest string, state *blobState, err error) {
+	p.mu.Lock()
+	delete(p.inflight, digest)
+	p.mu.Unlock()
+
+	state.err = err
+	close(state.done)
+}
+
+// downloadBlob fetches a whole blob of size bytes into the layout, passing
+// the bytes written so far to onWrite if it is not nil. A stream that
+// breaks partway is resumed rather than restarted.
+func (p *Puller) downloadBlob(ctx context.Context, registry, repo, digest string, size int64, onWrite func(int64)) (int64, error) {
+	release, err := p.acquire(ctx)
 	if err != nil {
 		return 0, err
 	}
-	defer r.Close()
+	defer release()
 
-	n, err := p.layout.WriteBlob(layer.Digest, r)
+	r, err := p.client.GetBlob(ctx, registry, repo, digest)
 	if err != nil {
 		return 0, err
 	}
+	blob := &resumableBlob{p: p, ctx: ctx, registry: registry, repo: repo, digest: digest, size: size, r: r}
+	defer blob.Close()
+
+	// a resumed stream is only as good as the ranges it was stitched from,
+	// so the write is verified
+	return p.layout.WriteBlobProgress(digest, blob, onWrite)
+}
+
+// resumableBlob reads a blob streamed by GetBlob. When the stream breaks
+// before size bytes, it carries on from the bytes read so far with
+// GetBlobRange, up to the retry policy's MaxRetries times, if the registry
+// serves ranges. A size of zero or less can't be resumed.
+type resumableBlob struct {
+	p        *Puller
+	ctx      context.Context
+	registry string
+	repo     string
+	digest   string
+	size     int64
 
+	r       io.ReadCloser
+	offset  int64
+	resumes int
+	// probed is set once the registry was asked whether it serves ranges,
+	// and ranged holds the answer
+	probed, ranged bool
+}
+
+func (b *resumableBlob) Read(buf []byte) (int, error) {
+	n, err := b.r.Read(buf)
+	b.offset += int64(n)
+	if err == nil || (err == io.EOF && (b.size <= 0 || b.offset >= b.size)) {
+		return n, err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if rerr := b.resume(err); rerr != nil {
+		return n, rerr
+	}
 	return n, nil
 }
 
-func (p *Puller) downloadLayerResumable(ctx context.Context, registry, repo string, layer oci.Blob, layerIdx, totalLayers int) (int64, error) {
+// resume replaces the broken stream with a range request from offset, or
+// returns why it can't.
+func (b *resumableBlob) resume(cause error) error {
+	if b.ctx.Err() != nil || b.size <= 0 || b.offset >= b.size || b.resumes >= b.p.opts.Retry.MaxRetries {
+		return cause
+	}
+	if !b.probed {
+		_, ranged, err := b.p.client.ProbeRange(b.ctx, b.registry, b.repo, b.digest)
+		b.probed, b.ranged = true, ranged && err == nil
+	}
+	if !b.ranged {
+		return cause
+	}
+
+	b.resumes++
+	b.p.log.Debug("resuming blob",
+		zap.String("digest", b.digest),
+		zap.Int64("offset", b.offset),
+		zap.Int("attempt", b.resumes),
+		zap.Error(cause))
+	select {
+	case <-b.ctx.Done():
+		return fmt.Errorf("fetch cancelled: %w", b.ctx.Err())
+	case <-time.After(b.p.opts.Retry.Delay(b.resumes)):
+	}
+
+	r, err := b.p.client.GetBlobRange(b.ctx, b.registry, b.repo, b.digest, b.offset, b.size-1)
+	if err != nil {
+		return fmt.Errorf("resume at %d: %w (after %w)", b.offset, err, cause)
+	}
+	b.r.Close()
+	b.r = r
+	return nil
+}
+
+func (b *resumableBlob) Close() error {
+	return b.r.Close()
+}
+
+func (p *Puller) downloadLayerResumable(ctx context.Context, image, registry, repo string, layer oci.Blob, layerIdx, totalLayers int, result *PullResult) (int64, error) {
+	// an empty layer has no chunks to fetch, and its content is known
+	if layer.Size == 0 {
+		if _, err := p.layout.WriteBlobVerified(layer.Digest, bytes.NewReader(nil)); err != nil {
+			return 0, fmt.Errorf("empty layer: %w", err)
+		}
+		p.reportProgress(layer, layerIdx, totalLayers, 0)
+		return 0, nil
+	}
+
 	// check if registry supports range requests
-	supportsRange, err := p.client.SupportsRange(ctx, registry, repo, layer.Digest)
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	size, supportsRange, err := p.client.ProbeRange(ctx, registry, repo, layer.Digest)
+	release()
 	if err != nil {
 		p.log.Debug("range check failed, falling back to full download", zap.Error(err))
 		supportsRange = false
 	}
 
+	// the chunk tree is sized from the manifest, so a registry disagreeing
+	// about the total would only surface as a short last chunk
+	if supportsRange && size >= 0 && size != layer.Size {
+		return 0, fmt.Errorf("%w: layer %s: manifest declares %d bytes, registry reports %d",
+			ErrSizeMismatch, layer.Digest, layer.Size, size)
+	}
+
 	if !supportsRange {
 		p.log.Debug("registry does not support range requests, using full download",
 			zap.String("registry", registry),
 			zap.String("digest", layer.Digest))
-		return p.downloadLayerFull(ctx, registry, repo, layer)
+		n, err := p.downloadBlob(ctx, registry, repo, layer.Digest, layer.Size, func(written int64) {
+			p.reportProgress(layer, layerIdx, totalLayers, written)
+		})
+		if err == nil {
+			p.reportProgress(layer, layerIdx, totalLayers, layer.Size)
+		}
+		return n, err
 	}
 
-	tree, statePath, resumed, err := p.loadOrCreateTree(layer.Digest, layer.Size)
+	tree, state, resumed, err := p.loadOrCreateTree(image, layer.Digest, layer.Size)
 	if err != nil {
 		return 0, err
 	}
@@ -213,18 +721,68 @@ func (p *Puller) downloadLayerResumable(ctx context.Context, registry, repo stri
 		p.log.Debug("layer already complete, finalizing",
 			zap.Int("layer", layerIdx),
 			zap.String("digest", layer.Digest))
-		if err := p.layout.FinalizeBlob(layer.Digest); err != nil {
-			return 0, err
+	}
+
+	budget := newChunkBudget(tree.NumChunks)
+	downloaded := int64(0)
+	for {
+		n, err := p.fetchMissingChunks(ctx, registry, repo, layer, layerIdx, totalLayers, tree, state, budget, result)
+		downloaded += n
+		if err != nil {
+			return downloaded, err
+		}
+
+		err = p.finalizeLayer(layer.Digest, tree, state)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrCorruptChunks) {
+			return downloaded, err
+		}
+
+		// cleared chunks are fetched again until one runs out of attempts
+		if blame := budget.exhausted(tree, p.opts.MaxChunkAttempts); len(blame) > 0 {
+			return downloaded, fmt.Errorf("%w: layer %s chunks %v fetched %d times: %w",
+				ErrRetryBudgetExhausted, layer.Digest, blame, p.opts.MaxChunkAttempts, err)
 		}
-		os.Remove(statePath)
+		p.log.Info("re-fetching corrupt chunks",
+			zap.String("digest", layer.Digest),
+			zap.Int("chunks", tree.NumChunks-tree.PresentCount))
+	}
+
+	if downloaded == 0 {
+		p.reportProgress(layer, layerIdx, totalLayers, layer.Size)
+	}
+	return downloaded, nil
+}
+
+// fetchMissingChunks downloads every chunk missing from tree into the
+// layer's partial blob, saving state as it goes.
+func (p *Puller) fetchMissingChunks(ctx context.Context, registry, repo string, layer oci.Blob, layerIdx, totalLayers int, tree *merkle.Tree, state *stateFile, budget *chunkBudget, result *PullResult) (int64, error) {
+	missingRanges := tree.MissingRanges()
+	if len(missingRanges) == 0 {
 		return 0, nil
 	}
 
 	downloaded := int64(0)
-	missingRanges := tree.MissingRanges()
+	// unsaved counts bytes written since the state was last saved
+	unsaved := int64(0)
 	totalMissing := 0
+	completed := layer.Size
 	for _, r := range missingRanges {
 		totalMissing += r[1] - r[0]
+		for chunkIdx := r[0]; chunkIdx < r[1]; chunkIdx++ {
+			completed -= int64(tree.ChunkLength(chunkIdx))
+		}
+	}
+	// announce the layer before its first chunk; OnProgress never did
+	if p.opts.OnLayerProgress != nil {
+		p.opts.OnLayerProgress(LayerProgress{
+			Digest:         layer.Digest,
+			Index:          layerIdx,
+			CompletedBytes: completed,
+			TotalBytes:     layer.Size,
+		})
 	}
 
 	p.log.Debug("downloading missing chunks",
@@ -237,22 +795,32 @@ func (p *Puller) downloadLayerResumable(ctx context.Context, registry, repo stri
 			offset := tree.ChunkOffset(chunkIdx)
 			length := int64(tree.ChunkLength(chunkIdx))
 
-			data, err := p.downloadChunk(ctx, registry, repo, layer.Digest, offset, length)
+			data, err := p.downloadChunkRetry(ctx, registry, repo, layer.Digest, offset, length, result)
 			if err != nil {
-				saveErr := p.saveTree(tree, statePath)
+				saveErr := state.save(tree)
 				return downloaded, errors.Join(fmt.Errorf("chunk %d: %w", chunkIdx, err), saveErr)
 			}
 
 			if err := p.layout.WriteBlobAt(layer.Digest, offset, data); err != nil {
-				saveErr := p.saveTree(tree, statePath)
+				saveErr := state.save(tree)
 				return downloaded, errors.Join(fmt.Errorf("write chunk %d: %w", chunkIdx, err), saveErr)
 			}
 
+			if p.opts.KeepChunks {
+				if err := p.keepChunk(layer.Digest, chunkIdx, data); err != nil {
+					saveErr := state.save(tree)
+					return downloaded, errors.Join(err, saveErr)
+				}
+			}
+
 			if err := tree.SetChunk(chunkIdx, data); err != nil {
-				saveErr := p.saveTree(tree, statePath)
+				saveErr := state.save(tree)
 				return downloaded, errors.Join(fmt.Errorf("set chunk %d: %w", chunkIdx, err), saveErr)
 			}
+			budget.record(chunkIdx, tree.ChunkHash(chunkIdx))
 			downloaded += int64(len(data))
+			unsaved += int64(len(data))
+			result.Chunks++
 
 			p.log.Debug("chunk downloaded",
 				zap.Int("layer", layerIdx),
@@ -262,14 +830,14 @@ func (p *Puller) downloadLayerResumable(ctx context.Context, registry, repo stri
 				zap.Int("bytes", len(data)),
 				zap.Float64("progress", tree.Progress()*100))
 
-			if p.opts.OnProgress != nil {
-				p.opts.OnProgress(layerIdx, totalLayers, tree.Progress())
-			}
+			completed += int64(len(data))
+			p.reportProgress(layer, layerIdx, totalLayers, completed)
 
-			if chunkIdx%10 == 0 {
-				if err := p.saveTree(tree, statePath); err != nil {
+			if unsaved >= p.opts.StateSaveInterval {
+				if err := state.save(tree); err != nil {
 					return downloaded, fmt.Errorf("save state: %w", err)
 				}
+				unsaved = 0
 			}
 		}
 	}
@@ -277,18 +845,170 @@ func (p *Puller) downloadLayerResumable(ctx context.Context, registry, repo stri
 	if !tree.Complete() {
 		return downloaded, fmt.Errorf("incomplete")
 	}
+	return downloaded, nil
+}
 
-	if err := p.layout.FinalizeBlob(layer.Digest); err != nil {
-		return downloaded, err
+// reportProgress passes a layer's completed bytes to the progress callbacks.
+func (p *Puller) reportProgress(layer oci.Blob, index, total int, completed int64) {
+	if p.opts.OnLayerProgress != nil {
+		p.opts.OnLayerProgress(LayerProgress{
+			Digest:         layer.Digest,
+			Index:          index,
+			CompletedBytes: completed,
+			TotalBytes:     layer.Size,
+		})
 	}
+	if p.opts.OnProgress != nil {
+		fraction := 1.0
+		if layer.Size > 0 {
+			fraction = float64(completed) / float64(layer.Size)
+		}
+		p.opts.OnProgress(index, total, fraction)
+	}
+}
 
-	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
-		p.log.Debug("cleanup state file", zap.String("path", statePath), zap.Error(err))
+// reportConfigProgress reports the config's completed bytes through
+// OnLayerProgress. OnProgress counts layers, so it doesn't see the config.
+func (p *Puller) reportConfigProgress(config oci.Blob, completed int64) {
+	if p.opts.OnLayerProgress != nil {
+		p.opts.OnLayerProgress(LayerProgress{
+			Digest:         config.Digest,
+			Index:          -1,
+			Config:         true,
+			CompletedBytes: completed,
+			TotalBytes:     config.Size,
+		})
+	}
+}
+
+// finalizeLayer verifies the assembled partial blob and moves it into place.
+// On a digest mismatch the corrupt chunks are cleared from the saved state so
+// a retry re-fetches only those; if none can be blamed, all are cleared.
+func (p *Puller) finalizeLayer(digest string, tree *merkle.Tree, state *stateFile) error {
+	// written before verifying so a corrupt layer's chunks can be compared
+	if p.opts.KeepChunks {
+		dir := p.chunkDir(digest)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create chunk dir: %w", err)
+		}
+		if err := writeChunkManifest(dir, tree); err != nil {
+			return err
+		}
+	}
+
+	computed, err := p.layout.PartialDigest(digest)
+	if err != nil {
+		return fmt.Errorf("verify partial: %w", err)
+	}
+
+	if computed != digest {
+		corrupted := p.verifyChunks(digest, tree)
+		if len(corrupted) == 0 {
+			for i := 0; i < tree.NumChunks; i++ {
+				corrupted = append(corrupted, i)
+			}
+		}
+		for _, idx := range corrupted {
+			tree.ClearChunk(idx)
+		}
+		if err := state.save(tree); err != nil {
+			return fmt.Errorf("%w: expected %s, got %s: save state: %w", ErrDigestMismatch, digest, computed, err)
+		}
+
+		p.log.Info("digest mismatch, cleared corrupt chunks",
+			zap.String("digest", digest),
+			zap.Int("count", len(corrupted)))
+		return fmt.Errorf("%w: expected %s, got %s: %w: %d chunks",
+			ErrDigestMismatch, digest, computed, ErrCorruptChunks, len(corrupted))
+	}
+
+	if err := p.layout.FinalizeBlob(digest); err != nil {
+		return err
+	}
+
+	if err := os.Remove(state.path); err != nil && !os.IsNotExist(err) {
+		p.log.Debug("cleanup state file", zap.String("path", state.path), zap.Error(err))
+	}
+	return nil
+}
+
+// statePath is where the resume state of layer d is saved.
+func (p *Puller) statePath(d string) string {
+	return filepath.Join(p.opts.StateDir, digest.Digest(d).Encoded()+StateFileExt)
+}
+
+// legacyStatePath is the state file older versions named after the first
+// 12 hex digits of d.
+func (p *Puller) legacyStatePath(d string) string {
+	encoded := digest.Digest(d).Encoded()
+	return filepath.Join(p.opts.StateDir, encoded[:min(len(encoded), 12)]+StateFileExt)
+}
+
+// removeState deletes leftover resume state for d, such as after another
+// pull completed the layer.
+func (p *Puller) removeState(d string) {
+	for _, path := range []string{p.statePath(d), p.legacyStatePath(d)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			p.log.Debug("cleanup state file", zap.String("path", path), zap.Error(err))
+		}
 	}
-	return downloaded, nil
+}
+
+// chunkDir is where KeepChunks writes a layer's chunk files.
+func (p *Puller) chunkDir(d string) string {
+	return filepath.Join(p.opts.StateDir, digest.Digest(d).Encoded()+".chunks")
+}
+
+// keepChunk writes one downloaded chunk to its own file for KeepChunks.
+func (p *Puller) keepChunk(digest string, index int, data []byte) error {
+	dir := p.chunkDir(digest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create chunk dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("chunk-%05d", index)), data, 0644); err != nil {
+		return fmt.Errorf("keep chunk %d: %w", index, err)
+	}
+	return nil
+}
+
+func (p *Puller) downloadChunkRetry(ctx context.Context, registry, repo, digest string, offset, length int64, result *PullResult) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= p.opts.Retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			result.Retries++
+			p.opts.Metrics.IncChunkRetries()
+			p.log.Debug("retrying chunk",
+				zap.String("digest", digest),
+				zap.Int64("offset", offset),
+				zap.Int("attempt", attempt),
+				zap.Error(lastErr))
+
+			delay := p.opts.Retry.Delay(attempt)
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("fetch cancelled: %w", ctx.Err())
+			case <-time.After(delay):
+			}
+		}
+
+		data, err := p.downloadChunk(ctx, registry, repo, digest, offset, length)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed after %d retries: %w", p.opts.Retry.MaxRetries+1, lastErr)
 }
 
 func (p *Puller) downloadChunk(ctx context.Context, registry, repo, digest string, offset, length int64) ([]byte, error) {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	r, err := p.client.GetBlobRange(ctx, registry, repo, digest, offset, offset+length-1)
 	if err != nil {
 		return nil, err
@@ -307,37 +1027,154 @@ func (p *Puller) downloadChunk(ctx context.Context, registry, repo, digest strin
 	return data, nil
 }
 
-func (p *Puller) loadOrCreateTree(digest string, size int64) (*merkle.Tree, string, bool, error) {
+func (p *Puller) loadOrCreateTree(image, d string, size int64) (*merkle.Tree, *stateFile, bool, error) {
 	if err := os.MkdirAll(p.opts.StateDir, 0755); err != nil {
-		return nil, "", false, err
+		return nil, nil, false, err
 	}
 
-	digestHash := strings.TrimPrefix(digest, "sha256:")
-	if len(digestHash) > 12 {
-		digestHash = digestHash[:12]
+	state := &stateFile{path: p.statePath(d), image: image, digest: d}
+
+	// adopt state saved under the short name older versions used
+	if _, err := os.Stat(state.path); os.IsNotExist(err) {
+		_ = os.Rename(p.legacyStatePath(d), state.path)
 	}
-	statePath := filepath.Join(p.opts.StateDir, digestHash+".state")
 
-	if _, err := os.Stat(statePath); err == nil {
-		tree, err := merkle.LoadFromFile(statePath)
+	if _, err := os.Stat(state.path); err == nil {
+		tree, err := merkle.LoadFromFile(state.path)
 		if err == nil {
 			// verify existing chunks on resume
-			corrupted := p.verifyChunks(digest, tree)
+			corrupted := p.verifyChunks(d, tree)
 			if len(corrupted) > 0 {
 				p.log.Info("found corrupted chunks, will re-download",
 					zap.Int("count", len(corrupted)),
-					zap.String("digest", digestHash))
+					zap.String("digest", d))
 				for _, idx := range corrupted {
 					tree.ClearChunk(idx)
 				}
-				p.saveTree(tree, statePath)
+				state.save(tree)
 			}
-			return tree, statePath, true, nil
+			return tree, state, true, nil
 		}
 	}
 
-	tree := merkle.New(size, p.opts.ChunkSize)
-	return tree, statePath, false, nil
+	chunkSize := p.opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = AutoChunkSize(size)
+	}
+
+	tree := merkle.New(size, chunkSize)
+
+	// a partial whose state was lost still holds its downloaded chunks
+	if recovered := p.recoverChunks(d, tree); recovered > 0 {
+		p.log.Info("recovered chunks from partial blob without state",
+			zap.Int("count", recovered),
+			zap.String("digest", d))
+		if err := state.save(tree); err != nil {
+			return nil, nil, false, fmt.Errorf("save state: %w", err)
+		}
+		return tree, state, true, nil
+	}
+	return tree, state, false, nil
+}
+
+// recoverChunks marks present the chunks of tree found in d's partial blob
+// and returns how many. Chunks never written read as zeros or past the end
+// of the file, so only full, non-zero chunks count; an all-zero chunk is
+// fetched again. Recovered data can't be checked until the layer is, and a
+// digest mismatch then clears every chunk.
+func (p *Puller) recoverChunks(d string, tree *merkle.Tree) int {
+	recovered := 0
+	for i := 0; i < tree.NumChunks; i++ {
+		length := tree.ChunkLength(i)
+		data, err := p.layout.ReadBlobAt(d, tree.ChunkOffset(i), length)
+		if err != nil {
+			return recovered
+		}
+		if len(data) != length {
+			break
+		}
+		if bytes.Count(data, []byte{0}) == len(data) {
+			continue
+		}
+		if err := tree.SetChunk(i, data); err != nil {
+			break
+		}
+		recovered++
+	}
+	return recovered
+}
+
+const (
+	// MinAutoChunkSize and MaxAutoChunkSize bound AutoChunkSize.
+	MinAutoChunkSize = 64 * 1024
+	MaxAutoChunkSize = 8 * 1024 * 1024
+
+	// autoChunkTarget is the chunk count AutoChunkSize aims for.
+	autoChunkTarget = 256
+)
+
+// AutoChunkSize returns a chunk size giving about 256 chunks for a blob of
+// size bytes: enough for fine-grained resume without a state entry per
+// megabyte of large layers. The result is a multiple of MinAutoChunkSize
+// within [MinAutoChunkSize, MaxAutoChunkSize].
+func AutoChunkSize(size int64) int {
+	chunk := (size + autoChunkTarget - 1) / autoChunkTarget
+	chunk = (chunk + MinAutoChunkSize - 1) / MinAutoChunkSize * MinAutoChunkSize
+	return int(min(max(chunk, MinAutoChunkSize), MaxAutoChunkSize))
+}
+
+// chunkBudget counts the fetches of each chunk in one layer download.
+type chunkBudget struct {
+	attempts []int
+	// last is each chunk's hash from its latest fetch
+	last []merkle.Hash
+	// unstable marks chunks whose bytes changed between fetches
+	unstable map[int]bool
+}
+
+func newChunkBudget(chunks int) *chunkBudget {
+	return &chunkBudget{
+		attempts: make([]int, chunks),
+		last:     make([]merkle.Hash, chunks),
+		unstable: make(map[int]bool),
+	}
+}
+
+func (b *chunkBudget) record(index int, h merkle.Hash) {
+	if b.attempts[index] > 0 && b.last[index] != h {
+		b.unstable[index] = true
+	}
+	b.attempts[index]++
+	b.last[index] = h
+}
+
+// exhausted returns the chunks to blame once a chunk missing from tree has
+// been fetched limit times: those whose bytes changed between fetches, or
+// every missing chunk when none did. It returns nil while budget remains.
+func (b *chunkBudget) exhausted(tree *merkle.Tree, limit int) []int {
+	var missing, unstable []int
+	spent := false
+	for i := 0; i < tree.NumChunks; i++ {
+		if tree.HasChunk(i) {
+			continue
+		}
+		missing = append(missing, i)
+		if b.unstable[i] {
+			unstable = append(unstable, i)
+		}
+		if b.attempts[i] >= limit {
+			spent = true
+		}
+	}
+
+	switch {
+	case !spent:
+		return nil
+	case len(unstable) > 0:
+		return unstable
+	default:
+		return missing
+	}
 }
 
 func (p *Puller) verifyChunks(digest string, tree *merkle.Tree) []int {
@@ -369,13 +1206,3 @@ func (p *Puller) verifyChunks(digest string, tree *merkle.Tree) []int {
 
 	return corrupted
 }
-
-func (p *Puller) saveTree(tree *merkle.Tree, path string) error {
-	return tree.SaveToFile(path)
-}
-
-func sha256Sum(data []byte) []byte {
-	h := sha256.New()
-	h.Write(data)
-	return h.Sum(nil)
-}
@@ -0,0 +1,1272 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/merkle"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// testRegistry serves a single-platform image over the distribution API.
+type testRegistry struct {
+	server   *httptest.Server
+	host     string
+	manifest []byte
+	blobs    map[string][]byte
+
+	manifestDigest string
+	// failRanges fails this many range requests with a 500 before succeeding.
+	failRanges atomic.Int32
+	// corruptRanges serves this many range responses with flipped bytes.
+	corruptRanges atomic.Int32
+	// corruptRange always serves this Range with bytes flipped differently on
+	// each request, like a broken cache node.
+	corruptRange  string
+	corruptServed atomic.Int32
+	// index, when set, is served for tag references instead of the manifest.
+	index []byte
+	// failManifests fails this many manifest-by-digest requests with a 503.
+	failManifests atomic.Int32
+	// blobRequests, when set, counts blob requests in flight, each held
+	// open briefly so concurrent ones overlap.
+	blobRequests *gauge
+	// resetBlobs drops the connection halfway through this many whole-blob
+	// responses.
+	resetBlobs atomic.Int32
+}
+
+// gauge tracks a current count and its peak.
+type gauge struct {
+	cur, peak atomic.Int32
+}
+
+func (g *gauge) inc() {
+	n := g.cur.Add(1)
+	for {
+		peak := g.peak.Load()
+		if n <= peak || g.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (g *gauge) dec() {
+	g.cur.Add(-1)
+}
+
+func newTestRegistry(t *testing.T, config []byte, layers ...[]byte) *testRegistry {
+	t.Helper()
+
+	reg := &testRegistry{blobs: make(map[string][]byte)}
+
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	reg.blobs[configDigest] = config
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: oci.Blob{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+	}
+	for _, layer := range layers {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+		reg.blobs[digest] = layer
+		manifest.Layers = append(manifest.Layers, oci.Blob{
+			MediaType: MediaTypeLayerGzip,
+			Digest:    digest,
+			Size:      int64(len(layer)),
+		})
+	}
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	reg.manifest = data
+	reg.manifestDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serveHTTP))
+	t.Cleanup(reg.server.Close)
+	reg.host = strings.TrimPrefix(reg.server.URL, "http://")
+
+	return reg
+}
+
+func (reg *testRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	if strings.Contains(path, "/manifests/") {
+		byDigest := strings.HasSuffix(path, "/"+reg.manifestDigest)
+		if reg.index != nil && !byDigest {
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Write(reg.index)
+			return
+		}
+		if byDigest && reg.failManifests.Load() > 0 {
+			reg.failManifests.Add(-1)
+			http.Error(w, "injected failure", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Write(reg.manifest)
+		return
+	}
+
+	if idx := strings.Index(path, "/blobs/"); idx != -1 {
+		if reg.blobRequests != nil {
+			reg.blobRequests.inc()
+			defer reg.blobRequests.dec()
+			time.Sleep(2 * time.Millisecond)
+		}
+		data, ok := reg.blobs[path[idx+len("/blobs/"):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") == "" && r.Method == http.MethodGet && reg.resetBlobs.Load() > 0 {
+			reg.resetBlobs.Add(-1)
+			conn, rw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(rw, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(data))
+			rw.Write(data[:len(data)/2])
+			rw.Flush()
+			conn.Close()
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" && rng != "bytes=0-0" && reg.failRanges.Load() > 0 {
+			reg.failRanges.Add(-1)
+			http.Error(w, "injected failure", http.StatusInternalServerError)
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" && rng != "bytes=0-0" && reg.corruptRanges.Load() > 0 {
+			reg.corruptRanges.Add(-1)
+			corrupt := bytes.Clone(data)
+			for i := range corrupt {
+				corrupt[i] ^= 0xff
+			}
+			data = corrupt
+		}
+		if rng := r.Header.Get("Range"); rng != "" && rng == reg.corruptRange {
+			n := byte(reg.corruptServed.Add(1))
+			corrupt := bytes.Clone(data)
+			for i := range corrupt {
+				corrupt[i] ^= n
+			}
+			data = corrupt
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// withIndex serves a single-platform index for the current platform.
+func (reg *testRegistry) withIndex() {
+	reg.index = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` +
+		`{"digest":"` + reg.manifestDigest + `","platform":{"architecture":"` + runtime.GOARCH + `","os":"` + runtime.GOOS + `"}}]}`)
+}
+
+func (reg *testRegistry) client() *oci.Client {
+	c := oci.NewClient()
+	c.SetInsecure(reg.host, true)
+	return c
+}
+
+func (reg *testRegistry) image() string {
+	return reg.host + "/test/repo:latest"
+}
+
+type recordingMetrics struct {
+	mu         sync.Mutex
+	downloaded int64
+	cached     int64
+	retries    int
+	layers     int
+	pulls      int
+}
+
+func (m *recordingMetrics) AddBytesDownloaded(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloaded += n
+}
+
+func (m *recordingMetrics) AddBytesCached(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cached += n
+}
+
+func (m *recordingMetrics) IncChunkRetries() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+func (m *recordingMetrics) IncLayersDownloaded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.layers++
+}
+
+func (m *recordingMetrics) ObservePullDuration(time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pulls++
+}
+
+func TestPullMetrics(t *testing.T) {
+	require := require.New(t)
+
+	config := []byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`)
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, config, layer)
+	reg.failRanges.Store(2)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	metrics := &recordingMetrics{}
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		ChunkSize: 1024,
+		Retry:     &oci.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond},
+		Metrics:   metrics,
+	})
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(3, result.Chunks)
+	require.Equal(2, result.Retries)
+	require.Equal(int64(len(config)+len(layer)), result.Downloaded)
+
+	require.Equal(result.Downloaded, metrics.downloaded)
+	require.Equal(int64(0), metrics.cached)
+	require.Equal(2, metrics.retries)
+	require.Equal(1, metrics.layers)
+	require.Equal(1, metrics.pulls)
+
+	// second pull is served from cache
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(int64(len(config)+len(layer)), metrics.cached)
+	require.Equal(1, metrics.layers)
+	require.Equal(2, metrics.pulls)
+}
+
+func TestPullResumeBlobStream(t *testing.T) {
+	require := require.New(t)
+
+	// the config is fetched whole, not in chunks
+	config := []byte(`{"rootfs":{"type":"layers","diff_ids":[]},"pad":"` + strings.Repeat("x", 4000) + `"}`)
+	reg := newTestRegistry(t, config, []byte("layer"))
+	reg.resetBlobs.Store(1)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		Retry: &oci.RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond},
+	})
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(int32(0), reg.resetBlobs.Load())
+	require.Equal(int64(len(config)+len("layer")), result.Downloaded)
+
+	data, err := l.ReadBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(config)))
+	require.NoError(err)
+	require.Equal(config, data)
+}
+
+func TestPullDigestMismatchRetry(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"corrupt"}`), layer)
+	reg.corruptRanges.Store(1)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	// one attempt per pull leaves the re-fetch to the next pull
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 1024, MaxChunkAttempts: 1})
+
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.True(errors.Is(err, ErrDigestMismatch))
+	require.True(errors.Is(err, ErrCorruptChunks))
+	require.False(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+}
+
+func TestPullCorruptChunkRefetch(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"heal"}`), layer)
+	reg.corruptRanges.Store(1)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 1024})
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+	// every chunk is cleared when the bad one can't be told apart
+	require.Equal(6, result.Chunks)
+}
+
+func TestPullRetryBudget(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"broken"}`), layer)
+	reg.corruptRange = "bytes=1024-2047"
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 1024, MaxChunkAttempts: 4})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := puller.Pull(context.Background(), reg.image())
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pull did not give up")
+	}
+	require.ErrorIs(err, ErrRetryBudgetExhausted)
+	require.ErrorIs(err, ErrDigestMismatch)
+	require.ErrorContains(err, "layer "+digest+" chunks [1] fetched 4 times")
+	require.Equal(int32(4), reg.corruptServed.Load())
+	require.False(l.HasBlob(digest))
+}
+
+func TestPullPlatformManifestRetry(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 2048)
+	reg := newTestRegistry(t, []byte(`{"image":"indexed"}`), layer)
+	reg.withIndex()
+	reg.failManifests.Store(2)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	client := reg.client()
+	client.SetRetryPolicy(oci.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond})
+	puller := NewPuller(l, client, logging.Nop(), PullOptions{ChunkSize: 1024})
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(int32(0), reg.failManifests.Load())
+	require.Equal(int64(len(layer)+len(`{"image":"indexed"}`)), result.Downloaded)
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+}
+
+func TestPullAllSharedLayer(t *testing.T) {
+	require := require.New(t)
+
+	shared := bytes.Repeat([]byte("s"), 4096)
+	ownA := bytes.Repeat([]byte("a"), 2048)
+	ownB := bytes.Repeat([]byte("b"), 2048)
+	configA := []byte(`{"image":"a"}`)
+	configB := []byte(`{"image":"b"}`)
+
+	regA := newTestRegistry(t, configA, shared, ownA)
+	regB := newTestRegistry(t, configB, shared, ownB)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	client := oci.NewClient()
+	client.SetInsecure(regA.host, true)
+	client.SetInsecure(regB.host, true)
+
+	puller := NewPuller(l, client, logging.Nop(), PullOptions{ChunkSize: 1024})
+
+	results, err := puller.PullAll(context.Background(), []string{regA.image(), regB.image()}, 2)
+	require.NoError(err)
+	require.Len(results, 2)
+
+	var downloaded int64
+	for _, r := range results {
+		require.NotNil(r)
+		downloaded += r.Downloaded
+	}
+	want := int64(len(configA) + len(configB) + len(shared) + len(ownA) + len(ownB))
+	require.Equal(want, downloaded)
+
+	index, err := l.GetIndex()
+	require.NoError(err)
+	require.Len(index.Manifests, 2)
+}
+
+func TestPullAllSharedLayerRemoved(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("s"), 4096)
+	client := newFakeClient([]byte(`{"image":"shared"}`), layer)
+	client.noRange = true
+	layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	core, logs := observer.New(zap.DebugLevel)
+	var once sync.Once
+	removed := false
+	puller := NewPuller(l, client, logging.Wrap(zap.New(core)), PullOptions{
+		// the owner stores the layer, then, while the other pull waits on
+		// it, the layer is removed as by a concurrent prune
+		OnLayerProgress: func(p LayerProgress) {
+			if p.Digest != layerDigest || p.CompletedBytes != p.TotalBytes || !l.HasBlob(layerDigest) {
+				return
+			}
+			once.Do(func() {
+				require.Eventually(func() bool {
+					return logs.FilterMessage("layer in flight, waiting").Len() > 0
+				}, 5*time.Second, time.Millisecond)
+				path, err := l.blobPath(layerDigest)
+				require.NoError(err)
+				require.NoError(os.Remove(path))
+				removed = true
+			})
+		},
+	})
+
+	results, err := puller.PullAll(context.Background(), []string{"quay.io/test/a:v1", "quay.io/test/b:v1"}, 2)
+	require.NoError(err)
+	require.Len(results, 2)
+	require.True(removed)
+	require.True(l.HasBlob(layerDigest))
+}
+
+func TestPullAllContinuesOnError(t *testing.T) {
+	require := require.New(t)
+
+	good := newTestRegistry(t, []byte(`{"image":"good"}`), []byte("good layer"))
+	bad := newTestRegistry(t, []byte(`{"image":"bad"}`))
+	bad.blobs = map[string][]byte{}
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	client := oci.NewClient()
+	client.SetInsecure(good.host, true)
+	client.SetInsecure(bad.host, true)
+
+	puller := NewPuller(l, client, logging.Nop(), PullOptions{})
+
+	results, err := puller.PullAll(context.Background(), []string{bad.image(), good.image()}, 2)
+	require.Error(err)
+	require.Contains(err.Error(), bad.image())
+	require.Nil(results[0])
+	require.NotNil(results[1])
+}
+
+func TestAutoChunkSize(t *testing.T) {
+	const kb, mb = 1024, 1024 * 1024
+
+	tests := []struct {
+		name       string
+		size       int64
+		wantChunks int64
+	}{
+		{"empty", 0, 0},
+		{"small config", 50 * kb, 1},
+		{"1MB", 1 * mb, 16},
+		{"16MB", 16 * mb, 256},
+		{"500MB layer", 500 * mb, 250},
+		{"2GB", 2048 * mb, 256},
+		{"4GB clamps to max", 4096 * mb, 512},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			size := AutoChunkSize(tt.size)
+			require.GreaterOrEqual(size, MinAutoChunkSize)
+			require.LessOrEqual(size, MaxAutoChunkSize)
+			require.Zero(size % MinAutoChunkSize)
+
+			chunks := (tt.size + int64(size) - 1) / int64(size)
+			require.Equal(tt.wantChunks, chunks)
+			if size < MaxAutoChunkSize {
+				require.LessOrEqual(chunks, int64(autoChunkTarget))
+			}
+		})
+	}
+}
+
+func TestPullAutoChunkSize(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 200*1024)
+	reg := newTestRegistry(t, []byte(`{"image":"auto"}`), layer)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{})
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(4, result.Chunks)
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+}
+
+func TestPullPlatform(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"os and architecture", `{"os":"linux","architecture":"amd64"}`, "linux/amd64"},
+		{"with variant", `{"os":"linux","architecture":"arm64","variant":"v8"}`, "linux/arm64/v8"},
+		{"missing os", `{"image":"bare"}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			reg := newTestRegistry(t, []byte(tt.config), []byte("layer"))
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			result, err := NewPuller(l, reg.client(), logging.Nop(), PullOptions{}).Pull(context.Background(), reg.image())
+			require.NoError(err)
+			require.Equal(tt.want, result.Platform)
+		})
+	}
+}
+
+func TestPullStateSaveInterval(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("s"), 10*1024)
+	reg := newTestRegistry(t, []byte(`{"image":"cadence"}`), layer)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	stateDir := t.TempDir()
+	statePath := filepath.Join(stateDir, strings.TrimPrefix(digest, "sha256:")+StateFileExt)
+
+	// saved records the chunk count on disk as each chunk lands, before the
+	// puller decides whether to save
+	var saved []int
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		ChunkSize:         1024,
+		StateDir:          stateDir,
+		StateSaveInterval: 3 * 1024,
+		OnProgress: func(_, _ int, _ float64) {
+			tree, err := merkle.LoadFromFile(statePath)
+			if err != nil {
+				saved = append(saved, 0)
+				return
+			}
+			saved = append(saved, tree.PresentCount)
+		},
+	})
+
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal([]int{0, 0, 0, 3, 3, 3, 6, 6, 6, 9}, saved)
+}
+
+func TestPullKeepChunks(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("k"), 2500)
+	reg := newTestRegistry(t, []byte(`{"image":"keep"}`), layer)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	stateDir := t.TempDir()
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		ChunkSize:  1024,
+		StateDir:   stateDir,
+		KeepChunks: true,
+	})
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.True(l.HasBlob(digest))
+
+	dir := filepath.Join(stateDir, strings.TrimPrefix(digest, "sha256:")+".chunks")
+	data, err := os.ReadFile(filepath.Join(dir, ChunksFile))
+	require.NoError(err)
+	var records []ChunkRecord
+	require.NoError(json.Unmarshal(data, &records))
+	require.Len(records, 3)
+
+	for i, rec := range records {
+		chunk, err := os.ReadFile(filepath.Join(dir, rec.File))
+		require.NoError(err)
+		require.Equal(i, rec.Index)
+		require.Equal(int64(i*1024), rec.Offset)
+		require.Equal(layer[rec.Offset:rec.Offset+int64(rec.Length)], chunk)
+		require.Equal(merkle.HashData(chunk).String(), rec.Hash)
+	}
+	require.Equal(2500-2048, records[2].Length)
+}
+
+func TestPullUnchanged(t *testing.T) {
+	require := require.New(t)
+
+	reg := newTestRegistry(t, []byte(`{"image":"same"}`), []byte("layer one"), []byte("layer two"))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{})
+
+	first, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.False(first.Unchanged)
+
+	second, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.True(second.Unchanged)
+	require.Equal(first.Digest, second.Digest)
+	require.Zero(second.Downloaded)
+
+	// same manifest under a new tag still adds the tag
+	other, err := puller.Pull(context.Background(), reg.host+"/test/repo:v2")
+	require.NoError(err)
+	require.False(other.Unchanged)
+	img, err := l.FindByRef(reg.host + "/test/repo:v2")
+	require.NoError(err)
+	require.Equal(first.Digest, img.Digest)
+}
+
+func TestPullTagMoved(t *testing.T) {
+	require := require.New(t)
+
+	reg := newTestRegistry(t, []byte(`{"image":"v1"}`), []byte("first layer"))
+	oldDigest := reg.manifestDigest
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{})
+
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+
+	// upstream moves latest to a new image
+	next := newTestRegistry(t, []byte(`{"image":"v2"}`), []byte("second layer"))
+	maps.Copy(reg.blobs, next.blobs)
+	reg.manifest = next.manifest
+	reg.manifestDigest = next.manifestDigest
+	require.NotEqual(oldDigest, reg.manifestDigest)
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.False(result.Unchanged)
+
+	index, err := l.GetIndex()
+	require.NoError(err)
+	var tagged []string
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationRefName] == reg.image() {
+			tagged = append(tagged, m.Digest)
+		}
+	}
+	require.Equal([]string{reg.manifestDigest}, tagged)
+}
+
+func TestPullEmptyLayer(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"empty"}`), []byte{}, layer)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	var progress []float64
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		ChunkSize: 1024,
+		OnProgress: func(layer, _ int, p float64) {
+			if layer == 0 {
+				progress = append(progress, p)
+			}
+		},
+	})
+
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(2, result.Layers)
+	require.Equal(3, result.Chunks)
+	require.Equal([]float64{1.0}, progress)
+
+	empty := fmt.Sprintf("sha256:%x", sha256.Sum256(nil))
+	require.True(l.HasBlob(empty))
+	require.Equal(int64(0), l.BlobSize(empty))
+	require.True(l.HasBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(layer))))
+}
+
+func TestPullLayerProgress(t *testing.T) {
+	require := require.New(t)
+
+	config := []byte(`{"image":"progress"}`)
+	layers := [][]byte{bytes.Repeat([]byte("a"), 3000), bytes.Repeat([]byte("b"), 1500)}
+	reg := newTestRegistry(t, config, layers...)
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	configSize := int64(len(config))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	var mu sync.Mutex
+	events := make(map[string][]LayerProgress)
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{
+		ChunkSize: 1024,
+		OnLayerProgress: func(p LayerProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			events[p.Digest] = append(events[p.Digest], p)
+		},
+	})
+
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Len(events, len(layers)+1)
+
+	// the config counts toward progress too, though it isn't a layer
+	got := events[configDigest]
+	require.GreaterOrEqual(len(got), 2)
+	for j, p := range got {
+		require.True(p.Config)
+		require.Equal(-1, p.Index)
+		require.Equal(configSize, p.TotalBytes)
+		if j > 0 {
+			require.Greater(p.CompletedBytes, got[j-1].CompletedBytes)
+		}
+	}
+	require.Equal(int64(0), got[0].CompletedBytes)
+	require.Equal(configSize, got[len(got)-1].CompletedBytes)
+
+	for i, layer := range layers {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+		got := events[digest]
+		require.NotEmpty(got, "layer %d", i)
+
+		for j, p := range got {
+			require.Equal(i, p.Index)
+			require.Equal(int64(len(layer)), p.TotalBytes)
+			if j > 0 {
+				require.Greater(p.CompletedBytes, got[j-1].CompletedBytes)
+			}
+		}
+		require.Equal(int64(0), got[0].CompletedBytes)
+		require.Equal(int64(len(layer)), got[len(got)-1].CompletedBytes)
+	}
+
+	// cached layers report once, complete
+	clear(events)
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal([]LayerProgress{{Digest: configDigest, Index: -1, Config: true, CompletedBytes: configSize, TotalBytes: configSize}}, events[configDigest])
+	for i, layer := range layers {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+		size := int64(len(layer))
+		require.Equal([]LayerProgress{{Digest: digest, Index: i, CompletedBytes: size, TotalBytes: size}}, events[digest])
+	}
+}
+
+func TestPullArtifact(t *testing.T) {
+	require := require.New(t)
+
+	sbom := []byte(`{"spdxVersion":"SPDX-2.3","name":"app"}`)
+	reg := newTestRegistry(t, oci.EmptyJSON, sbom)
+	// the empty config's content is known, so the registry needn't serve it
+	delete(reg.blobs, oci.EmptyJSONDigest)
+
+	sbomDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(sbom))
+	// the subject and annotations aren't modelled by oci.Manifest, but must
+	// be stored, and the manifest's digest kept, as the registry served them
+	data := []byte(fmt.Sprintf(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "artifactType": "application/spdx+json",
+  "config": {"mediaType": %q, "digest": %q, "size": %d},
+  "layers": [{"mediaType": "application/spdx+json", "digest": %q, "size": %d, "annotations": {"org.opencontainers.image.title": "sbom.spdx.json"}}],
+  "subject": {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:%s", "size": 1234},
+  "annotations": {"org.opencontainers.image.created": "2026-01-02T03:04:05Z"}
+}`, oci.MediaTypeEmptyJSON, oci.EmptyJSONDigest, len(oci.EmptyJSON), sbomDigest, len(sbom), strings.Repeat("a", 64)))
+	var manifest oci.Manifest
+	require.NoError(json.Unmarshal(data, &manifest))
+	require.True(manifest.IsArtifact())
+	reg.manifest = data
+	reg.manifestDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 16, VerifyDiffIDs: true})
+	result, err := puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+	require.Equal(reg.manifestDigest, result.Digest)
+	require.Empty(result.Platform)
+
+	config, err := l.ReadBlob(oci.EmptyJSONDigest)
+	require.NoError(err)
+	require.Equal(oci.EmptyJSON, config)
+	stored, err := l.ReadBlob(sbomDigest)
+	require.NoError(err)
+	require.Equal(sbom, stored)
+
+	img, err := l.FindByRef(reg.image())
+	require.NoError(err)
+	require.Equal(reg.manifestDigest, img.Digest)
+	storedManifest, err := l.ReadBlob(reg.manifestDigest)
+	require.NoError(err)
+	require.Equal(data, storedManifest)
+	index, err := l.GetIndex()
+	require.NoError(err)
+	require.Len(index.Manifests, 1)
+	require.Equal("application/spdx+json", index.Manifests[0].ArtifactType)
+
+	inspect, err := l.Inspect(reg.image())
+	require.NoError(err)
+	require.Equal("application/spdx+json", inspect.Manifest.ArtifactType)
+	require.Len(inspect.Manifest.Layers, 1)
+	require.Empty(inspect.Config.Platform())
+}
+
+func TestPullLayerSizeMismatch(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("x"), 3000)
+	reg := newTestRegistry(t, []byte(`{"image":"short"}`), layer)
+
+	var manifest oci.Manifest
+	require.NoError(json.Unmarshal(reg.manifest, &manifest))
+	manifest.Layers[0].Size = 3100
+	data, err := json.Marshal(manifest)
+	require.NoError(err)
+	reg.manifest = data
+	reg.manifestDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 1024})
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.ErrorIs(err, ErrSizeMismatch)
+	require.ErrorContains(err, "manifest declares 3100 bytes, registry reports 3000")
+	require.False(l.HasBlob(manifest.Layers[0].Digest))
+}
+
+func TestPullComputeDiffIDs(t *testing.T) {
+	require := require.New(t)
+
+	var layers, uncompressed [][]byte
+	for _, body := range []string{"base", "app"} {
+		tarball := buildTar(t, []tarEntry{{name: "etc/" + body, typeflag: tar.TypeReg, body: body}})
+		uncompressed = append(uncompressed, tarball)
+		layers = append(layers, gzipBytes(t, tarball))
+	}
+
+	var config ImageConfig
+	config.OS = "linux"
+	config.RootFS.Type = "layers"
+	for _, tarball := range uncompressed {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, fmt.Sprintf("sha256:%x", sha256.Sum256(tarball)))
+	}
+	configData, err := json.Marshal(config)
+	require.NoError(err)
+
+	reg := newTestRegistry(t, configData, layers...)
+
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(err)
+
+	puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 64, ComputeDiffIDs: true, VerifyDiffIDs: true})
+	_, err = puller.Pull(context.Background(), reg.image())
+	require.NoError(err)
+
+	require.FileExists(filepath.Join(dir, ".fray", DiffIDsFile))
+	diffIDs, err := l.DiffIDs()
+	require.NoError(err)
+	require.Len(diffIDs, len(layers))
+	for i, layer := range layers {
+		require.Equal(config.RootFS.DiffIDs[i], diffIDs[fmt.Sprintf("sha256:%x", sha256.Sum256(layer))])
+	}
+}
+
+func TestPullMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxConcurrency int
+		jobs           int
+	}{
+		{"capped below jobs", 2, 4},
+		{"single request", 1, 4},
+		{"cap above jobs", 8, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			requests := &gauge{}
+			client := oci.NewClient()
+			var images []string
+			for i := range 4 {
+				layer := bytes.Repeat([]byte{byte('a' + i)}, 4096)
+				reg := newTestRegistry(t, []byte(fmt.Sprintf(`{"image":%d}`, i)), layer)
+				reg.blobRequests = requests
+				client.SetInsecure(reg.host, true)
+				images = append(images, reg.image())
+			}
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			puller := NewPuller(l, client, logging.Nop(), PullOptions{ChunkSize: 512, Parallel: 8, MaxConcurrency: tt.maxConcurrency})
+			_, err = puller.PullAll(context.Background(), images, tt.jobs)
+			require.NoError(err)
+			require.LessOrEqual(requests.peak.Load(), int32(tt.maxConcurrency))
+		})
+	}
+}
+
+func TestPullStateFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		cached bool
+		legacy bool
+	}{
+		{name: "stale state for a cached layer", cached: true},
+		{name: "stale legacy state for a cached layer", cached: true, legacy: true},
+		{name: "legacy state resumed", legacy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			layer := bytes.Repeat([]byte("g"), 3000)
+			reg := newTestRegistry(t, []byte(`{"image":"state"}`), layer)
+			digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+			encoded := strings.TrimPrefix(digest, "sha256:")
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			if tt.cached {
+				_, err = l.WriteBlob(digest, bytes.NewReader(layer))
+				require.NoError(err)
+			}
+
+			stateDir := t.TempDir()
+			name := encoded + StateFileExt
+			if tt.legacy {
+				name = encoded[:12] + StateFileExt
+			}
+			require.NoError(merkle.New(int64(len(layer)), 1024).SaveToFile(filepath.Join(stateDir, name)))
+
+			puller := NewPuller(l, reg.client(), logging.Nop(), PullOptions{ChunkSize: 1024, StateDir: stateDir})
+			_, err = puller.Pull(context.Background(), reg.image())
+			require.NoError(err)
+			require.True(l.HasBlob(digest))
+
+			entries, err := os.ReadDir(stateDir)
+			require.NoError(err)
+			require.Empty(entries)
+		})
+	}
+}
+
+func TestPullResumeWithoutNetwork(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("r"), 4096)
+	client := newFakeClient([]byte(`{"image":"fake"}`), layer)
+	digest := client.manifest.Layers[0].Digest
+
+	// the chunk at 2048 fails until the registry recovers
+	recovered := false
+	client.failRange = func(_ string, start int64) error {
+		if start == 2048 && !recovered {
+			return fmt.Errorf("%w: status 503", oci.ErrTransient)
+		}
+		return nil
+	}
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	opts := PullOptions{ChunkSize: 1024, Retry: &oci.RetryPolicy{MaxRetries: 0, BaseDelay: time.Millisecond}}
+
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.True(errors.Is(err, oci.ErrTransient))
+	require.Equal([]string{"0-1023", "1024-2047", "2048-3071"}, client.ranges)
+	require.False(l.HasBlob(digest))
+
+	recovered = true
+	client.ranges = nil
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.NoError(err)
+	require.Equal([]string{"2048-3071", "3072-4095"}, client.ranges)
+
+	data, err := l.ReadBlob(digest)
+	require.NoError(err)
+	require.Equal(layer, data)
+}
+
+func TestPullInvalidManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "manifest list",
+			body:    `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`,
+			wantErr: "is an index, not a manifest",
+		},
+		{
+			name:    "schema1",
+			body:    `{"schemaVersion":1,"name":"test/repo","tag":"v1","fsLayers":[{"blobSum":"sha256:00"}]}`,
+			wantErr: "schema1 manifests are not supported",
+		},
+		{
+			name:    "empty",
+			body:    `{}`,
+			wantErr: "unsupported schema version 0",
+		},
+		{
+			name:    "no config",
+			body:    `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`,
+			wantErr: "no config digest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			client := newFakeClient([]byte(`{"image":"fake"}`))
+			client.manifest = &oci.Manifest{}
+			require.NoError(json.Unmarshal([]byte(tt.body), client.manifest))
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			_, err = NewPuller(l, client, logging.Nop(), PullOptions{}).Pull(context.Background(), "fake.io/test/repo:v1")
+			require.ErrorIs(err, oci.ErrInvalidManifest)
+			require.ErrorContains(err, tt.wantErr)
+
+			images, err := l.Images()
+			require.NoError(err)
+			require.Empty(images)
+		})
+	}
+}
+
+func TestPullLimits(t *testing.T) {
+	layers := [][]byte{bytes.Repeat([]byte("a"), 100), bytes.Repeat([]byte("b"), 100), bytes.Repeat([]byte("c"), 100)}
+	config := []byte(`{"image":"fake"}`)
+
+	tests := []struct {
+		name    string
+		opts    PullOptions
+		huge    bool
+		wantErr string
+	}{
+		{"within limits", PullOptions{MaxLayers: 3, MaxTotalSize: 300 + int64(len(config))}, false, ""},
+		{"too many layers", PullOptions{MaxLayers: 2}, false, "manifest declares 3 layers, max is 2"},
+		{"too large", PullOptions{MaxTotalSize: 250}, false, fmt.Sprintf("manifest declares %d bytes, max is 250", 300+len(config))},
+		{"overflowing sizes", PullOptions{MaxTotalSize: 1 << 40}, true, fmt.Sprintf("manifest declares %d bytes", int64(math.MaxInt64))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			client := newFakeClient(config, layers...)
+			if tt.huge {
+				for i := range client.manifest.Layers {
+					client.manifest.Layers[i].Size = math.MaxInt64 / 2
+				}
+			}
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			_, err = NewPuller(l, client, logging.Nop(), tt.opts).Pull(context.Background(), "fake.io/test/repo:v1")
+			if tt.wantErr == "" {
+				require.NoError(err)
+				return
+			}
+			require.ErrorIs(err, ErrLimitExceeded)
+			require.ErrorContains(err, tt.wantErr)
+			require.Empty(client.ranges, "nothing is downloaded")
+			count := 0
+			require.NoError(l.WalkBlobs(func(string, int64) error { count++; return nil }))
+			require.Zero(count)
+		})
+	}
+}
+
+func TestPullStateInProgress(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("s"), 4096)
+	client := newFakeClient([]byte(`{"image":"fake"}`), layer)
+	layerDigest := client.manifest.Layers[0].Digest
+	client.failRange = func(_ string, start int64) error {
+		if start >= 3072 {
+			return fmt.Errorf("%w: status 503", oci.ErrTransient)
+		}
+		return nil
+	}
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	opts := PullOptions{ChunkSize: 1024, Retry: &oci.RetryPolicy{MaxRetries: 0, BaseDelay: time.Millisecond}}
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.Error(err)
+
+	statePath := filepath.Join(l.Root(), ".fray", strings.TrimPrefix(layerDigest, "sha256:")+StateFileExt)
+	state, err := ReadPullState(statePath)
+	require.NoError(err)
+	require.Equal("fake.io/test/repo:v1", state.Image)
+	require.Equal(layerDigest, state.Digest)
+	require.Equal(int64(len(layer)), state.TotalSize)
+	require.Equal(3, state.PresentCount)
+
+	// the merkle state still loads on its own
+	tree, err := merkle.LoadFromFile(statePath)
+	require.NoError(err)
+	require.Equal(3, tree.PresentCount)
+
+	// state from older versions records no image
+	legacy := merkle.New(2048, 1024)
+	require.NoError(legacy.SetChunk(0, make([]byte, 1024)))
+	legacyDigest := digest.FromBytes([]byte("legacy")).String()
+	require.NoError(legacy.SaveToFile(filepath.Join(l.Root(), ".fray", strings.TrimPrefix(legacyDigest, "sha256:")+StateFileExt)))
+
+	layers, err := l.InProgress("")
+	require.NoError(err)
+	require.Len(layers, 2)
+	byDigest := map[string]InProgressLayer{}
+	for _, layer := range layers {
+		byDigest[layer.Digest] = layer
+	}
+
+	pulled := byDigest[layerDigest]
+	require.Equal("fake.io/test/repo:v1", pulled.Image)
+	require.Equal(0.75, pulled.Progress())
+	require.True(pulled.Resumable)
+
+	old := byDigest[legacyDigest]
+	require.Empty(old.Image)
+	require.Equal(0.5, old.Progress())
+	require.False(old.Resumable, "no partial blob was downloaded")
+
+	require.Equal([]string{"fake.io/test/repo:v1"}, InProgressImages(layers))
+
+	client.failRange = nil
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.NoError(err)
+	layers, err = l.InProgress("")
+	require.NoError(err)
+	require.Len(layers, 1, "finished layers leave no state")
+}
+
+func TestPullRecoverPartialWithoutState(t *testing.T) {
+	require := require.New(t)
+
+	layer := make([]byte, 4096)
+	for i := range layer {
+		layer[i] = byte(i%251) + 1
+	}
+	client := newFakeClient([]byte(`{"image":"fake"}`), layer)
+	layerDigest := client.manifest.Layers[0].Digest
+	client.failRange = func(_ string, start int64) error {
+		if start >= 2048 {
+			return fmt.Errorf("%w: status 503", oci.ErrTransient)
+		}
+		return nil
+	}
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	opts := PullOptions{ChunkSize: 1024, Retry: &oci.RetryPolicy{MaxRetries: 0, BaseDelay: time.Millisecond}}
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.Error(err)
+
+	// the state is lost but the partial blob survives
+	statePath := filepath.Join(l.Root(), ".fray", strings.TrimPrefix(layerDigest, "sha256:")+StateFileExt)
+	require.NoError(os.Remove(statePath))
+
+	client.failRange = nil
+	client.ranges = nil
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.NoError(err)
+	require.Equal([]string{"2048-3071", "3072-4095"}, client.ranges)
+	require.True(l.HasBlob(layerDigest))
+}
+
+func TestPullRecoverCorruptPartialWithoutState(t *testing.T) {
+	require := require.New(t)
+
+	layer := bytes.Repeat([]byte("p"), 4096)
+	client := newFakeClient([]byte(`{"image":"fake"}`), layer)
+	layerDigest := client.manifest.Layers[0].Digest
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	require.NoError(l.WriteBlobAt(layerDigest, 0, bytes.Repeat([]byte("x"), 2048)))
+
+	opts := PullOptions{ChunkSize: 1024, Retry: &oci.RetryPolicy{MaxRetries: 0, BaseDelay: time.Millisecond}}
+	_, err = NewPuller(l, client, logging.Nop(), opts).Pull(context.Background(), "fake.io/test/repo:v1")
+	require.NoError(err)
+	require.Equal([]string{"2048-3071", "3072-4095", "0-1023", "1024-2047", "2048-3071", "3072-4095"}, client.ranges,
+		"recovered chunks that fail verification are fetched again")
+
+	data, err := l.ReadBlob(layerDigest)
+	require.NoError(err)
+	require.Equal(layer, data)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// Reindex rebuilds index.json from the blobs, for when it was deleted or
+// corrupted. Entries of a readable index whose manifest blob still exists
+// are kept; every other manifest or image index that no stored index
+// references is added untagged, so it can be found by digest. It returns
+// the number of entries added.
+func (l *Layout) Reindex() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	roots, err := l.manifestRoots()
+	if err != nil {
+		return 0, err
+	}
+
+	index, err := l.readIndex()
+	if err != nil {
+		index = &Index{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	}
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(m Descriptor) bool {
+		path, err := l.blobPath(m.Digest)
+		if err != nil {
+			return true
+		}
+		_, err = os.Stat(path)
+		return err != nil
+	})
+	if index.Manifests == nil {
+		index.Manifests = []Descriptor{}
+	}
+
+	added := 0
+	for _, root := range roots {
+		indexed := slices.ContainsFunc(index.Manifests, func(m Descriptor) bool {
+			return m.Digest == root.Digest
+		})
+		if !indexed {
+			index.Manifests = append(index.Manifests, root)
+			added++
+		}
+	}
+
+	if err := l.writeIndex(index); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// manifestRoots returns a descriptor for every manifest or image index blob
+// that no other image index lists.
+func (l *Layout) manifestRoots() ([]Descriptor, error) {
+	var (
+		found    []Descriptor
+		children = make(map[string]bool)
+	)
+
+	err := l.walkBlobs(func(d, path string, info fs.FileInfo) error {
+		if info.Size() > oci.DefaultMaxManifestSize {
+			return nil
+		}
+		data, err := readPreservingAccess(path)
+		if err != nil {
+			return nil
+		}
+
+		var m struct {
+			MediaType    string       `json:"mediaType"`
+			ArtifactType string       `json:"artifactType"`
+			Config       *Descriptor  `json:"config"`
+			Layers       []Descriptor `json:"layers"`
+			Manifests    []Descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil
+		}
+
+		mediaType := m.MediaType
+		switch {
+		case m.Manifests != nil:
+			if mediaType == "" {
+				mediaType = mediaTypeOCIIndex
+			}
+			for _, child := range m.Manifests {
+				children[child.Digest] = true
+			}
+		case m.Config != nil && m.Layers != nil:
+			if mediaType == "" {
+				mediaType = mediaTypeOCIManifest
+			}
+		default:
+			return nil
+		}
+
+		found = append(found, Descriptor{MediaType: mediaType, ArtifactType: m.ArtifactType, Digest: d, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(found, func(desc Descriptor) bool {
+		return children[desc.Digest]
+	}), nil
+}
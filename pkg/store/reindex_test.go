@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+func TestReindex(t *testing.T) {
+	tests := []struct {
+		name       string
+		damage     func(t *testing.T, l *Layout)
+		wantAdded  int
+		wantTagged bool
+	}{
+		{
+			name: "missing index",
+			damage: func(t *testing.T, l *Layout) {
+				require.NoError(t, os.Remove(filepath.Join(l.Root(), IndexFile)))
+			},
+			wantAdded: 2,
+		},
+		{
+			name: "corrupt index",
+			damage: func(t *testing.T, l *Layout) {
+				require.NoError(t, os.WriteFile(filepath.Join(l.Root(), IndexFile), []byte("{not json"), 0644))
+			},
+			wantAdded: 2,
+		},
+		{
+			name:       "intact index",
+			damage:     func(*testing.T, *Layout) {},
+			wantTagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+
+			addAgedImage(t, l, "test/app:v1", "app layer", 0)
+			addAgedImage(t, l, "test/db:v1", "db layer", 0)
+			index, err := l.GetIndex()
+			require.NoError(err)
+			var want []string
+			for _, m := range index.Manifests {
+				want = append(want, m.Digest)
+			}
+
+			tt.damage(t, l)
+
+			added, err := l.Reindex()
+			require.NoError(err)
+			require.Equal(tt.wantAdded, added)
+
+			index, err = l.GetIndex()
+			require.NoError(err)
+			var got []string
+			for _, m := range index.Manifests {
+				got = append(got, m.Digest)
+			}
+			require.ElementsMatch(want, got)
+
+			for _, d := range want {
+				images, err := l.FindByDigest(d)
+				require.NoError(err)
+				require.Len(images, 1)
+			}
+			_, err = l.FindByRef("test/app:v1")
+			require.Equal(tt.wantTagged, err == nil)
+
+			// recovered images are referenced again, so GC keeps their blobs
+			result, err := l.GC(0)
+			require.NoError(err)
+			require.Zero(result.Blobs)
+		})
+	}
+}
+
+func TestReindexImageIndex(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	addAgedImage(t, l, "test/app:amd64", "amd64 layer", time.Hour)
+	index, err := l.GetIndex()
+	require.NoError(err)
+	child := index.Manifests[0]
+
+	list, err := json.Marshal(Index{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests:     []Descriptor{{MediaType: mediaTypeOCIManifest, Digest: child.Digest, Size: child.Size}},
+	})
+	require.NoError(err)
+	listDigest := digest.FromBytes(list).String()
+	_, err = l.WriteBlob(listDigest, strings.NewReader(string(list)))
+	require.NoError(err)
+
+	require.NoError(os.Remove(filepath.Join(l.Root(), IndexFile)))
+
+	added, err := l.Reindex()
+	require.NoError(err)
+	require.Equal(1, added)
+
+	index, err = l.GetIndex()
+	require.NoError(err)
+	require.Equal([]Descriptor{{MediaType: mediaTypeOCIIndex, Digest: listDigest, Size: int64(len(list))}}, index.Manifests)
+}
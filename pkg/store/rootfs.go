@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+var ErrUnsupportedPlatform = errors.New("unsupported platform")
+
+// ExtractRootfs writes the merged filesystem of the image indexed under ref
+// to destDir, applying each layer in order. Whiteouts remove files from
+// earlier layers. Only linux image manifests are supported; device nodes and
+// fifos are skipped. Layers with a recorded diff id are verified as they are
+// extracted.
+func (l *Layout) ExtractRootfs(ref, destDir string) error {
+	img, err := l.Inspect(ref)
+	if err != nil {
+		return err
+	}
+	if img.Config.OS != "" && img.Config.OS != "linux" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedPlatform, img.Config.OS)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("create rootfs: %w", err)
+	}
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return fmt.Errorf("open rootfs: %w", err)
+	}
+	defer root.Close()
+
+	diffIDs, err := l.DiffIDs()
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range img.Manifest.Layers {
+		if err := l.extractLayer(root, layer, diffIDs[layer.Digest]); err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// extractLayer applies layer to root, checking its uncompressed content
+// against diffID unless that is empty.
+func (l *Layout) extractLayer(root *os.Root, layer oci.Blob, diffID string) error {
+	r, err := l.OpenBlobDecompressed(layer.Digest, layer.MediaType)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if diffID == "" {
+		return ApplyLayer(r, NewDirTree(root))
+	}
+
+	h := digest.Canonical.New()
+	tee := io.TeeReader(r, h)
+	if err := ApplyLayer(tee, NewDirTree(root)); err != nil {
+		return err
+	}
+	// the tar reader stops at the end-of-archive marker, before any padding
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return fmt.Errorf("decompress %s: %w", layer.Digest, err)
+	}
+	if got := digest.Canonical.FromHash(h).String(); got != diffID {
+		return fmt.Errorf("%w: expected %s, got %s", ErrDiffIDMismatch, diffID, got)
+	}
+	return nil
+}
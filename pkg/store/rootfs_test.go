@@ -0,0 +1,216 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+func writeTestBlob(t *testing.T, l *Layout, data []byte) string {
+	t.Helper()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	_, err := l.WriteBlob(digest, bytes.NewReader(data))
+	require.NoError(t, err)
+	return digest
+}
+
+// newRootfsImage stores an image whose layers are gzipped tars of the given
+// entries and indexes it under ref.
+func newRootfsImage(t *testing.T, l *Layout, ref, goos string, layers ...[]tarEntry) {
+	t.Helper()
+
+	config, err := json.Marshal(map[string]string{"os": goos})
+	require.NoError(t, err)
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config:        oci.Blob{Digest: writeTestBlob(t, l, config), Size: int64(len(config))},
+	}
+	for _, entries := range layers {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(buildTar(t, entries))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		manifest.Layers = append(manifest.Layers, oci.Blob{
+			MediaType: MediaTypeLayerGzip,
+			Digest:    writeTestBlob(t, l, buf.Bytes()),
+			Size:      int64(buf.Len()),
+		})
+	}
+
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, l.AddManifest(Descriptor{
+		MediaType:   manifest.MediaType,
+		Digest:      writeTestBlob(t, l, data),
+		Size:        int64(len(data)),
+		Annotations: map[string]string{AnnotationRefName: ref},
+	}))
+}
+
+func TestExtractRootfs(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	newRootfsImage(t, l, "quay.io/test/app:v1", "linux",
+		[]tarEntry{
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/hostname", typeflag: tar.TypeReg, body: "base"},
+			{name: "etc/removed", typeflag: tar.TypeReg, body: "gone"},
+			{name: "bin/sh", typeflag: tar.TypeReg, body: "shell"},
+		},
+		[]tarEntry{
+			{name: "etc/.wh.removed", typeflag: tar.TypeReg},
+			{name: "etc/hostname", typeflag: tar.TypeReg, body: "override"},
+			{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "sh"},
+		},
+	)
+
+	dest := filepath.Join(t.TempDir(), "rootfs")
+	require.NoError(l.ExtractRootfs("quay.io/test/app:v1", dest))
+
+	data, err := os.ReadFile(filepath.Join(dest, "etc/hostname"))
+	require.NoError(err)
+	require.Equal("override", string(data))
+
+	_, err = os.Lstat(filepath.Join(dest, "etc/removed"))
+	require.True(errors.Is(err, os.ErrNotExist))
+	_, err = os.Lstat(filepath.Join(dest, "etc/.wh.removed"))
+	require.True(errors.Is(err, os.ErrNotExist))
+
+	link, err := os.Readlink(filepath.Join(dest, "bin/ash"))
+	require.NoError(err)
+	require.Equal("sh", link)
+}
+
+func TestExtractRootfsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		goos    string
+		layer   []tarEntry
+		wantErr error
+	}{
+		{
+			name:    "unknown image",
+			ref:     "quay.io/test/missing:v1",
+			wantErr: ErrImageNotFound,
+		},
+		{
+			name:    "non-linux image",
+			ref:     "quay.io/test/app:v1",
+			goos:    "windows",
+			wantErr: ErrUnsupportedPlatform,
+		},
+		{
+			name:    "path traversal",
+			ref:     "quay.io/test/app:v1",
+			goos:    "linux",
+			layer:   []tarEntry{{name: "../escape", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: ErrUnsafePath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			newRootfsImage(t, l, "quay.io/test/app:v1", tt.goos, tt.layer)
+
+			dir := t.TempDir()
+			err = l.ExtractRootfs(tt.ref, filepath.Join(dir, "rootfs"))
+			require.True(errors.Is(err, tt.wantErr), "got %v", err)
+
+			_, err = os.Stat(filepath.Join(dir, "escape"))
+			require.True(errors.Is(err, os.ErrNotExist))
+		})
+	}
+}
+
+func TestExtractRootfsRecordedDiffIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		diffID  string
+		wantErr error
+	}{
+		{name: "matching"},
+		{name: "mismatching", diffID: "sha256:" + strings.Repeat("0", 64), wantErr: ErrDiffIDMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			newRootfsImage(t, l, "quay.io/test/app:v1", "linux",
+				[]tarEntry{{name: "etc/hostname", typeflag: tar.TypeReg, body: "base"}})
+
+			img, err := l.Inspect("quay.io/test/app:v1")
+			require.NoError(err)
+			require.NoError(l.ComputeDiffIDs(&img.Manifest))
+			if tt.diffID != "" {
+				require.NoError(l.recordDiffIDs(map[string]string{img.Manifest.Layers[0].Digest: tt.diffID}))
+			}
+
+			err = l.ExtractRootfs("quay.io/test/app:v1", filepath.Join(t.TempDir(), "rootfs"))
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(err)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+// LayoutSource serves the images in a layout as a BlobClient, so a Puller
+// or proxy can use another layout as its upstream: a fray cache on the same
+// host, or a layout exported to removable media for offline seeding.
+// Images are found by the reference they were pulled as; the registry
+// named in it is never contacted.
+type LayoutSource struct {
+	layout *Layout
+}
+
+var _ ManifestClient = (*LayoutSource)(nil)
+
+// NewLayoutSource creates a source that reads images from l.
+func NewLayoutSource(l *Layout) *LayoutSource {
+	return &LayoutSource{layout: l}
+}
+
+// GetPlatformManifest returns the manifest tagged as registry/repo:ref, or
+// stored under digest ref, resolving an index to platform.
+func (s *LayoutSource) GetPlatformManifest(_ context.Context, registry, repo, ref, platform string) (*oci.Manifest, error) {
+	if platform != "" {
+		if err := oci.ValidatePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	d := ref
+	if _, err := digest.Parse(ref); err != nil {
+		img, err := s.layout.FindByRef(registry + "/" + repo + ":" + ref)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", oci.ErrNotFound, err)
+		}
+		d = img.Digest
+	}
+
+	// an index is resolved to one of its manifests; deeper nesting is refused
+	for range 2 {
+		data, err := s.layout.ReadBlob(d)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: manifest %s", oci.ErrNotFound, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var list oci.ManifestList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("parse manifest %s: %w", d, err)
+		}
+		if len(list.Manifests) > 0 {
+			if d, err = oci.SelectPlatform(list, platform); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var manifest oci.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("parse manifest %s: %w", d, err)
+		}
+		return &manifest, nil
+	}
+	return nil, fmt.Errorf("%w: nested index at %s", oci.ErrManifestDepth, d)
+}
+
+// GetRawManifest returns the manifest or index tagged as registry/repo:ref,
+// or stored under digest ref, as stored, and its media type.
+func (s *LayoutSource) GetRawManifest(_ context.Context, registry, repo, ref string) ([]byte, string, error) {
+	d, mediaType := ref, ""
+	if _, err := digest.Parse(ref); err != nil {
+		img, err := s.layout.FindByRef(registry + "/" + repo + ":" + ref)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", oci.ErrNotFound, err)
+		}
+		d, mediaType = img.Digest, img.MediaType
+	}
+
+	data, err := s.layout.ReadBlob(d)
+	if os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("%w: manifest %s", oci.ErrNotFound, d)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if mediaType == "" {
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, "", fmt.Errorf("parse manifest %s: %w", d, err)
+		}
+		mediaType = m.MediaType
+	}
+	return data, mediaType, nil
+}
+
+// GetBlob opens a blob.
+func (s *LayoutSource) GetBlob(_ context.Context, _, _, d string) (io.ReadCloser, error) {
+	return s.open(d)
+}
+
+// GetBlobRange returns bytes [start, end] of a blob; end is inclusive.
+func (s *LayoutSource) GetBlobRange(_ context.Context, _, _, d string, start, end int64) (io.ReadCloser, error) {
+	f, err := s.open(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, end-start+1), f}, nil
+}
+
+// StatBlob returns a blob's size.
+func (s *LayoutSource) StatBlob(_ context.Context, _, _, d string) (int64, error) {
+	size := s.layout.BlobSize(d)
+	if size < 0 {
+		return 0, fmt.Errorf("%w: blob %s", oci.ErrNotFound, d)
+	}
+	return size, nil
+}
+
+// ProbeRange reports a blob's size. Every blob in a layout can be read in
+// ranges.
+func (s *LayoutSource) ProbeRange(ctx context.Context, registry, repo, d string) (int64, bool, error) {
+	size, err := s.StatBlob(ctx, registry, repo, d)
+	if err != nil {
+		return -1, false, err
+	}
+	return size, true, nil
+}
+
+func (s *LayoutSource) open(d string) (*os.File, error) {
+	path, err := s.layout.blobPath(d)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: blob %s", oci.ErrNotFound, d)
+	}
+	return f, err
+}
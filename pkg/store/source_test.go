@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/logging"
+	"github.com/hexfusion/fray/pkg/oci"
+)
+
+func TestPullFromLayout(t *testing.T) {
+	const image = "quay.io/test/app:v1"
+	config := []byte(`{"os":"linux","architecture":"amd64"}`)
+	layers := [][]byte{bytes.Repeat([]byte("a"), 2500), []byte("small layer")}
+
+	// layout A is seeded from a registry; B only ever sees A
+	a, err := Open(t.TempDir())
+	require.NoError(t, err)
+	seeded, err := NewPuller(a, newFakeClient(config, layers...), logging.Nop(), PullOptions{}).Pull(context.Background(), image)
+	require.NoError(t, err)
+
+	// an index in A naming the image for the host platform
+	index, err := json.Marshal(oci.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests: []oci.Platform{{
+			Digest:   seeded.Digest,
+			Platform: oci.PlatformSpec{OS: runtime.GOOS, Architecture: runtime.GOARCH},
+		}},
+	})
+	require.NoError(t, err)
+	indexDigest := digest.FromBytes(index).String()
+	_, err = a.WriteBlobVerified(indexDigest, bytes.NewReader(index))
+	require.NoError(t, err)
+	require.NoError(t, a.SetTag("quay.io/test/app:multi", Descriptor{
+		MediaType: "application/vnd.oci.image.index.v1+json",
+		Digest:    indexDigest,
+		Size:      int64(len(index)),
+	}))
+
+	tests := []struct {
+		name    string
+		image   string
+		wantErr error
+	}{
+		{"tag", image, nil},
+		{"digest", "quay.io/test/app@" + seeded.Digest, nil},
+		{"index", "quay.io/test/app:multi", nil},
+		{"missing tag", "quay.io/test/app:v2", oci.ErrNotFound},
+		{"other repository", "quay.io/test/other:v1", oci.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			b, err := Open(t.TempDir())
+			require.NoError(err)
+
+			// chunks smaller than the first layer are served as ranges
+			result, err := NewPuller(b, NewLayoutSource(a), logging.Nop(), PullOptions{ChunkSize: 1024}).Pull(context.Background(), tt.image)
+			if tt.wantErr != nil {
+				require.ErrorIs(err, tt.wantErr)
+				return
+			}
+			require.NoError(err)
+			require.Equal(seeded.Digest, result.Digest)
+			require.Equal(int64(len(layers[0])+len(layers[1])), result.Downloaded-int64(len(config)))
+
+			for _, blob := range append([][]byte{config}, layers...) {
+				data, err := b.ReadBlob(digest.FromBytes(blob).String())
+				require.NoError(err)
+				require.Equal(blob, data)
+			}
+			img, err := b.FindByRef(tt.image)
+			require.NoError(err)
+			require.Equal(seeded.Digest, img.Digest)
+		})
+	}
+}
+
+func TestPullKeepIndex(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	// layout A holds an index of two images, one for the host
+	a, err := Open(t.TempDir())
+	require.NoError(err)
+	host, err := NewPuller(a, newFakeClient([]byte(`{"os":"host"}`), []byte("host layer")), logging.Nop(), PullOptions{}).Pull(ctx, "quay.io/test/app:host")
+	require.NoError(err)
+	other, err := NewPuller(a, newFakeClient([]byte(`{"os":"other"}`), []byte("other layer")), logging.Nop(), PullOptions{}).Pull(ctx, "quay.io/test/app:other")
+	require.NoError(err)
+
+	otherArch := "arm64"
+	if runtime.GOARCH == otherArch {
+		otherArch = "amd64"
+	}
+	index, err := json.Marshal(oci.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests: []oci.Platform{
+			{Digest: host.Digest, Platform: oci.PlatformSpec{OS: runtime.GOOS, Architecture: runtime.GOARCH}},
+			{Digest: other.Digest, Platform: oci.PlatformSpec{OS: runtime.GOOS, Architecture: otherArch}},
+		},
+	})
+	require.NoError(err)
+	indexDigest := digest.FromBytes(index).String()
+	_, err = a.WriteBlobVerified(indexDigest, bytes.NewReader(index))
+	require.NoError(err)
+	require.NoError(a.SetTag("quay.io/test/app:multi", Descriptor{MediaType: mediaTypeOCIIndex, Digest: indexDigest, Size: int64(len(index))}))
+
+	b, err := Open(t.TempDir())
+	require.NoError(err)
+	puller := NewPuller(b, NewLayoutSource(a), logging.Nop(), PullOptions{KeepIndex: true})
+
+	// the tag names the index, stored as served, and the host's image
+	result, err := puller.Pull(ctx, "quay.io/test/app:multi")
+	require.NoError(err)
+	require.Equal(indexDigest, result.Digest)
+	img, err := b.FindByRef("quay.io/test/app:multi")
+	require.NoError(err)
+	require.Equal(indexDigest, img.Digest)
+	require.Equal(mediaTypeOCIIndex, img.MediaType)
+	data, err := b.ReadBlob(indexDigest)
+	require.NoError(err)
+	require.Equal(index, data)
+	require.True(b.HasBlob(host.Digest))
+	require.False(b.HasBlob(other.Digest))
+
+	result, err = puller.Pull(ctx, "quay.io/test/app:multi")
+	require.NoError(err)
+	require.True(result.Unchanged)
+
+	// the other platform's image is pulled by the digest the index lists
+	result, err = puller.Pull(ctx, "quay.io/test/app@"+other.Digest)
+	require.NoError(err)
+	require.Equal(other.Digest, result.Digest)
+	require.True(b.HasBlob(digest.FromBytes([]byte("other layer")).String()))
+
+	// a client that can't fetch manifests as stored can't keep indexes
+	_, err = NewPuller(b, newFakeClient(nil), logging.Nop(), PullOptions{KeepIndex: true}).Pull(ctx, "quay.io/test/app:v1")
+	require.ErrorContains(err, "keep index")
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hexfusion/fray/pkg/digest"
+	"github.com/hexfusion/fray/pkg/merkle"
+)
+
+// PullState is what the Puller saves in a state file while a layer
+// downloads: the layer's merkle state and the download it belongs to.
+// State files from versions that saved only the merkle state still load,
+// with Image and Digest empty.
+type PullState struct {
+	// Image is the reference last pulled into this layer.
+	Image  string `json:"image,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	merkle.State
+}
+
+// stateFile is where a layer's PullState is saved, and what is recorded
+// in it besides the tree.
+type stateFile struct {
+	path   string
+	image  string
+	digest string
+}
+
+func (s *stateFile) save(tree *merkle.Tree) error {
+	return merkle.WriteJSONFile(s.path, &PullState{
+		Image:  s.image,
+		Digest: s.digest,
+		State:  *tree.Serialize(),
+	})
+}
+
+// ReadPullState reads the state file at path.
+func ReadPullState(path string) (*PullState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state PullState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// InProgressLayer is a layer download interrupted before it finished.
+type InProgressLayer struct {
+	// Image is empty for state that didn't record it.
+	Image        string `json:"image,omitempty"`
+	Digest       string `json:"digest,omitempty"`
+	TotalSize    int64  `json:"total_bytes"`
+	NumChunks    int    `json:"chunks"`
+	PresentCount int    `json:"present_chunks"`
+	// Resumable is whether the downloaded chunks are still on disk. If not,
+	// resuming starts the layer over.
+	Resumable bool   `json:"resumable"`
+	Path      string `json:"path"`
+}
+
+// Progress is the fraction of the layer's chunks downloaded.
+func (s InProgressLayer) Progress() float64 {
+	if s.NumChunks == 0 {
+		return 0
+	}
+	return float64(s.PresentCount) / float64(s.NumChunks)
+}
+
+// InProgress lists the layer downloads with state in stateDir, the
+// Puller's StateDir; empty means the default under the layout. Unreadable
+// state files are skipped.
+func (l *Layout) InProgress(stateDir string) ([]InProgressLayer, error) {
+	if stateDir == "" {
+		stateDir = filepath.Join(l.root, MetadataDir)
+	}
+	entries, err := os.ReadDir(stateDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []InProgressLayer
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), StateFileExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		path := filepath.Join(stateDir, e.Name())
+		state, err := ReadPullState(path)
+		if err != nil {
+			continue
+		}
+
+		d := state.Digest
+		if d == "" {
+			// only full length names are known digests
+			if parsed, err := digest.Parse(string(digest.Canonical) + ":" + name); err == nil && len(name) == 64 {
+				d = string(parsed)
+			}
+		}
+
+		resumable := false
+		if d != "" {
+			if partial, err := l.partialPath(d); err == nil {
+				_, err := os.Stat(partial)
+				resumable = err == nil
+			}
+		}
+
+		layers = append(layers, InProgressLayer{
+			Image:        state.Image,
+			Digest:       d,
+			TotalSize:    state.TotalSize,
+			NumChunks:    state.NumChunks,
+			PresentCount: state.PresentCount,
+			Resumable:    resumable,
+			Path:         path,
+		})
+	}
+	return layers, nil
+}
+
+// InProgressImages returns the images with layer downloads in progress, in
+// the order first seen.
+func InProgressImages(layers []InProgressLayer) []string {
+	var images []string
+	for _, layer := range layers {
+		if layer.Image != "" && !slices.Contains(images, layer.Image) {
+			images = append(images, layer.Image)
+		}
+	}
+	return images
+}
@@ -2,37 +2,67 @@ package store
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 
+	"github.com/hexfusion/fray/pkg/digest"
 	"github.com/hexfusion/fray/pkg/merkle"
 	"github.com/hexfusion/fray/pkg/oci"
 )
 
 var (
-	ErrDigestMismatch    = errors.New("digest mismatch")
+	ErrDigestMismatch    = oci.ErrDigestMismatch
 	ErrLayerIncomplete   = errors.New("layer incomplete")
 	ErrChunkSizeMismatch = errors.New("chunk size mismatch")
 	ErrRangeMismatch     = errors.New("range response size mismatch")
+	ErrSizeMismatch      = errors.New("size mismatch")
+	ErrInvalidDigest     = digest.ErrInvalid
+	// ErrCorruptChunks marks a digest mismatch whose bad chunks were cleared
+	// from state; retrying the download re-fetches only those chunks.
+	ErrCorruptChunks = errors.New("corrupt chunks cleared")
+	// ErrRetryBudgetExhausted is returned when a layer still fails
+	// verification after its chunks were fetched MaxChunkAttempts times.
+	ErrRetryBudgetExhausted = errors.New("chunk retry budget exhausted")
+	// ErrLimitExceeded is returned when a manifest declares more layers or
+	// bytes than PullOptions allow.
+	ErrLimitExceeded = errors.New("image exceeds pull limits")
 )
 
 const (
 	DefaultChunkSize = 1024 * 1024
-	TreeFile         = "tree.json"
+	// TreeFile is a layer's merkle state in its Store directory,
+	// <root>/layers/<encoded digest>/.
+	TreeFile = "tree.json"
+	// StateFileExt ends the Puller's merkle state files, saved flat in its
+	// StateDir as <encoded digest>.state.
+	StateFileExt = ".state"
+	// ChunksFile lists kept chunk files when chunks are kept for debugging.
+	ChunksFile = "chunks.json"
+	// DefaultStateSaveInterval is how many downloaded bytes may go unsaved
+	// in merkle state before it is written out.
+	DefaultStateSaveInterval = 16 * 1024 * 1024
+	// DefaultMaxChunkAttempts is how many times a pull fetches a chunk of a
+	// layer that fails verification before giving up.
+	DefaultMaxChunkAttempts = 3
 )
 
 // Store manages layer downloads with merkle tree state.
 type Store struct {
-	root        string
-	chunkSize   int
-	parallelism int
-	fetcher     *oci.Fetcher
+	root         string
+	chunkSize    int
+	parallelism  int
+	saveInterval int64
+	fetcher      *oci.Fetcher
+	// ranges fetches chunks; it is fetcher unless WithFetcher replaced it.
+	ranges     RangeFetcher
+	transport  http.RoundTripper
+	keepChunks bool
 }
 
 // Option configures a Store.
@@ -56,20 +86,83 @@ func WithParallelism(n int) Option {
 	}
 }
 
+// WithStateSaveInterval sets how many downloaded bytes may go unsaved in a
+// layer's merkle state.
+func WithStateSaveInterval(n int64) Option {
+	return func(s *Store) {
+		if n > 0 {
+			s.saveInterval = n
+		}
+	}
+}
+
+// WithIdleTimeout fails and retries a chunk fetch that receives no bytes
+// for d, in place of the fetcher's total request timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.fetcher.SetIdleTimeout(d)
+	}
+}
+
+// WithRetryPolicy sets how the fetcher retries failed chunk requests.
+func WithRetryPolicy(p oci.RetryPolicy) Option {
+	return func(s *Store) {
+		s.fetcher.SetRetryPolicy(p)
+	}
+}
+
+// WithTransport sends chunk fetches through rt, so stores can share one
+// connection pool. By default each store gets a transport with an idle
+// connection per parallel fetch.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(s *Store) {
+		s.transport = rt
+	}
+}
+
+// WithFetcher fetches chunks through f instead of the store's HTTP fetcher,
+// whose idle timeout and transport then go unused.
+func WithFetcher(f RangeFetcher) Option {
+	return func(s *Store) {
+		s.ranges = f
+	}
+}
+
+// WithKeepChunks keeps chunk files after assembly and writes a ChunksFile
+// next to them, for inspecting corrupt downloads.
+func WithKeepChunks(keep bool) Option {
+	return func(s *Store) {
+		s.keepChunks = keep
+	}
+}
+
 // New creates a new store.
 func New(root string, opts ...Option) *Store {
 	s := &Store{
-		root:        root,
-		chunkSize:   DefaultChunkSize,
-		parallelism: 1,
-		fetcher:     oci.NewFetcher(),
+		root:         root,
+		chunkSize:    DefaultChunkSize,
+		parallelism:  1,
+		saveInterval: DefaultStateSaveInterval,
+		fetcher:      oci.NewFetcher(),
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.transport == nil {
+		s.transport = oci.NewTransport(oci.TransportOptions{MaxIdleConnsPerHost: s.parallelism})
+	}
+	s.fetcher.SetTransport(s.transport)
+	if s.ranges == nil {
+		s.ranges = s.fetcher
+	}
 	return s
 }
 
+// RetryPolicy returns the fetcher's retry policy.
+func (s *Store) RetryPolicy() oci.RetryPolicy {
+	return s.fetcher.RetryPolicy()
+}
+
 // LayerState represents the download state of a layer.
 type LayerState struct {
 	Digest    string
@@ -90,6 +183,43 @@ type fetchResult struct {
 	err        error
 }
 
+// ChunkError is a chunk that failed to be fetched or stored.
+type ChunkError struct {
+	Index int
+	Err   error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d: %v", e.Index, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// FailedChunks returns every ChunkError in err's tree, in the order they
+// were reported.
+func FailedChunks(err error) []*ChunkError {
+	var failed []*ChunkError
+	var walk func(error)
+	walk = func(err error) {
+		if ce, ok := err.(*ChunkError); ok {
+			failed = append(failed, ce)
+			return
+		}
+		switch u := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, e := range u.Unwrap() {
+				walk(e)
+			}
+		case interface{ Unwrap() error }:
+			walk(u.Unwrap())
+		}
+	}
+	walk(err)
+	return failed
+}
+
 // GetOrCreateLayer gets existing layer state or creates new.
 func (s *Store) GetOrCreateLayer(digest string, size int64) (*LayerState, error) {
 	storePath := s.layerPath(digest)
@@ -130,7 +260,7 @@ func (s *Store) FetchChunk(ctx context.Context, layer *LayerState, url string, c
 	length := layer.Tree.ChunkLength(chunkIndex)
 	end := start + int64(length)
 
-	data, err := s.fetcher.FetchRange(ctx, url, start, end)
+	data, err := s.ranges.FetchRange(ctx, url, start, end)
 	if err != nil {
 		return fmt.Errorf("fetch chunk %d: %w", chunkIndex, err)
 	}
@@ -151,7 +281,9 @@ func (s *Store) FetchChunk(ctx context.Context, layer *LayerState, url string, c
 	return nil
 }
 
-// FetchMissing fetches all missing chunks with parallel downloads.
+// FetchMissing fetches all missing chunks with parallel downloads. Chunks
+// that fail don't stop the others; each is reported as a ChunkError, and
+// the state of those that succeeded is saved.
 func (s *Store) FetchMissing(ctx context.Context, layer *LayerState, url string, progress func(int, int)) error {
 	missing := layer.Tree.MissingChunks()
 	total := len(missing)
@@ -180,12 +312,12 @@ func (s *Store) FetchMissing(ctx context.Context, layer *LayerState, url string,
 		close(jobs)
 	}()
 
-	firstErr := s.collectResults(layer, results, total, progress)
+	err := s.collectResults(layer, results, total, progress)
 
 	wg.Wait()
 	s.SaveState(layer)
 
-	return firstErr
+	return err
 }
 
 func (s *Store) fetchWorker(ctx context.Context, layer *LayerState, url string, jobs <-chan fetchJob, results chan<- fetchResult, wg *sync.WaitGroup) {
@@ -203,55 +335,56 @@ func (s *Store) fetchWorker(ctx context.Context, layer *LayerState, url string,
 		length := layer.Tree.ChunkLength(j.chunkIndex)
 		end := start + int64(length)
 
-		data, err := s.fetcher.FetchRange(ctx, url, start, end)
+		data, err := s.ranges.FetchRange(ctx, url, start, end)
 		results <- fetchResult{j.index, j.chunkIndex, data, err}
 	}
 }
 
 func (s *Store) collectResults(layer *LayerState, results <-chan fetchResult, total int, progress func(int, int)) error {
-	var firstErr error
+	var failed []error
 	completed := 0
+	unsaved := int64(0)
 
 	for range total {
 		r := <-results
 
-		if r.err != nil && firstErr == nil {
-			firstErr = r.err
+		if r.err != nil {
+			failed = append(failed, &ChunkError{Index: r.chunkIndex, Err: r.err})
 			continue
 		}
 
-		if r.err == nil {
-			chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", r.chunkIndex))
-			if err := os.WriteFile(chunkPath, r.data, 0644); err != nil {
-				if firstErr == nil {
-					firstErr = fmt.Errorf("write chunk %d: %w", r.chunkIndex, err)
-				}
-				continue
-			}
+		chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", r.chunkIndex))
+		if err := os.WriteFile(chunkPath, r.data, 0644); err != nil {
+			failed = append(failed, &ChunkError{Index: r.chunkIndex, Err: fmt.Errorf("write: %w", err)})
+			continue
+		}
 
-			if err := layer.Tree.SetChunk(r.chunkIndex, r.data); err != nil {
-				if firstErr == nil {
-					firstErr = fmt.Errorf("update tree for chunk %d: %w", r.chunkIndex, err)
-				}
-				continue
-			}
+		if err := layer.Tree.SetChunk(r.chunkIndex, r.data); err != nil {
+			failed = append(failed, &ChunkError{Index: r.chunkIndex, Err: fmt.Errorf("update tree: %w", err)})
+			continue
+		}
 
-			completed++
-			if progress != nil {
-				progress(completed, total)
-			}
+		completed++
+		unsaved += int64(len(r.data))
+		if progress != nil {
+			progress(completed, total)
+		}
 
-			if completed%10 == 0 {
-				s.SaveState(layer)
-			}
+		if unsaved >= s.saveInterval {
+			s.SaveState(layer)
+			unsaved = 0
 		}
 	}
 
-	return firstErr
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d chunks failed: %w", len(failed), total, errors.Join(failed...))
+	}
+	return nil
 }
 
 func (s *Store) fetchMissingSeq(ctx context.Context, layer *LayerState, url string, missing []int, progress func(int, int)) error {
 	total := len(missing)
+	unsaved := int64(0)
 
 	for i, chunkIndex := range missing {
 		select {
@@ -265,69 +398,244 @@ func (s *Store) fetchMissingSeq(ctx context.Context, layer *LayerState, url stri
 			return err
 		}
 
+		unsaved += int64(layer.Tree.ChunkLength(chunkIndex))
 		if progress != nil {
 			progress(i+1, total)
 		}
 
-		if (i+1)%10 == 0 {
+		if unsaved >= s.saveInterval {
 			s.SaveState(layer)
+			unsaved = 0
 		}
 	}
 
 	return s.SaveState(layer)
 }
 
-// AssembleBlob assembles all chunks into the final blob.
-func (s *Store) AssembleBlob(layer *LayerState) (string, error) {
+// AssembleBlob assembles all chunks into the final blob. The chunks are
+// verified against the layer digest first, so a corrupt download writes
+// nothing. Unless WithKeepChunks is set, chunks are then moved into the
+// blob as it grows rather than copied, so the layer is never on disk twice.
+// Cancelling ctx stops it between chunks with ctx's error, writing no blob
+// and leaving the chunks in place, except once chunks have begun moving,
+// when it runs to the end rather than lose them.
+func (s *Store) AssembleBlob(ctx context.Context, layer *LayerState) (string, error) {
 	if !layer.Tree.Complete() {
 		return "", fmt.Errorf("%w: %d/%d chunks",
 			ErrLayerIncomplete, layer.Tree.PresentCount, layer.Tree.NumChunks)
 	}
 
-	blobPath := filepath.Join(layer.StorePath, "blob")
-	f, err := os.Create(blobPath)
+	expected, err := digest.Parse(layer.Digest)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
 
-	hasher := sha256.New()
+	blobPath := filepath.Join(layer.StorePath, "blob")
+	// chunks already moved into an assembled blob
+	if got, _, err := digestFile(blobPath, expected.Algorithm()); err == nil && got == expected {
+		return blobPath, nil
+	}
+
+	computedDigest, err := s.chunksDigest(ctx, layer, expected.Algorithm())
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+	if err != nil || computedDigest != expected {
+		if err == nil {
+			err = fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, layer.Digest, computedDigest)
+		}
+		cleared, clearErr := s.clearCorrupt(layer)
+		if clearErr != nil {
+			return "", fmt.Errorf("%w: %w", err, clearErr)
+		}
+		return "", fmt.Errorf("%w: %w: %d chunks", err, ErrCorruptChunks, cleared)
+	}
 
+	if err := s.concatChunks(ctx, layer, blobPath+".tmp", !s.keepChunks); err != nil {
+		return "", err
+	}
+	if err := os.Rename(blobPath+".tmp", blobPath); err != nil {
+		return "", fmt.Errorf("rename blob: %w", err)
+	}
+	return blobPath, nil
+}
+
+// chunksDigest hashes the layer's chunk files in order.
+func (s *Store) chunksDigest(ctx context.Context, layer *LayerState, algorithm digest.Algorithm) (digest.Digest, error) {
+	hasher := algorithm.New()
 	for i := 0; i < layer.Tree.NumChunks; i++ {
-		chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i))
-		data, err := os.ReadFile(chunkPath)
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i)))
 		if err != nil {
 			return "", fmt.Errorf("read chunk %d: %w", i, err)
 		}
+		hasher.Write(data)
+	}
+	return algorithm.FromHash(hasher), nil
+}
+
+// concatChunks writes the layer's chunks to path in order. With move set
+// the first chunk file becomes path and the rest are removed once
+// appended, so at most one chunk is held twice. Moved chunks can't be put
+// back, so a move is only cancelled before it starts; a copy cancelled
+// partway removes path.
+func (s *Store) concatChunks(ctx context.Context, layer *LayerState, path string, move bool) error {
+	chunkPath := func(i int) string {
+		return filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i))
+	}
 
+	first := 0
+	var (
+		f   *os.File
+		err error
+	)
+	if move {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := os.Rename(chunkPath(0), path); err != nil {
+			return fmt.Errorf("move chunk 0: %w", err)
+		}
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		first = 1
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for i := first; i < layer.Tree.NumChunks; i++ {
+		if !move {
+			if err := ctx.Err(); err != nil {
+				f.Close()
+				os.Remove(path)
+				return err
+			}
+		}
+		data, err := os.ReadFile(chunkPath(i))
+		if err != nil {
+			return fmt.Errorf("read chunk %d: %w", i, err)
+		}
 		if _, err := f.Write(data); err != nil {
-			return "", fmt.Errorf("write chunk %d: %w", i, err)
+			return fmt.Errorf("write chunk %d: %w", i, err)
 		}
+		if move {
+			os.Remove(chunkPath(i))
+		}
+	}
+	return f.Close()
+}
 
-		hasher.Write(data)
+// VerifyLayer re-hashes the stored chunk files against the tree and returns
+// the indexes of present chunks whose data is missing or does not match.
+func (s *Store) VerifyLayer(layer *LayerState) []int {
+	var corrupted []int
+
+	for i := 0; i < layer.Tree.NumChunks; i++ {
+		if !layer.Tree.HasChunk(i) {
+			continue
+		}
+
+		chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i))
+		data, err := os.ReadFile(chunkPath)
+		if err != nil || len(data) != layer.Tree.ChunkLength(i) {
+			corrupted = append(corrupted, i)
+			continue
+		}
+
+		if merkle.HashData(data) != layer.Tree.ChunkHash(i) {
+			corrupted = append(corrupted, i)
+		}
+	}
+
+	return corrupted
+}
+
+// clearCorrupt drops corrupt chunks from the tree and disk so the next fetch
+// re-downloads them. If no chunk can be blamed, every chunk is cleared.
+func (s *Store) clearCorrupt(layer *LayerState) (int, error) {
+	corrupted := s.VerifyLayer(layer)
+	if len(corrupted) == 0 {
+		for i := 0; i < layer.Tree.NumChunks; i++ {
+			corrupted = append(corrupted, i)
+		}
 	}
 
-	computedDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
-	if computedDigest != layer.Digest {
-		os.Remove(blobPath)
-		return "", fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, layer.Digest, computedDigest)
+	for _, i := range corrupted {
+		layer.Tree.ClearChunk(i)
+		chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i))
+		if err := os.Remove(chunkPath); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("remove chunk %d: %w", i, err)
+		}
 	}
 
-	return blobPath, nil
+	if err := s.SaveState(layer); err != nil {
+		return 0, fmt.Errorf("save state: %w", err)
+	}
+
+	return len(corrupted), nil
 }
 
-// CleanupChunks removes individual chunk files after assembly.
-func (s *Store) CleanupChunks(layer *LayerState) error {
+// CleanupChunks removes individual chunk files after assembly. With
+// WithKeepChunks it leaves them and writes a ChunksFile instead. Cancelled,
+// it stops and leaves the remaining chunk files.
+func (s *Store) CleanupChunks(ctx context.Context, layer *LayerState) error {
+	if s.keepChunks {
+		return writeChunkManifest(layer.StorePath, layer.Tree)
+	}
 	for i := 0; i < layer.Tree.NumChunks; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		chunkPath := filepath.Join(layer.StorePath, fmt.Sprintf("chunk-%05d", i))
 		os.Remove(chunkPath)
 	}
 	return nil
 }
 
-func (s *Store) layerPath(digest string) string {
-	clean := strings.TrimPrefix(digest, "sha256:")
-	return filepath.Join(s.root, "layers", clean)
+// ChunkRecord describes one chunk file in a ChunksFile.
+type ChunkRecord struct {
+	Index  int    `json:"index"`
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+	// Hash is the chunk's merkle tree hash.
+	Hash string `json:"hash"`
+}
+
+// writeChunkManifest writes a ChunksFile to dir for the chunks present in
+// tree.
+func writeChunkManifest(dir string, tree *merkle.Tree) error {
+	records := make([]ChunkRecord, 0, tree.PresentCount)
+	for i := 0; i < tree.NumChunks; i++ {
+		if !tree.HasChunk(i) {
+			continue
+		}
+		records = append(records, ChunkRecord{
+			Index:  i,
+			File:   fmt.Sprintf("chunk-%05d", i),
+			Offset: tree.ChunkOffset(i),
+			Length: tree.ChunkLength(i),
+			Hash:   tree.ChunkHash(i).String(),
+		})
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ChunksFile), data, 0644); err != nil {
+		return fmt.Errorf("write chunk manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) layerPath(d string) string {
+	return filepath.Join(s.root, "layers", digest.Digest(d).Encoded())
 }
 
 // BlobPath returns the path to an assembled blob, or empty if not assembled.
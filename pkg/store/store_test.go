@@ -1,14 +1,25 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/oci"
 )
 
 func TestNewStore(t *testing.T) {
@@ -129,7 +140,7 @@ func TestAssembleBlob(t *testing.T) {
 		require.NoError(layer.Tree.SetChunk(i, chunk))
 	}
 
-	blobPath, err := s.AssembleBlob(layer)
+	blobPath, err := s.AssembleBlob(context.Background(), layer)
 	require.NoError(err)
 
 	data, err := os.ReadFile(blobPath)
@@ -137,6 +148,83 @@ func TestAssembleBlob(t *testing.T) {
 	require.Equal(content, string(data))
 }
 
+func TestAssembleBlobWithoutCopy(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepChunks bool
+	}{
+		{"move chunks", false},
+		{"keep chunks", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			content := []byte("0123456789abcdefghijKLMNO")
+			sum := sha256.Sum256(content)
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+
+			s := New(t.TempDir(), WithChunkSize(10), WithKeepChunks(tt.keepChunks))
+			layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+			require.NoError(err)
+			for i := 0; i < layer.Tree.NumChunks; i++ {
+				data := content[layer.Tree.ChunkOffset(i) : layer.Tree.ChunkOffset(i)+int64(layer.Tree.ChunkLength(i))]
+				require.NoError(os.WriteFile(filepath.Join(layer.StorePath, chunkfmt(i)), data, 0644))
+				require.NoError(layer.Tree.SetChunk(i, data))
+			}
+			first, err := os.Stat(filepath.Join(layer.StorePath, chunkfmt(0)))
+			require.NoError(err)
+
+			blobPath, err := s.AssembleBlob(context.Background(), layer)
+			require.NoError(err)
+			data, err := os.ReadFile(blobPath)
+			require.NoError(err)
+			require.Equal(content, data)
+
+			blob, err := os.Stat(blobPath)
+			require.NoError(err)
+			require.Equal(!tt.keepChunks, os.SameFile(first, blob), "the first chunk becomes the blob")
+			for i := 1; i < layer.Tree.NumChunks; i++ {
+				_, err := os.Stat(filepath.Join(layer.StorePath, chunkfmt(i)))
+				require.Equal(tt.keepChunks, err == nil)
+			}
+
+			// assembling again finds the blob rather than the moved chunks
+			again, err := s.AssembleBlob(context.Background(), layer)
+			require.NoError(err)
+			require.Equal(blobPath, again)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			linked, err := l.LinkBlob(digest, blobPath)
+			require.NoError(err)
+			require.True(linked)
+
+			layoutPath, err := l.blobPath(digest)
+			require.NoError(err)
+			stored, err := os.Stat(layoutPath)
+			require.NoError(err)
+			require.True(os.SameFile(blob, stored), "the layout shares the assembled blob's inode")
+		})
+	}
+}
+
+func TestLinkBlobMismatch(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "blob")
+	require.NoError(os.WriteFile(path, []byte("content"), 0644))
+	sum := sha256.Sum256([]byte("other"))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	_, err = l.LinkBlob(digest, path)
+	require.ErrorIs(err, ErrDigestMismatch)
+	require.False(l.HasBlob(digest))
+}
+
 func TestAssembleBlobIncomplete(t *testing.T) {
 	require := require.New(t)
 
@@ -147,11 +235,62 @@ func TestAssembleBlobIncomplete(t *testing.T) {
 	layer, err := s.GetOrCreateLayer(digest, size)
 	require.NoError(err)
 
-	_, err = s.AssembleBlob(layer)
+	_, err = s.AssembleBlob(context.Background(), layer)
 	require.Error(err)
 	require.True(errors.Is(err, ErrLayerIncomplete))
 }
 
+func TestAssembleBlobClearsCorruptChunk(t *testing.T) {
+	require := require.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 5)
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	s := New(t.TempDir(), WithChunkSize(10))
+	layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+	require.NoError(err)
+	require.NoError(s.FetchMissing(context.Background(), layer, srv.URL, nil))
+	require.Len(ranges, 5)
+
+	// corrupt chunk 2 on disk
+	chunkPath := filepath.Join(layer.StorePath, chunkfmt(2))
+	require.NoError(os.WriteFile(chunkPath, []byte("xxxxxxxxxx"), 0644))
+	require.Equal([]int{2}, s.VerifyLayer(layer))
+
+	_, err = s.AssembleBlob(context.Background(), layer)
+	require.True(errors.Is(err, ErrDigestMismatch))
+	require.True(errors.Is(err, ErrCorruptChunks))
+	require.Equal([]int{2}, layer.Tree.MissingChunks())
+	_, err = os.Stat(chunkPath)
+	require.True(os.IsNotExist(err))
+
+	// cleared state is persisted for the next run
+	reloaded, err := s.GetOrCreateLayer(digest, int64(len(content)))
+	require.NoError(err)
+	require.Equal([]int{2}, reloaded.Tree.MissingChunks())
+
+	ranges = nil
+	require.NoError(s.FetchMissing(context.Background(), reloaded, srv.URL, nil))
+	require.Equal([]string{"bytes=20-29"}, ranges)
+
+	blobPath, err := s.AssembleBlob(context.Background(), reloaded)
+	require.NoError(err)
+	data, err := os.ReadFile(blobPath)
+	require.NoError(err)
+	require.Equal(content, data)
+}
+
 func TestCleanupChunks(t *testing.T) {
 	require := require.New(t)
 
@@ -170,7 +309,7 @@ func TestCleanupChunks(t *testing.T) {
 		require.NoError(os.WriteFile(chunkPath, []byte("data"), 0644))
 	}
 
-	require.NoError(s.CleanupChunks(layer))
+	require.NoError(s.CleanupChunks(context.Background(), layer))
 
 	// verify chunks are gone
 	for i := 0; i < layer.Tree.NumChunks; i++ {
@@ -180,6 +319,41 @@ func TestCleanupChunks(t *testing.T) {
 	}
 }
 
+func TestCleanupChunksKeep(t *testing.T) {
+	require := require.New(t)
+
+	s := New(t.TempDir(), WithChunkSize(10), WithKeepChunks(true))
+
+	content := []byte("0123456789abcdefghijKLMNO")
+	layer, err := s.GetOrCreateLayer("sha256:keep", int64(len(content)))
+	require.NoError(err)
+
+	for i := 0; i < layer.Tree.NumChunks; i++ {
+		data := content[layer.Tree.ChunkOffset(i) : layer.Tree.ChunkOffset(i)+int64(layer.Tree.ChunkLength(i))]
+		require.NoError(os.WriteFile(filepath.Join(layer.StorePath, chunkfmt(i)), data, 0644))
+		require.NoError(layer.Tree.SetChunk(i, data))
+	}
+
+	require.NoError(s.CleanupChunks(context.Background(), layer))
+
+	for i := 0; i < layer.Tree.NumChunks; i++ {
+		require.FileExists(filepath.Join(layer.StorePath, chunkfmt(i)))
+	}
+
+	data, err := os.ReadFile(filepath.Join(layer.StorePath, ChunksFile))
+	require.NoError(err)
+	var records []ChunkRecord
+	require.NoError(json.Unmarshal(data, &records))
+	require.Len(records, 3)
+	for i, rec := range records {
+		require.Equal(i, rec.Index)
+		require.Equal(chunkfmt(i), rec.File)
+		require.Equal(int64(i*10), rec.Offset)
+		require.Equal(layer.Tree.ChunkHash(i).String(), rec.Hash)
+	}
+	require.Equal(5, records[2].Length)
+}
+
 func TestBlobPath(t *testing.T) {
 	require := require.New(t)
 
@@ -212,3 +386,293 @@ func padInt(n, width int) string {
 	}
 	return s
 }
+
+func TestFetchMissingReusesConnections(t *testing.T) {
+	const (
+		chunkSize   = 1024
+		chunks      = 64
+		parallelism = 4
+	)
+	blob := bytes.Repeat([]byte("fray"), chunkSize*chunks/4)
+
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		wantConns func(n int32) bool
+	}{
+		{
+			name:      "default transport",
+			wantConns: func(n int32) bool { return n <= parallelism },
+		},
+		{
+			name:      "keep-alives disabled",
+			transport: oci.NewTransport(oci.TransportOptions{DisableKeepAlives: true}),
+			wantConns: func(n int32) bool { return n == 2*chunks },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			var conns atomic.Int32
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// keep every worker's request in flight at once
+				time.Sleep(time.Millisecond)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+			}))
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			srv.Start()
+			t.Cleanup(srv.Close)
+
+			opts := []Option{WithChunkSize(chunkSize), WithParallelism(parallelism)}
+			if tt.transport != nil {
+				opts = append(opts, WithTransport(tt.transport))
+			}
+			s := New(t.TempDir(), opts...)
+
+			// the second layer starts with every worker's connection idle
+			for _, name := range []string{"first", "second"} {
+				layer, err := s.GetOrCreateLayer(testDigest(name), int64(len(blob)))
+				require.NoError(err)
+				require.NoError(s.FetchMissing(context.Background(), layer, srv.URL, nil))
+				require.True(layer.Tree.Complete())
+			}
+			require.True(tt.wantConns(conns.Load()), "opened %d connections for %d chunks", conns.Load(), 2*chunks)
+		})
+	}
+}
+
+func TestFetchMissingResumeWithoutNetwork(t *testing.T) {
+	tests := []struct {
+		name        string
+		parallelism int
+	}{
+		{"sequential", 1},
+		{"parallel", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			content := bytes.Repeat([]byte("0123456789"), 5)
+			sum := sha256.Sum256(content)
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+			url := "fake://blob"
+
+			// the first fetch of chunk 3 fails as if the connection dropped
+			failed := false
+			fetcher := &fakeFetcher{
+				blobs: map[string][]byte{url: content},
+				fail: func(start int64) error {
+					if start == 30 && !failed {
+						failed = true
+						return errors.New("connection reset")
+					}
+					return nil
+				},
+			}
+
+			root := t.TempDir()
+			s := New(root, WithChunkSize(10), WithParallelism(tt.parallelism), WithFetcher(fetcher))
+			layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+			require.NoError(err)
+			require.Error(s.FetchMissing(context.Background(), layer, url, nil))
+
+			// a new store resumes from the saved state
+			fetcher.starts = nil
+			s = New(root, WithChunkSize(10), WithParallelism(tt.parallelism), WithFetcher(fetcher))
+			resumed, err := s.GetOrCreateLayer(digest, int64(len(content)))
+			require.NoError(err)
+			missing := resumed.Tree.MissingChunks()
+			require.Contains(missing, 3)
+			require.Less(len(missing), 5)
+
+			require.NoError(s.FetchMissing(context.Background(), resumed, url, nil))
+			require.Len(fetcher.starts, len(missing))
+
+			blobPath, err := s.AssembleBlob(context.Background(), resumed)
+			require.NoError(err)
+			data, err := os.ReadFile(blobPath)
+			require.NoError(err)
+			require.Equal(content, data)
+		})
+	}
+}
+
+func TestFetchMissingReportsEveryFailedChunk(t *testing.T) {
+	require := require.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	url := "fake://blob"
+
+	// chunks 2, 5 and 7 fail as if the registry went away mid-pull
+	errUnavailable := errors.New("service unavailable")
+	fetcher := &fakeFetcher{
+		blobs: map[string][]byte{url: content},
+		fail: func(start int64) error {
+			switch start {
+			case 20, 50, 70:
+				return errUnavailable
+			}
+			return nil
+		},
+	}
+
+	root := t.TempDir()
+	s := New(root, WithChunkSize(10), WithParallelism(3), WithFetcher(fetcher))
+	layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+	require.NoError(err)
+
+	err = s.FetchMissing(context.Background(), layer, url, nil)
+	require.ErrorIs(err, errUnavailable)
+	require.ErrorContains(err, "3 of 10 chunks failed")
+
+	var indexes []int
+	for _, ce := range FailedChunks(err) {
+		require.ErrorIs(ce, errUnavailable)
+		indexes = append(indexes, ce.Index)
+	}
+	require.ElementsMatch([]int{2, 5, 7}, indexes)
+
+	// the chunks that succeeded were saved
+	resumed, err := New(root, WithChunkSize(10)).GetOrCreateLayer(digest, int64(len(content)))
+	require.NoError(err)
+	require.Equal([]int{2, 5, 7}, resumed.Tree.MissingChunks())
+}
+
+// cancelAfter is a context cancelled once Err has been checked n times, so
+// a test can cancel at an exact chunk.
+type cancelAfter struct {
+	context.Context
+	n atomic.Int32
+}
+
+func newCancelAfter(n int) *cancelAfter {
+	c := &cancelAfter{Context: context.Background()}
+	c.n.Store(int32(n))
+	return c
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestAssembleBlobCancel(t *testing.T) {
+	const chunks = 20
+	content := bytes.Repeat([]byte("0123456789"), chunks)
+	for i := range content {
+		content[i] += byte(i / 10)
+	}
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name       string
+		keepChunks bool
+		// checks is how many times ctx is checked before it's cancelled:
+		// once per chunk hashed, once after, then once before moving chunks
+		// or once per chunk copied
+		checks int
+	}{
+		{name: "while hashing", checks: 3},
+		{name: "before moving chunks", checks: chunks + 1},
+		{name: "while copying chunks", keepChunks: true, checks: chunks + 1 + 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			root := t.TempDir()
+			s := New(root, WithChunkSize(10), WithKeepChunks(tt.keepChunks))
+			layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+			require.NoError(err)
+			for i := range chunks {
+				data := content[i*10 : (i+1)*10]
+				require.NoError(os.WriteFile(filepath.Join(layer.StorePath, chunkfmt(i)), data, 0644))
+				require.NoError(layer.Tree.SetChunk(i, data))
+			}
+			require.NoError(s.SaveState(layer))
+
+			ctx := newCancelAfter(tt.checks)
+			_, err = s.AssembleBlob(ctx, layer)
+			require.ErrorIs(err, context.Canceled)
+			// it stopped as soon as it saw the cancellation
+			require.GreaterOrEqual(ctx.n.Load(), int32(-2))
+			require.NoFileExists(filepath.Join(layer.StorePath, "blob"))
+			require.NoFileExists(filepath.Join(layer.StorePath, "blob.tmp"))
+
+			// the saved state matches the chunks left on disk, and resumes
+			fetcher := &fakeFetcher{blobs: map[string][]byte{"fake://blob": content}}
+			s = New(root, WithChunkSize(10), WithKeepChunks(tt.keepChunks), WithFetcher(fetcher))
+			resumed, err := s.GetOrCreateLayer(digest, int64(len(content)))
+			require.NoError(err)
+			require.Empty(resumed.Tree.MissingChunks())
+			require.Empty(s.VerifyLayer(resumed))
+
+			require.NoError(s.FetchMissing(context.Background(), resumed, "fake://blob", nil))
+			require.Empty(fetcher.starts)
+			blobPath, err := s.AssembleBlob(context.Background(), resumed)
+			require.NoError(err)
+			data, err := os.ReadFile(blobPath)
+			require.NoError(err)
+			require.Equal(content, data)
+		})
+	}
+}
+
+func TestAssembleBlobMoveNotCancelled(t *testing.T) {
+	require := require.New(t)
+
+	const chunks = 5
+	content := bytes.Repeat([]byte("0123456789"), chunks)
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	s := New(t.TempDir(), WithChunkSize(10))
+	layer, err := s.GetOrCreateLayer(digest, int64(len(content)))
+	require.NoError(err)
+	for i := range chunks {
+		data := content[i*10 : (i+1)*10]
+		require.NoError(os.WriteFile(filepath.Join(layer.StorePath, chunkfmt(i)), data, 0644))
+		require.NoError(layer.Tree.SetChunk(i, data))
+	}
+
+	// cancelled just after the move begins, the moved chunks would be lost,
+	// so it isn't checked again and the blob is finished
+	ctx := newCancelAfter(chunks + 2)
+	blobPath, err := s.AssembleBlob(ctx, layer)
+	require.NoError(err)
+	require.Equal(int32(0), ctx.n.Load())
+	data, err := os.ReadFile(blobPath)
+	require.NoError(err)
+	require.Equal(content, data)
+}
+
+func TestCleanupChunksCancel(t *testing.T) {
+	require := require.New(t)
+
+	s := New(t.TempDir(), WithChunkSize(10))
+	layer, err := s.GetOrCreateLayer(testDigest("cancel"), 50)
+	require.NoError(err)
+	for i := range layer.Tree.NumChunks {
+		require.NoError(os.WriteFile(filepath.Join(layer.StorePath, chunkfmt(i)), []byte("data"), 0644))
+	}
+
+	require.ErrorIs(s.CleanupChunks(newCancelAfter(2), layer), context.Canceled)
+	for i := range layer.Tree.NumChunks {
+		_, err := os.Stat(filepath.Join(layer.StorePath, chunkfmt(i)))
+		require.Equal(i >= 2, err == nil, "chunk %d", i)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// otherDevice returns a writable directory on a different filesystem than
+// dir, or skips the test if there is none.
+func otherDevice(t *testing.T, dir string) string {
+	t.Helper()
+
+	device := func(path string) uint64 {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		return uint64(fi.Sys().(*syscall.Stat_t).Dev)
+	}
+	for _, candidate := range []string{"/dev/shm", os.TempDir(), "/var/tmp"} {
+		if _, err := os.Stat(candidate); err != nil || device(candidate) == device(dir) {
+			continue
+		}
+		other, err := os.MkdirTemp(candidate, "fray-cross-device-")
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { os.RemoveAll(other) })
+		return other
+	}
+	t.Skip("no writable directory on another filesystem")
+	return ""
+}
+
+func TestSetTempDirCrossDevice(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	other := otherDevice(t, l.Root())
+
+	err = l.SetTempDir(filepath.Join(other, "scratch"))
+	require.True(errors.Is(err, ErrCrossDevice), "got %v", err)
+
+	// the layout keeps writing next to its blobs
+	d := testDigest("same device")
+	_, err = l.WriteBlob(d, strings.NewReader("same device"))
+	require.NoError(err)
+	require.True(l.HasBlob(d))
+}
+
+func TestRenameBlobCrossDevice(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	other := otherDevice(t, l.Root())
+
+	from := filepath.Join(other, "blob")
+	require.NoError(os.WriteFile(from, []byte("blob"), 0644))
+	to := filepath.Join(l.Root(), BlobsDir, "sha256", "blob")
+
+	err = renameBlob(from, to)
+	require.True(errors.Is(err, ErrCrossDevice), "got %v", err)
+	require.NoFileExists(to, "no silent copy")
+	require.FileExists(from)
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+const (
+	// MetadataVersion is the format of fray's own metadata in a layout:
+	// the state under MetadataDir and the annotations it indexes. oci-layout
+	// only versions the OCI parts.
+	MetadataVersion = 2
+	// MetadataDir holds fray's state inside a layout.
+	MetadataDir = ".fray"
+	// VersionFile, in MetadataDir, records the layout's MetadataVersion.
+	// Layouts without one are version 1.
+	VersionFile = "version"
+)
+
+// ErrUnsupportedVersion is returned when a layout was written by a newer
+// fray whose metadata this one can't read.
+var ErrUnsupportedVersion = errors.New("layout metadata version unsupported")
+
+// migrations upgrade a layout's metadata from the version they're keyed by
+// to the next.
+var migrations = map[int]func(l *Layout) error{
+	1: migrateStateNames,
+}
+
+// migrate brings the layout's metadata up to MetadataVersion, one version
+// at a time, and records it. A layout written by a newer fray is refused.
+func (l *Layout) migrate() error {
+	version, err := l.metadataVersion()
+	if err != nil {
+		return err
+	}
+	if version > MetadataVersion {
+		return fmt.Errorf("%w: %s is version %d, this fray supports up to %d",
+			ErrUnsupportedVersion, l.root, version, MetadataVersion)
+	}
+	if version == MetadataVersion {
+		return nil
+	}
+
+	for ; version < MetadataVersion; version++ {
+		if err := migrations[version](l); err != nil {
+			return fmt.Errorf("migrate layout to version %d: %w", version+1, err)
+		}
+	}
+	err = l.writeMetadataVersion()
+	if isReadOnly(err) {
+		// a read-only layout, such as one on removable media, can still
+		// be read
+		return nil
+	}
+	return err
+}
+
+// metadataVersion reads the layout's VersionFile, or 1 if it has none.
+func (l *Layout) metadataVersion() (int, error) {
+	data, err := os.ReadFile(filepath.Join(l.root, MetadataDir, VersionFile))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read metadata version: %w", err)
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, strings.TrimSpace(string(data)))
+	}
+	return version, nil
+}
+
+func (l *Layout) writeMetadataVersion() error {
+	dir := filepath.Join(l.root, MetadataDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	data := []byte(strconv.Itoa(MetadataVersion) + "\n")
+	if err := os.WriteFile(filepath.Join(dir, VersionFile), data, 0644); err != nil {
+		return fmt.Errorf("write metadata version: %w", err)
+	}
+	return nil
+}
+
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, os.ErrPermission)
+}
+
+// migrateStateNames renames the state files version 1 named after the
+// first 12 hex digits of a layer's digest to the full digest, where the
+// state records it. State that doesn't is adopted by the Puller when it
+// resumes the layer.
+func migrateStateNames(l *Layout) error {
+	dir := filepath.Join(l.root, MetadataDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || len(name) != 12+len(StateFileExt) || !strings.HasSuffix(name, StateFileExt) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		state, err := ReadPullState(path)
+		if err != nil || state.Digest == "" {
+			continue
+		}
+		d, err := digest.Parse(state.Digest)
+		if err != nil || !strings.HasPrefix(d.Encoded(), strings.TrimSuffix(name, StateFileExt)) {
+			continue
+		}
+
+		target := filepath.Join(dir, d.Encoded()+StateFileExt)
+		if _, err := os.Stat(target); err == nil {
+			// the Puller resumes from the full name; the short one is stale
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(path, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/merkle"
+)
+
+func TestOpenWritesMetadataVersion(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	_, err := Open(dir)
+	require.NoError(err)
+
+	data, err := os.ReadFile(filepath.Join(dir, MetadataDir, VersionFile))
+	require.NoError(err)
+	require.Equal("2\n", string(data))
+}
+
+func TestOpenMigratesVersion1(t *testing.T) {
+	require := require.New(t)
+
+	// a version 1 layout: no version file, and state saved under short names
+	dir := t.TempDir()
+	_, err := Open(dir)
+	require.NoError(err)
+	stateDir := filepath.Join(dir, MetadataDir)
+	require.NoError(os.Remove(filepath.Join(stateDir, VersionFile)))
+
+	tree := merkle.New(4096, 1024)
+	require.NoError(tree.SetChunk(0, make([]byte, 1024)))
+	saveState := func(name, d string) {
+		require.NoError(merkle.WriteJSONFile(filepath.Join(stateDir, name), &PullState{
+			Image:  "quay.io/test/app:v1",
+			Digest: d,
+			State:  *tree.Serialize(),
+		}))
+	}
+
+	recorded := testDigest("recorded")
+	saveState(recorded[7:19]+StateFileExt, recorded)
+	// state that didn't record its digest is left for the Puller
+	unrecorded := testDigest("unrecorded")
+	saveState(unrecorded[7:19]+StateFileExt, "")
+	// a short name beside the full one is stale
+	both := testDigest("both")
+	saveState(both[7:19]+StateFileExt, both)
+	saveState(both[7:]+StateFileExt, both)
+
+	_, err = Open(dir)
+	require.NoError(err)
+
+	var names []string
+	entries, err := os.ReadDir(stateDir)
+	require.NoError(err)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.ElementsMatch([]string{
+		VersionFile,
+		recorded[7:] + StateFileExt,
+		unrecorded[7:19] + StateFileExt,
+		both[7:] + StateFileExt,
+	}, names)
+
+	state, err := ReadPullState(filepath.Join(stateDir, recorded[7:]+StateFileExt))
+	require.NoError(err)
+	require.Equal(recorded, state.Digest)
+
+	version, err := os.ReadFile(filepath.Join(stateDir, VersionFile))
+	require.NoError(err)
+	require.Equal("2\n", string(version))
+
+	// reopening a current layout changes nothing
+	_, err = Open(dir)
+	require.NoError(err)
+	entries, err = os.ReadDir(stateDir)
+	require.NoError(err)
+	require.Len(entries, len(names))
+}
+
+func TestOpenRefusesUnsupportedVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+	}{
+		{"newer", "3\n"},
+		{"much newer", "100"},
+		{"garbage", "v2"},
+		{"zero", "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			dir := t.TempDir()
+			_, err := Open(dir)
+			require.NoError(err)
+			path := filepath.Join(dir, MetadataDir, VersionFile)
+			require.NoError(os.WriteFile(path, []byte(tt.version), 0644))
+
+			_, err = Open(dir)
+			require.ErrorIs(err, ErrUnsupportedVersion)
+
+			// the newer layout is left as it was
+			data, err := os.ReadFile(path)
+			require.NoError(err)
+			require.Equal(tt.version, string(data))
+		})
+	}
+}
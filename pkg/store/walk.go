@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+// blobAlgorithms are the blobs/ subdirectories that hold content.
+var blobAlgorithms = []digest.Algorithm{digest.SHA256, digest.SHA512}
+
+// WalkBlobs calls fn with the digest and size of every complete blob in
+// the layout, skipping partial downloads and in-progress temp files. An
+// error from fn stops the walk and is returned, except fs.SkipAll, which
+// stops it cleanly. Blobs written or removed during the walk may or may not
+// be visited.
+func (l *Layout) WalkBlobs(fn func(digest string, size int64) error) error {
+	return l.walkBlobs(func(d, _ string, info fs.FileInfo) error {
+		return fn(d, info.Size())
+	})
+}
+
+// walkBlobs is WalkBlobs with the blob's path and file info, for callers
+// that need modification times or to remove blobs.
+func (l *Layout) walkBlobs(fn func(d, path string, info fs.FileInfo) error) error {
+	for _, algorithm := range blobAlgorithms {
+		dir := filepath.Join(l.root, BlobsDir, string(algorithm))
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if !completeBlob(e) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				// removed since the directory was read
+				continue
+			}
+			if err := fn(string(algorithm)+":"+e.Name(), filepath.Join(dir, e.Name()), info); err != nil {
+				if errors.Is(err, fs.SkipAll) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
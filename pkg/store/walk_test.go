@@ -0,0 +1,96 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexfusion/fray/pkg/digest"
+)
+
+func TestWalkBlobs(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+
+	want := map[string]int64{}
+	for _, content := range []string{"one", "two blob", "three blobs"} {
+		d := digest.FromBytes([]byte(content)).String()
+		_, err := l.WriteBlob(d, strings.NewReader(content))
+		require.NoError(err)
+		want[d] = int64(len(content))
+	}
+	sha512Content := "sha512 blob"
+	d512 := digest.SHA512.FromBytes([]byte(sha512Content)).String()
+	_, err = l.WriteBlob(d512, strings.NewReader(sha512Content))
+	require.NoError(err)
+	want[d512] = int64(len(sha512Content))
+
+	// a stray partial download and temp file are skipped
+	require.NoError(l.WriteBlobAt(testDigest("partial"), 0, []byte("half")))
+	blobDir := filepath.Join(l.Root(), BlobsDir, string(digest.SHA256))
+	require.NoError(os.WriteFile(filepath.Join(blobDir, ".tmp-upload"), []byte("tmp"), 0644))
+
+	got := map[string]int64{}
+	require.NoError(l.WalkBlobs(func(d string, size int64) error {
+		got[d] = size
+		return nil
+	}))
+	require.Equal(want, got)
+}
+
+func TestWalkBlobsStop(t *testing.T) {
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name    string
+		ret     error
+		wantErr error
+	}{
+		{"skip all", fs.SkipAll, nil},
+		{"error", errStop, errStop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := Open(t.TempDir())
+			require.NoError(err)
+			for _, content := range []string{"a", "b", "c"} {
+				_, err := l.WriteBlob(digest.FromBytes([]byte(content)).String(), strings.NewReader(content))
+				require.NoError(err)
+			}
+
+			visited := 0
+			err = l.WalkBlobs(func(string, int64) error {
+				visited++
+				return tt.ret
+			})
+			if tt.wantErr == nil {
+				require.NoError(err)
+			} else {
+				require.ErrorIs(err, tt.wantErr)
+			}
+			require.Equal(1, visited)
+		})
+	}
+}
+
+func TestWalkBlobsEmpty(t *testing.T) {
+	require := require.New(t)
+
+	l, err := Open(t.TempDir())
+	require.NoError(err)
+	require.NoError(os.RemoveAll(filepath.Join(l.Root(), BlobsDir)))
+
+	require.NoError(l.WalkBlobs(func(string, int64) error {
+		return errors.New("no blobs expected")
+	}))
+}
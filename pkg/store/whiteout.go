@@ -0,0 +1,306 @@
+package store
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+var ErrUnsafePath = errors.New("layer entry escapes rootfs")
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// LayerTree is a filesystem that layers are applied to. Names are slash
+// separated and relative to the tree root.
+type LayerTree interface {
+	// Write creates or replaces name from a tar entry, creating parents.
+	Write(name string, hdr *tar.Header, r io.Reader) error
+	// Remove deletes name and anything below it. Missing names are ignored.
+	Remove(name string) error
+	// List returns the names of dir's children, or nil if dir is not a
+	// directory.
+	List(dir string) ([]string, error)
+}
+
+// ApplyLayer applies an uncompressed layer tar to tree. A .wh.<name> entry
+// removes name from lower layers and a .wh..wh..opq entry hides everything
+// lower layers put in its directory. Entries that climb out of the tree
+// fail with ErrUnsafePath.
+func ApplyLayer(r io.Reader, tree LayerTree) error {
+	tr := tar.NewReader(r)
+
+	// added tracks this layer's paths so opaque markers only hide lower layers
+	added := make(map[string]bool)
+	var opaque []string
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+
+		name, err := cleanEntryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+
+		switch {
+		case base == whiteoutOpaque:
+			opaque = append(opaque, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			if err := tree.Remove(target); err != nil {
+				return fmt.Errorf("whiteout %s: %w", target, err)
+			}
+			continue
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			if hdr.Linkname, err = cleanEntryPath(hdr.Linkname); err != nil {
+				return err
+			}
+		}
+
+		if err := tree.Write(name, hdr, tr); err != nil {
+			return fmt.Errorf("extract %s: %w", name, err)
+		}
+		for p := name; p != "."; p = path.Dir(p) {
+			added[p] = true
+		}
+	}
+
+	for _, dir := range opaque {
+		if err := clearOpaque(tree, dir, added); err != nil {
+			return fmt.Errorf("opaque %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// cleanEntryPath returns a tar entry name relative to the tree root,
+// rejecting names that climb out of it.
+func cleanEntryPath(name string) (string, error) {
+	p := path.Clean(strings.TrimLeft(name, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return p, nil
+}
+
+// clearOpaque removes everything under dir that the current layer didn't add.
+func clearOpaque(tree LayerTree, dir string, added map[string]bool) error {
+	names, err := tree.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		p := path.Join(dir, n)
+		if !added[p] {
+			if err := tree.Remove(p); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := clearOpaque(tree, p, added); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DirTree applies layers to a directory. os.Root confines symlinks from
+// earlier layers to the directory as well as the entries themselves.
+type DirTree struct {
+	root *os.Root
+}
+
+// NewDirTree returns a LayerTree backed by root.
+func NewDirTree(root *os.Root) *DirTree {
+	return &DirTree{root: root}
+}
+
+// Write creates name on disk. Device nodes and fifos need privileges to
+// create and are skipped.
+func (d *DirTree) Write(name string, hdr *tar.Header, r io.Reader) error {
+	root := d.root
+
+	if dir := path.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if info, err := root.Lstat(name); err == nil && !info.IsDir() {
+			if err := root.Remove(name); err != nil {
+				return err
+			}
+		}
+		if err := root.Mkdir(name, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+
+	case tar.TypeReg:
+		if err := d.Remove(name); err != nil {
+			return err
+		}
+		f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := d.Remove(name); err != nil {
+			return err
+		}
+		return root.Symlink(hdr.Linkname, name)
+
+	case tar.TypeLink:
+		if err := d.Remove(name); err != nil {
+			return err
+		}
+		return root.Link(hdr.Linkname, name)
+
+	default:
+		return nil
+	}
+
+	privileged := os.Geteuid() == 0
+	mode := hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if hdr.Typeflag == tar.TypeDir && !privileged {
+		// later entries must still be writable without CAP_DAC_OVERRIDE
+		mode |= 0700
+	}
+	if err := root.Chmod(name, mode); err != nil {
+		return err
+	}
+	if privileged {
+		if err := root.Lchown(name, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	return root.Chtimes(name, hdr.ModTime, hdr.ModTime)
+}
+
+// Remove deletes name and anything below it.
+func (d *DirTree) Remove(name string) error {
+	if _, err := d.root.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return d.root.RemoveAll(name)
+}
+
+// List returns the entries of dir.
+func (d *DirTree) List(dir string) ([]string, error) {
+	info, err := d.root.Lstat(dir)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := d.root.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
+
+// MemTree is an in-memory LayerTree that keeps headers but not content, for
+// inspecting the merged view of an image's layers without extracting them.
+type MemTree struct {
+	entries map[string]*tar.Header
+}
+
+// NewMemTree returns an empty MemTree.
+func NewMemTree() *MemTree {
+	return &MemTree{entries: make(map[string]*tar.Header)}
+}
+
+// Write records hdr under name. Missing parents are recorded as directories.
+func (m *MemTree) Write(name string, hdr *tar.Header, _ io.Reader) error {
+	for p := path.Dir(name); p != "."; p = path.Dir(p) {
+		if _, ok := m.entries[p]; !ok {
+			m.entries[p] = &tar.Header{Name: p, Typeflag: tar.TypeDir, Mode: 0755}
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		m.removeChildren(name)
+	}
+	h := *hdr
+	h.Name = name
+	m.entries[name] = &h
+	return nil
+}
+
+// Remove deletes name and anything below it.
+func (m *MemTree) Remove(name string) error {
+	delete(m.entries, name)
+	m.removeChildren(name)
+	return nil
+}
+
+func (m *MemTree) removeChildren(name string) {
+	for p := range m.entries {
+		if strings.HasPrefix(p, name+"/") {
+			delete(m.entries, p)
+		}
+	}
+}
+
+// List returns the names of dir's children.
+func (m *MemTree) List(dir string) ([]string, error) {
+	var names []string
+	for p := range m.entries {
+		if path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Paths returns every recorded entry name in sorted order.
+func (m *MemTree) Paths() []string {
+	return slices.Sorted(maps.Keys(m.entries))
+}
+
+// Header returns the header recorded for name.
+func (m *MemTree) Header(name string) (*tar.Header, bool) {
+	h, ok := m.entries[name]
+	return h, ok
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dirPaths lists every path under dir, slash separated and sorted.
+func dirPaths(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		paths = append(paths, filepath.ToSlash(rel))
+		return err
+	})
+	require.NoError(t, err)
+
+	slices.Sort(paths)
+	return paths
+}
+
+// memPaths lists every path under dir through List.
+func memPaths(t *testing.T, tree *MemTree, dir string) []string {
+	t.Helper()
+
+	names, err := tree.List(dir)
+	require.NoError(t, err)
+
+	var paths []string
+	for _, n := range names {
+		p := path.Join(dir, n)
+		paths = append(paths, p)
+		paths = append(paths, memPaths(t, tree, p)...)
+	}
+
+	slices.Sort(paths)
+	return paths
+}
+
+func TestApplyLayer(t *testing.T) {
+	base := []tarEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/hosts", typeflag: tar.TypeReg, body: "hosts"},
+		{name: "etc/passwd", typeflag: tar.TypeReg, body: "root"},
+		{name: "var/lib/app/", typeflag: tar.TypeDir},
+		{name: "var/lib/app/old/data", typeflag: tar.TypeReg, body: "old"},
+		{name: "var/lib/app/state", typeflag: tar.TypeReg, body: "old"},
+	}
+
+	tests := []struct {
+		name    string
+		layers  [][]tarEntry
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "plain add",
+			layers: [][]tarEntry{base, {{name: "./etc/motd", typeflag: tar.TypeReg, body: "hi"}}},
+			want: []string{
+				"etc", "etc/hosts", "etc/motd", "etc/passwd",
+				"var", "var/lib", "var/lib/app", "var/lib/app/old", "var/lib/app/old/data", "var/lib/app/state",
+			},
+		},
+		{
+			name:   "file whiteout",
+			layers: [][]tarEntry{base, {{name: "etc/.wh.passwd", typeflag: tar.TypeReg}}},
+			want: []string{
+				"etc", "etc/hosts",
+				"var", "var/lib", "var/lib/app", "var/lib/app/old", "var/lib/app/old/data", "var/lib/app/state",
+			},
+		},
+		{
+			name:   "directory whiteout",
+			layers: [][]tarEntry{base, {{name: "var/lib/.wh.app", typeflag: tar.TypeReg}}},
+			want:   []string{"etc", "etc/hosts", "etc/passwd", "var", "var/lib"},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]tarEntry{base, {
+				{name: "var/lib/app/old/new", typeflag: tar.TypeReg, body: "new"},
+				{name: "var/lib/app/.wh..wh..opq", typeflag: tar.TypeReg},
+				{name: "var/lib/app/state", typeflag: tar.TypeReg, body: "new"},
+			}},
+			want: []string{
+				"etc", "etc/hosts", "etc/passwd",
+				"var", "var/lib", "var/lib/app", "var/lib/app/old", "var/lib/app/old/new", "var/lib/app/state",
+			},
+		},
+		{
+			name:    "parent traversal",
+			layers:  [][]tarEntry{{{name: "etc/../../escape", typeflag: tar.TypeReg, body: "x"}}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "hardlink traversal",
+			layers:  [][]tarEntry{{{name: "etc/shadow", typeflag: tar.TypeLink, linkname: "../outside"}}},
+			wantErr: ErrUnsafePath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/mem", func(t *testing.T) {
+			require := require.New(t)
+
+			tree := NewMemTree()
+			var err error
+			for _, layer := range tt.layers {
+				if err = ApplyLayer(bytes.NewReader(buildTar(t, layer)), tree); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(err)
+
+			require.Equal(tt.want, memPaths(t, tree, "."))
+		})
+
+		t.Run(tt.name+"/dir", func(t *testing.T) {
+			require := require.New(t)
+
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "rootfs")
+			require.NoError(os.Mkdir(dest, 0755))
+			root, err := os.OpenRoot(dest)
+			require.NoError(err)
+			defer root.Close()
+
+			for _, layer := range tt.layers {
+				if err = ApplyLayer(bytes.NewReader(buildTar(t, layer)), NewDirTree(root)); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				require.True(errors.Is(err, tt.wantErr), "got %v", err)
+				require.Equal([]string{"rootfs"}, dirPaths(t, parent))
+				return
+			}
+			require.NoError(err)
+			require.Equal(tt.want, dirPaths(t, dest))
+		})
+	}
+}
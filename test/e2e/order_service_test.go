@@ -2,6 +2,7 @@ package e2e_test
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -9,12 +10,14 @@ import (
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 
 	"github.com/hexfusion/fray/gen/order"
+	"github.com/hexfusion/fray/pkg/cel"
 )
 
 // orderServer implements order.OrderServiceServer with validation.
@@ -37,7 +40,7 @@ func (s *orderServer) CreateOrder(ctx context.Context, req *order.CreateOrderReq
 
 	// validate the order
 	if err := req.Order.Validate(); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+		return nil, invalidArgument("validation failed", err)
 	}
 
 	s.mu.Lock()
@@ -54,7 +57,7 @@ func (s *orderServer) CreateOrder(ctx context.Context, req *order.CreateOrderReq
 func (s *orderServer) GetOrder(ctx context.Context, req *order.GetOrderRequest) (*order.GetOrderResponse, error) {
 	// validate the request
 	if err := req.Validate(); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+		return nil, invalidArgument("validation failed", err)
 	}
 
 	s.mu.RLock()
@@ -75,7 +78,7 @@ func (s *orderServer) UpdateOrder(ctx context.Context, req *order.UpdateOrderReq
 
 	// validate the new order
 	if err := req.Order.Validate(); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+		return nil, invalidArgument("validation failed", err)
 	}
 
 	s.mu.Lock()
@@ -88,7 +91,7 @@ func (s *orderServer) UpdateOrder(ctx context.Context, req *order.UpdateOrderReq
 
 	// validate the transition
 	if err := req.Order.ValidateUpdate(old); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "transition validation failed: %v", err)
+		return nil, invalidArgument("transition validation failed", err)
 	}
 
 	s.orders[req.Order.Id] = req.Order
@@ -97,7 +100,7 @@ func (s *orderServer) UpdateOrder(ctx context.Context, req *order.UpdateOrderReq
 
 func (s *orderServer) ListOrders(ctx context.Context, req *order.ListOrdersRequest) (*order.ListOrdersResponse, error) {
 	if err := req.Validate(); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+		return nil, invalidArgument("validation failed", err)
 	}
 
 	s.mu.RLock()
@@ -111,6 +114,45 @@ func (s *orderServer) ListOrders(ctx context.Context, req *order.ListOrdersReque
 	return &order.ListOrdersResponse{Orders: orders}, nil
 }
 
+// invalidArgument returns an InvalidArgument status for err. Field
+// violations from validation are attached as a google.rpc.BadRequest.
+func invalidArgument(msg string, err error) error {
+	st := status.Newf(codes.InvalidArgument, "%s: %v", msg, err)
+
+	var result *cel.ValidationResult
+	if !errors.As(err, &result) {
+		return st.Err()
+	}
+
+	br := &errdetails.BadRequest{}
+	for _, v := range result.Violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	detailed, derr := st.WithDetails(br)
+	if derr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// fieldViolations returns the BadRequest field paths in a status error.
+func fieldViolations(err error) []string {
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		br, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, v := range br.FieldViolations {
+			fields = append(fields, v.Field)
+		}
+	}
+	return fields
+}
+
 var _ = Describe("OrderService", func() {
 	var (
 		server   *grpc.Server
@@ -207,6 +249,36 @@ var _ = Describe("OrderService", func() {
 			Expect(err.Error()).To(ContainSubstring("Sku"))
 		})
 
+		It("reports every field violation with its path", func() {
+			ctx := context.Background()
+			o := validOrder()
+			o.CustomerEmail = "not-an-email"
+			o.Version = 0
+			o.Items[0].Sku = "lowercase-sku"
+			o.Items[0].Quantity = 9999
+
+			_, err := client.CreateOrder(ctx, &order.CreateOrderRequest{Order: o})
+			Expect(err).To(HaveOccurred())
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(fieldViolations(err)).To(ConsistOf(
+				"CustomerEmail",
+				"Version",
+				"Items[0].Quantity",
+				"Items[0].Sku",
+			))
+		})
+
+		It("reports a missing required field once", func() {
+			ctx := context.Background()
+			o := validOrder()
+			o.Items[0].Sku = ""
+
+			_, err := client.CreateOrder(ctx, &order.CreateOrderRequest{Order: o})
+			Expect(err).To(HaveOccurred())
+			Expect(fieldViolations(err)).To(Equal([]string{"Items[0].Sku"}))
+			Expect(err.Error()).To(ContainSubstring("Items[0].Sku: is required"))
+		})
+
 		It("rejects order with quantity too high", func() {
 			ctx := context.Background()
 			o := validOrder()
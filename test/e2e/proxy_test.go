@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -104,12 +105,20 @@ var _ = Describe("Proxy", func() {
 
 	Describe("Blob endpoints", func() {
 		It("should return 404 for non-existent blobs", func() {
-			resp, err := http.Get(ts.URL + "/v2/docker.io/library/alpine/blobs/sha256:notexist")
+			resp, err := http.Get(ts.URL + "/v2/docker.io/library/alpine/blobs/sha256:" + strings.Repeat("0", 64))
 			Expect(err).NotTo(HaveOccurred())
 			defer resp.Body.Close()
 
 			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
 		})
+
+		It("should return 400 for malformed digests", func() {
+			resp, err := http.Get(ts.URL + "/v2/docker.io/library/alpine/blobs/sha256:notexist")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
 	})
 
 	Describe("Manifest pull", Label("integration"), func() {
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hexfusion/fray/pkg/logging"
 	"github.com/hexfusion/fray/pkg/oci"
+	"github.com/hexfusion/fray/pkg/store"
 )
 
 // Test images representing different scenarios.
@@ -113,6 +115,85 @@ func TestRegistryCompatibility(t *testing.T) {
 	}
 }
 
+func TestConcurrentPull(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	l, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open layout: %v", err)
+	}
+
+	client := oci.NewClient()
+	client.SetAuth(oci.NewRegistryAuth())
+
+	images := []string{
+		"quay.io/prometheus/busybox:latest",
+		"docker.io/library/alpine:latest",
+	}
+
+	puller := store.NewPuller(l, client, logging.Nop(), store.PullOptions{})
+	results, err := puller.PullAll(ctx, images, len(images))
+	if err != nil {
+		t.Fatalf("PullAll: %v", err)
+	}
+
+	for i, r := range results {
+		t.Logf("%s: digest=%s layers=%d downloaded=%d", images[i], r.Digest, r.Layers, r.Downloaded)
+	}
+
+	index, err := l.GetIndex()
+	if err != nil {
+		t.Fatalf("GetIndex: %v", err)
+	}
+	if len(index.Manifests) != len(images) {
+		t.Errorf("expected %d manifests in index, got %d", len(images), len(index.Manifests))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	l, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open layout: %v", err)
+	}
+
+	client := oci.NewClient()
+	client.SetAuth(oci.NewRegistryAuth())
+
+	images := []string{
+		"quay.io/prometheus/busybox:latest",
+		// quay answers unknown repositories with 401
+		"quay.io/nonexistent/image:v999",
+	}
+
+	puller := store.NewPuller(l, client, logging.Nop(), store.PullOptions{})
+	results, errs := puller.CheckAll(ctx, images, len(images))
+
+	if errs[0] != nil {
+		t.Fatalf("%s: %v", images[0], errs[0])
+	}
+	t.Logf("%s: digest=%s layers=%d range=%v", images[0], results[0].Digest, results[0].Layers, results[0].RangeSupported)
+	if !results[0].RangeSupported {
+		t.Error("Range requests not supported")
+	}
+
+	if errs[1] == nil {
+		t.Fatalf("%s: expected failure but got success", images[1])
+	}
+	t.Logf("expected failure: %v", errs[1])
+}
+
 func shortMediaType(mediaType string) string {
 	switch mediaType {
 	case "application/vnd.docker.distribution.manifest.v2+json":